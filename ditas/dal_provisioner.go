@@ -18,6 +18,7 @@
 package ditas
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/provision/kubernetes"
 	"deployment-engine/utils"
@@ -45,7 +46,7 @@ func NewDALProvisioner() *DALProvisioner {
 	return &DALProvisioner{}
 }
 
-func (p DALProvisioner) Provision(config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p DALProvisioner) Provision(ctx context.Context, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 	result := make(model.Parameters)
 
 	var err error
@@ -118,7 +119,7 @@ func (p DALProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 		dalImages[imageName] = imageInfo
 	}
 
-	kubeClient, err := kubernetes.NewClient(config.ConfigurationFile)
+	kubeClient, err := kubernetes.NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		logger.WithError(err).Error("Error getting kubernetes client")
 		return result, err
@@ -136,7 +137,7 @@ func (p DALProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	deployment := kubernetes.GetDeploymentDescription(dalID, int32(1), int64(30), labels, dalImages, "", "", repoSecrets, nil)
 
 	logger.Info("Creating DAL deployment")
-	_, err = kubeClient.CreateOrUpdateDeployment(logger, DitasNamespace, &deployment)
+	_, err = kubeClient.CreateOrUpdateDeployment(ctx, logger, DitasNamespace, &deployment)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error deploying DAL %s", dalID), err)
 	}
@@ -174,7 +175,7 @@ func (p DALProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	}
 
 	logger.Info("Creating or updating DAL service")
-	_, err = kubeClient.CreateOrUpdateService(logger, DitasNamespace, &vdcService)
+	_, err = kubeClient.CreateOrUpdateService(ctx, logger, DitasNamespace, &vdcService)
 	if err != nil {
 		for _, port := range ports {
 			config.LiberatePort(port.TargetPort.IntValue())
@@ -19,10 +19,15 @@ package ditas
 
 import (
 	"deployment-engine/infrastructure"
+	"deployment-engine/infrastructure/kubernetes"
+	"deployment-engine/jobs"
+	"deployment-engine/model"
 	"deployment-engine/persistence/mongorepo"
 	"deployment-engine/provision"
 	"deployment-engine/provision/ansible"
 	"deployment-engine/restfrontend"
+	"deployment-engine/utils"
+	"deployment-engine/webhooks"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -52,6 +57,10 @@ type DitasFrontend struct {
 
 func NewDitasFrontend() (*DitasFrontend, error) {
 	viper.SetDefault(DitasUseDefaultFrontendConfigProperty, DitasUseDefaultFrontendConfigDefaultValue)
+	viper.SetDefault(infrastructure.AutocleanProperty, infrastructure.AutocleanDefaultValue)
+	viper.SetDefault(infrastructure.DriftCheckIntervalProperty, infrastructure.DriftCheckIntervalDefaultValue)
+	viper.SetDefault(infrastructure.DriftCheckInstanceProperty, infrastructure.DriftCheckInstanceDefaultValue)
+	viper.SetDefault(jobs.InstanceProperty, jobs.InstanceDefaultValue)
 	repository, err := mongorepo.CreateRepositoryNative()
 	if err != nil {
 		return nil, err
@@ -64,14 +73,21 @@ func NewDitasFrontend() (*DitasFrontend, error) {
 
 	publicKeyPath := os.Getenv("HOME") + "/.ssh/id_rsa.pub"
 
+	dispatcher := webhooks.NewDispatcher(repository, repository)
+	events := dispatcher.Notifying(repository)
+
 	deployer := &infrastructure.Deployer{
 		Repository:        repository,
 		Vault:             repository,
+		Events:            events,
+		Leases:            repository,
 		PublicKeyPath:     publicKeyPath,
 		DeploymentsFolder: viper.GetString(ansible.InventoryFolderProperty),
+		Autoclean:         viper.GetBool(infrastructure.AutocleanProperty),
 	}
 
 	controller := provision.NewProvisionerController(provisioner, repository)
+	controller.Events = events
 
 	vdcManager, err := NewVDCManager(deployer, controller)
 	if err != nil {
@@ -88,10 +104,19 @@ func NewDitasFrontend() (*DitasFrontend, error) {
 			DeploymentController:  deployer,
 			ProvisionerController: controller,
 			Vault:                 repository,
+			JobManager:            jobs.NewManager(repository, viper.GetString(jobs.InstanceProperty)),
+			Events:                events,
+			Webhooks:              dispatcher,
 		},
 		VDCManagerInstance: vdcManager,
 	}
 
+	driftInterval := viper.GetDuration(infrastructure.DriftCheckIntervalProperty)
+	if viper.GetString(infrastructure.DriftCheckInstanceProperty) != result.DefaultFrontend.JobManager.Instance {
+		driftInterval = 0
+	}
+	infrastructure.NewDriftChecker(deployer, driftInterval).Start()
+
 	result.initializeRoutes()
 
 	if viper.GetBool(DitasUseDefaultFrontendConfigProperty) {
@@ -129,8 +154,22 @@ func (a *DitasFrontend) ValidateRequest(request blueprint.Blueprint) error {
 
 	for _, infra := range resources {
 		provider := infra.Provider
-		if provider.APIType != "cloudsigma" && provider.APIType != "kubernetes" {
-			return fmt.Errorf("Invalid provider type %s found in infrastructure %s. Only cloudsigma or kubernetes is supported", provider.APIType, infra.Name)
+		registration, ok := infrastructure.GetProvider(provider.APIType)
+		if !ok {
+			return fmt.Errorf("Invalid provider type %s found in infrastructure %s. Supported types are: %v", provider.APIType, infra.Name, infrastructure.ProviderTypes())
+		}
+
+		if registration.Validate != nil {
+			var modelInfra model.InfrastructureType
+			err := utils.TransformObject(infra, &modelInfra)
+			if err != nil {
+				return fmt.Errorf("Error reading infrastructure %s: %w", infra.Name, err)
+			}
+
+			err = registration.Validate(modelInfra)
+			if err != nil {
+				return fmt.Errorf("Invalid infrastructure %s: %w", infra.Name, err)
+			}
 		}
 
 		/*_, err := url.ParseRequestURI(provider.APIEndpoint)
@@ -146,7 +185,7 @@ func (a *DitasFrontend) ValidateRequest(request blueprint.Blueprint) error {
 			return fmt.Errorf("No resources provided for infrastructure %s", infra.Name)
 		}
 
-		if provider.APIType != "kubernetes" {
+		if provider.APIType != kubernetes.DeploymentType {
 			resNames := make([]string, 0, len(infra.Resources))
 			masterFound := false
 			storageSpace := int64(0)
@@ -268,7 +307,7 @@ func (a *DitasFrontend) createDep(w http.ResponseWriter, r *http.Request, p http
 		return
 	}
 
-	dep, err := a.VDCManagerInstance.DeployBlueprint(request)
+	dep, err := a.VDCManagerInstance.DeployBlueprint(r.Context(), request)
 
 	if err != nil {
 		log.WithError(err).Error("Error deploying blueprint")
@@ -338,7 +377,7 @@ func (a *DitasFrontend) moveVDC(w http.ResponseWriter, r *http.Request, p httpro
 		return
 	}
 
-	dep, err := a.VDCManagerInstance.CopyVDC(blueprintID, vdc, targetInfra)
+	dep, err := a.VDCManagerInstance.CopyVDC(r.Context(), blueprintID, vdc, targetInfra)
 	if err != nil {
 		restfrontend.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error moving VDC: %s", err.Error()))
 		return
@@ -423,7 +462,7 @@ func (a *DitasFrontend) createDatasource(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	result, err := a.VDCManagerInstance.DeployDatasource(blueprintID, vdcID, infraID, datasource, restfrontend.GetParameters(r.URL.Query()))
+	result, err := a.VDCManagerInstance.DeployDatasource(r.Context(), blueprintID, vdcID, infraID, datasource, restfrontend.GetParameters(r.URL.Query()))
 	if err != nil {
 		restfrontend.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -478,7 +517,7 @@ func (a *DitasFrontend) getVDCInfo(w http.ResponseWriter, r *http.Request, p htt
 		restfrontend.RespondWithError(w, http.StatusBadRequest, "VDC identifier is mandatory")
 	}
 
-	vdcInfo, err := a.VDCManagerInstance.GetVDCInformation(blueprintID, vdcID)
+	vdcInfo, err := a.VDCManagerInstance.GetVDCInformation(r.Context(), blueprintID, vdcID)
 	if err != nil {
 		restfrontend.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -555,7 +594,7 @@ func (a *DitasFrontend) createDal(w http.ResponseWriter, r *http.Request, p http
 		return
 	}
 
-	result, err := a.VDCManagerInstance.DeployDAL(blueprintID, vdcID, infraID, dalID)
+	result, err := a.VDCManagerInstance.DeployDAL(r.Context(), blueprintID, vdcID, infraID, dalID)
 	if err != nil {
 		restfrontend.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -641,7 +680,7 @@ func (a *DitasFrontend) setDal(w http.ResponseWriter, r *http.Request, p httprou
 		return
 	}
 
-	result, err := a.VDCManagerInstance.SetDALInUse(blueprintID, vdcID, infraID, dalID, ip)
+	result, err := a.VDCManagerInstance.SetDALInUse(r.Context(), blueprintID, vdcID, infraID, dalID, ip)
 	if err != nil {
 		restfrontend.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
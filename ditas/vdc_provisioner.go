@@ -19,6 +19,7 @@
 package ditas
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/provision/kubernetes"
 	"deployment-engine/utils"
@@ -76,7 +77,7 @@ func (p VDCProvisioner) GetImageVersion(imageName string) string {
 	return version
 }
 
-func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters, vdcID string) (model.Parameters, error) {
+func (p VDCProvisioner) CreateVDC(ctx context.Context, logger *logrus.Entry, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters, vdcID string) (model.Parameters, error) {
 	result := make(model.Parameters)
 	var err error
 
@@ -125,7 +126,7 @@ func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.Kuber
 		return result, errors.New("Unexpected type found for DALs information. Expected map[string]string")
 	}
 
-	kubeClient, err := kubernetes.NewClient(config.ConfigurationFile)
+	kubeClient, err := kubernetes.NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
 	}
@@ -218,7 +219,7 @@ func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.Kuber
 	configMap.Data["blueprint.json"] = string(strBp)
 
 	logger.Info("Creating or updating VDC config map")
-	_, err = kubeClient.CreateOrUpdateConfigMap(logger, DitasNamespace, &configMap)
+	_, err = kubeClient.CreateOrUpdateConfigMap(ctx, logger, DitasNamespace, &configMap)
 
 	if err != nil {
 		return result, err
@@ -280,7 +281,7 @@ func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.Kuber
 	vdcDeployment.Spec.Template.Spec.ShareProcessNamespace = &shareNamespace
 
 	logger.Info("Creating or updating VDC pod")
-	_, err = kubeClient.CreateOrUpdateDeployment(logger, DitasNamespace, &vdcDeployment)
+	_, err = kubeClient.CreateOrUpdateDeployment(ctx, logger, DitasNamespace, &vdcDeployment)
 
 	if err != nil {
 		return result, err
@@ -331,7 +332,7 @@ func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.Kuber
 	}
 
 	logger.Info("Creating or updating VDC service")
-	_, err = kubeClient.CreateOrUpdateService(logger, DitasNamespace, &vdcService)
+	_, err = kubeClient.CreateOrUpdateService(ctx, logger, DitasNamespace, &vdcService)
 	if err != nil {
 		return result, err
 	}
@@ -341,7 +342,7 @@ func (p VDCProvisioner) CreateVDC(logger *logrus.Entry, config *kubernetes.Kuber
 	return result, err
 }
 
-func (p VDCProvisioner) ModifyVDC(logger *logrus.Entry, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters, vdcID string) (model.Parameters, error) {
+func (p VDCProvisioner) ModifyVDC(ctx context.Context, logger *logrus.Entry, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters, vdcID string) (model.Parameters, error) {
 	result := make(model.Parameters)
 
 	hostsRaw, ok := args[HostsProperty]
@@ -354,7 +355,7 @@ func (p VDCProvisioner) ModifyVDC(logger *logrus.Entry, config *kubernetes.Kuber
 		return result, errors.New("Unexpected type found for list of hosts to modidy. Expected map[string]string")
 	}
 
-	kubeClient, err := kubernetes.NewClient(config.ConfigurationFile)
+	kubeClient, err := kubernetes.NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
 	}
@@ -381,7 +382,7 @@ func (p VDCProvisioner) ModifyVDC(logger *logrus.Entry, config *kubernetes.Kuber
 	return result, nil
 }
 
-func (p VDCProvisioner) Provision(config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p VDCProvisioner) Provision(ctx context.Context, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 	result := make(model.Parameters)
 	mode, ok := args.GetString(VDCProvisionModeProperty)
 	if !ok {
@@ -400,9 +401,9 @@ func (p VDCProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 
 	switch mode {
 	case VDCProvisionModeCreate:
-		return p.CreateVDC(logger, config, infra, args, vdcID)
+		return p.CreateVDC(ctx, logger, config, infra, args, vdcID)
 	case VDCProvisionModeModify:
-		return p.ModifyVDC(logger, config, infra, args, vdcID)
+		return p.ModifyVDC(ctx, logger, config, infra, args, vdcID)
 	default:
 		return result, fmt.Errorf("Unrecognized operation mode: %s", mode)
 	}
@@ -58,6 +58,9 @@ const (
 	DataAdministratorOwnerValue    = "DataAdministrator"
 	PersistenceTypeRookValue       = "rook"
 	PersistenceTypeGlusterFSValue  = "glusterfs"
+
+	// DitasPrincipal is recorded in the events of the operations performed by the VDC manager
+	DitasPrincipal = "ditas"
 )
 
 type VDCManager struct {
@@ -177,21 +180,24 @@ func (m *VDCManager) filterInfrastructures(ownerFilter string, infras []blueprin
 	return result
 }
 
-func (m *VDCManager) createDeployment(deployment model.Deployment) (model.DeploymentInfo, error) {
-	deploymentInfo, err := m.DeploymentController.CreateDeployment(deployment)
+func (m *VDCManager) createDeployment(ctx context.Context, deployment model.Deployment) (model.DeploymentInfo, error) {
+	deploymentInfo, err := m.DeploymentController.CreateDeploymentWithOptions(ctx, deployment, infrastructure.DeploymentOptions{
+		Autoclean: m.DeploymentController.Autoclean,
+		Principal: DitasPrincipal,
+	})
 	if err != nil {
 		toDelete := make([]string, len(deploymentInfo))
 		for i, infra := range deploymentInfo {
 			toDelete[i] = infra.ID
 		}
-		errDelete := m.DeploymentController.DeleteDeployment(toDelete)
+		errDelete := m.DeploymentController.DeleteDeployment(ctx, DitasPrincipal, toDelete)
 		if errDelete != nil {
 			return deploymentInfo, fmt.Errorf("Error in deployment: %w and error cleaning deployment: %w", err, errDelete)
 		}
 		return deploymentInfo, fmt.Errorf("Error creating deployment: %w Partial deployment deleted", err)
 	}
 
-	deploymentInfo, err = m.provisionKubernetes(deploymentInfo)
+	deploymentInfo, err = m.provisionKubernetes(ctx, deploymentInfo)
 	if err != nil {
 		log.WithError(err).Error("Error deploying kubernetes. Trying to clean deployment")
 
@@ -207,25 +213,25 @@ func (m *VDCManager) createDeployment(deployment model.Deployment) (model.Deploy
 	return deploymentInfo, nil
 }
 
-func (m *VDCManager) DeployVMD(infra model.InfrastructureDeploymentInfo, blueprintID string) (string, error) {
+func (m *VDCManager) DeployVMD(ctx context.Context, infra model.InfrastructureDeploymentInfo, blueprintID string) (string, error) {
 	args := make(model.Parameters)
 	args[BlueprintIDProperty] = blueprintID
 	args[VariablesProperty] = m.getVarsFromConfig()
-	_, _, err := m.ProvisionerController.Provision(infra.ID, "vdm", args, "kubernetes")
+	_, _, err := m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "vdm", args, "kubernetes")
 	if err != nil {
 		return "", utils.WrapLogAndReturnError(log.WithField("infrastructure", infra.ID), fmt.Sprintf("Error deploying VDM in infrastructure %s", infra.ID), err)
 	}
 	return infra.GetMasterIP()
 }
 
-func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, error) {
+func (m *VDCManager) DeployBlueprint(ctx context.Context, bp blueprint.Blueprint) (VDCInformation, error) {
 	var vdcInfo VDCInformation
 	if bp.ID == "" {
 		return vdcInfo, errors.New("Invalid blueprint. Id is mandatory")
 	}
 
 	var dataOwnerDeployment model.DeploymentInfo
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": bp.ID}).Decode(&vdcInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": bp.ID}).Decode(&vdcInfo)
 	if err != nil {
 
 		vdcInfo = VDCInformation{
@@ -242,7 +248,7 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 			return vdcInfo, fmt.Errorf("Error transforming resources from blueprint: %w", err)
 		}
 
-		dataOwnerDeployment, err = m.createDeployment(deployment)
+		dataOwnerDeployment, err = m.createDeployment(ctx, deployment)
 		if err != nil {
 			return vdcInfo, fmt.Errorf("Error creating Data Administrator clusters: %w", err)
 		}
@@ -252,7 +258,7 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 			vdcInfo.DataOwnerDeployment[i] = infra.ID
 		}
 
-		_, err = m.Collection.InsertOne(context.Background(), vdcInfo)
+		_, err = m.Collection.InsertOne(ctx, vdcInfo)
 		if err != nil {
 			log.WithError(err).Error("Error saving blueprint VDC information")
 			return vdcInfo, err
@@ -274,13 +280,13 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 	if vdcInfo.VDMIP == "" {
 		infra := m.findDefaultInfra(dataOwnerDeployment)
 
-		vdmIP, err := m.DeployVMD(infra, vdcInfo.ID)
+		vdmIP, err := m.DeployVMD(ctx, infra, vdcInfo.ID)
 		if err != nil {
 			return vdcInfo, fmt.Errorf("Error deploying VDM in infrastructure %s: %w", infra.ID, err)
 		}
 		vdcInfo.VDMIP = vdmIP
 		vdcInfo.VDMInfraID = infra.ID
-		_, err = m.Collection.ReplaceOne(context.Background(), bson.M{"_id": vdcInfo.ID}, vdcInfo, options.Replace())
+		_, err = m.Collection.ReplaceOne(ctx, bson.M{"_id": vdcInfo.ID}, vdcInfo, options.Replace())
 		if err != nil {
 			return vdcInfo, fmt.Errorf("Error updating VDM information: %w", err)
 		}
@@ -295,7 +301,7 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 		if err != nil {
 			return vdcInfo, fmt.Errorf("Error transforming application developer resources: %w", err)
 		}
-		appDeveloperDeploymentInfo, err = m.createDeployment(appDeveloperDeployment)
+		appDeveloperDeploymentInfo, err = m.createDeployment(ctx, appDeveloperDeployment)
 		if err != nil {
 			return vdcInfo, fmt.Errorf("Error creating Application Developer cluster: %w", err)
 		}
@@ -326,7 +332,7 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 		vdmIP = vdcInfo.VDMIP
 	}
 
-	tombstonePort, cafPort, _, err := m.DeployVDC(bp, infra, vdcID, vdmIP, make(map[string]string))
+	tombstonePort, cafPort, _, err := m.DeployVDC(ctx, bp, infra, vdcID, vdmIP, make(map[string]string))
 	if err != nil {
 		return vdcInfo, err
 	}
@@ -359,7 +365,7 @@ func (m *VDCManager) DeployBlueprint(bp blueprint.Blueprint) (VDCInformation, er
 	vdcInfo.VDCs[vdcID] = config
 	vdcInfo.NumVDCs++
 
-	_, err = m.Collection.ReplaceOne(context.Background(), bson.M{"_id": vdcInfo.ID}, vdcInfo, options.Replace())
+	_, err = m.Collection.ReplaceOne(ctx, bson.M{"_id": vdcInfo.ID}, vdcInfo, options.Replace())
 	if err != nil {
 		return vdcInfo, fmt.Errorf("Error saving VDC information: %w", err)
 	}
@@ -389,7 +395,7 @@ func (m *VDCManager) findDefaultInfra(deployments ...model.DeploymentInfo) model
 			ansible.AnsibleWaitForSSHReadyProperty: []string{"false"},
 		}
 
-		return m.ProvisionerController.Provision(deployment.ID, infra.ID, solution, args, "kubernetes")
+		return m.ProvisionerController.Provision(ctx, DitasPrincipal, deployment.ID, infra.ID, solution, args, "kubernetes")
 	}
 	return deployment, nil
 }*/
@@ -403,14 +409,14 @@ func (m *VDCManager) getVarsFromConfig() model.Parameters {
 	return result
 }
 
-func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
+func (m *VDCManager) doProvisionKubernetes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
 	if infra.Provider.APIType != "kubernetes" {
 		var dep model.InfrastructureDeploymentInfo
 
 		logger := log.WithField("infrastructure", infra.ID)
 
 		logger.Info("Waiting for SSH ports to be ready")
-		err := utils.WaitForSSHReady(infra, true)
+		err := utils.WaitForSSHReady(ctx, infra, true)
 		if err != nil {
 			return dep, fmt.Errorf("Error waiting for ssh port to be ready: %w", err)
 		}
@@ -420,19 +426,19 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 
 		// 1. Add new keys to .ssh/known_hosts
 		args := make(model.Parameters)
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "hosts", args, "")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "hosts", args, "")
 
 		logger.Info("Installing Kubernetes")
 
 		// 2. Deploy Kubernetes
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "kubernetes", args, "")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "kubernetes", args, "")
 		//err := m.provisionKubernetesWithKubespray(deployment.ID, infra)
 		if err != nil {
 			return dep, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error deploying kubernetes on infrastructure %s", infra.ID), err)
 		}
 
 		// 3. Deploy Helm (needed for fluentd and very convenient to deploy software)
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "helm", args, "")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "helm", args, "")
 		if err != nil {
 			return dep, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error deploying helm in infrastructure %s", infra.ID), err)
 		}
@@ -462,7 +468,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 			}
 
 			// 4. Deploy fluentd (Log Analysis Service)
-			dep, _, err = m.ProvisionerController.Provision(infra.ID, "fluentd", args, "")
+			dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "fluentd", args, "")
 			if err != nil {
 				return dep, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error installing fluentd at infrastructure %s", infra.ID), err)
 			}
@@ -470,7 +476,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 
 		// 5. Deploy traefik (Ingress manager to expose metrics endpoints without opening tons of ports. May provide load balancing if necessary)
 		logger.Info("Deploying Traefik to the cluster")
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "traefik", args, "kubernetes")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "traefik", args, "kubernetes")
 		if err != nil {
 			return dep, utils.WrapLogAndReturnError(logger, "Error deploying traefik ingress controller", err)
 		}
@@ -478,7 +484,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 
 		// 6. Deploy Kube State Metrics to expose monitoring data of the cluster to Data Analytics
 		logger.Info("Deploying Kube State Metrics")
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "kube-state-metrics", args, "kubernetes")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "kube-state-metrics", args, "kubernetes")
 		if err != nil {
 			return dep, utils.WrapLogAndReturnError(logger, "Error deploying Kube State Metrics", err)
 		}
@@ -493,7 +499,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 		args[kubernetes.TraefikRedirectionServiceNamespace] = "kube-system"
 
 		logger.Info("Exposing Kube State Merrics through Traefik")
-		dep, _, err = m.ProvisionerController.Provision(infra.ID, "traefik", args, "kubernetes")
+		dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "traefik", args, "kubernetes")
 		if err != nil {
 			return dep, utils.WrapLogAndReturnError(logger, "Error exposing Kube State Metrics", err)
 		}
@@ -513,7 +519,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 			if persistenceToDeploy != "" {
 				// 8. Deploy persistence solution. Rook (moderately fast deployment ~3-5min) or GlusterFS (moderately slow ~10-12min)
 				logger.Infof("Deploying persistence solution %s", persistenceToDeploy)
-				dep, _, err = m.ProvisionerController.Provision(infra.ID, persistenceToDeploy, args, framework)
+				dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, persistenceToDeploy, args, framework)
 				if err != nil {
 					return dep, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error deploying %s to kubernetes cluster %s", persistenceToDeploy, infra.ID), err)
 				}
@@ -530,7 +536,7 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 					args[kubernetes.TraefikRedirectionServiceNamespace] = "rook-ceph"
 
 					logger.Info("Exposing Rook metrics through Traefik")
-					dep, _, err = m.ProvisionerController.Provision(infra.ID, "traefik", args, "kubernetes")
+					dep, _, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "traefik", args, "kubernetes")
 					if err != nil {
 						return dep, utils.WrapLogAndReturnError(logger, "Error exposing rook metrics", err)
 					}
@@ -544,8 +550,8 @@ func (m *VDCManager) doProvisionKubernetes(infra model.InfrastructureDeploymentI
 	return infra, nil
 }
 
-func (m *VDCManager) provisionKubernetesParallel(infra model.InfrastructureDeploymentInfo, c chan KubernetesProvisionResult) {
-	res, err := m.doProvisionKubernetes(infra)
+func (m *VDCManager) provisionKubernetesParallel(ctx context.Context, infra model.InfrastructureDeploymentInfo, c chan KubernetesProvisionResult) {
+	res, err := m.doProvisionKubernetes(ctx, infra)
 	c <- KubernetesProvisionResult{
 		Infra: res,
 		Error: err,
@@ -553,13 +559,13 @@ func (m *VDCManager) provisionKubernetesParallel(infra model.InfrastructureDeplo
 	return
 }
 
-func (m *VDCManager) provisionKubernetes(deployment model.DeploymentInfo) (model.DeploymentInfo, error) {
+func (m *VDCManager) provisionKubernetes(ctx context.Context, deployment model.DeploymentInfo) (model.DeploymentInfo, error) {
 	var err error
 	result := make(model.DeploymentInfo, 0)
 	channel := make(chan KubernetesProvisionResult, len(deployment))
 
 	for _, infra := range deployment {
-		go m.provisionKubernetesParallel(infra, channel)
+		go m.provisionKubernetesParallel(ctx, infra, channel)
 	}
 
 	for remaining := len(deployment); remaining > 0; remaining-- {
@@ -575,7 +581,7 @@ func (m *VDCManager) provisionKubernetes(deployment model.DeploymentInfo) (model
 	return result, err
 }
 
-func (m *VDCManager) DeployVDC(blueprint blueprint.Blueprint, infra model.InfrastructureDeploymentInfo, vdcID, vdmIP string, dals map[string]string) (int, int, model.InfrastructureDeploymentInfo, error) {
+func (m *VDCManager) DeployVDC(ctx context.Context, blueprint blueprint.Blueprint, infra model.InfrastructureDeploymentInfo, vdcID, vdmIP string, dals map[string]string) (int, int, model.InfrastructureDeploymentInfo, error) {
 
 	var deployment model.InfrastructureDeploymentInfo
 	kubeConfigRaw, ok := infra.Products["kubernetes"]
@@ -589,10 +595,10 @@ func (m *VDCManager) DeployVDC(blueprint blueprint.Blueprint, infra model.Infras
 		return -1, -1, deployment, fmt.Errorf("Error reading kubernetes configuration from infrastructure %s: %w", infra.ID, err)
 	}
 
-	return m.doDeployVDC(infra, blueprint, vdcID, vdmIP, dals)
+	return m.doDeployVDC(ctx, infra, blueprint, vdcID, vdmIP, dals)
 }
 
-func (m *VDCManager) doDeployVDC(infra model.InfrastructureDeploymentInfo, bp blueprint.Blueprint, vdcID, vdmIP string, dals map[string]string) (int, int, model.InfrastructureDeploymentInfo, error) {
+func (m *VDCManager) doDeployVDC(ctx context.Context, infra model.InfrastructureDeploymentInfo, bp blueprint.Blueprint, vdcID, vdmIP string, dals map[string]string) (int, int, model.InfrastructureDeploymentInfo, error) {
 
 	args := make(model.Parameters)
 	args[BlueprintProperty] = bp
@@ -607,7 +613,7 @@ func (m *VDCManager) doDeployVDC(infra model.InfrastructureDeploymentInfo, bp bl
 		bp.CookbookAppendix.Resources.Infrastructures[i] = infra
 	}
 
-	infra, out, err := m.ProvisionerController.Provision(infra.ID, "vdc", args, "kubernetes")
+	infra, out, err := m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, "vdc", args, "kubernetes")
 	tombstonePort := -1
 	cafPort := -1
 	ok := false
@@ -655,10 +661,10 @@ func (m *VDCManager) findVDCInfrastructure(vdcInfo VDCInformation, vdcID, target
 	return targetInfra, nil
 }
 
-func (m *VDCManager) CopyVDC(blueprintID, vdcID, targetInfraID string) (VDCConfiguration, error) {
+func (m *VDCManager) CopyVDC(ctx context.Context, blueprintID, vdcID, targetInfraID string) (VDCConfiguration, error) {
 	var vdcInfo VDCInformation
 	var vdcConfig VDCConfiguration
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": blueprintID}).Decode(&vdcInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": blueprintID}).Decode(&vdcInfo)
 	if err != nil {
 		return vdcConfig, fmt.Errorf("Error finding deployment for blueprint %s: %w", blueprintID, err)
 	}
@@ -690,7 +696,7 @@ func (m *VDCManager) CopyVDC(blueprintID, vdcID, targetInfraID string) (VDCConfi
 		return vdcConfig, fmt.Errorf("Error finding target infrastructure %s: %w", targetInfraID, err)
 	}
 
-	tombstonePort, cafPort, targetInfra, err := m.DeployVDC(bp, targetInfra, vdcID, vdmIP, vdcConfig.DALsInUse)
+	tombstonePort, cafPort, targetInfra, err := m.DeployVDC(ctx, bp, targetInfra, vdcID, vdmIP, vdcConfig.DALsInUse)
 	if err != nil {
 		return vdcConfig, fmt.Errorf("Error creating copy of VDC %s in infrastructure %s: %w", vdcID, targetInfra.ID, err)
 	}
@@ -708,7 +714,7 @@ func (m *VDCManager) CopyVDC(blueprintID, vdcID, targetInfraID string) (VDCConfi
 
 	vdcInfo.VDCs[vdcID] = vdcConfig
 
-	updRes := m.Collection.FindOneAndReplace(context.Background(), bson.M{"_id": blueprintID}, vdcInfo, options.FindOneAndReplace())
+	updRes := m.Collection.FindOneAndReplace(ctx, bson.M{"_id": blueprintID}, vdcInfo, options.FindOneAndReplace())
 
 	if updRes.Err() != nil {
 		return vdcConfig, fmt.Errorf("Error updating VDC information for blueprint %s: %w", blueprintID, updRes.Err())
@@ -742,7 +748,7 @@ func (m *VDCManager) doDeleteVDC(infra *model.InfrastructureDeploymentInfo, vdcI
 	return err
 }
 
-func (m *VDCManager) saveDatasourceInformation(dsID, dsType, vdcID string, infra model.InfrastructureDeploymentInfo, params model.Parameters, vdcInformation VDCInformation) error {
+func (m *VDCManager) saveDatasourceInformation(ctx context.Context, dsID, dsType, vdcID string, infra model.InfrastructureDeploymentInfo, params model.Parameters, vdcInformation VDCInformation) error {
 	vdcInfo, ok := vdcInformation.VDCs[vdcID]
 	if !ok {
 		return fmt.Errorf("Can't find VDC %s in the abstract blueprint information", vdcID)
@@ -826,12 +832,12 @@ func (m *VDCManager) saveDatasourceInformation(dsID, dsType, vdcID string, infra
 	vdcInfo.Infrastructures[infra.ID] = infraInfo
 	vdcInformation.VDCs[vdcID] = vdcInfo
 
-	_, err := m.Collection.ReplaceOne(context.Background(), bson.M{"_id": vdcInformation.ID}, vdcInformation, options.Replace())
+	_, err := m.Collection.ReplaceOne(ctx, bson.M{"_id": vdcInformation.ID}, vdcInformation, options.Replace())
 
 	return err
 }
 
-func (m *VDCManager) DeployDatasource(blueprintID, vdcID, infraID, datasourceType string, args model.Parameters) (model.Parameters, error) {
+func (m *VDCManager) DeployDatasource(ctx context.Context, blueprintID, vdcID, infraID, datasourceType string, args model.Parameters) (model.Parameters, error) {
 	var blueprintInfo VDCInformation
 	var result model.Parameters
 
@@ -840,7 +846,7 @@ func (m *VDCManager) DeployDatasource(blueprintID, vdcID, infraID, datasourceTyp
 		return result, errors.New("A unique identifier is expected in the query parameter 'id'")
 	}
 
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
 	if err != nil {
 		return result, fmt.Errorf("Can't find information for blueprint %s: %s", blueprintID, err.Error())
 	}
@@ -867,12 +873,12 @@ func (m *VDCManager) DeployDatasource(blueprintID, vdcID, infraID, datasourceTyp
 
 	logger := log.WithField("datasource", datasourceType)
 
-	_, result, err = m.ProvisionerController.Provision(infra.ID, datasourceType, args, "kubernetes")
+	_, result, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infra.ID, datasourceType, args, "kubernetes")
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error creating datasource", err)
 	}
 
-	err = m.saveDatasourceInformation(dsID, datasourceType, vdcID, infra, result, blueprintInfo)
+	err = m.saveDatasourceInformation(ctx, dsID, datasourceType, vdcID, infra, result, blueprintInfo)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error saving datasource configuration", err)
 	}
@@ -880,10 +886,10 @@ func (m *VDCManager) DeployDatasource(blueprintID, vdcID, infraID, datasourceTyp
 	return result, err
 }
 
-func (m *VDCManager) GetVDCInformation(blueprintID, vdcID string) (VDCConfiguration, error) {
+func (m *VDCManager) GetVDCInformation(ctx context.Context, blueprintID, vdcID string) (VDCConfiguration, error) {
 	var vdcInfo VDCInformation
 	var result VDCConfiguration
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": blueprintID}, options.FindOne()).Decode(&vdcInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": blueprintID}, options.FindOne()).Decode(&vdcInfo)
 	if err != nil {
 		return result, fmt.Errorf("Error getting blueprint %s information: %w", blueprintID, err)
 	}
@@ -896,7 +902,7 @@ func (m *VDCManager) GetVDCInformation(blueprintID, vdcID string) (VDCConfigurat
 	return result, nil
 }
 
-func (m *VDCManager) DeployDAL(blueprintID, vdcID, infraID, dalID string) (VDCConfiguration, error) {
+func (m *VDCManager) DeployDAL(ctx context.Context, blueprintID, vdcID, infraID, dalID string) (VDCConfiguration, error) {
 	var blueprintInfo VDCInformation
 	var result model.Parameters
 
@@ -907,7 +913,7 @@ func (m *VDCManager) DeployDAL(blueprintID, vdcID, infraID, dalID string) (VDCCo
 		"dal":       dalID,
 	})
 
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
 	if err != nil {
 		return VDCConfiguration{}, fmt.Errorf("Can't find information for blueprint %s: %s", blueprintID, err.Error())
 	}
@@ -950,7 +956,7 @@ func (m *VDCManager) DeployDAL(blueprintID, vdcID, infraID, dalID string) (VDCCo
 	args[SecretsProperty] = secrets
 	args[DALIdentifierProperty] = dalID
 
-	_, result, err = m.ProvisionerController.Provision(infraID, "dal", args, "kubernetes")
+	_, result, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, infraID, "dal", args, "kubernetes")
 	if err != nil {
 		return vdcInfo, fmt.Errorf("Error deploying DAL %s: %w", dalID, err)
 	}
@@ -966,7 +972,7 @@ func (m *VDCManager) DeployDAL(blueprintID, vdcID, infraID, dalID string) (VDCCo
 		infraInfo.DALInformation[dalID] = ports
 		vdcInfo.Infrastructures[infraID] = infraInfo
 		blueprintInfo.VDCs[vdcID] = vdcInfo
-		_, err := m.Collection.ReplaceOne(context.Background(), bson.M{"_id": blueprintInfo.ID}, blueprintInfo, options.Replace())
+		_, err := m.Collection.ReplaceOne(ctx, bson.M{"_id": blueprintInfo.ID}, blueprintInfo, options.Replace())
 		if err != nil {
 			return vdcInfo, utils.WrapLogAndReturnError(logger, "Error saving DAL information to database", err)
 		}
@@ -975,11 +981,11 @@ func (m *VDCManager) DeployDAL(blueprintID, vdcID, infraID, dalID string) (VDCCo
 	return vdcInfo, err
 }
 
-func (m *VDCManager) SetDALInUse(blueprintID, vdcID, vdcInfraID, dalID, dalIP string) (model.Parameters, error) {
+func (m *VDCManager) SetDALInUse(ctx context.Context, blueprintID, vdcID, vdcInfraID, dalID, dalIP string) (model.Parameters, error) {
 	var blueprintInfo VDCInformation
 	var result model.Parameters
 
-	err := m.Collection.FindOne(context.Background(), bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
+	err := m.Collection.FindOne(ctx, bson.M{"_id": blueprintID}).Decode(&blueprintInfo)
 	if err != nil {
 		return result, fmt.Errorf("Can't find information for blueprint %s: %s", blueprintID, err.Error())
 	}
@@ -1000,7 +1006,7 @@ func (m *VDCManager) SetDALInUse(blueprintID, vdcID, vdcInfraID, dalID, dalIP st
 	args[VDCProvisionModeProperty] = VDCProvisionModeModify
 	args[VDCIDProperty] = vdcID
 	args[HostsProperty] = vdcInfo.DALsInUse
-	_, result, err = m.ProvisionerController.Provision(vdcInfraID, "vdc", args, "kubernetes")
+	_, result, err = m.ProvisionerController.Provision(ctx, DitasPrincipal, vdcInfraID, "vdc", args, "kubernetes")
 
 	if err != nil {
 		return result, fmt.Errorf("Error updating DAL information of VDC %s: %w", vdcID, err)
@@ -1008,7 +1014,7 @@ func (m *VDCManager) SetDALInUse(blueprintID, vdcID, vdcInfraID, dalID, dalIP st
 
 	blueprintInfo.VDCs[vdcID] = vdcInfo
 
-	_, err = m.Collection.ReplaceOne(context.Background(), bson.M{"_id": blueprintInfo.ID}, blueprintInfo, options.Replace())
+	_, err = m.Collection.ReplaceOne(ctx, bson.M{"_id": blueprintInfo.ID}, blueprintInfo, options.Replace())
 	if err != nil {
 		return result, fmt.Errorf("Error updating information about abstract blueprint %s: %w", blueprintID, err)
 	}
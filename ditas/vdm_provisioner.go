@@ -19,6 +19,7 @@
 package ditas
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/provision/kubernetes"
 	"deployment-engine/utils"
@@ -72,7 +73,7 @@ func (p VDMProvisioner) GetImageVersion(imageName string) string {
 	return version
 }
 
-func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p VDMProvisioner) Provision(ctx context.Context, config *kubernetes.KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	logger := logrus.WithFields(logrus.Fields{
@@ -126,7 +127,7 @@ func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 		return result, utils.WrapLogAndReturnError(logger, "Error reading configuration map", err)
 	}
 
-	kubeClient, err := kubernetes.NewClient(config.ConfigurationFile)
+	kubeClient, err := kubernetes.NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		logger.WithError(err).Error("Error getting kubernetes client")
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
@@ -141,7 +142,7 @@ func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	}
 
 	logger.Info("Creating or updating VDM config map")
-	_, err = kubeClient.CreateOrUpdateConfigMap(logger, DitasNamespace, &configMap)
+	_, err = kubeClient.CreateOrUpdateConfigMap(ctx, logger, DitasNamespace, &configMap)
 
 	if err != nil {
 		return result, err
@@ -188,7 +189,7 @@ func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	}
 
 	logger.Infof("Creating PVC %s", pvc.Name)
-	_, err = kubeClient.CreateOrUpdatePVC(logger, DitasNamespace, &pvc)
+	_, err = kubeClient.CreateOrUpdatePVC(ctx, logger, DitasNamespace, &pvc)
 	if err != nil {
 		return result, fmt.Errorf("Error creating PVC %s: %w", pvc.Name, err)
 	}
@@ -196,7 +197,7 @@ func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	vdmDeployment := kubernetes.GetDeploymentDescription("vdm", int32(1), int64(30), vdmLabels, imageSet, DitasVDMConfigMapName, "/etc/ditas", repSecrets, []kubernetes.VolumeData{vdmPVC})
 
 	logger.Info("Creating or updating VDM pod")
-	_, err = kubeClient.CreateOrUpdateDeployment(logger, DitasNamespace, &vdmDeployment)
+	_, err = kubeClient.CreateOrUpdateDeployment(ctx, logger, DitasNamespace, &vdmDeployment)
 
 	if err != nil {
 		return result, err
@@ -264,7 +265,7 @@ func (p VDMProvisioner) Provision(config *kubernetes.KubernetesConfiguration, in
 	}
 
 	logger.Info("Creating or updating VDM service")
-	_, err = kubeClient.CreateOrUpdateService(logger, DitasNamespace, &vdmService)
+	_, err = kubeClient.CreateOrUpdateService(ctx, logger, DitasNamespace, &vdmService)
 	if err != nil {
 		return result, err
 	}
@@ -0,0 +1,245 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"context"
+	"deployment-engine/model"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+const (
+	APIVersion    = "2016-11-15"
+	DefaultRegion = "us-east-1"
+)
+
+var regionalEndpoint = regexp.MustCompile(`^ec2\.([a-z0-9-]+)\.amazonaws\.com`)
+
+type AWSError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e AWSError) Error() string {
+	return fmt.Sprintf("Error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Filter is a filter of the Describe operations of the EC2 API
+type Filter struct {
+	Name   string
+	Values []string
+}
+
+// TagFilter returns a filter of the resources with a tag with the value passed as parameter
+func TagFilter(key, value string) Filter {
+	return Filter{Name: "tag:" + key, Values: []string{value}}
+}
+
+// Client accesses the EC2 Query API signing the requests with the access keys of an account
+type Client struct {
+	httpClient *resty.Client
+	endpoint   string
+	signer     signer
+}
+
+// ResolveEndpoint returns the URL and the region of an endpoint, which can be a region name or the URL of the EC2 API. The region of URLs of other EC2 compatible services is the default one.
+func ResolveEndpoint(endpoint string) (string, string) {
+	if endpoint == "" {
+		endpoint = DefaultRegion
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		return fmt.Sprintf("https://ec2.%s.amazonaws.com", endpoint), endpoint
+	}
+
+	region := DefaultRegion
+	if parsed, err := url.Parse(endpoint); err == nil {
+		if match := regionalEndpoint.FindStringSubmatch(parsed.Hostname()); match != nil {
+			region = match[1]
+		}
+	}
+	return endpoint, region
+}
+
+// NewClient creates a new client for an endpoint, as accepted by ResolveEndpoint. The user name of the credentials is the access key identifier and the password is the secret access key.
+func NewClient(endpoint string, credentials model.BasicAuthSecret, debug bool) *Client {
+	endpointURL, region := ResolveEndpoint(endpoint)
+	return &Client{
+		httpClient: resty.New().SetDebug(debug),
+		endpoint:   endpointURL,
+		signer: signer{
+			accessKey: credentials.Username,
+			secretKey: credentials.Password,
+			region:    region,
+			service:   "ec2",
+		},
+	}
+}
+
+func (c *Client) execute(ctx context.Context, action string, params url.Values, result interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("Action", action)
+	params.Set("Version", APIVersion)
+	payload := []byte(params.Encode())
+
+	requestURL, err := url.Parse(c.endpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid EC2 endpoint %s: %w", c.endpoint, err)
+	}
+
+	now := time.Now().UTC()
+	headers := map[string]string{
+		"Host":         requestURL.Host,
+		"Content-Type": "application/x-www-form-urlencoded; charset=utf-8",
+		"X-Amz-Date":   now.Format(amzDateFormat),
+	}
+	authorization := c.signer.authorization(resty.MethodPost, requestURL, headers, payload, now)
+
+	request := c.httpClient.R().SetContext(ctx).SetBody(payload).SetHeader("Authorization", authorization)
+	for name, value := range headers {
+		if name != "Host" {
+			request.SetHeader(name, value)
+		}
+	}
+
+	response, err := request.Post(c.endpoint)
+	if err != nil {
+		return fmt.Errorf("Error executing %s action: %w", action, err)
+	}
+
+	if response.IsError() {
+		awsErr := AWSError{
+			StatusCode: response.StatusCode(),
+			Message:    response.String(),
+		}
+		var errResponse ErrorResponse
+		if xml.Unmarshal(response.Body(), &errResponse) == nil && len(errResponse.Errors) > 0 {
+			awsErr.Code = errResponse.Errors[0].Code
+			awsErr.Message = errResponse.Errors[0].Message
+		}
+		return awsErr
+	}
+
+	if result != nil {
+		err = xml.Unmarshal(response.Body(), result)
+		if err != nil {
+			return fmt.Errorf("Error decoding response of %s action: %w", action, err)
+		}
+	}
+
+	return nil
+}
+
+func addList(params url.Values, prefix string, values []string) {
+	for i, value := range values {
+		params.Set(fmt.Sprintf("%s.%d", prefix, i+1), value)
+	}
+}
+
+func addFilters(params url.Values, filters []Filter) {
+	for i, filter := range filters {
+		prefix := fmt.Sprintf("Filter.%d", i+1)
+		params.Set(prefix+".Name", filter.Name)
+		addList(params, prefix+".Value", filter.Values)
+	}
+}
+
+// addTagSpecification tags the resources of a type created in the request with the tags passed as parameter
+func addTagSpecification(params url.Values, index int, resourceType string, tags map[string]string) {
+	prefix := fmt.Sprintf("TagSpecification.%d", index)
+	params.Set(prefix+".ResourceType", resourceType)
+	i := 1
+	for key, value := range tags {
+		params.Set(fmt.Sprintf("%s.Tag.%d.Key", prefix, i), key)
+		params.Set(fmt.Sprintf("%s.Tag.%d.Value", prefix, i), value)
+		i++
+	}
+}
+
+func (c *Client) ImportKeyPair(ctx context.Context, name, publicKey string) (KeyPairInfo, error) {
+	var result KeyPairInfo
+	params := url.Values{
+		"KeyName":           []string{name},
+		"PublicKeyMaterial": []string{base64.StdEncoding.EncodeToString([]byte(publicKey))},
+	}
+	err := c.execute(ctx, "ImportKeyPair", params, &result)
+	return result, err
+}
+
+func (c *Client) DeleteKeyPair(ctx context.Context, name string) error {
+	return c.execute(ctx, "DeleteKeyPair", url.Values{"KeyName": []string{name}}, nil)
+}
+
+// RunInstances launches the instances described by the parameters passed
+func (c *Client) RunInstances(ctx context.Context, params url.Values) ([]Instance, error) {
+	var result RunInstancesResponse
+	err := c.execute(ctx, "RunInstances", params, &result)
+	return result.Instances, err
+}
+
+func (c *Client) DescribeInstances(ctx context.Context, filters ...Filter) ([]Instance, error) {
+	var result DescribeInstancesResponse
+	params := url.Values{}
+	addFilters(params, filters)
+	err := c.execute(ctx, "DescribeInstances", params, &result)
+	return result.Instances(), err
+}
+
+func (c *Client) TerminateInstances(ctx context.Context, ids ...string) error {
+	params := url.Values{}
+	addList(params, "InstanceId", ids)
+	return c.execute(ctx, "TerminateInstances", params, nil)
+}
+
+func (c *Client) DescribeVolumes(ctx context.Context, filters ...Filter) ([]Volume, error) {
+	var result DescribeVolumesResponse
+	params := url.Values{}
+	addFilters(params, filters)
+	err := c.execute(ctx, "DescribeVolumes", params, &result)
+	return result.Volumes, err
+}
+
+func (c *Client) DeleteVolume(ctx context.Context, id string) error {
+	return c.execute(ctx, "DeleteVolume", url.Values{"VolumeId": []string{id}}, nil)
+}
+
+func (c *Client) DescribeImages(ctx context.Context, ids ...string) ([]Image, error) {
+	var result DescribeImagesResponse
+	params := url.Values{}
+	addList(params, "ImageId", ids)
+	err := c.execute(ctx, "DescribeImages", params, &result)
+	return result.Images, err
+}
+
+func (c *Client) DescribeInstanceTypes(ctx context.Context, types ...string) ([]InstanceTypeInfo, error) {
+	var result DescribeInstanceTypesResponse
+	params := url.Values{}
+	addList(params, "InstanceType", types)
+	err := c.execute(ctx, "DescribeInstanceTypes", params, &result)
+	return result.InstanceTypes, err
+}
@@ -0,0 +1,61 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestSignature checks the signer against the get-vanilla case of the AWS Signature Version 4 test suite
+func TestSignature(t *testing.T) {
+	s := signer{
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "us-east-1",
+		service:   "service",
+	}
+
+	date := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	requestURL, _ := url.Parse("https://example.amazonaws.com/")
+	authorization := s.authorization("GET", requestURL, map[string]string{
+		"Host":       "example.amazonaws.com",
+		"X-Amz-Date": "20150830T123600Z",
+	}, []byte{}, date)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if authorization != expected {
+		t.Fatalf("Unexpected authorization header:\n%s\nexpected:\n%s", authorization, expected)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	cases := map[string][2]string{
+		"":                                       [2]string{"https://ec2.us-east-1.amazonaws.com", "us-east-1"},
+		"eu-west-1":                              [2]string{"https://ec2.eu-west-1.amazonaws.com", "eu-west-1"},
+		"https://ec2.eu-central-1.amazonaws.com": [2]string{"https://ec2.eu-central-1.amazonaws.com", "eu-central-1"},
+		"http://localhost:4566":                  [2]string{"http://localhost:4566", DefaultRegion},
+	}
+
+	for endpoint, expected := range cases {
+		resolved, region := ResolveEndpoint(endpoint)
+		if resolved != expected[0] || region != expected[1] {
+			t.Fatalf("Endpoint %s resolved to %s in %s but expected %v", endpoint, resolved, region, expected)
+		}
+	}
+}
@@ -0,0 +1,802 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DeploymentType = "aws"
+
+	// SubnetProperty is the infrastructure property with the subnet in which the instances are launched. The default subnet of the account is used if it's not set.
+	SubnetProperty = "aws_subnet_id"
+	// SecurityGroupsProperty is the infrastructure property with the comma separated list of security groups of the instances
+	SecurityGroupsProperty = "aws_security_group_ids"
+	// UsernameProperty is the infrastructure property with the user name to access the instances. It depends on the AMI used.
+	UsernameProperty = "aws_username"
+
+	DefaultUsername = "ubuntu"
+
+	// InfrastructureTag is the tag of the instances and volumes with the identifier of the infrastructure they belong to
+	InfrastructureTag = "deployment-engine:infrastructure"
+
+	SSDVolumeType = "gp2"
+	HDDVolumeType = "st1"
+
+	// MinHDDSize is the minimum size in Mb of the st1 volumes
+	MinHDDSize = 125 * 1024
+
+	instanceTimeout = 600 * time.Second
+	deleteTimeout   = 600 * time.Second
+)
+
+// dataDevices are the device names assigned to the data drives, in order
+var dataDevices = []string{"/dev/sdf", "/dev/sdg", "/dev/sdh", "/dev/sdi", "/dev/sdj", "/dev/sdk", "/dev/sdl", "/dev/sdm", "/dev/sdn", "/dev/sdo", "/dev/sdp"}
+
+var authErrors = map[string]bool{
+	"AuthFailure":           true,
+	"UnauthorizedOperation": true,
+	"InvalidClientTokenId":  true,
+	"SignatureDoesNotMatch": true,
+}
+
+type AWSDeployer struct {
+	publicKey string
+	client    *Client
+}
+
+type NodeCreationResult struct {
+	Info  model.NodeInfo
+	Error error
+}
+
+// deploymentSettings are the properties of an infrastructure that are shared by all its nodes
+type deploymentSettings struct {
+	infraID        string
+	keyName        string
+	subnetID       string
+	securityGroups []string
+	username       string
+	instanceTypes  map[string]InstanceTypeInfo
+}
+
+func NewDeployer(endpoint string, credentials model.BasicAuthSecret, publicKeyPath string) (*AWSDeployer, error) {
+
+	viper.SetDefault("debug_aws_client", false)
+
+	pubKeyRaw, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		log.WithError(err).Error("Error reading public key")
+		return nil, err
+	}
+
+	return &AWSDeployer{
+		client:    NewClient(endpoint, credentials, viper.GetBool("debug_aws_client")),
+		publicKey: string(pubKeyRaw),
+	}, nil
+}
+
+func hasErrorCode(err error, codes ...string) bool {
+	var awsErr AWSError
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	for _, code := range codes {
+		if awsErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isAuthError(err error) bool {
+	var awsErr AWSError
+	return errors.As(err, &awsErr) && authErrors[awsErr.Code]
+}
+
+func keyPairName(infraID string) string {
+	return fmt.Sprintf("deployment-engine-%s", infraID)
+}
+
+// sizeInGiB converts a size in Mb to the GiB that EBS expects, rounding up
+func sizeInGiB(size int64) int64 {
+	return (size + 1023) / 1024
+}
+
+func gibToBytes(size int64) int64 {
+	return size * 1024 * 1024 * 1024
+}
+
+func volumeType(drive model.Drive) string {
+	if strings.EqualFold(drive.Type, "HDD") {
+		return HDDVolumeType
+	}
+	return SSDVolumeType
+}
+
+// describeInstanceTypes returns the information of the instance types of the resources, which is used to fill the cores and RAM of the nodes
+func (d AWSDeployer) describeInstanceTypes(ctx context.Context, logger *log.Entry, resources []model.ResourceType) (map[string]InstanceTypeInfo, error) {
+	result := make(map[string]InstanceTypeInfo)
+	types := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		if _, ok := result[resource.Type]; !ok {
+			result[resource.Type] = InstanceTypeInfo{}
+			types = append(types, resource.Type)
+		}
+	}
+
+	infos, err := d.client.DescribeInstanceTypes(ctx, types...)
+	if err != nil {
+		logger.WithError(err).Warn("Can't get the information of the instance types")
+		return map[string]InstanceTypeInfo{}, err
+	}
+
+	for _, info := range infos {
+		result[info.InstanceType] = info
+	}
+	return result, nil
+}
+
+// prepare imports the public key of the deployment engine as the key pair of the infrastructure and reads its properties
+func (d AWSDeployer) prepare(ctx context.Context, logger *log.Entry, infraID string, properties model.ExtraPropertiesType, resources []model.ResourceType) (deploymentSettings, error) {
+	settings := deploymentSettings{
+		infraID:        infraID,
+		keyName:        keyPairName(infraID),
+		subnetID:       properties[SubnetProperty],
+		securityGroups: make([]string, 0),
+		username:       DefaultUsername,
+	}
+
+	if username := properties[UsernameProperty]; username != "" {
+		settings.username = username
+	}
+
+	for _, group := range strings.Split(properties[SecurityGroupsProperty], ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			settings.securityGroups = append(settings.securityGroups, group)
+		}
+	}
+
+	instanceTypes, err := d.describeInstanceTypes(ctx, logger, resources)
+	if err != nil {
+		return settings, err
+	}
+	settings.instanceTypes = instanceTypes
+
+	logger.Info("Importing key pair")
+	_, err = d.client.ImportKeyPair(ctx, settings.keyName, d.publicKey)
+	if err != nil && !hasErrorCode(err, "InvalidKeyPair.Duplicate") {
+		logger.WithError(err).Error("Error importing key pair")
+		return settings, err
+	}
+
+	return settings, nil
+}
+
+func (d AWSDeployer) returnError(logger *log.Entry, msg string, result NodeCreationResult, err error, c chan NodeCreationResult) error {
+	logger.WithError(err).Error(msg)
+	result.Error = err
+	deleteError := d.deletePartialDeployment(logger, result)
+	if deleteError != nil {
+		result.Error = deleteError
+	}
+	c <- result
+	return err
+}
+
+// deletePartialDeployment terminates the instance of a node that couldn't be created. It doesn't use the context of the operation so the resources are deleted even if it was cancelled.
+func (d AWSDeployer) deletePartialDeployment(logger *log.Entry, result NodeCreationResult) error {
+	logger.Info("Undoing partial deployment...")
+	return d.deleteHost(context.Background(), logger, result.Info)
+}
+
+// runInstanceParams returns the parameters to launch the instance of a resource with its EBS volumes, tagging both with the infrastructure identifier
+func (d AWSDeployer) runInstanceParams(settings deploymentSettings, hostname, rootDevice string, resource model.ResourceType) url.Values {
+	params := url.Values{
+		"ImageId":      []string{resource.ImageId},
+		"InstanceType": []string{resource.Type},
+		"MinCount":     []string{"1"},
+		"MaxCount":     []string{"1"},
+		"KeyName":      []string{settings.keyName},
+	}
+
+	if settings.subnetID != "" {
+		params.Set("SubnetId", settings.subnetID)
+	}
+	addList(params, "SecurityGroupId", settings.securityGroups)
+
+	mapping := 1
+	addMapping := func(device string, size int64, volumeType string) {
+		prefix := fmt.Sprintf("BlockDeviceMapping.%d", mapping)
+		params.Set(prefix+".DeviceName", device)
+		params.Set(prefix+".Ebs.VolumeSize", strconv.FormatInt(size, 10))
+		params.Set(prefix+".Ebs.VolumeType", volumeType)
+		params.Set(prefix+".Ebs.DeleteOnTermination", "true")
+		mapping++
+	}
+
+	if rootDevice != "" {
+		addMapping(rootDevice, sizeInGiB(resource.Disk), SSDVolumeType)
+	}
+	for i, drive := range resource.Drives {
+		addMapping(dataDevices[i], sizeInGiB(drive.Size), volumeType(drive))
+	}
+
+	tags := map[string]string{
+		InfrastructureTag: settings.infraID,
+		"Name":            hostname,
+	}
+	addTagSpecification(params, 1, "instance", tags)
+	addTagSpecification(params, 2, "volume", tags)
+
+	return params
+}
+
+func (d AWSDeployer) findInstance(ctx context.Context, id string) (Instance, bool, error) {
+	instances, err := d.client.DescribeInstances(ctx, Filter{Name: "instance-id", Values: []string{id}})
+	if err != nil || len(instances) == 0 {
+		return Instance{}, false, err
+	}
+	return instances[0], true, nil
+}
+
+func (d AWSDeployer) waitForInstance(ctx context.Context, id, status string, timeout time.Duration) (Instance, bool, error) {
+	var instance Instance
+	_, timedOut, err := utils.WaitForStatusChange(ctx, status, timeout, func() (string, error) {
+		current, found, err := d.findInstance(ctx, id)
+		if err != nil {
+			return status, err
+		}
+		if !found {
+			return "terminated", nil
+		}
+		instance = current
+		return instance.State.Name, nil
+	})
+	return instance, timedOut, err
+}
+
+// launchInstance runs the instance of a node and waits for it to be running
+func (d AWSDeployer) launchInstance(ctx context.Context, logger *log.Entry, settings deploymentSettings, hostname string, resource model.ResourceType) (Instance, error) {
+	rootDevice := ""
+	if resource.Disk > 0 {
+		images, err := d.client.DescribeImages(ctx, resource.ImageId)
+		if err != nil {
+			return Instance{}, err
+		}
+		if len(images) == 0 {
+			return Instance{}, fmt.Errorf("AMI %s not found", resource.ImageId)
+		}
+		rootDevice = images[0].RootDeviceName
+	}
+
+	instances, err := d.client.RunInstances(ctx, d.runInstanceParams(settings, hostname, rootDevice, resource))
+	if err != nil {
+		return Instance{}, err
+	}
+
+	if len(instances) == 0 || instances[0].InstanceID == "" {
+		return Instance{}, errors.New("The instance could not be launched but we didn't get an error")
+	}
+
+	id := instances[0].InstanceID
+	logger.WithField("instance", id).Info("Waiting for instance to be running")
+	instance, timedOut, err := d.waitForInstance(ctx, id, "pending", instanceTimeout)
+	instance.InstanceID = id
+	if err != nil {
+		return instance, err
+	}
+
+	if timedOut {
+		return instance, fmt.Errorf("Timeout waiting for instance %s to be running", id)
+	}
+
+	if instance.State.Name != "running" {
+		return instance, fmt.Errorf("Instance %s in unexpected state: %s", id, instance.State.Name)
+	}
+
+	return instance, nil
+}
+
+// fillDrives records the EBS volumes of the instance as the boot and data drives of the node
+func (d AWSDeployer) fillDrives(ctx context.Context, info *model.NodeInfo, instance Instance, resource model.ResourceType) error {
+	devices := make(map[string]string)
+	ids := make([]string, 0, len(instance.BlockDeviceMapping))
+	for _, mapping := range instance.BlockDeviceMapping {
+		devices[mapping.DeviceName] = mapping.EBS.VolumeID
+		ids = append(ids, mapping.EBS.VolumeID)
+	}
+
+	sizes := make(map[string]int64)
+	if len(ids) > 0 {
+		volumes, err := d.client.DescribeVolumes(ctx, Filter{Name: "volume-id", Values: ids})
+		if err != nil {
+			return err
+		}
+		for _, volume := range volumes {
+			sizes[volume.VolumeID] = gibToBytes(volume.Size)
+		}
+	}
+
+	info.DriveUUID = devices[instance.RootDeviceName]
+	info.DriveSize = sizes[info.DriveUUID]
+	info.DataDrives = make([]model.DriveInfo, 0, len(resource.Drives))
+	for i, drive := range resource.Drives {
+		id, ok := devices[dataDevices[i]]
+		if !ok {
+			return fmt.Errorf("Volume of drive %s is not attached to instance %s", drive.Name, instance.InstanceID)
+		}
+		info.DataDrives = append(info.DataDrives, model.DriveInfo{
+			Name: utils.DataDriveName(info.Hostname, drive),
+			UUID: id,
+			Size: sizes[id],
+		})
+	}
+
+	return nil
+}
+
+func (d AWSDeployer) CreateServer(ctx context.Context, settings deploymentSettings, resource model.ResourceType, pfx string, c chan NodeCreationResult) error {
+	result := NodeCreationResult{}
+
+	logger := log.WithField("resource", resource.Name)
+
+	if resource.Name == "" {
+		return d.returnError(logger, "", result, fmt.Errorf("Resource with empty name found in infrastructure "+pfx), c)
+	}
+
+	nodeName, err := utils.ClearHostName(pfx + "-" + resource.Name)
+	if err != nil {
+		return d.returnError(logger, fmt.Sprintf("Invalid combination of hostname for infrastructure %s and resource %s", pfx, resource.Name), result, err, c)
+	}
+
+	result.Info = model.NodeInfo{
+		Role:            strings.ToLower(resource.Role),
+		Hostname:        nodeName,
+		Username:        settings.username,
+		ResourceName:    resource.Name,
+		ExtraProperties: resource.ExtraProperties,
+	}
+	logger = logger.WithField("host", nodeName)
+
+	logger.Info("Launching instance")
+	instance, err := d.launchInstance(ctx, logger, settings, nodeName, resource)
+	result.Info.UUID = instance.InstanceID
+	if err != nil {
+		return d.returnError(logger, "Error launching instance", result, err, c)
+	}
+
+	err = d.fillDrives(ctx, &result.Info, instance, resource)
+	if err != nil {
+		return d.returnError(logger, "Error getting volumes of instance", result, err, c)
+	}
+
+	result.Info.IP = instance.PublicIPAddress
+	if result.Info.IP == "" {
+		result.Info.IP = instance.PrivateIPAddress
+	}
+
+	if result.Info.IP == "" {
+		msg := "Can't find network information for instance"
+		return d.returnError(logger, msg, result, errors.New(msg), c)
+	}
+
+	result.Info.Cores = instance.CPUOptions.CoreCount * instance.CPUOptions.ThreadsPerCore
+	if typeInfo, ok := settings.instanceTypes[resource.Type]; ok {
+		if result.Info.Cores == 0 {
+			result.Info.Cores = typeInfo.VCPUInfo.DefaultVCPUs
+		}
+		result.Info.RAM = typeInfo.MemoryInfo.SizeInMiB * 1024 * 1024
+	}
+
+	logger.Info("Instance deployment complete")
+
+	c <- result
+	return nil
+}
+
+// createNodes creates a node for each resource in parallel, adding the ones that succeed to the infrastructure
+func (d AWSDeployer) createNodes(ctx context.Context, logger *log.Entry, infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) error {
+	settings, err := d.prepare(ctx, logger, infra.ID, infra.ExtraProperties, resources)
+	if err != nil {
+		return err
+	}
+
+	c := make(chan NodeCreationResult, len(resources))
+
+	for _, resource := range resources {
+		go d.CreateServer(ctx, settings, resource, infra.Name, c)
+	}
+
+	var failed = false
+
+	for remaining := len(resources); remaining > 0; remaining-- {
+		result := <-c
+		if result.Error == nil {
+			infra.AddNode(result.Info)
+		} else {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("Deployment failed")
+	}
+
+	return nil
+}
+
+// ValidateInfrastructure checks that an infrastructure has the information needed to be created in EC2
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if infra.Name == "" {
+		return errors.New("Name is mandatory for each aws infrastructure")
+	}
+
+	if len(infra.Resources) == 0 {
+		return errors.New("At least one resource is needed")
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if names[resource.Name] {
+			return fmt.Errorf("Name of resource %s is not unique", resource.Name)
+		}
+		names[resource.Name] = true
+
+		err := validateResource(resource)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateResource checks that a resource can be created as an EC2 instance
+func validateResource(resource model.ResourceType) error {
+	if resource.Name == "" {
+		return errors.New("Resource with empty name found")
+	}
+
+	if resource.ImageId == "" {
+		return fmt.Errorf("Empty AMI found for resource %s", resource.Name)
+	}
+
+	if resource.Type == "" {
+		return fmt.Errorf("Instance type is mandatory for resource %s", resource.Name)
+	}
+
+	if len(resource.Drives) > len(dataDevices) {
+		return fmt.Errorf("Resource %s has %d drives but at most %d are supported", resource.Name, len(resource.Drives), len(dataDevices))
+	}
+
+	for _, drive := range resource.Drives {
+		driveType := strings.ToUpper(drive.Type)
+		if driveType != "" && driveType != "SSD" && driveType != "HDD" {
+			return fmt.Errorf("Invalid type %s of drive %s in resource %s. Valid types are SSD and HDD", drive.Type, drive.Name, resource.Name)
+		}
+		if drive.Size <= 0 {
+			return fmt.Errorf("Size of drive %s in resource %s must be greater than 0", drive.Name, resource.Name)
+		}
+		if driveType == "HDD" && drive.Size < MinHDDSize {
+			return fmt.Errorf("HDD drive %s in resource %s must be at least %d Mb", drive.Name, resource.Name, MinHDDSize)
+		}
+	}
+
+	return nil
+}
+
+// PlanInfrastructure checks the credentials, the AMIs and the instance types needed to create the infrastructure and describes the instances and volumes that would be created
+func (d AWSDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	logger := log.WithField("infrastructure", infra.Name)
+
+	checkedImages := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if checkedImages[resource.ImageId] {
+			continue
+		}
+		checkedImages[resource.ImageId] = true
+		images, err := d.client.DescribeImages(ctx, resource.ImageId)
+		if isAuthError(err) {
+			plan.AddError("Invalid credentials: %s", err.Error())
+			return plan, nil
+		}
+		if err != nil && !hasErrorCode(err, "InvalidAMIID.NotFound", "InvalidAMIID.Malformed") {
+			plan.AddError("Error checking AMI of resource %s: %s", resource.Name, err.Error())
+		} else if len(images) == 0 {
+			plan.AddError("AMI %s of resource %s not found", resource.ImageId, resource.Name)
+		}
+	}
+
+	instanceTypes, err := d.describeInstanceTypes(ctx, logger, infra.Resources)
+	if hasErrorCode(err, "InvalidInstanceType") {
+		plan.AddError("Invalid instance type found: %s", err.Error())
+	}
+
+	for _, resource := range infra.Resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			plan.AddError("Invalid hostname for resource %s: %s", resource.Name, err.Error())
+		}
+
+		node := model.NodePlan{
+			Hostname: hostname,
+			Role:     strings.ToLower(resource.Role),
+			Drives:   make([]model.DrivePlan, 0, len(resource.Drives)+1),
+		}
+
+		if typeInfo, ok := instanceTypes[resource.Type]; ok {
+			node.Cores = typeInfo.VCPUInfo.DefaultVCPUs
+			node.RAM = typeInfo.MemoryInfo.SizeInMiB * 1024 * 1024
+		}
+
+		node.Drives = append(node.Drives, model.DrivePlan{
+			Name:   utils.BootDriveName(hostname),
+			Action: model.DriveActionClone,
+			Source: resource.ImageId,
+			Size:   gibToBytes(sizeInGiB(resource.Disk)),
+		})
+
+		for _, drive := range resource.Drives {
+			node.Drives = append(node.Drives, model.DrivePlan{
+				Name:   utils.DataDriveName(hostname, drive),
+				Action: model.DriveActionCreate,
+				Size:   gibToBytes(sizeInGiB(drive.Size)),
+			})
+		}
+
+		plan.Nodes = append(plan.Nodes, node)
+	}
+
+	return plan, nil
+}
+
+func (d AWSDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+
+	deployment := model.InfrastructureDeploymentInfo{
+		ID:              infra.InfrastructureID(),
+		Products:        make(map[string]interface{}),
+		ExtraProperties: infra.ExtraProperties,
+	}
+
+	if infra.Name == "" {
+		return deployment, errors.New("Name is mandatory for each aws infrastructure")
+	}
+
+	deployment.Name = infra.Name
+	deployment.Type = DeploymentType
+	deployment.Nodes = make(map[string][]model.NodeInfo)
+	deployment.Status = "creating"
+
+	var logger = log.WithField("deployment", infra.Name)
+
+	err := d.createNodes(ctx, logger, &deployment, infra.Resources)
+	if err != nil {
+		logger.WithError(err).Errorf("Deployment failed")
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	logger.Infof("Nodes successfully created")
+	deployment.Status = "running"
+
+	return deployment, nil
+}
+
+// AddNodes creates new nodes in an existing infrastructure
+func (d AWSDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	for _, resource := range resources {
+		err := validateResource(resource)
+		if err != nil {
+			return infra, err
+		}
+
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return infra, err
+		}
+		if _, found := infra.FindNode(hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", hostname, infra.ID)
+		}
+	}
+
+	logger.Infof("Adding %d nodes", len(resources))
+	err := d.createNodes(ctx, logger, &infra, resources)
+	if err != nil {
+		logger.WithError(err).Error("Error adding nodes")
+		return infra, err
+	}
+
+	logger.Info("Nodes successfully added")
+	return infra, nil
+}
+
+// RemoveNodes deletes nodes from an existing infrastructure given their hostnames
+func (d AWSDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]error)
+
+	for _, hostname := range hostnames {
+		node, found := infra.FindNode(hostname)
+		if !found {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+			continue
+		}
+
+		err := d.deleteHost(ctx, logger, node)
+		if err != nil {
+			logger.WithError(err).Errorf("Error deleting node %s", hostname)
+			result[hostname] = err
+			continue
+		}
+		infra.RemoveNode(hostname)
+	}
+
+	return infra, result
+}
+
+// terminateInstance terminates an instance and waits for it to be terminated
+func (d AWSDeployer) terminateInstance(ctx context.Context, logInput *log.Entry, id string) error {
+	logger := logInput.WithField("instance", id)
+	logger.Info("Terminating instance")
+	err := d.client.TerminateInstances(ctx, id)
+	if hasErrorCode(err, "InvalidInstanceID.NotFound") {
+		return nil
+	}
+	if err != nil {
+		logger.WithError(err).Error("Error terminating instance")
+		return err
+	}
+
+	_, timedOut, err := d.waitForInstance(ctx, id, "shutting-down", deleteTimeout)
+	if err == nil && timedOut {
+		err = fmt.Errorf("Timeout waiting for instance %s to be terminated", id)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Error waiting for instance to be terminated")
+	}
+	return err
+}
+
+// deleteVolumes deletes the volumes passed as parameter that are left after terminating their instances
+func (d AWSDeployer) deleteVolumes(ctx context.Context, logger *log.Entry, volumes []Volume) map[string]error {
+	result := make(map[string]error)
+	for _, volume := range volumes {
+		if volume.Status != "available" {
+			continue
+		}
+		logger.WithField("volume", volume.VolumeID).Info("Deleting volume")
+		err := d.client.DeleteVolume(ctx, volume.VolumeID)
+		if err != nil && !hasErrorCode(err, "InvalidVolume.NotFound") {
+			logger.WithError(err).Errorf("Error deleting volume %s", volume.VolumeID)
+			result[volume.VolumeID] = err
+		}
+	}
+	return result
+}
+
+func (d AWSDeployer) deleteHost(ctx context.Context, logInput *log.Entry, host model.NodeInfo) error {
+	logger := logInput.WithField("host", host.Hostname)
+
+	if host.UUID != "" {
+		err := d.terminateInstance(ctx, logger, host.UUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, 0, len(host.DataDrives)+1)
+	if host.DriveUUID != "" {
+		ids = append(ids, host.DriveUUID)
+	}
+	for _, drive := range host.DataDrives {
+		ids = append(ids, drive.UUID)
+	}
+
+	if len(ids) > 0 {
+		volumes, err := d.client.DescribeVolumes(ctx, Filter{Name: "volume-id", Values: ids})
+		if err != nil {
+			logger.WithError(err).Error("Error getting volumes of host")
+			return err
+		}
+		if errs := d.deleteVolumes(ctx, logger, volumes); len(errs) > 0 {
+			return fmt.Errorf("Errors deleting volumes: %v", errs)
+		}
+	}
+
+	logger.Info("Host successfully deleted")
+	return nil
+}
+
+// DeleteInfrastructure terminates the instances of the nodes of the infrastructure and then any other instance or volume tagged with its identifier, so nothing is left behind if a deployment failed halfway. The key pair of the infrastructure is deleted once all of them are gone.
+func (d AWSDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	logger.Info("Deleting infrastructure")
+
+	result := make(map[string]error)
+
+	logger.Info("Deleting nodes")
+	infra.ForEachNode(func(node model.NodeInfo) {
+		err := d.deleteHost(ctx, logger, node)
+		if err != nil {
+			logger.WithError(err).Errorf("Error deleting node %s", node.Hostname)
+			result[node.Hostname] = err
+		}
+	})
+
+	tag := TagFilter(InfrastructureTag, infra.ID)
+	instances, err := d.client.DescribeInstances(ctx, tag, Filter{Name: "instance-state-name", Values: []string{"pending", "running", "stopping", "stopped"}})
+	if err != nil {
+		logger.WithError(err).Error("Error finding instances of infrastructure")
+		result[infra.ID] = err
+		return result
+	}
+
+	for _, instance := range instances {
+		if err := d.terminateInstance(ctx, logger, instance.InstanceID); err != nil {
+			result[instance.InstanceID] = err
+		}
+	}
+
+	volumes, err := d.client.DescribeVolumes(ctx, tag)
+	if err != nil {
+		logger.WithError(err).Error("Error finding volumes of infrastructure")
+		result[infra.ID] = err
+		return result
+	}
+
+	for id, err := range d.deleteVolumes(ctx, logger, volumes) {
+		result[id] = err
+	}
+
+	if len(result) > 0 {
+		return result
+	}
+
+	keyName := keyPairName(infra.ID)
+	err = d.client.DeleteKeyPair(ctx, keyName)
+	if err != nil {
+		logger.WithError(err).Error("Error deleting key pair")
+		result[keyName] = err
+		return result
+	}
+
+	logger.Info("Nodes deleted. Infrastructure clear")
+	return result
+}
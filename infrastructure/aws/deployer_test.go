@@ -0,0 +1,641 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"context"
+	"deployment-engine/model"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeAccessKey = "AKIDTEST"
+	fakeSecretKey = "secret"
+	fakeImage     = "ami-12345678"
+	fakePublicKey = "ssh-rsa AAAA test"
+)
+
+type fakeVolume struct {
+	Volume
+	deleteOnTermination bool
+}
+
+// fakeAMI is the only image of the fake EC2 API
+var fakeAMI = Image{ImageID: fakeImage, Name: "ubuntu", State: "available", RootDeviceName: "/dev/sda1"}
+
+// fakeTypes are the instance types of the fake EC2 API
+var fakeTypes = map[string]InstanceTypeInfo{
+	"t2.micro":  InstanceTypeInfo{InstanceType: "t2.micro", VCPUInfo: VCPUInfo{DefaultVCPUs: 1}, MemoryInfo: MemoryInfo{SizeInMiB: 1024}},
+	"t2.medium": InstanceTypeInfo{InstanceType: "t2.medium", VCPUInfo: VCPUInfo{DefaultVCPUs: 2}, MemoryInfo: MemoryInfo{SizeInMiB: 4096}},
+}
+
+// fakeEC2 is a minimal stand-in of the EC2 Query API which checks the signature of the requests. Instances are running as soon as they are launched and terminated as soon as they are terminated.
+// Instances are identified by their Name tag and volumes by their instance and device, so the tests can tell which node each resource belongs to.
+type fakeEC2 struct {
+	lock      sync.Mutex
+	keypairs  map[string]string
+	instances map[string]Instance
+	volumes   map[string]*fakeVolume
+}
+
+func newFakeEC2() *fakeEC2 {
+	return &fakeEC2{
+		keypairs:  make(map[string]string),
+		instances: make(map[string]Instance),
+		volumes:   make(map[string]*fakeVolume),
+	}
+}
+
+func writeXML(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(code)
+	xml.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, code int, errCode string) {
+	writeXML(w, code, ErrorResponse{Errors: []ErrorType{ErrorType{Code: errCode, Message: errCode}}})
+}
+
+// list returns the values of a list parameter such as InstanceId.N
+func list(form url.Values, prefix string) []string {
+	result := make([]string, 0)
+	for i := 1; form.Get(fmt.Sprintf("%s.%d", prefix, i)) != ""; i++ {
+		result = append(result, form.Get(fmt.Sprintf("%s.%d", prefix, i)))
+	}
+	return result
+}
+
+func tagValue(tags []Tag, key string) string {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// matches checks if a resource with the given id, state and tags passes the filters of the request
+func matches(form url.Values, id, state string, tags []Tag) bool {
+	for i := 1; form.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		name := form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		values := list(form, fmt.Sprintf("Filter.%d.Value", i))
+		value := ""
+		switch {
+		case name == "instance-id" || name == "volume-id":
+			value = id
+		case name == "instance-state-name":
+			value = state
+		case strings.HasPrefix(name, "tag:"):
+			value = tagValue(tags, strings.TrimPrefix(name, "tag:"))
+		}
+		found := false
+		for _, v := range values {
+			found = found || v == value
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	date, _ := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	expected := signer{accessKey: fakeAccessKey, secretKey: fakeSecretKey, region: DefaultRegion, service: "ec2"}.authorization(r.Method, r.URL, map[string]string{
+		"Host":         r.Host,
+		"Content-Type": r.Header.Get("Content-Type"),
+		"X-Amz-Date":   r.Header.Get("X-Amz-Date"),
+	}, body, date)
+	if r.Header.Get("Authorization") != expected {
+		writeError(w, http.StatusUnauthorized, "AuthFailure")
+		return
+	}
+
+	form, _ := url.ParseQuery(string(body))
+	switch form.Get("Action") {
+	case "ImportKeyPair":
+		name := form.Get("KeyName")
+		if _, ok := f.keypairs[name]; ok {
+			writeError(w, http.StatusBadRequest, "InvalidKeyPair.Duplicate")
+			return
+		}
+		material, _ := base64.StdEncoding.DecodeString(form.Get("PublicKeyMaterial"))
+		f.keypairs[name] = string(material)
+		writeXML(w, http.StatusOK, KeyPairInfo{KeyName: name})
+	case "DeleteKeyPair":
+		delete(f.keypairs, form.Get("KeyName"))
+		writeXML(w, http.StatusOK, struct{}{})
+	case "DescribeInstanceTypes":
+		result := DescribeInstanceTypesResponse{}
+		for _, name := range list(form, "InstanceType") {
+			info, ok := fakeTypes[name]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "InvalidInstanceType")
+				return
+			}
+			result.InstanceTypes = append(result.InstanceTypes, info)
+		}
+		writeXML(w, http.StatusOK, result)
+	case "DescribeImages":
+		result := DescribeImagesResponse{}
+		for _, id := range list(form, "ImageId") {
+			if id != fakeImage {
+				writeError(w, http.StatusBadRequest, "InvalidAMIID.NotFound")
+				return
+			}
+			result.Images = append(result.Images, fakeAMI)
+		}
+		writeXML(w, http.StatusOK, result)
+	case "RunInstances":
+		f.runInstance(w, form)
+	case "DescribeInstances":
+		result := DescribeInstancesResponse{Reservations: []Reservation{Reservation{}}}
+		for _, instance := range f.instances {
+			if matches(form, instance.InstanceID, instance.State.Name, instance.Tags) {
+				result.Reservations[0].Instances = append(result.Reservations[0].Instances, instance)
+			}
+		}
+		writeXML(w, http.StatusOK, result)
+	case "TerminateInstances":
+		for _, id := range list(form, "InstanceId") {
+			instance, ok := f.instances[id]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "InvalidInstanceID.NotFound")
+				return
+			}
+			instance.State.Name = "terminated"
+			for _, mapping := range instance.BlockDeviceMapping {
+				if volume := f.volumes[mapping.EBS.VolumeID]; volume != nil {
+					volume.Status = "available"
+					if volume.deleteOnTermination {
+						delete(f.volumes, volume.VolumeID)
+					}
+				}
+			}
+			instance.BlockDeviceMapping = nil
+			f.instances[id] = instance
+		}
+		writeXML(w, http.StatusOK, struct{}{})
+	case "DescribeVolumes":
+		result := DescribeVolumesResponse{}
+		for _, volume := range f.volumes {
+			if matches(form, volume.VolumeID, volume.Status, volume.Tags) {
+				result.Volumes = append(result.Volumes, volume.Volume)
+			}
+		}
+		writeXML(w, http.StatusOK, result)
+	case "DeleteVolume":
+		if _, ok := f.volumes[form.Get("VolumeId")]; !ok {
+			writeError(w, http.StatusBadRequest, "InvalidVolume.NotFound")
+			return
+		}
+		delete(f.volumes, form.Get("VolumeId"))
+		writeXML(w, http.StatusOK, struct{}{})
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidAction")
+	}
+}
+
+func (f *fakeEC2) tags(form url.Values, resourceType string) []Tag {
+	for i := 1; form.Get(fmt.Sprintf("TagSpecification.%d.ResourceType", i)) != ""; i++ {
+		prefix := fmt.Sprintf("TagSpecification.%d", i)
+		if form.Get(prefix+".ResourceType") != resourceType {
+			continue
+		}
+		tags := make([]Tag, 0)
+		for j := 1; form.Get(fmt.Sprintf("%s.Tag.%d.Key", prefix, j)) != ""; j++ {
+			tags = append(tags, Tag{
+				Key:   form.Get(fmt.Sprintf("%s.Tag.%d.Key", prefix, j)),
+				Value: form.Get(fmt.Sprintf("%s.Tag.%d.Value", prefix, j)),
+			})
+		}
+		return tags
+	}
+	return nil
+}
+
+func (f *fakeEC2) addVolume(instance *Instance, device string, size int64, volumeType string, tags []Tag) {
+	volume := &fakeVolume{
+		Volume: Volume{
+			VolumeID:   fmt.Sprintf("vol-%s-%s", instance.InstanceID, path.Base(device)),
+			Size:       size,
+			Status:     "in-use",
+			VolumeType: volumeType,
+			Tags:       tags,
+		},
+		deleteOnTermination: true,
+	}
+	f.volumes[volume.VolumeID] = volume
+	instance.BlockDeviceMapping = append(instance.BlockDeviceMapping, InstanceBlockDeviceMapping{
+		DeviceName: device,
+		EBS:        EBSInstanceBlockDevice{VolumeID: volume.VolumeID, Status: "attached"},
+	})
+}
+
+func (f *fakeEC2) runInstance(w http.ResponseWriter, form url.Values) {
+	if form.Get("ImageId") != fakeImage {
+		writeError(w, http.StatusBadRequest, "InvalidAMIID.NotFound")
+		return
+	}
+	image := fakeAMI
+
+	info, ok := fakeTypes[form.Get("InstanceType")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValue")
+		return
+	}
+
+	if _, ok := f.keypairs[form.Get("KeyName")]; !ok {
+		writeError(w, http.StatusBadRequest, "InvalidKeyPair.NotFound")
+		return
+	}
+
+	tags := f.tags(form, "instance")
+	address := len(f.instances) + 1
+	instance := Instance{
+		InstanceID:       "i-" + tagValue(tags, "Name"),
+		ImageID:          image.ImageID,
+		InstanceType:     info.InstanceType,
+		State:            InstanceState{Name: "running"},
+		RootDeviceName:   image.RootDeviceName,
+		CPUOptions:       CPUOptions{CoreCount: info.VCPUInfo.DefaultVCPUs, ThreadsPerCore: 1},
+		Tags:             tags,
+		PrivateIPAddress: fmt.Sprintf("10.0.0.%d", address),
+		PublicIPAddress:  fmt.Sprintf("54.0.0.%d", address),
+	}
+
+	volumeTags := f.tags(form, "volume")
+	rootMapped := false
+	for i := 1; form.Get(fmt.Sprintf("BlockDeviceMapping.%d.DeviceName", i)) != ""; i++ {
+		prefix := fmt.Sprintf("BlockDeviceMapping.%d", i)
+		device := form.Get(prefix + ".DeviceName")
+		size, _ := strconv.ParseInt(form.Get(prefix+".Ebs.VolumeSize"), 10, 64)
+		f.addVolume(&instance, device, size, form.Get(prefix+".Ebs.VolumeType"), volumeTags)
+		rootMapped = rootMapped || device == image.RootDeviceName
+	}
+	if !rootMapped {
+		f.addVolume(&instance, image.RootDeviceName, 8, SSDVolumeType, volumeTags)
+	}
+
+	f.instances[instance.InstanceID] = instance
+	pending := instance
+	pending.State.Name = "pending"
+	writeXML(w, http.StatusOK, RunInstancesResponse{Instances: []Instance{pending}})
+}
+
+func (f *fakeEC2) tagged(infraID string) (int, int) {
+	instances := 0
+	for _, instance := range f.instances {
+		if instance.State.Name != "terminated" && matches(url.Values{"Filter.1.Name": []string{"tag:" + InfrastructureTag}, "Filter.1.Value.1": []string{infraID}}, "", "", instance.Tags) {
+			instances++
+		}
+	}
+	return instances, len(f.volumes)
+}
+
+func newTestDeployer(url, secretKey string) AWSDeployer {
+	return AWSDeployer{
+		client: NewClient(url, model.BasicAuthSecret{
+			Username: fakeAccessKey,
+			Password: secretKey,
+		}, false),
+		publicKey: fakePublicKey,
+	}
+}
+
+func testInfra(resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name:      "Test_Infra",
+		Resources: resources,
+		ExtraProperties: model.ExtraPropertiesType{
+			SecurityGroupsProperty: "sg-1, sg-2",
+		},
+	}
+}
+
+func TestDeployAndDeleteInfrastructure(t *testing.T) {
+	fake := newFakeEC2()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Role:    "Master",
+			Type:    "t2.medium",
+			Disk:    20480,
+			ImageId: fakeImage,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Type: "SSD", Size: 1024},
+				model.Drive{Name: "archive", Type: "HDD", Size: MinHDDSize},
+			},
+		},
+		model.ResourceType{
+			Name:    "slave",
+			Role:    "slave",
+			Type:    "t2.micro",
+			ImageId: fakeImage,
+		}))
+
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if infra.Status != "running" || infra.NumNodes() != 2 {
+		t.Fatalf("Unexpected infrastructure deployed: %v", infra)
+	}
+
+	if fake.keypairs[keyPairName(infra.ID)] != fakePublicKey {
+		t.Fatalf("Key pair not imported with the public key: %v", fake.keypairs)
+	}
+
+	master, found := infra.FindNode("test-infra-master")
+	if !found {
+		t.Fatalf("Can't find master node in %v", infra.Nodes)
+	}
+
+	if master.Role != "master" || master.Username != DefaultUsername || master.Cores != 2 || master.RAM != 4096*1024*1024 || !strings.HasPrefix(master.IP, "54.0.0.") {
+		t.Fatalf("Unexpected master node: %v", master)
+	}
+
+	if fake.instances[master.UUID].InstanceType != "t2.medium" || master.DriveSize != 20*1024*1024*1024 {
+		t.Fatalf("Unexpected instance for master: %v %v", fake.instances[master.UUID], master)
+	}
+
+	if len(master.DataDrives) != 2 || fake.volumes[master.DataDrives[0].UUID].VolumeType != SSDVolumeType || fake.volumes[master.DataDrives[1].UUID].VolumeType != HDDVolumeType {
+		t.Fatalf("Unexpected data drives for master: %v", master.DataDrives)
+	}
+
+	if master.DataDrives[1].Size != 125*1024*1024*1024 || master.DataDrives[1].Name != "data-test-infra-master-archive" {
+		t.Fatalf("Unexpected HDD drive for master: %v", master.DataDrives[1])
+	}
+
+	slave, _ := infra.FindNode("test-infra-slave")
+	if slave.DriveUUID == "" || slave.DriveSize != 8*1024*1024*1024 || slave.Cores != 1 {
+		t.Fatalf("Expected default root volume for slave but found %v", slave)
+	}
+
+	instances, volumes := fake.tagged(infra.ID)
+	if instances != 2 || volumes != 4 {
+		t.Fatalf("Expected 2 instances and 4 volumes tagged but found %d and %d", instances, volumes)
+	}
+
+	status, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil || status["test-infra-master"].Status != model.NodeStatusRunning {
+		t.Fatalf("Unexpected status of master: %v %v", status, err)
+	}
+
+	// Instance and volume tagged with the infrastructure but not recorded in it, as left by a failed deployment
+	orphan := Instance{InstanceID: "i-orphan", State: InstanceState{Name: "running"}, Tags: []Tag{Tag{Key: InfrastructureTag, Value: infra.ID}}}
+	fake.addVolume(&orphan, "/dev/sda1", 8, SSDVolumeType, orphan.Tags)
+	fake.instances[orphan.InstanceID] = orphan
+	fake.volumes["vol-orphan"] = &fakeVolume{Volume: Volume{VolumeID: "vol-orphan", Status: "available", Tags: orphan.Tags}}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	instances, volumes = fake.tagged(infra.ID)
+	if instances != 0 || volumes != 0 || len(fake.keypairs) != 0 {
+		t.Fatalf("Resources left after deleting infrastructure: %d instances, %d volumes, key pairs %v", instances, volumes, fake.keypairs)
+	}
+}
+
+func TestDeployInfrastructureFailure(t *testing.T) {
+	fake := newFakeEC2()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{Name: "master", Type: "t2.micro", ImageId: fakeImage},
+		model.ResourceType{Name: "slave", Type: "t2.micro", ImageId: "ami-missing"}))
+
+	if err == nil {
+		t.Fatal("Infrastructure with missing AMI deployed")
+	}
+
+	if infra.NumNodes() != 1 {
+		t.Fatalf("Expected only master node in failed infrastructure but found %v", infra.Nodes)
+	}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	instances, volumes := fake.tagged(infra.ID)
+	if instances != 0 || volumes != 0 || len(fake.keypairs) != 0 {
+		t.Fatalf("Resources left after deleting infrastructure: %d instances, %d volumes, key pairs %v", instances, volumes, fake.keypairs)
+	}
+}
+
+func TestUnknownInstanceType(t *testing.T) {
+	fake := newFakeEC2()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{Name: "master", Type: "t2.unknown", ImageId: fakeImage}))
+
+	if err == nil {
+		t.Fatal("Infrastructure with unknown instance type deployed")
+	}
+
+	instances, _ := fake.tagged(infra.ID)
+	if instances != 0 || infra.NumNodes() != 0 {
+		t.Fatalf("Instances created with unknown instance type: %v", infra.Nodes)
+	}
+}
+
+func TestTooManyDrives(t *testing.T) {
+	fake := newFakeEC2()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	resource := model.ResourceType{Name: "slave", Type: "t2.micro", ImageId: fakeImage}
+	for i := 0; i <= len(dataDevices); i++ {
+		resource.Drives = append(resource.Drives, model.Drive{Name: fmt.Sprintf("data%d", i), Size: 1024})
+	}
+
+	if err := validateResource(resource); err == nil {
+		t.Fatal("Resource with more drives than devices validated")
+	}
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{Name: "master", Type: "t2.micro", ImageId: fakeImage}))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	infra, err = deployer.AddNodes(context.Background(), infra, []model.ResourceType{resource})
+	if err == nil {
+		t.Fatal("Added node with more drives than devices")
+	}
+
+	instances, _ := fake.tagged(infra.ID)
+	if instances != 1 || infra.NumNodes() != 1 {
+		t.Fatalf("Expected only master node but found %v", infra.Nodes)
+	}
+}
+
+func TestPlanInfrastructure(t *testing.T) {
+	server := httptest.NewServer(newFakeEC2())
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Role:    "Master",
+			Type:    "t2.medium",
+			Disk:    10240,
+			ImageId: fakeImage,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Size: 1024},
+			},
+		}))
+
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) > 0 || len(plan.Nodes) != 1 {
+		t.Fatalf("Unexpected plan: %v", plan)
+	}
+
+	master := plan.Nodes[0]
+	if master.Cores != 2 || master.RAM != 4096*1024*1024 || len(master.Drives) != 2 || master.Drives[0].Size != 10*1024*1024*1024 {
+		t.Fatalf("Unexpected plan for master node: %v", master)
+	}
+
+	invalid := testInfra(
+		model.ResourceType{Name: "a", Type: "t2.huge", ImageId: fakeImage},
+		model.ResourceType{Name: "b", Type: "t2.micro", ImageId: "ami-missing"})
+	plan, err = deployer.PlanInfrastructure(context.Background(), invalid)
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	// Missing AMI and invalid instance type
+	if len(plan.Errors) != 2 {
+		t.Fatalf("Expected 2 errors in plan but found %v", plan.Errors)
+	}
+
+	deployer = newTestDeployer(server.URL, "wrong")
+	plan, err = deployer.PlanInfrastructure(context.Background(), invalid)
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) != 1 || !strings.HasPrefix(plan.Errors[0], "Invalid credentials") {
+		t.Fatalf("Expected invalid credentials error but found %v", plan.Errors)
+	}
+}
+
+func TestCheckNodes(t *testing.T) {
+	fake := newFakeEC2()
+	fake.instances["running"] = Instance{
+		InstanceID:         "running",
+		State:              InstanceState{Name: "running"},
+		PrivateIPAddress:   "10.0.0.1",
+		BlockDeviceMapping: []InstanceBlockDeviceMapping{InstanceBlockDeviceMapping{EBS: EBSInstanceBlockDevice{VolumeID: "root"}}},
+	}
+	fake.instances["stopped"] = Instance{InstanceID: "stopped", State: InstanceState{Name: "stopped"}}
+	fake.instances["terminated"] = Instance{InstanceID: "terminated", State: InstanceState{Name: "terminated"}}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	infra := model.InfrastructureDeploymentInfo{ID: "infra"}
+	infra.AddNode(model.NodeInfo{Hostname: "running", Role: "master", UUID: "running", DriveUUID: "root", IP: "10.0.0.1"})
+	infra.AddNode(model.NodeInfo{Hostname: "drifted", Role: "slave", UUID: "running", DriveUUID: "root", IP: "10.0.0.2",
+		DataDrives: []model.DriveInfo{model.DriveInfo{UUID: "detached"}},
+	})
+	infra.AddNode(model.NodeInfo{Hostname: "stopped", Role: "slave", UUID: "stopped"})
+	infra.AddNode(model.NodeInfo{Hostname: "terminated", Role: "slave", UUID: "terminated"})
+	infra.AddNode(model.NodeInfo{Hostname: "missing", Role: "slave", UUID: "missing"})
+
+	deployer := newTestDeployer(server.URL, fakeSecretKey)
+	result, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"running":    model.NodeStatusRunning,
+		"drifted":    model.NodeStatusDrifted,
+		"stopped":    model.NodeStatusStopped,
+		"terminated": model.NodeStatusMissing,
+		"missing":    model.NodeStatusMissing,
+	}
+	for hostname, status := range expected {
+		if result[hostname].Status != status {
+			t.Fatalf("Expected status %s for node %s but found %v", status, hostname, result[hostname])
+		}
+	}
+
+	if len(result["drifted"].Problems) != 2 {
+		t.Fatalf("Expected detached volume and IP problems for drifted node but found %v", result["drifted"].Problems)
+	}
+}
+
+func TestValidateInfrastructure(t *testing.T) {
+	valid := model.ResourceType{Name: "node", Type: "t2.micro", ImageId: fakeImage}
+
+	if err := ValidateInfrastructure(testInfra(valid)); err != nil {
+		t.Fatalf("Valid infrastructure rejected: %s", err.Error())
+	}
+
+	noType := valid
+	noType.Type = ""
+
+	smallHDD := valid
+	smallHDD.Drives = []model.Drive{model.Drive{Name: "data", Type: "HDD", Size: 1024}}
+
+	for _, infra := range []model.InfrastructureType{
+		testInfra(),
+		testInfra(valid, valid),
+		testInfra(noType),
+		testInfra(smallHDD),
+	} {
+		if err := ValidateInfrastructure(infra); err == nil {
+			t.Fatalf("Invalid infrastructure accepted: %v", infra)
+		}
+	}
+}
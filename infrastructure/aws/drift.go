@@ -0,0 +1,94 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (d AWSDeployer) checkNode(ctx context.Context, logger *log.Entry, node model.NodeInfo) model.NodeStatus {
+	if node.UUID == "" {
+		return model.NodeStatus{
+			Status:   model.NodeStatusMissing,
+			Problems: []string{"The node doesn't have an instance identifier"},
+		}
+	}
+
+	instance, found, err := d.findInstance(ctx, node.UUID)
+	if err != nil {
+		logger.WithError(err).Errorf("Error getting details of instance %s", node.UUID)
+		return model.NodeStatus{
+			Status:   model.NodeStatusUnknown,
+			Problems: []string{fmt.Sprintf("Error getting details of instance %s: %s", node.UUID, err.Error())},
+		}
+	}
+
+	if !found || instance.State.Name == "terminated" || instance.State.Name == "shutting-down" {
+		return model.NodeStatus{
+			Status:   model.NodeStatusMissing,
+			Problems: []string{fmt.Sprintf("Instance %s has been terminated", node.UUID)},
+		}
+	}
+
+	status := model.NodeStatus{
+		Status: model.NodeStatusRunning,
+	}
+
+	attached := make(map[string]bool)
+	for _, mapping := range instance.BlockDeviceMapping {
+		attached[mapping.EBS.VolumeID] = true
+	}
+
+	if node.DriveUUID != "" && !attached[node.DriveUUID] {
+		status.Problems = append(status.Problems, fmt.Sprintf("Boot volume %s is not attached to the instance", node.DriveUUID))
+	}
+
+	for _, drive := range node.DataDrives {
+		if !attached[drive.UUID] {
+			status.Problems = append(status.Problems, fmt.Sprintf("Data volume %s is not attached to the instance", drive.UUID))
+		}
+	}
+
+	if node.IP != "" && node.IP != instance.PublicIPAddress && node.IP != instance.PrivateIPAddress {
+		status.Problems = append(status.Problems, fmt.Sprintf("IP %s is not assigned to the instance", node.IP))
+	}
+
+	if len(status.Problems) > 0 {
+		status.Status = model.NodeStatusDrifted
+	}
+
+	if instance.State.Name != "running" {
+		status.Status = model.NodeStatusStopped
+		status.Problems = append(status.Problems, fmt.Sprintf("Instance is %s", instance.State.Name))
+	}
+
+	return status
+}
+
+// CheckNodes gets the state of the instances of the infrastructure and checks that their volumes and IPs are still attached
+func (d AWSDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		result[node.Hostname] = d.checkNode(ctx, logger, node)
+	})
+	return result, nil
+}
@@ -0,0 +1,140 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+type ErrorType struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type ErrorResponse struct {
+	Errors    []ErrorType `xml:"Errors>Error"`
+	RequestID string      `xml:"RequestID"`
+}
+
+type Tag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type EBSInstanceBlockDevice struct {
+	VolumeID string `xml:"volumeId"`
+	Status   string `xml:"status"`
+}
+
+type InstanceBlockDeviceMapping struct {
+	DeviceName string                 `xml:"deviceName"`
+	EBS        EBSInstanceBlockDevice `xml:"ebs"`
+}
+
+type InstanceState struct {
+	Code int    `xml:"code"`
+	Name string `xml:"name"`
+}
+
+type CPUOptions struct {
+	CoreCount      int `xml:"coreCount"`
+	ThreadsPerCore int `xml:"threadsPerCore"`
+}
+
+type Instance struct {
+	InstanceID         string                       `xml:"instanceId"`
+	ImageID            string                       `xml:"imageId"`
+	InstanceType       string                       `xml:"instanceType"`
+	State              InstanceState                `xml:"instanceState"`
+	PrivateIPAddress   string                       `xml:"privateIpAddress"`
+	PublicIPAddress    string                       `xml:"ipAddress"`
+	RootDeviceName     string                       `xml:"rootDeviceName"`
+	BlockDeviceMapping []InstanceBlockDeviceMapping `xml:"blockDeviceMapping>item"`
+	CPUOptions         CPUOptions                   `xml:"cpuOptions"`
+	Tags               []Tag                        `xml:"tagSet>item"`
+}
+
+type Reservation struct {
+	Instances []Instance `xml:"instancesSet>item"`
+}
+
+type RunInstancesResponse struct {
+	Instances []Instance `xml:"instancesSet>item"`
+}
+
+type DescribeInstancesResponse struct {
+	Reservations []Reservation `xml:"reservationSet>item"`
+}
+
+// Instances returns the instances of all the reservations
+func (r DescribeInstancesResponse) Instances() []Instance {
+	result := make([]Instance, 0)
+	for _, reservation := range r.Reservations {
+		result = append(result, reservation.Instances...)
+	}
+	return result
+}
+
+type VolumeAttachment struct {
+	InstanceID string `xml:"instanceId"`
+	Device     string `xml:"device"`
+	Status     string `xml:"status"`
+}
+
+type Volume struct {
+	VolumeID string `xml:"volumeId"`
+	// Size in GiB
+	Size        int64              `xml:"size"`
+	Status      string             `xml:"status"`
+	VolumeType  string             `xml:"volumeType"`
+	Attachments []VolumeAttachment `xml:"attachmentSet>item"`
+	Tags        []Tag              `xml:"tagSet>item"`
+}
+
+type DescribeVolumesResponse struct {
+	Volumes []Volume `xml:"volumeSet>item"`
+}
+
+type Image struct {
+	ImageID        string `xml:"imageId"`
+	Name           string `xml:"name"`
+	State          string `xml:"imageState"`
+	RootDeviceName string `xml:"rootDeviceName"`
+}
+
+type DescribeImagesResponse struct {
+	Images []Image `xml:"imagesSet>item"`
+}
+
+type VCPUInfo struct {
+	DefaultVCPUs int `xml:"defaultVCpus"`
+}
+
+type MemoryInfo struct {
+	SizeInMiB int64 `xml:"sizeInMiB"`
+}
+
+type InstanceTypeInfo struct {
+	InstanceType string     `xml:"instanceType"`
+	VCPUInfo     VCPUInfo   `xml:"vCpuInfo"`
+	MemoryInfo   MemoryInfo `xml:"memoryInfo"`
+}
+
+type DescribeInstanceTypesResponse struct {
+	InstanceTypes []InstanceTypeInfo `xml:"instanceTypeSet>item"`
+}
+
+type KeyPairInfo struct {
+	KeyName        string `xml:"keyName"`
+	KeyFingerprint string `xml:"keyFingerprint"`
+}
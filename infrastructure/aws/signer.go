@@ -0,0 +1,106 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package aws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	shortDateFormat  = "20060102"
+)
+
+// signer signs requests with the AWS Signature Version 4 process
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+// authorization returns the value of the Authorization header for a request. The headers must include the host and the date in X-Amz-Date format and they are all signed.
+func (s signer) authorization(method string, requestURL *url.URL, headers map[string]string, payload []byte, date time.Time) string {
+	names := make([]string, 0, len(headers))
+	values := make(map[string]string, len(headers))
+	for name, value := range headers {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		values[lower] = strings.TrimSpace(value)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := requestURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery(requestURL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(payload),
+	}, "\n")
+
+	shortDate := date.UTC().Format(shortDateFormat)
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", shortDate, s.region, s.service)
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		date.UTC().Format(amzDateFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), shortDate)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", signingAlgorithm, s.accessKey, scope, signedHeaders, signature)
+}
@@ -0,0 +1,145 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OrphanedStatus = "orphaned"
+)
+
+// CleanupReport contains the result of removing the infrastructures of a deployment that failed partially
+type CleanupReport struct {
+	// Identifiers of the infrastructures that were successfully deleted
+	Deleted []string `json:"deleted"`
+	// Errors found deleting infrastructures, indexed by infrastructure identifier. These infrastructures are kept in the repository with orphaned status.
+	Orphaned map[string]string `json:"orphaned"`
+}
+
+// DeploymentError is returned when a deployment fails. It contains the original cause and, if autoclean was enabled, the result of the cleanup.
+type DeploymentError struct {
+	Cause   error
+	Cleanup *CleanupReport
+}
+
+func (e DeploymentError) Error() string {
+	if e.Cleanup == nil {
+		return e.Cause.Error()
+	}
+
+	msg := fmt.Sprintf("%s. Deleted infrastructures: [%s]", e.Cause.Error(), strings.Join(e.Cleanup.Deleted, ", "))
+	if len(e.Cleanup.Orphaned) > 0 {
+		orphaned := make([]string, 0, len(e.Cleanup.Orphaned))
+		for id, err := range e.Cleanup.Orphaned {
+			orphaned = append(orphaned, fmt.Sprintf("%s: %s", id, err))
+		}
+		msg = fmt.Sprintf("%s. Orphaned infrastructures: [%s]", msg, strings.Join(orphaned, ", "))
+	}
+	return msg
+}
+
+func (e DeploymentError) Unwrap() error {
+	return e.Cause
+}
+
+// cleanPartialDeployment deletes the infrastructures of a failed deployment. Created infrastructures are deleted from the provider and the repository while the nodes of failed infrastructures that were created are deleted from the provider.
+// Infrastructures that can't be deleted are marked as orphaned in the repository and returned. The context of the deployment is not used so the cleanup is done even if the deployment was cancelled.
+func (c *Deployer) cleanPartialDeployment(principal string, created, failed []model.InfrastructureDeploymentInfo, progress model.ProgressFunc) ([]model.InfrastructureDeploymentInfo, CleanupReport) {
+	ctx := context.Background()
+	report := CleanupReport{
+		Deleted:  make([]string, 0, len(created)+len(failed)),
+		Orphaned: make(map[string]string),
+	}
+	remaining := make([]model.InfrastructureDeploymentInfo, 0)
+
+	for _, infra := range created {
+		logger := log.WithField("infrastructure", infra.ID)
+		logger.Info("Autoclean: deleting infrastructure of failed deployment")
+		c.reportProgress(progress, infra.Name, "deleting", nil)
+		_, err := c.DeleteInfrastructure(ctx, principal, infra.ID)
+		if err != nil {
+			logger.WithError(err).Error("Autoclean: error deleting infrastructure")
+			infra, err = c.markOrphaned(principal, infra, err, &report)
+			c.reportProgress(progress, infra.Name, OrphanedStatus, err)
+			remaining = append(remaining, infra)
+		} else {
+			report.Deleted = append(report.Deleted, infra.ID)
+			c.reportProgress(progress, infra.Name, "deleted", nil)
+		}
+	}
+
+	for _, infra := range failed {
+		if infra.NumNodes() == 0 {
+			continue
+		}
+
+		logger := log.WithField("infrastructure", infra.ID)
+		logger.Info("Autoclean: deleting nodes of failed infrastructure")
+		delErr := c.deleteFailedInfrastructure(ctx, principal, infra)
+		if delErr != nil {
+			logger.WithError(delErr).Error("Autoclean: error deleting nodes of failed infrastructure")
+			infra.Provider.Credentials = nil
+			saved, err := c.Repository.AddInfrastructure(infra)
+			if err != nil {
+				logger.WithError(err).Error("Autoclean: error saving orphaned infrastructure")
+			} else {
+				infra = saved
+			}
+			infra, _ = c.markOrphaned(principal, infra, delErr, &report)
+			c.reportProgress(progress, infra.Name, OrphanedStatus, delErr)
+			remaining = append(remaining, infra)
+		} else {
+			report.Deleted = append(report.Deleted, infra.ID)
+			c.recordNodes(principal, model.EventNodeDeleted, infra)
+		}
+	}
+
+	return remaining, report
+}
+
+func (c *Deployer) deleteFailedInfrastructure(ctx context.Context, principal string, infra model.InfrastructureDeploymentInfo) error {
+	deployer, err := c.findProvider(principal, infra.ID, infra.Provider)
+	if err != nil {
+		return err
+	}
+
+	delErrors := deployer.DeleteInfrastructure(ctx, infra)
+	if delErrors != nil && len(delErrors) > 0 {
+		return fmt.Errorf("Errors found deleting infrastructure: %v", delErrors)
+	}
+	return nil
+}
+
+func (c *Deployer) markOrphaned(principal string, infra model.InfrastructureDeploymentInfo, cause error, report *CleanupReport) (model.InfrastructureDeploymentInfo, error) {
+	report.Orphaned[infra.ID] = cause.Error()
+	updated, err := c.Repository.UpdateInfrastructureStatus(infra.ID, OrphanedStatus)
+	if err != nil {
+		log.WithError(err).Errorf("Error marking infrastructure %s as orphaned", infra.ID)
+		infra.Status = OrphanedStatus
+		return infra, cause
+	}
+	c.recordStatus(principal, infra.Status, updated)
+	return updated, cause
+}
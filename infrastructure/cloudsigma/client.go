@@ -17,10 +17,13 @@
 package cloudsigma
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 
 	resty "github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -30,6 +33,9 @@ const (
 	ServerStopAction  = "stop"
 )
 
+// ErrNotFound is returned by the operations which look for the first resource of a list when it's empty
+var ErrNotFound = errors.New("Drive not found")
+
 type CloudSigmaError struct {
 	Code        int    `json:"http_code"`
 	Description string `json:"error_description"`
@@ -41,15 +47,42 @@ func (e CloudSigmaError) Error() string {
 
 type Client struct {
 	httpClient *resty.Client
+	retry      RetryPolicy
+	limiter    *rateLimiter
 }
 
 func NewClient(baseUrl string, username string, password string, debug bool) *Client {
 	return &Client{
 		httpClient: resty.New().SetRedirectPolicy(resty.FlexibleRedirectPolicy(20)).SetHostURL(baseUrl).SetBasicAuth(username, password).SetDebug(debug),
+		retry:      DefaultRetryPolicy(),
+		limiter:    accountLimiter(baseUrl, username),
 	}
 }
 
-func execute(request *resty.Request, path string, method string, result interface{}) error {
+// BaseURL returns the URL of the CloudSigma API used by the client
+func (c *Client) BaseURL() string {
+	return c.httpClient.HostURL
+}
+
+// SetRetryPolicy sets how the requests that fail are retried
+func (c *Client) SetRetryPolicy(policy RetryPolicy) *Client {
+	c.retry = policy
+	return c
+}
+
+// SetRateLimit sets the maximum number of requests per second sent to the account of the client, shared by every client of the same API URL and username. Zero disables the limit.
+func (c *Client) SetRateLimit(requestsPerSecond float64) *Client {
+	c.limiter.SetLimit(requestsPerSecond)
+	return c
+}
+
+// request creates a request which will be cancelled with the context passed as parameter
+func (c *Client) request(ctx context.Context) *resty.Request {
+	return c.httpClient.R().SetContext(ctx)
+}
+
+// execute sends a request, retrying it according to the retry policy of the client if it fails and can be sent again safely
+func (c *Client) execute(request *resty.Request, path string, method string, result interface{}) error {
 
 	//request.SetError(&CloudSigmaError{})
 
@@ -57,97 +90,152 @@ func execute(request *resty.Request, path string, method string, result interfac
 		request.SetResult(result)
 	}
 
-	response, errRequest := request.Execute(method, path)
+	ctx := request.Context()
+	logger := log.WithFields(log.Fields{
+		"method": method,
+		"path":   path,
+	})
 
-	if errRequest != nil {
-		return fmt.Errorf("Error executing request to %s %s: %w", method, path, errRequest)
-	}
+	for attempt := 1; ; attempt++ {
+		err := c.limiter.Wait(ctx)
+		if err != nil {
+			return fmt.Errorf("Error executing request to %s %s: %w", method, path, err)
+		}
+
+		Metrics.Add("requests", 1)
+		response, errRequest := request.Execute(method, path)
 
-	if response.IsError() {
-		return CloudSigmaError{
-			Code:        response.StatusCode(),
-			Description: response.String(),
+		if errRequest == nil && !response.IsError() {
+			if attempt > 1 {
+				logger.Infof("Request succeeded after %d retries", attempt-1)
+			}
+			return nil
+		}
+
+		if errRequest == nil && response.StatusCode() == http.StatusTooManyRequests {
+			Metrics.Add("throttled", 1)
 		}
-	}
 
-	return nil
+		err = errRequest
+		if err != nil {
+			err = fmt.Errorf("Error executing request to %s %s: %w", method, path, errRequest)
+		} else {
+			err = CloudSigmaError{
+				Code:        response.StatusCode(),
+				Description: response.String(),
+			}
+		}
+
+		if !retryable(method, response, errRequest) || ctx.Err() != nil {
+			return err
+		}
+
+		if attempt >= c.retry.MaxAttempts {
+			Metrics.Add("retries_exhausted", 1)
+			logger.WithError(err).Errorf("Request failed after %d retries", attempt-1)
+			return err
+		}
+
+		wait := c.retry.wait(attempt, response)
+		Metrics.Add("retries", 1)
+		logger.WithError(err).Warnf("Request failed on attempt %d of %d. Retrying in %s", attempt, c.retry.MaxAttempts, wait)
+
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+	}
 }
 
-func getFirstObjectOfList(request *resty.Request, path string, method string) (ResourceType, error) {
+func (c *Client) getFirstObjectOfList(request *resty.Request, path string, method string) (ResourceType, error) {
 	var listResponse RequestResponseType
-	err := execute(request, path, method, &listResponse)
+	err := c.execute(request, path, method, &listResponse)
 	if err == nil {
 		if len(listResponse.Objects) > 0 {
 			return listResponse.Objects[0], nil
 		}
-		return ResourceType{}, errors.New("Drive not found")
+		return ResourceType{}, ErrNotFound
 
 	}
 	return ResourceType{}, err
 }
 
-func (c *Client) GetLibDrive(params map[string]string) (ResourceType, error) {
-	return getFirstObjectOfList(c.httpClient.R().SetQueryParams(params), "/libdrives", resty.MethodGet)
+func (c *Client) GetLibDrive(ctx context.Context, params map[string]string) (ResourceType, error) {
+	return c.getFirstObjectOfList(c.request(ctx).SetQueryParams(params), "/libdrives", resty.MethodGet)
+}
+
+func (c *Client) GetLibDriveDetails(ctx context.Context, uuid string) (ResourceType, error) {
+	var result ResourceType
+	path := fmt.Sprintf("/libdrives/%s/", uuid)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
+	return result, err
 }
 
-func (c *Client) CloneDrive(uuid string, info *ResourceType, library bool) (ResourceType, error) {
+func (c *Client) CloneDrive(ctx context.Context, uuid string, info *ResourceType, library bool) (ResourceType, error) {
 	source := "libdrives"
 	if !library {
 		source = "drives"
 	}
 	path := fmt.Sprintf("/%s/%s/action/?do=clone", source, uuid)
-	request := c.httpClient.R()
+	request := c.request(ctx)
 	if info != nil {
 		request = request.SetBody(info)
 	}
-	return getFirstObjectOfList(request, path, resty.MethodPost)
+	return c.getFirstObjectOfList(request, path, resty.MethodPost)
 }
 
-func (c *Client) GetDriveDetails(uuid string) (ResourceType, error) {
+func (c *Client) GetDriveDetails(ctx context.Context, uuid string) (ResourceType, error) {
 	var result ResourceType
 	path := fmt.Sprintf("/drives/%s", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
 	return result, err
 }
 
-func (c *Client) DeleteDrive(uuid string) error {
+func (c *Client) DeleteDrive(ctx context.Context, uuid string) error {
 	path := fmt.Sprintf("/drives/%s/", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodDelete, nil)
+	err := c.execute(c.request(ctx), path, resty.MethodDelete, nil)
 	return err
 }
 
-func (c *Client) CreateDrive(drive ResourceType) (ResourceType, error) {
-	return getFirstObjectOfList(c.httpClient.R().SetBody(drive), "/drives/", resty.MethodPost)
+func (c *Client) CreateDrive(ctx context.Context, drive ResourceType) (ResourceType, error) {
+	return c.getFirstObjectOfList(c.request(ctx).SetBody(drive), "/drives/", resty.MethodPost)
 }
 
-func (c *Client) CreateServers(servers RequestResponseType) (RequestResponseType, error) {
+func (c *Client) CreateServers(ctx context.Context, servers RequestResponseType) (RequestResponseType, error) {
 	var result RequestResponseType
-	err := execute(c.httpClient.R().SetBody(servers), "/servers/", resty.MethodPost, &result)
+	err := c.execute(c.request(ctx).SetBody(servers), "/servers/", resty.MethodPost, &result)
 	return result, err
 }
 
-func (c *Client) GetServerDetails(uuid string) (ResourceType, error) {
+func (c *Client) GetServerDetails(ctx context.Context, uuid string) (ResourceType, error) {
 	var result ResourceType
 	var path = fmt.Sprintf("/servers/%s", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
+	return result, err
+}
+
+// UpdateServer replaces the definition of a stopped server
+func (c *Client) UpdateServer(ctx context.Context, server ResourceType) (ResourceType, error) {
+	var result ResourceType
+	path := fmt.Sprintf("/servers/%s/", server.UUID)
+	err := c.execute(c.request(ctx).SetBody(server), path, resty.MethodPut, &result)
 	return result, err
 }
 
-func (c *Client) ExecuteServerAction(uuid string, action string) (ActionResultType, error) {
+func (c *Client) ExecuteServerAction(ctx context.Context, uuid string, action string) (ActionResultType, error) {
 	var result ActionResultType
 	path := fmt.Sprintf("/servers/%s/action/?do=%s", uuid, action)
-	err := execute(c.httpClient.R(), path, resty.MethodPost, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodPost, &result)
 	return result, err
 }
 
-func (c *Client) DeleteServerWithDrives(uuid string) error {
+func (c *Client) DeleteServerWithDrives(ctx context.Context, uuid string) error {
 	path := fmt.Sprintf("/servers/%s/?recurse=all_drives", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodDelete, nil)
+	err := c.execute(c.request(ctx), path, resty.MethodDelete, nil)
 	return err
 }
 
-func (c *Client) CreateTag(name string, resources []ResourceType) (ResourceType, error) {
-	request := c.httpClient.R().SetBody(RequestResponseType{
+func (c *Client) CreateTag(ctx context.Context, name string, resources []ResourceType) (ResourceType, error) {
+	request := c.request(ctx).SetBody(RequestResponseType{
 		Objects: []ResourceType{
 			ResourceType{
 				Name:      name,
@@ -155,38 +243,53 @@ func (c *Client) CreateTag(name string, resources []ResourceType) (ResourceType,
 			},
 		},
 	})
-	return getFirstObjectOfList(request, "/tags/", resty.MethodPost)
+	return c.getFirstObjectOfList(request, "/tags/", resty.MethodPost)
+}
+
+// ListTags returns every tag of the account
+func (c *Client) ListTags(ctx context.Context) (RequestResponseType, error) {
+	var result RequestResponseType
+	err := c.execute(c.request(ctx).SetQueryParam("limit", "0"), "/tags/", resty.MethodGet, &result)
+	return result, err
 }
 
-func (c *Client) GetByTag(uuid string, resourceType string) (RequestResponseType, error) {
+func (c *Client) GetByTag(ctx context.Context, uuid string, resourceType string) (RequestResponseType, error) {
 	var result RequestResponseType
 	path := fmt.Sprintf("/tags/%s/%s/", uuid, resourceType)
-	err := execute(c.httpClient.R(), path, resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
 	return result, err
 }
 
-func (c *Client) GetTagInformation(uuid string) (ResourceType, error) {
+func (c *Client) GetTagInformation(ctx context.Context, uuid string) (ResourceType, error) {
 	var result ResourceType
 	path := fmt.Sprintf("/tag/%s/", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
 	return result, err
 }
 
-func (c *Client) DeleteTag(uuid string) error {
+func (c *Client) DeleteTag(ctx context.Context, uuid string) error {
 	path := fmt.Sprintf("/tags/%s/", uuid)
-	err := execute(c.httpClient.R(), path, resty.MethodDelete, nil)
+	err := c.execute(c.request(ctx), path, resty.MethodDelete, nil)
 	return err
 }
 
-func (c *Client) GetAvailableIps() (RequestResponseType, error) {
+// GetVLAN returns the information of a private network of the account
+func (c *Client) GetVLAN(ctx context.Context, uuid string) (ResourceType, error) {
+	var result ResourceType
+	path := fmt.Sprintf("/vlans/%s/", uuid)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
+	return result, err
+}
+
+func (c *Client) GetAvailableIps(ctx context.Context) (RequestResponseType, error) {
 	var result RequestResponseType
-	err := execute(c.httpClient.R(), "/ips", resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), "/ips", resty.MethodGet, &result)
 	return result, err
 }
 
-func (c *Client) GetIPReference(ip string) (IPReferenceType, error) {
+func (c *Client) GetIPReference(ctx context.Context, ip string) (IPReferenceType, error) {
 	var result IPReferenceType
 	path := fmt.Sprintf("/ips/%s/", ip)
-	err := execute(c.httpClient.R(), path, resty.MethodGet, &result)
+	err := c.execute(c.request(ctx), path, resty.MethodGet, &result)
 	return result, err
 }
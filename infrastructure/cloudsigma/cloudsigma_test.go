@@ -17,6 +17,7 @@
 package cloudsigma
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -62,6 +63,7 @@ func TestMain(m *testing.M) {
 		}
 	}
 
+	os.Exit(m.Run())
 }
 
 func waitForStatusChange(t *testing.T, tag string, resourceType string, status string, timeout time.Duration) (RequestResponseType, bool, error) {
@@ -70,7 +72,7 @@ func waitForStatusChange(t *testing.T, tag string, resourceType string, status s
 	var response RequestResponseType
 	var err error
 	for !ready && waited < timeout && err == nil {
-		response, err := client.GetByTag(tag, resourceType)
+		response, err := client.GetByTag(context.Background(), tag, resourceType)
 		if err != nil {
 			t.Fatalf("Error getting resource type %s by tag %s: %s", resourceType, tag, err.Error())
 		}
@@ -91,9 +93,9 @@ func waitForStatusChange(t *testing.T, tag string, resourceType string, status s
 
 func TestTag(t *testing.T) {
 	if *integration {
-		tag, err := client.CreateTag("test-tag", []ResourceType{})
-		err = client.DeleteTag(tag.UUID)
-		tag, err = client.GetTagInformation(tag.UUID)
+		tag, err := client.CreateTag(context.Background(), "test-tag", []ResourceType{})
+		err = client.DeleteTag(context.Background(), tag.UUID)
+		tag, err = client.GetTagInformation(context.Background(), tag.UUID)
 
 		if err == nil {
 			t.Fatalf("Expected 404 error getting tag but got tag %s", tag.UUID)
@@ -104,7 +106,7 @@ func TestTag(t *testing.T) {
 func TestListDrives(t *testing.T) {
 
 	if *integration {
-		drive, err := client.GetLibDrive(map[string]string{
+		drive, err := client.GetLibDrive(context.Background(), map[string]string{
 			"version": "16.04 DITAS",
 		})
 
@@ -120,7 +122,7 @@ func TestListDrives(t *testing.T) {
 			t.Fatalf("Invalid version found: %s", drive.Version)
 		}
 
-		clone, err := client.CloneDrive(drive.UUID, nil, true)
+		clone, err := client.CloneDrive(context.Background(), drive.UUID, nil, true)
 
 		if err != nil {
 			t.Fatalf("Error cloning DITAS drive %s", err.Error())
@@ -134,7 +136,7 @@ func TestListDrives(t *testing.T) {
 			t.Fatalf("Same UUID found in drive and clone")
 		}
 
-		tag, err := client.CreateTag("test-vdc", []ResourceType{clone})
+		tag, err := client.CreateTag(context.Background(), "test-vdc", []ResourceType{clone})
 		if err != nil {
 			t.Fatalf("Error creating test tag")
 		}
@@ -178,7 +180,7 @@ func TestListDrives(t *testing.T) {
 					Drive:      clone,
 				}},
 				NICS: []ServerNICType{ServerNICType{
-					IPV4Conf: ServerIPV4ConfType{
+					IPV4Conf: &ServerIPV4ConfType{
 						Conf: "dhcp",
 					},
 					Model: "virtio",
@@ -190,7 +192,7 @@ func TestListDrives(t *testing.T) {
 			}},
 		}
 
-		availableServers, err := client.CreateServers(resources)
+		availableServers, err := client.CreateServers(context.Background(), resources)
 		if err != nil {
 			t.Fatalf("Error creating servers: %s", err.Error())
 		}
@@ -222,7 +224,7 @@ func TestListDrives(t *testing.T) {
 			t.Fatalf("Server tag %s is different than expected %s", found.Tags[0].UUID, tag.UUID)
 		}
 
-		serverInfo, err := client.GetServerDetails(found.UUID)
+		serverInfo, err := client.GetServerDetails(context.Background(), found.UUID)
 
 		if err != nil {
 			t.Errorf("Error reading individual server %s information: %s", found.UUID, err.Error())
@@ -236,7 +238,7 @@ func TestListDrives(t *testing.T) {
 			t.Errorf("Empty status returned for individual server %s", found.UUID)
 		}
 
-		actionResult, err := client.ExecuteServerAction(found.UUID, ServerStartAction)
+		actionResult, err := client.ExecuteServerAction(context.Background(), found.UUID, ServerStartAction)
 
 		if err != nil {
 			t.Fatalf("Error starting server %s", err.Error())
@@ -263,7 +265,7 @@ func TestListDrives(t *testing.T) {
 			}
 		}
 
-		actionResult, err = client.ExecuteServerAction(found.UUID, ServerStopAction)
+		actionResult, err = client.ExecuteServerAction(context.Background(), found.UUID, ServerStopAction)
 
 		if err != nil {
 			t.Fatalf("Error stopping server %s", err.Error())
@@ -290,13 +292,13 @@ func TestListDrives(t *testing.T) {
 			}
 		}
 
-		err = client.DeleteServerWithDrives(found.UUID)
+		err = client.DeleteServerWithDrives(context.Background(), found.UUID)
 
 		if err != nil {
 			t.Fatalf("Error deleting server: %s", err.Error())
 		}
 
-		resources, err = client.GetByTag(tag.UUID, ServersType)
+		resources, err = client.GetByTag(context.Background(), tag.UUID, ServersType)
 
 		if err != nil {
 			t.Fatalf("Error getting servers after delete: %s", err.Error())
@@ -306,7 +308,7 @@ func TestListDrives(t *testing.T) {
 			t.Fatalf("Found server %s after deletion", resources.Objects[0].UUID)
 		}
 
-		resources, err = client.GetByTag(tag.UUID, DrivesType)
+		resources, err = client.GetByTag(context.Background(), tag.UUID, DrivesType)
 
 		if err != nil {
 			t.Fatalf("Error getting drives after delete: %s", err.Error())
@@ -316,13 +318,13 @@ func TestListDrives(t *testing.T) {
 			t.Fatalf("Found drive %s after deletion", resources.Objects[0].UUID)
 		}
 
-		err = client.DeleteTag(tag.UUID)
+		err = client.DeleteTag(context.Background(), tag.UUID)
 
 		if err != nil {
 			t.Fatalf("Error deleting tag: %s", err.Error())
 		}
 
-		tag, err = client.GetTagInformation(tag.UUID)
+		tag, err = client.GetTagInformation(context.Background(), tag.UUID)
 
 		if err == nil {
 			t.Fatalf("Expected 404 error getting tag but got tag %s", tag.UUID)
@@ -17,17 +17,19 @@
 package cloudsigma
 
 import (
+	"context"
 	"deployment-engine/model"
+	"deployment-engine/persistence"
 	"deployment-engine/utils"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"regexp"
+	"net"
+	"net/http"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
-
 	"github.com/sethvargo/go-password/password"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -42,11 +44,28 @@ const (
 	BootDriveTypeCustom  = "custom"
 
 	BootDriveTypeDefault = BootDriveTypeLibrary
+
+	// CloudInitTimeoutProperty is the maximum time to wait for cloud-init to finish in the nodes with user-data
+	CloudInitTimeoutProperty = "cloudsigma.cloudinit_timeout"
+
+	CloudInitTimeoutDefaultValue = "15m"
 )
 
 type CloudsigmaDeployer struct {
 	publicKey string
 	client    *Client
+	// instance is the name of this instance of the deployment engine, which is tagged in the resources it creates
+	instance string
+	// leases reserves the IPs of the nodes being created in the pool of the account
+	leases   persistence.IPLeaseRepository
+	pool     string
+	leaseTTL time.Duration
+	// cloudInitTimeout is the maximum time to wait for cloud-init in the nodes with user-data
+	cloudInitTimeout time.Duration
+	// waitCloudInit waits for cloud-init to finish in a node and returns its final status. If it's nil it's checked by SSH.
+	waitCloudInit func(ctx context.Context, node model.NodeInfo, timeout time.Duration) (string, error)
+	// imageTTL is the time the boot images found in the library are cached
+	imageTTL time.Duration
 }
 
 type NodeCreationResult struct {
@@ -64,22 +83,46 @@ type HostDisks struct {
 	Data  []ResourceType
 }
 
-func NewDeployer(apiURL string, credentials model.BasicAuthSecret, publicKeyPath string) (*CloudsigmaDeployer, error) {
+// NewDeployer creates a CloudSigma deployer for an account. The IPs of the nodes are reserved in the lease repository while they are created, so it returns ErrNoIPLeases if it's nil.
+func NewDeployer(apiURL string, credentials model.BasicAuthSecret, publicKeyPath string, leases persistence.IPLeaseRepository) (*CloudsigmaDeployer, error) {
+	if leases == nil {
+		return nil, ErrNoIPLeases
+	}
 
 	viper.SetDefault("debug_cs_client", false)
+	viper.SetDefault(EngineInstanceProperty, EngineInstanceDefault)
+	viper.SetDefault(MaxAttemptsProperty, MaxAttemptsDefaultValue)
+	viper.SetDefault(BackoffProperty, BackoffDefaultValue)
+	viper.SetDefault(MaxBackoffProperty, MaxBackoffDefaultValue)
+	viper.SetDefault(RateLimitProperty, RateLimitDefaultValue)
+	viper.SetDefault(IPLeaseTTLProperty, IPLeaseTTLDefaultValue)
+	viper.SetDefault(CloudInitTimeoutProperty, CloudInitTimeoutDefaultValue)
+	viper.SetDefault(ImageCacheTTLProperty, ImageCacheTTLDefaultValue)
 
 	pubKeyRaw, err := ioutil.ReadFile(publicKeyPath)
 	if err == nil {
 		pubKey := string(pubKeyRaw)
 		client := NewClient(apiURL,
-			credentials.Username, credentials.Password, viper.GetBool("debug_cs_client"))
+			credentials.Username, credentials.Password, viper.GetBool("debug_cs_client")).
+			SetRetryPolicy(RetryPolicy{
+				MaxAttempts: viper.GetInt(MaxAttemptsProperty),
+				Backoff:     viper.GetDuration(BackoffProperty),
+				MaxBackoff:  viper.GetDuration(MaxBackoffProperty),
+			}).
+			SetRateLimit(viper.GetFloat64(RateLimitProperty))
 		return &CloudsigmaDeployer{
-			client:    client,
-			publicKey: pubKey,
+			client:           client,
+			publicKey:        pubKey,
+			instance:         viper.GetString(EngineInstanceProperty),
+			leases:           leases,
+			pool:             ipPool(apiURL, credentials.Username),
+			leaseTTL:         viper.GetDuration(IPLeaseTTLProperty),
+			cloudInitTimeout: viper.GetDuration(CloudInitTimeoutProperty),
+			imageTTL:         viper.GetDuration(ImageCacheTTLProperty),
 		}, nil
 	}
 
-	log.Infof("Error reading public key: %w", err)
+	log.WithError(err).Error("Error reading public key")
 
 	return nil, err
 }
@@ -95,10 +138,10 @@ func (d *CloudsigmaDeployer) returnError(logger *log.Entry, msg string, result N
 	return err
 }
 
-func (d *CloudsigmaDeployer) waitForDiskReady(logInput *log.Entry, uuid, status string) DiskCreationResult {
+func (d *CloudsigmaDeployer) waitForDiskReady(ctx context.Context, logInput *log.Entry, uuid, status string) DiskCreationResult {
 	logger := logInput.WithField("drive", uuid)
 	timeout := 60 * time.Second
-	drive, timedOut, err := d.waitForStatusChange(uuid, status, timeout, d.client.GetDriveDetails)
+	drive, timedOut, err := d.waitForStatusChange(ctx, uuid, status, timeout, d.client.GetDriveDetails)
 	result := DiskCreationResult{
 		Disk:  drive,
 		Error: err,
@@ -125,12 +168,13 @@ func (d *CloudsigmaDeployer) waitForDiskReady(logInput *log.Entry, uuid, status
 	return result
 }
 
-func (d *CloudsigmaDeployer) createDataDisk(logInput *log.Entry, hostname string, storage model.Drive, c chan DiskCreationResult) {
+func (d *CloudsigmaDeployer) createDataDisk(ctx context.Context, logInput *log.Entry, hostname string, storage model.Drive, tags []ResourceType, c chan DiskCreationResult) {
 	logInput.Info("Creating data disk")
-	dataDisk, err := d.client.CreateDrive(ResourceType{
+	dataDisk, err := d.client.CreateDrive(ctx, ResourceType{
 		Media: "disk",
 		Size:  storage.Size * 1024 * 1024,
-		Name:  fmt.Sprintf("data-%s-%s", hostname, storage.Name),
+		Name:  utils.DataDriveName(hostname, storage),
+		Tags:  tags,
 	})
 	result := DiskCreationResult{
 		Disk:  dataDisk,
@@ -144,15 +188,15 @@ func (d *CloudsigmaDeployer) createDataDisk(logInput *log.Entry, hostname string
 	logInput.Info("Data disk created")
 	logger := logInput.WithField("disk", dataDisk.UUID)
 	logger.Info("Waiting for data disk to be ready")
-	c <- d.waitForDiskReady(logger, dataDisk.UUID, "creating")
+	c <- d.waitForDiskReady(ctx, logger, dataDisk.UUID, "creating")
 	return
 }
 
-func (d *CloudsigmaDeployer) isLibraryDrive(resource model.ResourceType) bool {
+func isLibraryDrive(resource model.ResourceType) bool {
 	return resource.ExtraProperties == nil || resource.ExtraProperties[BootDriveTypeProperty] == "" || resource.ExtraProperties[BootDriveTypeProperty] == BootDriveTypeLibrary
 }
 
-func (d *CloudsigmaDeployer) cloneDisk(logInput *log.Entry, hostname string, resource model.ResourceType, c chan DiskCreationResult) {
+func (d *CloudsigmaDeployer) cloneDisk(ctx context.Context, logInput *log.Entry, hostname string, resource model.ResourceType, tags []ResourceType, c chan DiskCreationResult) {
 
 	logger := log.WithField("disk", resource.ImageId)
 	drive := ResourceType{}
@@ -162,11 +206,12 @@ func (d *CloudsigmaDeployer) cloneDisk(logInput *log.Entry, hostname string, res
 		drive.Size = resource.Disk * 1024 * 1024
 	}
 
-	drive.Name = fmt.Sprintf("boot-%s", hostname)
+	drive.Name = utils.BootDriveName(hostname)
+	drive.Tags = tags
 
 	logger.Info("Cloning disk")
 
-	cloned, err := d.client.CloneDrive(resource.ImageId, &drive, d.isLibraryDrive(resource))
+	cloned, err := d.client.CloneDrive(ctx, resource.ImageId, &drive, isLibraryDrive(resource))
 
 	result := DiskCreationResult{
 		Disk:  cloned,
@@ -186,20 +231,20 @@ func (d *CloudsigmaDeployer) cloneDisk(logInput *log.Entry, hostname string, res
 	}
 
 	logger.Info("Disk cloned. Waiting for it to be ready...")
-	c <- d.waitForDiskReady(logger, cloned.UUID, "cloning_dst")
+	c <- d.waitForDiskReady(ctx, logger, cloned.UUID, "cloning_dst")
 	return
 }
 
-func (d *CloudsigmaDeployer) createHostDrives(logInput *log.Entry, hostname string, resource model.ResourceType) (HostDisks, error) {
+func (d *CloudsigmaDeployer) createHostDrives(ctx context.Context, logInput *log.Entry, hostname string, resource model.ResourceType, tags []ResourceType) (HostDisks, error) {
 	logInput.Info("Creating host drives")
 	result := HostDisks{
 		Data: make([]ResourceType, 0, len(resource.Drives)),
 	}
 	totalDisks := len(resource.Drives) + 1
 	c := make(chan DiskCreationResult, totalDisks)
-	go d.cloneDisk(logInput, hostname, resource, c)
+	go d.cloneDisk(ctx, logInput, hostname, resource, tags, c)
 	for _, localDisk := range resource.Drives {
-		go d.createDataDisk(logInput, hostname, localDisk, c)
+		go d.createDataDisk(ctx, logInput, hostname, localDisk, tags, c)
 	}
 	var err error
 	for remaining := totalDisks; remaining > 0; remaining-- {
@@ -225,9 +270,19 @@ func (d *CloudsigmaDeployer) createHostDrives(logInput *log.Entry, hostname stri
 	return result, err
 }
 
-func (d *CloudsigmaDeployer) createServer(logger *log.Entry, nodeName string, resource model.ResourceType, disk ResourceType, dataDisks []ResourceType, ip IPReferenceType, pw string) (ResourceType, error) {
-	conf := "static"
+// serverMeta returns the metadata of a server: the public key of the engine and, if any, the cloud-init user-data, encoded in base64 so it can contain any character
+func (d *CloudsigmaDeployer) serverMeta(userData string) map[string]string {
+	meta := map[string]string{
+		"ssh_public_key": d.publicKey,
+	}
+	if userData != "" {
+		meta["cloudinit-user-data"] = base64.StdEncoding.EncodeToString([]byte(userData))
+		meta["base64_fields"] = "cloudinit-user-data"
+	}
+	return meta
+}
 
+func (d *CloudsigmaDeployer) createServer(ctx context.Context, logger *log.Entry, nodeName string, resource model.ResourceType, disk ResourceType, dataDisks []ResourceType, ip IPReferenceType, pw string, userData string, tags []ResourceType) (ResourceType, error) {
 	drives := make([]ServerDriveType, len(dataDisks)+1)
 
 	drives[0] = ServerDriveType{
@@ -251,21 +306,14 @@ func (d *CloudsigmaDeployer) createServer(logger *log.Entry, nodeName string, re
 			Mem:         resource.RAM * 1024 * 1024,
 			VNCPassword: pw,
 			Drives:      drives,
-			NICS: []ServerNICType{ServerNICType{
-				IPV4Conf: ServerIPV4ConfType{
-					Conf: conf,
-					IP:   ip,
-				},
-				Model: "virtio",
-			}},
-			Meta: map[string]string{
-				"ssh_public_key": d.publicKey,
-			},
-			SMP: resource.Cores,
+			NICS:        serverNICs(resource, ip),
+			Meta:        d.serverMeta(userData),
+			SMP:         resource.Cores,
+			Tags:        tags,
 		}},
 	}
 
-	servers, err := d.client.CreateServers(servers)
+	servers, err := d.client.CreateServers(ctx, servers)
 
 	if err != nil {
 		logger.WithError(err).Error("Error creating server")
@@ -290,10 +338,56 @@ func (d *CloudsigmaDeployer) createServer(logger *log.Entry, nodeName string, re
 	return server, nil
 }
 
-func (d *CloudsigmaDeployer) startServer(logger *log.Entry, uuid string) (ResourceType, error) {
+// serverNICs returns the network interfaces of the server of a resource: the public one with a static IP followed by one in each of its private networks
+func serverNICs(resource model.ResourceType, ip IPReferenceType) []ServerNICType {
+	nics := make([]ServerNICType, 0, len(resource.Networks)+1)
+	nics = append(nics, ServerNICType{
+		IPV4Conf: &ServerIPV4ConfType{
+			Conf: "static",
+			IP:   ip,
+		},
+		Model: "virtio",
+	})
+	for _, network := range resource.Networks {
+		nics = append(nics, ServerNICType{
+			VLAN:  &ResourceType{UUID: network.ID},
+			Model: "virtio",
+		})
+	}
+	return nics
+}
+
+// privateNetworks returns the information of the private networks of a server created for a resource, with the addresses requested for the node and the MACs of its interfaces
+func privateNetworks(resource model.ResourceType, server ResourceType) []model.NetworkInfo {
+	result := make([]model.NetworkInfo, 0, len(resource.Networks))
+	used := make(map[int]bool)
+	for _, network := range resource.Networks {
+		info := model.NetworkInfo{
+			ID: network.ID,
+			IP: network.IP,
+		}
+		if network.IP != "" {
+			info.Netmask = network.Netmask
+			if info.Netmask == 0 {
+				info.Netmask = DefaultNetmask
+			}
+		}
+		for i, nic := range server.NICS {
+			if !used[i] && nic.VLAN != nil && nic.VLAN.UUID == network.ID {
+				used[i] = true
+				info.MAC = nic.MAC
+				break
+			}
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+func (d *CloudsigmaDeployer) startServer(ctx context.Context, logger *log.Entry, uuid string) (ResourceType, error) {
 	logger.Info("Starting server")
 	logger.WithField("server", uuid)
-	actionResult, err := d.client.ExecuteServerAction(uuid, ServerStartAction)
+	actionResult, err := d.client.ExecuteServerAction(ctx, uuid, ServerStartAction)
 	if err != nil {
 		logger.WithError(err).Error("Error starting server")
 		return ResourceType{}, err
@@ -307,7 +401,7 @@ func (d *CloudsigmaDeployer) startServer(logger *log.Entry, uuid string) (Resour
 	logger.Info("Server booting")
 
 	timeout := 120 * time.Second
-	server, timedOut, err := d.waitForStatusChange(uuid, "starting", timeout, d.client.GetServerDetails)
+	server, timedOut, err := d.waitForStatusChange(ctx, uuid, "starting", timeout, d.client.GetServerDetails)
 
 	logger.Infof("Waiting for server to start")
 
@@ -327,7 +421,9 @@ func (d *CloudsigmaDeployer) startServer(logger *log.Entry, uuid string) (Resour
 	return server, nil
 }
 
-func (d *CloudsigmaDeployer) CreateServer(resource model.ResourceType, ip IPReferenceType, pfx string, c chan NodeCreationResult) error {
+// CreateServer creates the drives and the server of a resource, tagging all of them with the tags passed as parameter, and starts it.
+// If the node has user-data it waits for cloud-init to finish before reporting it.
+func (d *CloudsigmaDeployer) CreateServer(ctx context.Context, resource model.ResourceType, ip IPReferenceType, infraID, pfx string, userData *model.UserData, tags []ResourceType, c chan NodeCreationResult) error {
 	result := NodeCreationResult{}
 
 	logger := log.WithField("resource", resource.Name)
@@ -336,7 +432,7 @@ func (d *CloudsigmaDeployer) CreateServer(resource model.ResourceType, ip IPRefe
 		return d.returnError(logger, "", result, fmt.Errorf("Resource with empty name found in infrastructure "+pfx), c)
 	}
 
-	nodeName, err := d.clearHostName(pfx + "-" + resource.Name)
+	nodeName, err := utils.ClearHostName(pfx + "-" + resource.Name)
 	if err != nil {
 		return d.returnError(logger, fmt.Sprintf("Invalid combination of hostname for infrastructure %s and resource %s", pfx, resource.Name), result, err, c)
 	}
@@ -346,18 +442,44 @@ func (d *CloudsigmaDeployer) CreateServer(resource model.ResourceType, ip IPRefe
 			Role:            strings.ToLower(resource.Role),
 			Hostname:        nodeName,
 			Username:        "cloudsigma",
+			ResourceName:    resource.Name,
 			ExtraProperties: resource.ExtraProperties,
 		},
 	}
 	logger.Info("Creating node", nodeName)
 
+	image, err := d.bootImage(ctx, resource)
+	if err != nil {
+		return d.returnError(logger, "Error finding boot image", result, err, c)
+	}
+	resource.ImageId = image.UUID
+	result.Info.ImageID = image.UUID
+	result.Info.OSFamily = image.OSFamily
+
+	renderedUserData := ""
+	if userData != nil {
+		renderedUserData, err = userData.Render(model.UserDataVars{
+			InfrastructureID:   infraID,
+			InfrastructureName: pfx,
+			Hostname:           nodeName,
+			Role:               result.Info.Role,
+			ResourceName:       resource.Name,
+			IP:                 ip.UUID,
+			Networks:           resource.Networks,
+			ExtraProperties:    resource.ExtraProperties,
+		})
+		if err != nil {
+			return d.returnError(logger, "Error rendering user-data", result, err, c)
+		}
+	}
+
 	pw, err := password.Generate(10, 3, 2, false, false)
 
 	if err != nil {
 		return d.returnError(logger, fmt.Sprintf("Error generating random password: %s\n", err.Error()), result, err, c)
 	}
 
-	disks, err := d.createHostDrives(logger, nodeName, resource)
+	disks, err := d.createHostDrives(ctx, logger, nodeName, resource, tags)
 	result.Info.DriveUUID = disks.Drive.UUID
 	result.Info.DataDrives = make([]model.DriveInfo, len(disks.Data))
 	result.Info.DriveSize = disks.Drive.Size
@@ -375,13 +497,26 @@ func (d *CloudsigmaDeployer) CreateServer(resource model.ResourceType, ip IPRefe
 
 	logger.Infof("Creating server")
 
-	server, err := d.createServer(logger, nodeName, resource, disks.Drive, disks.Data, ip, pw)
+	server, err := d.createServer(ctx, logger, nodeName, resource, disks.Drive, disks.Data, ip, pw, renderedUserData, tags)
 	result.Info.UUID = server.UUID
 	if err != nil {
 		return d.returnError(logger, "Error creating server", result, err, c)
 	}
 
-	server, err = d.startServer(logger, server.UUID)
+	// The MACs of the private interfaces are only known once the server is created, so their configuration is added to its user-data before starting it
+	if config := networksCloudConfig(privateNetworks(resource, server)); config != "" {
+		renderedUserData, err = combineUserData(config, renderedUserData)
+		if err != nil {
+			return d.returnError(logger, "Error adding configuration of private networks to user-data", result, err, c)
+		}
+		server.Meta = d.serverMeta(renderedUserData)
+		server, err = d.client.UpdateServer(ctx, server)
+		if err != nil {
+			return d.returnError(logger, "Error setting user-data of server", result, err, c)
+		}
+	}
+
+	server, err = d.startServer(ctx, logger, server.UUID)
 	if err != nil {
 		return d.returnError(logger, "Error starting server", result, err, c)
 	}
@@ -392,73 +527,388 @@ func (d *CloudsigmaDeployer) CreateServer(resource model.ResourceType, ip IPRefe
 	}
 
 	result.Info.IP = ip.UUID
+	result.Info.Networks = privateNetworks(resource, server)
 	result.Info.CPU = server.CPU
 	result.Info.RAM = server.Mem
 	result.Info.Cores = server.SMP
 
+	if renderedUserData != "" {
+		logger.Info("Waiting for cloud-init to finish")
+		result.Info.CloudInitStatus, err = d.cloudInitStatus(ctx, result.Info)
+		if err != nil {
+			return d.returnError(logger, "Error waiting for cloud-init", result, err, c)
+		}
+		if result.Info.CloudInitStatus != model.CloudInitStatusDone {
+			logger.Warn("Cloud-init finished with errors")
+		}
+	}
+
 	logger.Info("Server deployment complete!!!!")
 
 	c <- result
 	return nil
 }
 
-func (d *CloudsigmaDeployer) waitForStatusChange(uuid string, status string, timeout time.Duration, getter func(string) (ResourceType, error)) (ResourceType, bool, error) {
+// cloudInitStatus waits for cloud-init to finish in a node and returns its final status
+func (d *CloudsigmaDeployer) cloudInitStatus(ctx context.Context, node model.NodeInfo) (string, error) {
+	timeout := d.cloudInitTimeout
+	if timeout <= 0 {
+		timeout, _ = time.ParseDuration(CloudInitTimeoutDefaultValue)
+	}
+
+	if d.waitCloudInit != nil {
+		return d.waitCloudInit(ctx, node, timeout)
+	}
+	return utils.WaitForCloudInit(ctx, node, timeout)
+}
+
+func (d *CloudsigmaDeployer) waitForStatusChange(ctx context.Context, uuid string, status string, timeout time.Duration, getter func(context.Context, string) (ResourceType, error)) (ResourceType, bool, error) {
 	var resource ResourceType
 	var err error
-	_, timedOut, err := utils.WaitForStatusChange(status, timeout, func() (string, error) {
-		resource, err = getter(uuid)
+	_, timedOut, err := utils.WaitForStatusChange(ctx, status, timeout, func() (string, error) {
+		resource, err = getter(ctx, uuid)
 		return resource.Status, err
 	})
 	return resource, timedOut, err
 }
 
+// deletePartialDeployment deletes the resources of a node that couldn't be created. It doesn't use the context of the operation so the resources are deleted even if it was cancelled.
 func (d *CloudsigmaDeployer) deletePartialDeployment(logInput *log.Entry, nodeInfo NodeCreationResult) error {
 	logInput.Info("Undoing partial deployment...")
-	return d.deleteHost(logInput, nodeInfo.Info)
+	return d.deleteHost(context.Background(), logInput, nodeInfo.Info)
 }
 
-func (d *CloudsigmaDeployer) findFreeIps(log *log.Entry, numNodes int) ([]IPReferenceType, error) {
-	ipInfo, err := d.client.GetAvailableIps()
-	result := make([]IPReferenceType, 0, numNodes)
-	if err == nil {
-		numIps := len(ipInfo.Objects)
-		for i := 0; i < numIps && len(result) < numNodes; i++ {
-			currentIP := ipInfo.Objects[i]
-			if currentIP.Server == nil {
-				logger := log.WithField("IP", currentIP.UUID)
-				ref, err := d.client.GetIPReference(currentIP.UUID)
-				if err == nil {
-					result = append(result, ref)
-				} else {
-					logger.WithError(err).Error("Error getting IP information")
-				}
+// createNodes creates a node for each resource in parallel, adding the ones that succeed to the infrastructure. Their servers and drives are tagged with the infrastructure and the instance of the deployment engine.
+func (d CloudsigmaDeployer) createNodes(ctx context.Context, logger *log.Entry, infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) error {
+	numNodes := len(resources)
+
+	resources, err := utils.ResolveFlavors(DeploymentType, resources)
+	if err != nil {
+		return err
+	}
+
+	tags, err := d.engineTags(ctx, infra.ID)
+	if err != nil {
+		logger.WithError(err).Error("Error getting tags of the infrastructure")
+		return err
+	}
+
+	logger.Info("Leasing IPs")
+	ips, err := d.leaseIps(ctx, logger, infra.ID, resources)
+	if err != nil {
+		return err
+	}
+	// Once the nodes finish the IPs are either attached to their servers or free again
+	defer d.releaseIps(logger, infra.ID, ips)
+
+	logger.Infof("Leased IPs of the nodes")
+
+	c := make(chan NodeCreationResult, numNodes)
+
+	for i, resource := range resources {
+		userData := resource.UserData
+		if userData == nil {
+			userData = infra.UserData
+		}
+		go d.CreateServer(ctx, resource, ips[i], infra.ID, infra.Name, userData, tags, c)
+	}
+
+	var failed = false
+
+	for remaining := numNodes; remaining > 0; remaining-- {
+		result := <-c
+		if result.Error == nil {
+			infra.AddNode(result.Info)
+		} else {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("Deployment failed")
+	}
+
+	return nil
+}
+
+// ValidateInfrastructure checks that an infrastructure has the information needed to be created in CloudSigma
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if infra.Name == "" {
+		return errors.New("Name is mandatory for each cloudsigma infrastructure")
+	}
+
+	if len(infra.Resources) == 0 {
+		return errors.New("At least one resource is needed")
+	}
+
+	resources, err := utils.ResolveFlavors(DeploymentType, infra.Resources)
+	if err != nil {
+		return err
+	}
+
+	if infra.UserData != nil {
+		err = infra.UserData.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	ips := make(map[string]string)
+	privateIps := make(map[string]string)
+	for _, resource := range resources {
+		if resource.Name == "" {
+			return errors.New("Resource with empty name found")
+		}
+
+		if names[resource.Name] {
+			return fmt.Errorf("Name of resource %s is not unique", resource.Name)
+		}
+		names[resource.Name] = true
+
+		if resource.ImageId == "" && (resource.Image == nil || resource.Image.IsEmpty()) {
+			return fmt.Errorf("Empty boot image found for resource %s", resource.Name)
+		}
+
+		if resource.ImageId == "" && !isLibraryDrive(resource) {
+			return fmt.Errorf("Boot image of resource %s can only be looked up in the library", resource.Name)
+		}
+
+		if resource.CPU <= 0 || resource.Cores < 0 || resource.RAM <= 0 {
+			return fmt.Errorf("CPU and RAM must be greater than 0 for resource %s, or it must have a flavor type", resource.Name)
+		}
+
+		if resource.IP != "" {
+			if !isIPv4(resource.IP) {
+				return fmt.Errorf("Invalid IP %s for resource %s", resource.IP, resource.Name)
+			}
+			if ips[resource.IP] != "" {
+				return fmt.Errorf("IP %s is requested by resources %s and %s", resource.IP, ips[resource.IP], resource.Name)
+			}
+			ips[resource.IP] = resource.Name
+		}
+
+		err = validateNetworks(resource, privateIps)
+		if err != nil {
+			return err
+		}
+
+		if resource.UserData != nil {
+			err = resource.UserData.Validate()
+			if err != nil {
+				return fmt.Errorf("Invalid user-data for resource %s: %w", resource.Name, err)
 			}
 		}
-	} else {
-		log.WithError(err).Error("Error getting list of free IPs")
 	}
-	return result, err
+
+	return nil
+}
+
+func isIPv4(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() != nil
+}
+
+// validateNetworks checks the private networks of a resource. The private IPs already used by other resources are indexed by network and IP in privateIps.
+func validateNetworks(resource model.ResourceType, privateIps map[string]string) error {
+	networks := make(map[string]bool)
+	for _, network := range resource.Networks {
+		if network.ID == "" {
+			return fmt.Errorf("Private network without identifier found in resource %s", resource.Name)
+		}
+
+		if networks[network.ID] {
+			return fmt.Errorf("Private network %s is repeated in resource %s", network.ID, resource.Name)
+		}
+		networks[network.ID] = true
+
+		if network.IP == "" {
+			continue
+		}
+
+		if !isIPv4(network.IP) {
+			return fmt.Errorf("Invalid IP %s for resource %s in private network %s", network.IP, resource.Name, network.ID)
+		}
+
+		if network.Netmask < 0 || network.Netmask > 32 {
+			return fmt.Errorf("Invalid netmask %d for resource %s in private network %s", network.Netmask, resource.Name, network.ID)
+		}
+
+		key := network.ID + "/" + network.IP
+		if privateIps[key] != "" {
+			return fmt.Errorf("IP %s in private network %s is requested by resources %s and %s", network.IP, network.ID, privateIps[key], resource.Name)
+		}
+		privateIps[key] = resource.Name
+	}
+	return nil
+}
+
+func isAuthError(err error) bool {
+	var csErr CloudSigmaError
+	return errors.As(err, &csErr) && (csErr.Code == http.StatusUnauthorized || csErr.Code == http.StatusForbidden)
+}
+
+// checkImage verifies that the boot image of a resource exists in the library or in the account drives, depending on the boot drive type, and returns its identifier
+func (d CloudsigmaDeployer) checkImage(ctx context.Context, resource model.ResourceType) (string, error) {
+	if isLibraryDrive(resource) {
+		image, err := d.bootImage(ctx, resource)
+		return image.UUID, err
+	}
+
+	_, err := d.client.GetDriveDetails(ctx, resource.ImageId)
+	var csErr CloudSigmaError
+	if errors.As(err, &csErr) && csErr.Code == http.StatusNotFound {
+		return "", fmt.Errorf("Boot image %s not found", resource.ImageId)
+	}
+	return resource.ImageId, err
 }
 
-func (d CloudsigmaDeployer) clearHostName(hostname string) (string, error) {
-	toReplace := strings.ToLower(hostname)
-	reg, err := regexp.Compile("[^a-zA-Z0-9-]+")
+// imageKey identifies the boot image of a resource, whether it's selected by identifier or looked up in the library
+func imageKey(resource model.ResourceType) string {
+	if resource.ImageId == "" && resource.Image != nil {
+		return resource.Image.String()
+	}
+	return resource.ImageId
+}
+
+// planIps returns the IPs that would be assigned to the resources, adding to the plan the problems found with the requested ones and the lack of free IPs
+func (d CloudsigmaDeployer) planIps(ctx context.Context, plan *model.InfrastructurePlan, resources []model.ResourceType) ([]string, error) {
+	ips, err := d.accountIps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(resources))
+	for i, resource := range resources {
+		if resource.IP == "" {
+			continue
+		}
+		result[i] = resource.IP
+		if err := ips.checkRequested(resource); err != nil {
+			plan.AddError("%s", err.Error())
+		} else if ips.leased[resource.IP] {
+			plan.AddError("IP %s requested by resource %s is reserved by an infrastructure being created", resource.IP, resource.Name)
+		}
+	}
+
+	free := ips.freeExcept(requestedIps(resources))
+	needed := 0
+	for i, resource := range resources {
+		if resource.IP != "" {
+			continue
+		}
+		if needed < len(free) {
+			result[i] = free[needed]
+		}
+		needed++
+	}
+
+	if needed > len(free) {
+		plan.AddError("Not enough free IPs found. %d are needed but only %d are available", needed, len(free))
+	}
+	return result, nil
+}
+
+// checkNetwork verifies that a private network exists in the account
+func (d CloudsigmaDeployer) checkNetwork(ctx context.Context, network string) error {
+	_, err := d.client.GetVLAN(ctx, network)
+	if isNotFound(err) {
+		return fmt.Errorf("Private network %s not found", network)
+	}
+	return err
+}
+
+// PlanInfrastructure checks the credentials, the boot images and the free IPs needed to create the infrastructure and describes the servers and drives that would be created
+func (d CloudsigmaDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	logger := log.WithField("infrastructure", infra.Name)
+
+	resources, err := utils.ResolveFlavors(DeploymentType, infra.Resources)
 	if err != nil {
-		return "", err
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	ips, err := d.planIps(ctx, &plan, resources)
+	if err != nil {
+		if isAuthError(err) {
+			plan.AddError("Invalid credentials: %s", err.Error())
+			return plan, nil
+		}
+		logger.WithError(err).Error("Error getting list of free IPs")
+		plan.AddError("Error getting the list of free IPs: %s", err.Error())
 	}
 
-	replaced := reg.ReplaceAllString(toReplace, "-")
-	if len(replaced) > 255 || len(replaced) == 0 {
-		return "", fmt.Errorf("Infrastructure or host name of resource is too long or too short. Infrastructure name + resource name should be between 0 and 255 alphanumeric characters")
+	checkedImages := make(map[string]string)
+	checkedNetworks := make(map[string]bool)
+	for i, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			plan.AddError("Invalid hostname for resource %s: %s", resource.Name, err.Error())
+		}
+
+		node := model.NodePlan{
+			Hostname: hostname,
+			Role:     strings.ToLower(resource.Role),
+			CPU:      resource.CPU,
+			Cores:    resource.Cores,
+			RAM:      resource.RAM * 1024 * 1024,
+			Drives:   make([]model.DrivePlan, 0, len(resource.Drives)+1),
+		}
+
+		if i < len(ips) {
+			node.IP = ips[i]
+		}
+
+		source, checked := checkedImages[imageKey(resource)]
+		if !checked {
+			source, err = d.checkImage(ctx, resource)
+			if err != nil {
+				plan.AddError("Error checking boot image of resource %s: %s", resource.Name, err.Error())
+			}
+			checkedImages[imageKey(resource)] = source
+		}
+
+		for _, network := range resource.Networks {
+			if !checkedNetworks[network.ID] {
+				checkedNetworks[network.ID] = true
+				err = d.checkNetwork(ctx, network.ID)
+				if err != nil {
+					plan.AddError("Error checking private network of resource %s: %s", resource.Name, err.Error())
+				}
+			}
+		}
+
+		node.Drives = append(node.Drives, model.DrivePlan{
+			Name:   utils.BootDriveName(hostname),
+			Action: model.DriveActionClone,
+			Source: source,
+			Size:   resource.Disk * 1024 * 1024,
+		})
+
+		for _, drive := range resource.Drives {
+			node.Drives = append(node.Drives, model.DrivePlan{
+				Name:   utils.DataDriveName(hostname, drive),
+				Action: model.DriveActionCreate,
+				Size:   drive.Size * 1024 * 1024,
+			})
+		}
+
+		plan.Nodes = append(plan.Nodes, node)
 	}
 
-	return replaced, nil
+	return plan, nil
 }
 
-func (d CloudsigmaDeployer) DeployInfrastructure(infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+func (d CloudsigmaDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
 
 	deployment := model.InfrastructureDeploymentInfo{
-		ID:              uuid.New().String(),
+		ID:              infra.InfrastructureID(),
 		Products:        make(map[string]interface{}),
 		ExtraProperties: infra.ExtraProperties,
 	}
@@ -467,64 +917,80 @@ func (d CloudsigmaDeployer) DeployInfrastructure(infra model.InfrastructureType)
 		return deployment, errors.New("Name is mandatory for each cloudsigma infrastructure")
 	}
 
-	numNodes := len(infra.Resources)
 	deployment.Name = infra.Name
 	deployment.Type = DeploymentType
+	deployment.UserData = infra.UserData
 	deployment.Nodes = make(map[string][]model.NodeInfo)
 	deployment.Status = "creating"
 
 	var logger = log.WithField("deployment", infra.Name)
 
-	logger.Info("Getting the list of free IPs")
-	ips, err := d.findFreeIps(logger, numNodes)
+	err := d.createNodes(ctx, logger, &deployment, infra.Resources)
 	if err != nil {
+		logger.WithError(err).Errorf("Deployment failed")
+		deployment.Status = "failed"
 		return deployment, err
 	}
 
-	if len(ips) < numNodes {
-		return deployment, errors.New("Not enough free IPs found to create the cluster")
-	}
+	logger.Infof("Nodes successfully created")
+	deployment.Status = "running"
 
-	logger.Infof("Found enough free IPs")
+	return deployment, nil
+}
 
-	c := make(chan NodeCreationResult, numNodes)
+// AddNodes creates new nodes in an existing infrastructure
+func (d CloudsigmaDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return infra, err
+		}
+		if _, found := infra.FindNode(hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", hostname, infra.ID)
+		}
+	}
 
-	for i, resource := range infra.Resources {
-		go d.CreateServer(resource, ips[i], infra.Name, c)
+	logger.Infof("Adding %d nodes", len(resources))
+	err := d.createNodes(ctx, logger, &infra, resources)
+	if err != nil {
+		logger.WithError(err).Error("Error adding nodes")
+		return infra, err
 	}
 
-	var failed = false
+	logger.Info("Nodes successfully added")
+	return infra, nil
+}
 
-	for remaining := numNodes; remaining > 0; remaining-- {
-		result := <-c
-		if result.Error == nil {
-			role := strings.ToLower(result.Info.Role)
-			roleNodes, ok := deployment.Nodes[role]
-			if !ok {
-				roleNodes = make([]model.NodeInfo, 0, 1)
-			}
-			roleNodes = append(roleNodes, result.Info)
-			deployment.Nodes[role] = roleNodes
-		} else {
-			failed = true
+// RemoveNodes deletes nodes from an existing infrastructure given their hostnames
+func (d CloudsigmaDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]error)
+
+	for _, hostname := range hostnames {
+		node, found := infra.FindNode(hostname)
+		if !found {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+			continue
 		}
-	}
 
-	if failed {
-		logger.Errorf("Deployment failed")
-		deployment.Status = "failed"
-		return deployment, errors.New("Deployment failed")
+		err := d.deleteHost(ctx, logger, node)
+		if err != nil {
+			logger.WithError(err).Errorf("Error deleting node %s", hostname)
+			result[hostname] = err
+			continue
+		}
+		infra.RemoveNode(hostname)
 	}
-	logger.Infof("Nodes successfully created")
-	deployment.Status = "running"
 
-	return deployment, nil
+	return infra, result
 }
 
-func (d *CloudsigmaDeployer) deleteDrive(logInput *log.Entry, uuid string) error {
+func (d *CloudsigmaDeployer) deleteDrive(ctx context.Context, logInput *log.Entry, uuid string) error {
 	logger := logInput.WithField("drive", uuid)
 	logger.Info("Deleting drive from host")
-	err := d.client.DeleteDrive(uuid)
+	err := d.client.DeleteDrive(ctx, uuid)
 	if err != nil {
 		logger.WithError(err).Error("Error deleting drive")
 		return err
@@ -533,11 +999,11 @@ func (d *CloudsigmaDeployer) deleteDrive(logInput *log.Entry, uuid string) error
 	return nil
 }
 
-func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo) error {
+func (d *CloudsigmaDeployer) deleteHost(ctx context.Context, logInput *log.Entry, host model.NodeInfo) error {
 
 	logger := log.WithField("host", host.Hostname)
 	if host.UUID != "" {
-		serverInfo, err := d.client.GetServerDetails(host.UUID)
+		serverInfo, err := d.client.GetServerDetails(ctx, host.UUID)
 		status := "running"
 		if err != nil {
 			logger.WithError(err).Error("Error getting host status. Let's suppose it's running")
@@ -547,7 +1013,7 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 
 		if status == "running" {
 			logger.Info("Stopping server")
-			stopResult, err := d.client.ExecuteServerAction(host.UUID, ServerStopAction)
+			stopResult, err := d.client.ExecuteServerAction(ctx, host.UUID, ServerStopAction)
 			if err != nil {
 				log.WithError(err).Error("Error issuing stop action")
 				return err
@@ -560,9 +1026,7 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 			}
 
 			logger.Info("Waiting for server to stop")
-			server, timedOut, err := d.waitForStatusChange(host.UUID, "stopping", 60*time.Second, func(uuid string) (ResourceType, error) {
-				return d.client.GetServerDetails(uuid)
-			})
+			server, timedOut, err := d.waitForStatusChange(ctx, host.UUID, "stopping", 60*time.Second, d.client.GetServerDetails)
 
 			if err != nil {
 				logger.WithError(err).Error("Error stopping server")
@@ -583,7 +1047,7 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 			logger.Info("Server stopped")
 		}
 		logger.Info("Deleting server")
-		err = d.client.DeleteServerWithDrives(host.UUID)
+		err = d.client.DeleteServerWithDrives(ctx, host.UUID)
 
 		if err != nil {
 			logger.WithError(err).Error("Error deleting server with drives")
@@ -598,7 +1062,7 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 
 		for _, drive := range host.DataDrives {
 			logger := logger.WithField("drive", drive.UUID)
-			err2 := d.deleteDrive(logger, drive.UUID)
+			err2 := d.deleteDrive(ctx, logger, drive.UUID)
 			if err2 != nil {
 				logger.WithError(err2).Error("Error deleting drive")
 				err = err2
@@ -608,7 +1072,7 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 
 	if host.DriveUUID != "" {
 		logger := logger.WithField("drive", host.DriveUUID)
-		err2 := d.deleteDrive(logger, host.DriveUUID)
+		err2 := d.deleteDrive(ctx, logger, host.DriveUUID)
 		if err2 != nil {
 			logger.WithError(err2).Error("Error deleting drive")
 			err = err2
@@ -619,7 +1083,8 @@ func (d *CloudsigmaDeployer) deleteHost(logInput *log.Entry, host model.NodeInfo
 
 }
 
-func (d CloudsigmaDeployer) DeleteInfrastructure(infra model.InfrastructureDeploymentInfo) map[string]error {
+// DeleteInfrastructure deletes the nodes of the infrastructure and then any other server or drive tagged with its identifier, so nothing is left behind if a deployment failed halfway. The tag is deleted once all of them are gone.
+func (d CloudsigmaDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
 	logger := log.WithField("infrastructure", infra.ID)
 
 	logger.Info("Deleting infrastructure")
@@ -628,13 +1093,22 @@ func (d CloudsigmaDeployer) DeleteInfrastructure(infra model.InfrastructureDeplo
 
 	logger.Info("Deleting nodes")
 	infra.ForEachNode(func(node model.NodeInfo) {
-		err := d.deleteHost(logger, node)
+		err := d.deleteHost(ctx, logger, node)
 		if err != nil {
 			logger.WithError(err).Errorf("Error deleting node %s", node.Hostname)
 			result[node.Hostname] = err
 		}
 	})
 
+	logger.Info("Deleting resources tagged with the infrastructure")
+	d.deleteTaggedResources(ctx, logger, infra.ID, result)
+
+	// Leases left by nodes whose creation was interrupted
+	err := d.leases.ReleaseIPLeases(infra.ID)
+	if err != nil {
+		logger.WithError(err).Error("Error releasing IP leases of infrastructure")
+	}
+
 	if len(result) == 0 {
 		logger.Info("Nodes deleted. Infrastructure clear")
 	}
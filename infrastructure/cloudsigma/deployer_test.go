@@ -0,0 +1,907 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/persistence/memoryrepo"
+	"deployment-engine/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	fakeUsername = "user"
+	fakePassword = "password"
+	fakeImage    = "image-uuid"
+	fakeVLAN     = "vlan-uuid"
+)
+
+// fakeCloudSigma is a minimal stand-in of the CloudSigma API with a set of IPs, library drives, servers, drives and tags. Operations finish immediately.
+type fakeCloudSigma struct {
+	ips       []ResourceType
+	libdrives map[string]ResourceType
+	servers   map[string]ResourceType
+	drives    map[string]ResourceType
+	tags      map[string]ResourceType
+	lock      sync.Mutex
+	lastID    int
+	// libraryQueries counts the requests to the library drives
+	libraryQueries int
+}
+
+func newFakeCloudSigma() *fakeCloudSigma {
+	return &fakeCloudSigma{
+		ips: []ResourceType{
+			ResourceType{UUID: "10.0.0.1", Server: &ResourceType{UUID: "used"}},
+			ResourceType{UUID: "10.0.0.2"},
+			ResourceType{UUID: "10.0.0.3"},
+		},
+		libdrives: map[string]ResourceType{
+			fakeImage:     ResourceType{UUID: fakeImage, Name: "Ubuntu", Distribution: "Ubuntu", Version: "18.04"},
+			"centos-uuid": ResourceType{UUID: "centos-uuid", Name: "CentOS 7", Distribution: "CentOS", Version: "7"},
+		},
+		servers: make(map[string]ResourceType),
+		drives:  make(map[string]ResourceType),
+		tags:    make(map[string]ResourceType),
+	}
+}
+
+func (f *fakeCloudSigma) newID(kind string) string {
+	f.lastID++
+	return fmt.Sprintf("%s-%d", kind, f.lastID)
+}
+
+func hasTag(resource ResourceType, uuid string) bool {
+	for _, tag := range resource.Tags {
+		if tag.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// tagged returns the resources of a collection tagged with the tag passed as parameter
+func tagged(resources map[string]ResourceType, uuid string) []ResourceType {
+	result := make([]ResourceType, 0)
+	for _, resource := range resources {
+		if hasTag(resource, uuid) {
+			result = append(result, resource)
+		}
+	}
+	return result
+}
+
+// create handles the requests that create, update or delete resources, returning false if the request is not one of them
+func (f *fakeCloudSigma) create(w http.ResponseWriter, r *http.Request, path []string) bool {
+	var body RequestResponseType
+	var single ResourceType
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if len(path) == 1 && path[0] != "drives" {
+			json.NewDecoder(r.Body).Decode(&body)
+		} else {
+			json.NewDecoder(r.Body).Decode(&single)
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "tags":
+		tag := ResourceType{UUID: f.newID("tag"), Name: body.Objects[0].Name}
+		f.tags[tag.UUID] = tag
+		f.respond(w, http.StatusCreated, RequestResponseType{Objects: []ResourceType{tag}})
+	case r.Method == http.MethodPost && ((len(path) == 1 && path[0] == "drives") || (len(path) == 3 && path[0] == "libdrives" && r.URL.Query().Get("do") == "clone")):
+		single.UUID = f.newID("drive")
+		single.Status = "unmounted"
+		f.drives[single.UUID] = single
+		f.respond(w, http.StatusAccepted, RequestResponseType{Objects: []ResourceType{single}})
+	case r.Method == http.MethodPost && len(path) == 1 && path[0] == "servers":
+		server := body.Objects[0]
+		server.UUID = f.newID("server")
+		for i := range server.NICS {
+			server.NICS[i].MAC = f.newID("mac")
+		}
+		server.Status = "stopped"
+		f.servers[server.UUID] = server
+		f.respond(w, http.StatusCreated, RequestResponseType{Objects: []ResourceType{server}})
+	case r.Method == http.MethodPut && len(path) == 2 && path[0] == "servers":
+		server, ok := f.servers[path[1]]
+		if !ok || server.Status != "stopped" {
+			return false
+		}
+		server.Meta = single.Meta
+		f.servers[server.UUID] = server
+		f.respond(w, http.StatusOK, server)
+	case r.Method == http.MethodPost && len(path) == 3 && path[0] == "servers":
+		server, ok := f.servers[path[1]]
+		if !ok {
+			return false
+		}
+		server.Status = map[string]string{ServerStartAction: "running", ServerStopAction: "stopped"}[r.URL.Query().Get("do")]
+		f.servers[server.UUID] = server
+		f.respond(w, http.StatusAccepted, ActionResultType{Result: "success", UUID: server.UUID})
+	case r.Method == http.MethodDelete && len(path) == 2 && path[0] == "servers":
+		server, ok := f.servers[path[1]]
+		if !ok {
+			return false
+		}
+		for _, drive := range server.Drives {
+			delete(f.drives, drive.Drive.UUID)
+		}
+		delete(f.servers, server.UUID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && len(path) == 2 && (path[0] == "drives" || path[0] == "tags"):
+		collection := map[string]map[string]ResourceType{"drives": f.drives, "tags": f.tags}[path[0]]
+		if _, ok := collection[path[1]]; !ok {
+			return false
+		}
+		delete(collection, path[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return false
+	}
+	return true
+}
+
+func (f *fakeCloudSigma) respond(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func (f *fakeCloudSigma) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username != fakeUsername || password != fakePassword {
+		f.respond(w, http.StatusUnauthorized, []CloudSigmaError{CloudSigmaError{Code: 401, Description: "Authentication failed"}})
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if f.create(w, r, path) {
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "tags":
+		tags := make([]ResourceType, 0, len(f.tags))
+		for _, tag := range f.tags {
+			tags = append(tags, tag)
+		}
+		f.respond(w, http.StatusOK, RequestResponseType{Objects: tags})
+		return
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "tags":
+		if _, ok := f.tags[path[1]]; ok {
+			collection := map[string]map[string]ResourceType{ServersType: f.servers, DrivesType: f.drives}[path[2]]
+			f.respond(w, http.StatusOK, RequestResponseType{Objects: tagged(collection, path[1])})
+			return
+		}
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "ips":
+		f.respond(w, http.StatusOK, RequestResponseType{Objects: f.ips})
+		return
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "ips":
+		f.respond(w, http.StatusOK, IPReferenceType{UUID: path[1], Gateway: "10.0.0.254", Netmask: 24})
+		return
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "vlans" && path[1] == fakeVLAN:
+		f.respond(w, http.StatusOK, ResourceType{UUID: fakeVLAN})
+		return
+	case r.Method == http.MethodGet && len(path) == 1 && path[0] == "libdrives":
+		f.libraryQueries++
+		query := r.URL.Query()
+		drives := make([]ResourceType, 0)
+		for _, drive := range f.libdrives {
+			if (query.Get("name") == "" || query.Get("name") == drive.Name) &&
+				(query.Get("distribution") == "" || query.Get("distribution") == drive.Distribution) &&
+				(query.Get("version") == "" || query.Get("version") == drive.Version) {
+				drives = append(drives, drive)
+			}
+		}
+		f.respond(w, http.StatusOK, RequestResponseType{Objects: drives})
+		return
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "libdrives":
+		f.libraryQueries++
+		drive, ok := f.libdrives[path[1]]
+		if ok {
+			f.respond(w, http.StatusOK, drive)
+			return
+		}
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "servers":
+		server, ok := f.servers[path[1]]
+		if ok {
+			f.respond(w, http.StatusOK, server)
+			return
+		}
+	case r.Method == http.MethodGet && len(path) == 2 && path[0] == "drives":
+		drive, ok := f.drives[path[1]]
+		if ok {
+			f.respond(w, http.StatusOK, drive)
+			return
+		}
+	}
+
+	f.respond(w, http.StatusNotFound, []CloudSigmaError{CloudSigmaError{Code: 404, Description: "Not found"}})
+}
+
+func newTestDeployer(url, password string) CloudsigmaDeployer {
+	return CloudsigmaDeployer{
+		client: NewClient(url, fakeUsername, password, false).SetRateLimit(0),
+		leases: memoryrepo.CreateMemoryRepository(),
+	}
+}
+
+func testInfra(resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name:      "Test_Infra",
+		Resources: resources,
+	}
+}
+
+func TestPlanInfrastructure(t *testing.T) {
+	server := httptest.NewServer(newFakeCloudSigma())
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Role:    "Master",
+			CPU:     2000,
+			Cores:   2,
+			RAM:     4096,
+			Disk:    20480,
+			ImageId: fakeImage,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Size: 1024},
+			},
+		},
+		model.ResourceType{
+			Name:    "slave",
+			Role:    "slave",
+			CPU:     2000,
+			RAM:     4096,
+			ImageId: fakeImage,
+		}))
+
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) > 0 {
+		t.Fatalf("Unexpected errors found in plan: %v", plan.Errors)
+	}
+
+	if len(plan.Nodes) != 2 {
+		t.Fatalf("Expected 2 nodes in plan but found %d", len(plan.Nodes))
+	}
+
+	master := plan.Nodes[0]
+	if master.Hostname != "test-infra-master" || master.Role != "master" || master.IP != "10.0.0.2" || master.RAM != 4096*1024*1024 {
+		t.Fatalf("Unexpected plan for master node: %v", master)
+	}
+
+	if len(master.Drives) != 2 {
+		t.Fatalf("Expected boot and data drives for master but found %v", master.Drives)
+	}
+
+	if master.Drives[0].Action != model.DriveActionClone || master.Drives[0].Source != fakeImage || master.Drives[0].Name != "boot-test-infra-master" {
+		t.Fatalf("Unexpected boot drive plan: %v", master.Drives[0])
+	}
+
+	if master.Drives[1].Action != model.DriveActionCreate || master.Drives[1].Name != "data-test-infra-master-data" || master.Drives[1].Size != 1024*1024*1024 {
+		t.Fatalf("Unexpected data drive plan: %v", master.Drives[1])
+	}
+
+	if plan.Nodes[1].IP != "10.0.0.3" {
+		t.Fatalf("Expected IP 10.0.0.3 for slave but found %s", plan.Nodes[1].IP)
+	}
+}
+
+func TestPlanInfrastructureErrors(t *testing.T) {
+	server := httptest.NewServer(newFakeCloudSigma())
+	defer server.Close()
+
+	resource := model.ResourceType{
+		Name:    "node",
+		CPU:     2000,
+		RAM:     4096,
+		ImageId: "missing",
+	}
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(resource, resource, resource))
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	// Not enough IPs and missing image, reported only once
+	if len(plan.Errors) != 2 {
+		t.Fatalf("Expected 2 errors in plan but found %v", plan.Errors)
+	}
+
+	deployer = newTestDeployer(server.URL, "wrong")
+	plan, err = deployer.PlanInfrastructure(context.Background(), testInfra(resource))
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) != 1 || !strings.HasPrefix(plan.Errors[0], "Invalid credentials") {
+		t.Fatalf("Expected invalid credentials error but found %v", plan.Errors)
+	}
+}
+
+func TestCheckNodes(t *testing.T) {
+	fake := newFakeCloudSigma()
+	fake.drives["boot"] = ResourceType{UUID: "boot"}
+	fake.drives["detached"] = ResourceType{UUID: "detached"}
+	fake.servers["running"] = ResourceType{
+		UUID:   "running",
+		Status: "running",
+		Drives: []ServerDriveType{
+			ServerDriveType{Drive: ResourceType{UUID: "boot"}},
+		},
+		NICS: []ServerNICType{
+			ServerNICType{IPV4Conf: &ServerIPV4ConfType{IP: IPReferenceType{UUID: "10.0.0.1"}}},
+		},
+	}
+	fake.servers["drifted"] = fake.servers["running"]
+	fake.servers["stopped"] = ResourceType{UUID: "stopped", Status: "stopped"}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	infra := model.InfrastructureDeploymentInfo{ID: "infra"}
+	infra.AddNode(model.NodeInfo{Hostname: "running", Role: "master", UUID: "running", DriveUUID: "boot", IP: "10.0.0.1"})
+	infra.AddNode(model.NodeInfo{Hostname: "drifted", Role: "slave", UUID: "drifted", DriveUUID: "boot", IP: "10.0.0.1",
+		DataDrives: []model.DriveInfo{
+			model.DriveInfo{UUID: "detached"},
+			model.DriveInfo{UUID: "deleted"},
+		},
+	})
+	infra.AddNode(model.NodeInfo{Hostname: "stopped", Role: "slave", UUID: "stopped"})
+	infra.AddNode(model.NodeInfo{Hostname: "missing", Role: "slave", UUID: "missing"})
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	result, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"running": model.NodeStatusRunning,
+		"drifted": model.NodeStatusDrifted,
+		"stopped": model.NodeStatusStopped,
+		"missing": model.NodeStatusMissing,
+	}
+	for hostname, status := range expected {
+		if result[hostname].Status != status {
+			t.Fatalf("Expected status %s for node %s but found %v", status, hostname, result[hostname])
+		}
+	}
+
+	problems := result["drifted"].Problems
+	if len(problems) != 2 || !strings.Contains(problems[0], "not attached") || !strings.Contains(problems[1], "deleted") {
+		t.Fatalf("Unexpected problems found for drifted node: %v", problems)
+	}
+
+	deployer = newTestDeployer(server.URL, "wrong")
+	result, err = deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	if result["running"].Status != model.NodeStatusUnknown {
+		t.Fatalf("Expected unknown status with invalid credentials but found %v", result["running"])
+	}
+}
+
+func testResource(name string, drives ...model.Drive) model.ResourceType {
+	return model.ResourceType{
+		Name:    name,
+		Role:    "master",
+		CPU:     2000,
+		RAM:     4096,
+		ImageId: fakeImage,
+		Drives:  drives,
+	}
+}
+
+func TestDeployAndDeleteInfrastructure(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(testResource("master", model.Drive{Name: "data", Size: 1024})))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if infra.NumNodes() != 1 || len(fake.servers) != 1 || len(fake.drives) != 2 {
+		t.Fatalf("Unexpected resources created: %v %v", fake.servers, fake.drives)
+	}
+
+	infraTags, _ := deployer.findTags(context.Background(), infrastructureTagName(infra.ID))
+	instanceTags, _ := deployer.findTags(context.Background(), InstanceTagPrefix+EngineInstanceDefault)
+	if len(infraTags) != 1 || len(instanceTags) != 1 {
+		t.Fatalf("Unexpected tags created: %v", fake.tags)
+	}
+
+	for _, resource := range append(tagged(fake.servers, infraTags[0].UUID), tagged(fake.drives, infraTags[0].UUID)...) {
+		if !hasTag(resource, instanceTags[0].UUID) {
+			t.Fatalf("Resource %s not tagged with the instance of the engine: %v", resource.Name, resource.Tags)
+		}
+	}
+
+	if len(tagged(fake.servers, infraTags[0].UUID)) != 1 || len(tagged(fake.drives, infraTags[0].UUID)) != 2 {
+		t.Fatalf("Resources not tagged with the infrastructure: %v %v", fake.servers, fake.drives)
+	}
+
+	// Drive left behind by a crash of the engine, which isn't in the infrastructure
+	fake.drives["leftover"] = ResourceType{UUID: "leftover", Status: "unmounted", Tags: []ResourceType{infraTags[0]}}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	if len(fake.servers) > 0 || len(fake.drives) > 0 {
+		t.Fatalf("Resources left after deleting infrastructure: %v %v", fake.servers, fake.drives)
+	}
+
+	if _, ok := fake.tags[infraTags[0].UUID]; ok || len(fake.tags) != 1 {
+		t.Fatalf("Unexpected tags after deleting infrastructure: %v", fake.tags)
+	}
+}
+
+func TestNewDeployerWithoutLeases(t *testing.T) {
+	_, err := NewDeployer("http://localhost", model.BasicAuthSecret{Username: fakeUsername, Password: fakePassword}, "", nil)
+	if !errors.Is(err, ErrNoIPLeases) {
+		t.Fatalf("Expected missing lease repository error but got %v", err)
+	}
+}
+
+func TestIPLeases(t *testing.T) {
+	server := httptest.NewServer(newFakeCloudSigma())
+	defer server.Close()
+
+	leases := memoryrepo.CreateMemoryRepository()
+	deployer := newTestDeployer(server.URL, fakePassword)
+	deployer.leases = leases
+	deployer.pool = ipPool(server.URL, fakeUsername)
+
+	// IP being attached to a node of another infrastructure
+	acquired, err := leases.AcquireIPLease(model.IPLease{Pool: deployer.pool, IP: "10.0.0.2", InfrastructureID: "other", ExpirationTime: time.Now().Add(time.Hour)})
+	if err != nil || !acquired {
+		t.Fatalf("Error leasing IP: %v", err)
+	}
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(testResource("master")))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	ips := make([]string, 0)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		ips = append(ips, node.IP)
+	})
+	if len(ips) != 1 || ips[0] != "10.0.0.3" {
+		t.Fatalf("Expected node with free IP 10.0.0.3 but got %v", ips)
+	}
+
+	current, _ := leases.FindIPLeases(deployer.pool)
+	if len(current) != 1 || current[0].InfrastructureID != "other" {
+		t.Fatalf("IP lease of the infrastructure not released after creating its node: %v", current)
+	}
+
+	// Not enough IPs: the one leased by the failed deployment must be released
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra(testResource("master"), testResource("worker")))
+	if err == nil {
+		t.Fatal("Expected error deploying infrastructure without enough free IPs")
+	}
+
+	current, _ = leases.FindIPLeases(deployer.pool)
+	if len(current) != 1 {
+		t.Fatalf("IP leases not released after failed deployment: %v", current)
+	}
+}
+
+func TestRequestedIPAndNetworks(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	deployer.waitCloudInit = func(ctx context.Context, node model.NodeInfo, timeout time.Duration) (string, error) {
+		return model.CloudInitStatusDone, nil
+	}
+
+	master := testResource("master")
+	master.IP = "10.0.0.3"
+	master.Networks = []model.Network{model.Network{ID: fakeVLAN, IP: "192.168.0.1"}}
+	worker := testResource("worker")
+	worker.Role = "worker"
+	worker.Networks = []model.Network{model.Network{ID: fakeVLAN, IP: "192.168.0.2", Netmask: 16}}
+	worker.UserData = &model.UserData{Inline: "#!/bin/sh\necho worker"}
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(master, worker))
+	if err != nil || len(plan.Errors) > 0 {
+		t.Fatalf("Unexpected errors planning infrastructure: %v %v", err, plan.Errors)
+	}
+
+	if plan.Nodes[0].IP != "10.0.0.3" || plan.Nodes[1].IP != "10.0.0.2" {
+		t.Fatalf("Requested IP not honored in plan: %v", plan.Nodes)
+	}
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(master, worker))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	node, ok := infra.FindNode("test-infra-master")
+	if !ok || node.IP != "10.0.0.3" {
+		t.Fatalf("Requested IP not assigned to node: %v", node)
+	}
+
+	if len(node.Networks) != 1 || node.Networks[0].ID != fakeVLAN || node.Networks[0].IP != "192.168.0.1" || node.Networks[0].MAC == "" || node.PrivateIP() != "192.168.0.1" {
+		t.Fatalf("Unexpected private networks of node: %v", node.Networks)
+	}
+
+	node, _ = infra.FindNode("test-infra-worker")
+	if node.IP != "10.0.0.2" || node.PrivateIP() != "192.168.0.2" || node.Networks[0].Netmask != 16 {
+		t.Fatalf("Unexpected addresses of node: %v", node)
+	}
+
+	// The private IPs are configured by cloud-init on the interfaces with the MACs of the nodes, along with their own user-data
+	infra.ForEachNode(func(node model.NodeInfo) {
+		decoded, _ := base64.StdEncoding.DecodeString(fake.servers[node.UUID].Meta["cloudinit-user-data"])
+		userData := string(decoded)
+		network := node.Networks[0]
+		configured := fmt.Sprintf(`= "%s" ]; then ip link set "${nic##*/}" up && ip addr replace %s/%d dev`, network.MAC, network.IP, network.Netmask)
+		if !strings.Contains(userData, "bootcmd:") || !strings.Contains(userData, configured) || node.CloudInitStatus != model.CloudInitStatusDone {
+			t.Fatalf("Private IP of node %s not configured by user-data: %s", node.Hostname, userData)
+		}
+		if node.Role == "worker" && (!strings.HasPrefix(userData, "Content-Type: multipart/mixed") || !strings.Contains(userData, "Merge-Type: ") || !strings.Contains(userData, worker.UserData.Inline)) {
+			t.Fatalf("User-data of node %s not combined with configuration of private networks: %s", node.Hostname, userData)
+		}
+	})
+
+	// The IP is attached to a server and the network doesn't exist
+	used := testResource("master")
+	used.IP = "10.0.0.1"
+	used.Networks = []model.Network{model.Network{ID: "missing"}}
+	plan, err = deployer.PlanInfrastructure(context.Background(), testInfra(used))
+	if err != nil || len(plan.Errors) != 2 {
+		t.Fatalf("Expected attached IP and missing network errors but found %v %v", err, plan.Errors)
+	}
+
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra(used))
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.1") {
+		t.Fatalf("Expected error deploying with IP attached to a server but got %v", err)
+	}
+}
+
+func TestUserData(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	waited := make(map[string]string)
+	var lock sync.Mutex
+	deployer := newTestDeployer(server.URL, fakePassword)
+	deployer.waitCloudInit = func(ctx context.Context, node model.NodeInfo, timeout time.Duration) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		waited[node.Hostname] = node.IP
+		if node.Role == "worker" {
+			return model.CloudInitStatusError, nil
+		}
+		return model.CloudInitStatusDone, nil
+	}
+
+	master := testResource("master")
+	worker := testResource("worker")
+	worker.Role = "worker"
+	worker.UserData = &model.UserData{Inline: "#!/bin/sh\necho worker"}
+	infra := testInfra(master, worker)
+	infra.UserData = &model.UserData{Template: "#cloud-config\nhostname: {{.Hostname}}\n# {{.Role}} {{.IP}}"}
+
+	deployment, err := deployer.DeployInfrastructure(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	userData := make(map[string]string)
+	for _, server := range fake.servers {
+		if server.Meta["base64_fields"] != "cloudinit-user-data" {
+			t.Fatalf("User-data of server %s not marked as base64: %v", server.Name, server.Meta)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(server.Meta["cloudinit-user-data"])
+		if err != nil {
+			t.Fatalf("Invalid user-data of server %s: %s", server.Name, err.Error())
+		}
+		userData[server.Name] = string(decoded)
+	}
+
+	if userData["test-infra-master"] != "#cloud-config\nhostname: test-infra-master\n# master 10.0.0.2" || userData["test-infra-worker"] != worker.UserData.Inline {
+		t.Fatalf("Unexpected user-data of servers: %v", userData)
+	}
+
+	masterNode, _ := deployment.FindNode("test-infra-master")
+	workerNode, _ := deployment.FindNode("test-infra-worker")
+	if masterNode.CloudInitStatus != model.CloudInitStatusDone || workerNode.CloudInitStatus != model.CloudInitStatusError {
+		t.Fatalf("Unexpected cloud-init status of nodes: %s %s", masterNode.CloudInitStatus, workerNode.CloudInitStatus)
+	}
+
+	if len(waited) != 2 || waited["test-infra-master"] != "10.0.0.2" {
+		t.Fatalf("Unexpected wait for cloud-init: %v", waited)
+	}
+
+	// Nodes added later use the user-data of the infrastructure
+	deployment, err = deployer.AddNodes(context.Background(), deployment, []model.ResourceType{testResource("extra")})
+	if err != nil {
+		t.Fatalf("Error adding node: %s", err.Error())
+	}
+
+	if extra, _ := deployment.FindNode("test-infra-extra"); extra.CloudInitStatus != model.CloudInitStatusDone {
+		t.Fatalf("User-data of the infrastructure not used by added node: %v", extra)
+	}
+}
+
+// setFlavors configures the flavor catalog of every provider and the one of CloudSigma, returning a function which restores the previous ones
+func setFlavors(common, cloudsigma map[string]interface{}) func() {
+	provider := DeploymentType + "." + utils.FlavorsProperty
+	previousCommon := viper.Get(utils.FlavorsProperty)
+	previousProvider := viper.Get(provider)
+	viper.Set(utils.FlavorsProperty, common)
+	viper.Set(provider, cloudsigma)
+	return func() {
+		viper.Set(utils.FlavorsProperty, previousCommon)
+		viper.Set(provider, previousProvider)
+	}
+}
+
+func TestFlavors(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	defer setFlavors(map[string]interface{}{
+		"small": map[string]interface{}{"cpu": 1000, "cores": 1, "ram": 1024, "disk": 10240},
+		"large": map[string]interface{}{"cpu": 8000, "cores": 4, "ram": 16384},
+	}, map[string]interface{}{
+		"Large": map[string]interface{}{"cpu": 4000, "cores": 2, "ram": 8192, "disk": 20480},
+	})()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+
+	small := model.ResourceType{Name: "small", Type: "small", ImageId: fakeImage, Disk: 5120}
+	large := model.ResourceType{Name: "large", Type: "LARGE", ImageId: fakeImage}
+	if err := ValidateInfrastructure(testInfra(small, large)); err != nil {
+		t.Fatalf("Infrastructure with flavors rejected: %s", err.Error())
+	}
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(small, large))
+	if err != nil || len(plan.Errors) > 0 || plan.Nodes[1].CPU != 4000 || plan.Nodes[1].Drives[0].Size != 20480*1024*1024 {
+		t.Fatalf("Unexpected plan of infrastructure with flavors: %v %v", err, plan)
+	}
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(small, large))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	node, _ := infra.FindNode("test-infra-small")
+	if node.CPU != 1000 || node.Cores != 1 || node.RAM != 1024*1024*1024 || node.DriveSize != 5120*1024*1024 {
+		t.Fatalf("Flavor small not applied to node: %v", node)
+	}
+
+	node, _ = infra.FindNode("test-infra-large")
+	if node.CPU != 4000 || node.Cores != 2 || node.RAM != 8192*1024*1024 || node.DriveSize != 20480*1024*1024 {
+		t.Fatalf("Flavor large of the provider not applied to node: %v", node)
+	}
+
+	unknown := model.ResourceType{Name: "unknown", Type: "huge", ImageId: fakeImage}
+	if err := ValidateInfrastructure(testInfra(unknown)); err == nil || !strings.Contains(err.Error(), "huge") {
+		t.Fatalf("Expected unknown flavor error but got %v", err)
+	}
+
+	_, err = deployer.AddNodes(context.Background(), infra, []model.ResourceType{unknown})
+	if err == nil || infra.NumNodes() != 2 {
+		t.Fatalf("Node with unknown flavor added: %v", err)
+	}
+}
+
+func TestBootImageLookup(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+
+	master := testResource("master")
+	master.ImageId = ""
+	master.Image = &model.Image{Distribution: "CentOS", Version: "7"}
+	worker := testResource("worker")
+	worker.Role = "worker"
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(master, worker))
+	if err != nil || len(plan.Errors) > 0 || plan.Nodes[0].Drives[0].Source != "centos-uuid" {
+		t.Fatalf("Unexpected plan with image lookup: %v %v", err, plan)
+	}
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(master, worker))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	node, _ := infra.FindNode("test-infra-master")
+	if node.ImageID != "centos-uuid" || node.OSFamily != "RedHat" {
+		t.Fatalf("Unexpected boot image of node found by OS: %v", node)
+	}
+
+	node, _ = infra.FindNode("test-infra-worker")
+	if node.ImageID != fakeImage || node.OSFamily != "Debian" {
+		t.Fatalf("Unexpected boot image of node with image identifier: %v", node)
+	}
+
+	// Both images were looked up once while planning
+	if fake.libraryQueries != 2 {
+		t.Fatalf("Expected 2 queries to the library but found %d", fake.libraryQueries)
+	}
+
+	missing := testResource("missing")
+	missing.ImageId = ""
+	missing.Image = &model.Image{Distribution: "Ubuntu", Version: "4.10"}
+	plan, err = deployer.PlanInfrastructure(context.Background(), testInfra(missing))
+	if err != nil || len(plan.Errors) != 1 || !strings.Contains(plan.Errors[0], "4.10") {
+		t.Fatalf("Expected missing image error but found %v %v", err, plan.Errors)
+	}
+
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra(missing))
+	if err == nil {
+		t.Fatal("Infrastructure deployed with missing image")
+	}
+}
+
+func TestValidateInfrastructure(t *testing.T) {
+	valid := testResource("master")
+	valid.IP = "10.0.0.2"
+	valid.Networks = []model.Network{model.Network{ID: fakeVLAN, IP: "192.168.0.1"}, model.Network{ID: "other"}}
+
+	if err := ValidateInfrastructure(testInfra(valid)); err != nil {
+		t.Fatalf("Valid infrastructure rejected: %s", err.Error())
+	}
+
+	invalidIP := valid
+	invalidIP.IP = "10.0.0"
+
+	sameIP := valid
+	sameIP.Name = "worker"
+	sameIP.Networks = nil
+
+	repeatedNetwork := valid
+	repeatedNetwork.Networks = []model.Network{model.Network{ID: fakeVLAN}, model.Network{ID: fakeVLAN}}
+
+	invalidPrivateIP := valid
+	invalidPrivateIP.Networks = []model.Network{model.Network{ID: fakeVLAN, IP: "fe80::1"}}
+
+	invalidNetmask := valid
+	invalidNetmask.Networks = []model.Network{model.Network{ID: fakeVLAN, IP: "192.168.0.1", Netmask: 33}}
+
+	samePrivateIP := valid
+	samePrivateIP.Name = "worker"
+	samePrivateIP.IP = ""
+
+	invalidUserData := valid
+	invalidUserData.UserData = &model.UserData{Template: "{{.Hostname"}
+
+	noImage := valid
+	noImage.ImageId = ""
+	noImage.Image = &model.Image{}
+
+	customImageLookup := valid
+	customImageLookup.ImageId = ""
+	customImageLookup.Image = &model.Image{Name: "Ubuntu"}
+	customImageLookup.ExtraProperties = model.ExtraPropertiesType{BootDriveTypeProperty: BootDriveTypeCustom}
+
+	invalidInfraUserData := testInfra(valid)
+	invalidInfraUserData.UserData = &model.UserData{Inline: "#cloud-config", Template: "#cloud-config"}
+
+	for _, infra := range []model.InfrastructureType{
+		testInfra(invalidIP),
+		testInfra(valid, sameIP),
+		testInfra(repeatedNetwork),
+		testInfra(invalidPrivateIP),
+		testInfra(invalidNetmask),
+		testInfra(valid, samePrivateIP),
+		testInfra(invalidUserData),
+		testInfra(noImage),
+		testInfra(customImageLookup),
+		invalidInfraUserData,
+	} {
+		if err := ValidateInfrastructure(infra); err == nil {
+			t.Fatalf("Invalid infrastructure accepted: %v", infra.Resources)
+		}
+	}
+}
+
+func TestTaggedResources(t *testing.T) {
+	fake := newFakeCloudSigma()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(testResource("master")))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	instanceTags, _ := deployer.findTags(context.Background(), InstanceTagPrefix+EngineInstanceDefault)
+	fake.tags["gone"] = ResourceType{UUID: "gone", Name: infrastructureTagName("gone-id")}
+	fake.tags["other"] = ResourceType{UUID: "other", Name: InstanceTagPrefix + "other"}
+	fake.servers["orphan"] = ResourceType{UUID: "orphan", Name: "orphan", Status: "stopped", Tags: []ResourceType{instanceTags[0], fake.tags["gone"]}}
+	fake.servers["foreign"] = ResourceType{UUID: "foreign", Name: "foreign", Status: "stopped", Tags: []ResourceType{fake.tags["other"]}}
+
+	resources, err := deployer.TaggedResources(context.Background())
+	if err != nil {
+		t.Fatalf("Error listing tagged resources: %s", err.Error())
+	}
+
+	orphans := make([]model.TaggedResource, 0)
+	for _, resource := range resources {
+		if resource.UUID == "foreign" {
+			t.Fatal("Resource of another instance of the engine listed")
+		}
+		if resource.InfrastructureID == "gone-id" {
+			orphans = append(orphans, resource)
+		} else if resource.InfrastructureID != infra.ID {
+			t.Fatalf("Unexpected infrastructure of resource: %v", resource)
+		}
+	}
+
+	if len(resources) != 3 || len(orphans) != 1 || orphans[0].UUID != "orphan" || orphans[0].Type != ServersType {
+		t.Fatalf("Unexpected tagged resources: %v", resources)
+	}
+
+	errs := deployer.DeleteResources(context.Background(), orphans)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting orphan resources: %v", errs)
+	}
+
+	if _, ok := fake.servers["orphan"]; ok || len(fake.servers) != 2 {
+		t.Fatalf("Unexpected servers after deleting orphans: %v", fake.servers)
+	}
+
+	if _, ok := fake.tags["gone"]; ok {
+		t.Fatal("Tag of orphan infrastructure not deleted")
+	}
+}
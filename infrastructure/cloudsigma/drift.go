@@ -0,0 +1,131 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func isNotFound(err error) bool {
+	var csErr CloudSigmaError
+	return errors.As(err, &csErr) && csErr.Code == http.StatusNotFound
+}
+
+// checkDrive returns a problem if the drive is not attached to the server, explaining if it has been deleted
+func (d CloudsigmaDeployer) checkDrive(ctx context.Context, attached map[string]bool, kind, uuid string) string {
+	if attached[uuid] {
+		return ""
+	}
+
+	_, err := d.client.GetDriveDetails(ctx, uuid)
+	if isNotFound(err) {
+		return fmt.Sprintf("%s %s has been deleted", kind, uuid)
+	}
+	return fmt.Sprintf("%s %s is not attached to the server", kind, uuid)
+}
+
+func (d CloudsigmaDeployer) checkNode(ctx context.Context, logger *log.Entry, node model.NodeInfo) model.NodeStatus {
+	if node.UUID == "" {
+		return model.NodeStatus{
+			Status:   model.NodeStatusMissing,
+			Problems: []string{"The node doesn't have a server identifier"},
+		}
+	}
+
+	server, err := d.client.GetServerDetails(ctx, node.UUID)
+	if err != nil {
+		if isNotFound(err) {
+			return model.NodeStatus{
+				Status:   model.NodeStatusMissing,
+				Problems: []string{fmt.Sprintf("Server %s has been deleted", node.UUID)},
+			}
+		}
+		logger.WithError(err).Errorf("Error getting details of server %s", node.UUID)
+		return model.NodeStatus{
+			Status:   model.NodeStatusUnknown,
+			Problems: []string{fmt.Sprintf("Error getting details of server %s: %s", node.UUID, err.Error())},
+		}
+	}
+
+	status := model.NodeStatus{
+		Status: model.NodeStatusRunning,
+	}
+
+	attached := make(map[string]bool)
+	for _, drive := range server.Drives {
+		attached[drive.Drive.UUID] = true
+	}
+
+	if node.DriveUUID != "" {
+		if problem := d.checkDrive(ctx, attached, "Boot drive", node.DriveUUID); problem != "" {
+			status.Problems = append(status.Problems, problem)
+		}
+	}
+
+	for _, drive := range node.DataDrives {
+		if problem := d.checkDrive(ctx, attached, "Data drive", drive.UUID); problem != "" {
+			status.Problems = append(status.Problems, problem)
+		}
+	}
+
+	if node.IP != "" {
+		found := false
+		for _, nic := range server.NICS {
+			found = found || (nic.IPV4Conf != nil && nic.IPV4Conf.IP.UUID == node.IP)
+		}
+		if !found {
+			status.Problems = append(status.Problems, fmt.Sprintf("IP %s is not assigned to the server", node.IP))
+		}
+	}
+
+	for _, network := range node.Networks {
+		found := false
+		for _, nic := range server.NICS {
+			found = found || (nic.VLAN != nil && nic.VLAN.UUID == network.ID)
+		}
+		if !found {
+			status.Problems = append(status.Problems, fmt.Sprintf("Server is not attached to private network %s", network.ID))
+		}
+	}
+
+	if len(status.Problems) > 0 {
+		status.Status = model.NodeStatusDrifted
+	}
+
+	if server.Status != "running" {
+		status.Status = model.NodeStatusStopped
+		status.Problems = append(status.Problems, fmt.Sprintf("Server is %s", server.Status))
+	}
+
+	return status
+}
+
+// CheckNodes gets the state of the servers of the infrastructure and checks that their boot and data drives are still attached
+func (d CloudsigmaDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		result[node.Hostname] = d.checkNode(ctx, logger, node)
+	})
+	return result, nil
+}
@@ -0,0 +1,140 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ImageCacheTTLProperty is the time during which the library drives found for the boot images of the resources are reused without querying CloudSigma again
+	ImageCacheTTLProperty = "cloudsigma.image_cache_ttl"
+
+	ImageCacheTTLDefaultValue = "1h"
+)
+
+// BootImage is the boot image resolved for a resource
+type BootImage struct {
+	UUID     string
+	OSFamily string
+}
+
+type cachedImage struct {
+	image      BootImage
+	expiration time.Time
+}
+
+var (
+	// imageCache keeps the images found in the libraries, indexed by API URL and selector, so the nodes of the same infrastructure and the ones created afterwards don't look them up again
+	imageCache     = make(map[string]cachedImage)
+	imageCacheLock sync.Mutex
+)
+
+func (d CloudsigmaDeployer) imageCacheTTL() time.Duration {
+	if d.imageTTL <= 0 {
+		ttl, _ := time.ParseDuration(ImageCacheTTLDefaultValue)
+		return ttl
+	}
+	return d.imageTTL
+}
+
+func cachedBootImage(key string) (BootImage, bool) {
+	imageCacheLock.Lock()
+	defer imageCacheLock.Unlock()
+	cached, ok := imageCache[key]
+	if !ok || time.Now().After(cached.expiration) {
+		delete(imageCache, key)
+		return BootImage{}, false
+	}
+	return cached.image, true
+}
+
+func (d CloudsigmaDeployer) cacheBootImage(key string, image BootImage) {
+	imageCacheLock.Lock()
+	defer imageCacheLock.Unlock()
+	imageCache[key] = cachedImage{
+		image:      image,
+		expiration: time.Now().Add(d.imageCacheTTL()),
+	}
+}
+
+// libraryQuery returns the parameters to find a library drive with the image selector
+func libraryQuery(image model.Image) map[string]string {
+	params := make(map[string]string)
+	if image.Name != "" {
+		params["name"] = image.Name
+	}
+	if image.Distribution != "" {
+		params["distribution"] = image.Distribution
+	}
+	if image.Version != "" {
+		params["version"] = image.Version
+	}
+	return params
+}
+
+// bootImage returns the boot image of a resource: the drive of its image identifier or the library drive selected by its image.
+// Library drives are cached. Drives of the account used as custom boot images aren't, and their OS family is unknown.
+func (d CloudsigmaDeployer) bootImage(ctx context.Context, resource model.ResourceType) (BootImage, error) {
+	if resource.ImageId != "" && !isLibraryDrive(resource) {
+		return BootImage{UUID: resource.ImageId}, nil
+	}
+
+	key := d.client.BaseURL() + "/libdrives/" + resource.ImageId
+	if resource.ImageId == "" && resource.Image != nil {
+		key = fmt.Sprintf("%s/libdrives?name=%s&distribution=%s&version=%s", d.client.BaseURL(), resource.Image.Name, resource.Image.Distribution, resource.Image.Version)
+	}
+
+	if image, ok := cachedBootImage(key); ok {
+		return image, nil
+	}
+
+	var drive ResourceType
+	var err error
+	if resource.ImageId != "" {
+		drive, err = d.client.GetLibDriveDetails(ctx, resource.ImageId)
+		if isNotFound(err) {
+			return BootImage{}, fmt.Errorf("Boot image %s not found", resource.ImageId)
+		}
+	} else if resource.Image != nil && !resource.Image.IsEmpty() {
+		drive, err = d.client.GetLibDrive(ctx, libraryQuery(*resource.Image))
+		if errors.Is(err, ErrNotFound) {
+			return BootImage{}, fmt.Errorf("Boot image %s not found in the library", resource.Image)
+		}
+	} else {
+		return BootImage{}, fmt.Errorf("Boot image not specified for resource %s", resource.Name)
+	}
+
+	if err != nil {
+		return BootImage{}, err
+	}
+
+	image := BootImage{
+		UUID:     drive.UUID,
+		OSFamily: model.OSFamily(drive.Distribution),
+	}
+	if drive.Distribution == "" && drive.OS != "" {
+		image.OSFamily = model.OSFamily(drive.OS)
+	}
+	d.cacheBootImage(key, image)
+	return image, nil
+}
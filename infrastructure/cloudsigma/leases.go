@@ -0,0 +1,222 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IPLeaseTTLProperty is the time after which the IP reserved for a node is available again if the engine didn't release it, for example because it stopped while creating the node
+	IPLeaseTTLProperty = "cloudsigma.ip_lease_ttl"
+
+	IPLeaseTTLDefaultValue = "1h"
+)
+
+// ErrNoIPLeases is returned when a deployer is created without the repository in which the IPs of its nodes are reserved
+var ErrNoIPLeases = errors.New("An IP lease repository is needed to reserve the IPs of the CloudSigma nodes")
+
+// ipPool returns the pool of the leases of the IPs of a CloudSigma account
+func ipPool(apiURL, username string) string {
+	return fmt.Sprintf("%s/%s@%s", DeploymentType, username, apiURL)
+}
+
+func (d CloudsigmaDeployer) ipLeaseTTL() time.Duration {
+	if d.leaseTTL <= 0 {
+		ttl, _ := time.ParseDuration(IPLeaseTTLDefaultValue)
+		return ttl
+	}
+	return d.leaseTTL
+}
+
+// accountIPs is the state of the public IPs of the account
+type accountIPs struct {
+	// all the IPs of the account indexed by identifier
+	all map[string]ResourceType
+	// free are the IPs which are neither attached to a server nor leased, in the order returned by CloudSigma
+	free []string
+	// leased are the IPs reserved for nodes being created
+	leased map[string]bool
+}
+
+// accountIps returns the state of the public IPs of the account, taking into account the leases of the nodes being created
+func (d CloudsigmaDeployer) accountIps(ctx context.Context) (accountIPs, error) {
+	result := accountIPs{
+		all:    make(map[string]ResourceType),
+		free:   make([]string, 0),
+		leased: make(map[string]bool),
+	}
+
+	ipInfo, err := d.client.GetAvailableIps(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	leases, err := d.leases.FindIPLeases(d.pool)
+	if err != nil {
+		return result, fmt.Errorf("Error getting IP leases: %w", err)
+	}
+
+	for _, lease := range leases {
+		result.leased[lease.IP] = true
+	}
+
+	for _, ip := range ipInfo.Objects {
+		result.all[ip.UUID] = ip
+		if ip.Server == nil && !result.leased[ip.UUID] {
+			result.free = append(result.free, ip.UUID)
+		}
+	}
+	return result, nil
+}
+
+// checkRequested returns an error if the IP requested by a resource isn't an IP of the account or it's attached to a server
+func (a accountIPs) checkRequested(resource model.ResourceType) error {
+	ip, ok := a.all[resource.IP]
+	if !ok {
+		return fmt.Errorf("IP %s requested by resource %s is not an IP of the account", resource.IP, resource.Name)
+	}
+	if ip.Server != nil {
+		return fmt.Errorf("IP %s requested by resource %s is already attached to server %s", resource.IP, resource.Name, ip.Server.UUID)
+	}
+	return nil
+}
+
+// freeExcept returns the free IPs which haven't been requested by any resource
+func (a accountIPs) freeExcept(requested map[string]bool) []string {
+	result := make([]string, 0, len(a.free))
+	for _, ip := range a.free {
+		if !requested[ip] {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+// requestedIps returns the IPs requested by the resources
+func requestedIps(resources []model.ResourceType) map[string]bool {
+	result := make(map[string]bool)
+	for _, resource := range resources {
+		if resource.IP != "" {
+			result[resource.IP] = true
+		}
+	}
+	return result
+}
+
+// leaseIP reserves an IP for an infrastructure, returning false if it's reserved by another one
+func (d CloudsigmaDeployer) leaseIP(infraID, ip string) (bool, error) {
+	acquired, err := d.leases.AcquireIPLease(model.IPLease{
+		Pool:             d.pool,
+		IP:               ip,
+		InfrastructureID: infraID,
+		ExpirationTime:   time.Now().Add(d.ipLeaseTTL()),
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error leasing IP %s: %w", ip, err)
+	}
+	return acquired, nil
+}
+
+// leaseIps reserves the public IPs of the nodes of an infrastructure, in the same order as the resources: the one requested by a resource or the first free one.
+// The leases must be released once the servers have the IPs attached, or they fail. If any IP can't be reserved the ones already reserved are released and an error is returned.
+func (d CloudsigmaDeployer) leaseIps(ctx context.Context, logger *log.Entry, infraID string, resources []model.ResourceType) ([]IPReferenceType, error) {
+	ips, err := d.accountIps(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Error getting list of free IPs")
+		return nil, err
+	}
+
+	result := make([]IPReferenceType, len(resources))
+	leased := make([]IPReferenceType, 0, len(resources))
+	fail := func(err error) ([]IPReferenceType, error) {
+		d.releaseIps(logger, infraID, leased)
+		return nil, err
+	}
+
+	for i, resource := range resources {
+		if resource.IP == "" {
+			continue
+		}
+
+		err := ips.checkRequested(resource)
+		if err != nil {
+			return fail(err)
+		}
+
+		acquired, err := d.leaseIP(infraID, resource.IP)
+		if err != nil {
+			return fail(err)
+		}
+		if !acquired {
+			return fail(fmt.Errorf("IP %s requested by resource %s is reserved by another infrastructure being created", resource.IP, resource.Name))
+		}
+		leased = append(leased, IPReferenceType{UUID: resource.IP})
+
+		result[i], err = d.client.GetIPReference(ctx, resource.IP)
+		if err != nil {
+			return fail(fmt.Errorf("Error getting information of IP %s: %w", resource.IP, err))
+		}
+	}
+
+	candidates := ips.freeExcept(requestedIps(resources))
+	next := 0
+	for i, resource := range resources {
+		for resource.IP == "" && result[i].UUID == "" {
+			if next >= len(candidates) {
+				return fail(errors.New("Not enough free IPs found to create the cluster"))
+			}
+			ip := candidates[next]
+			next++
+
+			ipLogger := logger.WithField("IP", ip)
+			acquired, err := d.leaseIP(infraID, ip)
+			if err != nil {
+				return fail(err)
+			}
+			if !acquired {
+				ipLogger.Debug("IP leased by another infrastructure")
+				continue
+			}
+			ref, err := d.client.GetIPReference(ctx, ip)
+			if err != nil {
+				ipLogger.WithError(err).Error("Error getting IP information")
+				d.releaseIps(logger, infraID, []IPReferenceType{IPReferenceType{UUID: ip}})
+				continue
+			}
+			leased = append(leased, ref)
+			result[i] = ref
+		}
+	}
+	return result, nil
+}
+
+// releaseIps releases the leases of IPs reserved for an infrastructure
+func (d CloudsigmaDeployer) releaseIps(logger *log.Entry, infraID string, ips []IPReferenceType) {
+	for _, ip := range ips {
+		err := d.leases.ReleaseIPLease(d.pool, ip.UUID, infraID)
+		if err != nil {
+			logger.WithError(err).WithField("IP", ip.UUID).Error("Error releasing IP lease")
+		}
+	}
+}
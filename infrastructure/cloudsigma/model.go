@@ -36,6 +36,7 @@ type ResourceType struct {
 	Size         int64             `json:"size,omitempty"`
 	Server       *ResourceType     `json:"server,omitempty"`
 	Media        string            `json:"media,omitempty"`
+	OS           string            `json:"os,omitempty"`
 }
 
 type IPReferenceType struct {
@@ -86,10 +87,12 @@ type NICInfoType struct {
 	IPV4Info ResourceType `json:"ip_v4"`
 }
 
+// ServerNICType is a network interface of a server, either public with an IP configuration or private in a VLAN
 type ServerNICType struct {
-	IPV4Conf ServerIPV4ConfType `json:"ip_v4_conf"`
-	Model    string             `json:"model"`
-	VLAN     string             `json:"vlan,omitempty"`
+	IPV4Conf *ServerIPV4ConfType `json:"ip_v4_conf,omitempty"`
+	Model    string              `json:"model"`
+	VLAN     *ResourceType       `json:"vlan,omitempty"`
+	MAC      string              `json:"mac,omitempty"`
 }
 
 /*type Server struct {
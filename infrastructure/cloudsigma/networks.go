@@ -0,0 +1,85 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"bytes"
+	"deployment-engine/model"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	// DefaultNetmask is the prefix length of the private networks of the resources which don't set one
+	DefaultNetmask = 24
+
+	// networksMergeType makes cloud-init append the lists of the user-data to the ones of the configuration of the private networks instead of replacing them
+	networksMergeType = "list(append)+dict(recurse_array)+str()"
+)
+
+// networksCloudConfig returns a cloud-config which sets the requested private IPs on the interfaces of a node on every boot, since CloudSigma VLANs have no DHCP.
+// Interfaces are found by their MAC. It's empty if no private IP was requested.
+func networksCloudConfig(networks []model.NetworkInfo) string {
+	var config strings.Builder
+	for _, network := range networks {
+		if network.IP == "" || network.MAC == "" {
+			continue
+		}
+		if config.Len() == 0 {
+			config.WriteString("#cloud-config\nbootcmd:\n")
+		}
+		fmt.Fprintf(&config, `- [sh, -c, 'for nic in /sys/class/net/*; do if [ "$(cat $nic/address)" = "%s" ]; then ip link set "${nic##*/}" up && ip addr replace %s/%d dev "${nic##*/}"; fi; done']`+"\n",
+			strings.ToLower(network.MAC), network.IP, network.Netmask)
+	}
+	return config.String()
+}
+
+// combineUserData returns a MIME multipart user-data with the configuration of the private networks followed by the user-data of the node, if any.
+// Cloud-init finds out the type of the user-data part from its first line, as it does when it's passed alone.
+func combineUserData(networks, userData string) (string, error) {
+	if userData == "" {
+		return networks, nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	parts := []struct {
+		header  textproto.MIMEHeader
+		content string
+	}{
+		{textproto.MIMEHeader{"Content-Type": {"text/cloud-config"}, "Merge-Type": {networksMergeType}}, networks},
+		{textproto.MIMEHeader{"Content-Type": {"text/plain"}}, userData},
+	}
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(part.header)
+		if err != nil {
+			return "", err
+		}
+		_, err = partWriter.Write([]byte(part.content))
+		if err != nil {
+			return "", err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n%s", writer.Boundary(), body.String()), nil
+}
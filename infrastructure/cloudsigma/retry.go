@@ -0,0 +1,203 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"expvar"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+const (
+	MaxAttemptsProperty = "cloudsigma.retry.max_attempts"
+	BackoffProperty     = "cloudsigma.retry.backoff"
+	MaxBackoffProperty  = "cloudsigma.retry.max_backoff"
+	RateLimitProperty   = "cloudsigma.rate_limit"
+
+	MaxAttemptsDefaultValue = 5
+	BackoffDefaultValue     = "1s"
+	MaxBackoffDefaultValue  = "30s"
+	RateLimitDefaultValue   = 10
+)
+
+// Metrics are the counters of the requests sent to CloudSigma by every client, published with expvar
+var Metrics = expvar.NewMap("cloudsigma")
+
+// RetryPolicy configures how failed requests are retried. Waits grow exponentially from Backoff up to MaxBackoff, with random jitter, unless the server sets them with a Retry-After header.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy with the default values of the properties
+func DefaultRetryPolicy() RetryPolicy {
+	backoff, _ := time.ParseDuration(BackoffDefaultValue)
+	maxBackoff, _ := time.ParseDuration(MaxBackoffDefaultValue)
+	return RetryPolicy{
+		MaxAttempts: MaxAttemptsDefaultValue,
+		Backoff:     backoff,
+		MaxBackoff:  maxBackoff,
+	}
+}
+
+// wait returns how long to wait before the attempt following the one passed as parameter, starting from 1
+func (p RetryPolicy) wait(attempt int, response *resty.Response) time.Duration {
+	if response != nil {
+		if wait, ok := retryAfter(response.Header().Get("Retry-After")); ok {
+			return wait
+		}
+	}
+
+	backoff := p.Backoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	// Half of the backoff is fixed and the other half random, so parallel clients don't retry at once
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of seconds or a date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// retryable returns true if a request can be sent again after the response or error passed as parameter.
+// Idempotent requests are retried after network errors, throttling and server errors, but others such as creations only after throttling since the server may have processed them before failing.
+func retryable(method string, response *resty.Response, err error) bool {
+	idempotent := method == resty.MethodGet || method == resty.MethodHead || method == resty.MethodPut || method == resty.MethodDelete || method == resty.MethodOptions
+
+	if err != nil {
+		return idempotent
+	}
+
+	switch response.StatusCode() {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// rateLimiter spaces the requests of a client so that no more than a number of them are sent per second
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	lock     sync.Mutex
+}
+
+// limiters are the rate limiters of the CloudSigma accounts indexed by API URL and username, so that the clients of the deployers of every infrastructure of an account share the same limit
+var (
+	limiters     = make(map[string]*rateLimiter)
+	limitersLock sync.Mutex
+)
+
+// accountLimiter returns the rate limiter of the requests to an account, creating it with the default limit if it doesn't exist
+func accountLimiter(baseURL, username string) *rateLimiter {
+	key := username + "@" + baseURL
+
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+
+	limiter, ok := limiters[key]
+	if !ok {
+		limiter = newRateLimiter(RateLimitDefaultValue)
+		limiters[key] = limiter
+	}
+	return limiter
+}
+
+// newRateLimiter creates a limiter of the requests per second passed as parameter. Limits equal or less than zero disable it.
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	limiter := &rateLimiter{}
+	limiter.SetLimit(requestsPerSecond)
+	return limiter
+}
+
+// SetLimit changes the number of requests per second. Limits equal or less than zero disable it.
+func (l *rateLimiter) SetLimit(requestsPerSecond float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.interval = 0
+	if requestsPerSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+}
+
+// Wait blocks until a request can be sent or the context is cancelled
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	if l.interval == 0 {
+		l.lock.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.lock.Unlock()
+
+	return sleep(ctx, slot.Sub(now))
+}
+
+// sleep waits for the duration passed as parameter or until the context is cancelled
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
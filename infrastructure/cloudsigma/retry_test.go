@@ -0,0 +1,207 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingServer answers the first failures requests with the status passed as parameter and the rest with an empty server
+func failingServer(failures int32, status int, headers map[string]string) (*httptest.Server, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) <= failures {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"uuid": "server-uuid"}`))
+	}))
+	return server, &count
+}
+
+// counter returns the value of a metric of the CloudSigma clients
+func counter(name string) int64 {
+	if value, ok := Metrics.Get(name).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+func testClient(url string) *Client {
+	return NewClient(url, fakeUsername, fakePassword, false).
+		SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}).
+		SetRateLimit(0)
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	server, count := failingServer(2, http.StatusServiceUnavailable, nil)
+	defer server.Close()
+
+	retries := counter("retries")
+
+	result, err := testClient(server.URL).GetServerDetails(context.Background(), "server-uuid")
+	if err != nil {
+		t.Fatalf("Error getting server after retries: %s", err.Error())
+	}
+
+	if result.UUID != "server-uuid" || *count != 3 {
+		t.Fatalf("Expected server after 3 requests but got %v after %d", result, *count)
+	}
+
+	if counter("retries") != retries+2 {
+		t.Fatalf("Expected 2 retries counted but got %d", counter("retries")-retries)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	server, count := failingServer(10, http.StatusBadGateway, nil)
+	defer server.Close()
+
+	err := testClient(server.URL).DeleteDrive(context.Background(), "drive-uuid")
+	var csError CloudSigmaError
+	if !errors.As(err, &csError) || csError.Code != http.StatusBadGateway {
+		t.Fatalf("Expected bad gateway error but got %v", err)
+	}
+
+	if *count != 3 {
+		t.Fatalf("Expected 3 attempts but got %d", *count)
+	}
+}
+
+func TestNoRetryNotIdempotent(t *testing.T) {
+	server, count := failingServer(1, http.StatusInternalServerError, nil)
+	defer server.Close()
+
+	_, err := testClient(server.URL).CreateServers(context.Background(), RequestResponseType{})
+	if err == nil {
+		t.Fatal("Expected error creating servers")
+	}
+
+	if *count != 1 {
+		t.Fatalf("Creation retried after server error: %d attempts", *count)
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	server, count := failingServer(1, http.StatusTooManyRequests, map[string]string{"Retry-After": "1"})
+	defer server.Close()
+
+	start := time.Now()
+	_, err := testClient(server.URL).CreateServers(context.Background(), RequestResponseType{})
+	if err != nil {
+		t.Fatalf("Error creating servers after throttling: %s", err.Error())
+	}
+
+	if *count != 2 {
+		t.Fatalf("Expected 2 attempts but got %d", *count)
+	}
+
+	if time.Since(start) < time.Second {
+		t.Fatalf("Retry-After not respected: retried after %s", time.Since(start))
+	}
+}
+
+func TestNoRetryClientError(t *testing.T) {
+	server, count := failingServer(1, http.StatusNotFound, nil)
+	defer server.Close()
+
+	_, err := testClient(server.URL).GetServerDetails(context.Background(), "server-uuid")
+	if !isNotFound(err) || *count != 1 {
+		t.Fatalf("Expected not found error after 1 attempt but got %v after %d", err, *count)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	server, count := failingServer(10, http.StatusServiceUnavailable, map[string]string{"Retry-After": "60"})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := testClient(server.URL).GetServerDetails(ctx, "server-uuid")
+	if err == nil || *count != 1 {
+		t.Fatalf("Expected error after 1 attempt but got %v after %d", err, *count)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	wait, ok := retryAfter("3")
+	if !ok || wait != 3*time.Second {
+		t.Fatalf("Wrong wait for seconds: %s", wait)
+	}
+
+	wait, ok = retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if !ok || wait <= 50*time.Second || wait > time.Minute {
+		t.Fatalf("Wrong wait for date: %s", wait)
+	}
+
+	if _, ok = retryAfter("soon"); ok {
+		t.Fatal("Invalid Retry-After header accepted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, Backoff: 100 * time.Millisecond, MaxBackoff: 400 * time.Millisecond}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 400} {
+		max *= time.Millisecond
+		wait := policy.wait(attempt+1, nil)
+		if wait < max/2 || wait > max {
+			t.Fatalf("Wait %s of attempt %d out of range [%s, %s]", wait, attempt+1, max/2, max)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		err := limiter.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("Error waiting for limiter: %s", err.Error())
+		}
+	}
+
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatalf("Requests not limited: 5 requests in %s", time.Since(start))
+	}
+}
+
+func TestAccountLimiter(t *testing.T) {
+	first := NewClient("https://limits.example", "user", "password", false).SetRateLimit(100)
+	second := NewClient("https://limits.example", "user", "other", false)
+	other := NewClient("https://limits.example", "other", "password", false)
+
+	if first.limiter != second.limiter || first.limiter == other.limiter {
+		t.Fatal("Rate limiters not shared by the clients of the same account")
+	}
+
+	if second.limiter.interval != 10*time.Millisecond {
+		t.Fatalf("Rate limit of the account changed by a new client: %s", second.limiter.interval)
+	}
+}
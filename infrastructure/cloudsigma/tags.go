@@ -0,0 +1,229 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package cloudsigma
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EngineInstanceProperty is the name of this instance of the deployment engine, used to tell apart the resources it creates from the ones of other instances using the same account
+	EngineInstanceProperty = "cloudsigma.engine_instance"
+
+	EngineInstanceDefault = "default"
+
+	// InfrastructureTagPrefix is the prefix of the name of the tag of the servers and drives of an infrastructure, followed by its identifier
+	InfrastructureTagPrefix = "deployment-engine-infrastructure-"
+
+	// InstanceTagPrefix is the prefix of the name of the tag of the servers and drives created by an instance of the deployment engine, followed by its name
+	InstanceTagPrefix = "deployment-engine-instance-"
+)
+
+func infrastructureTagName(infraID string) string {
+	return InfrastructureTagPrefix + infraID
+}
+
+func (d CloudsigmaDeployer) instanceTagName() string {
+	if d.instance == "" {
+		return InstanceTagPrefix + EngineInstanceDefault
+	}
+	return InstanceTagPrefix + d.instance
+}
+
+// findTags returns the tags of the account with the name passed as parameter. There may be more than one since CloudSigma doesn't enforce unique names.
+func (d CloudsigmaDeployer) findTags(ctx context.Context, name string) ([]ResourceType, error) {
+	tags, err := d.client.ListTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tags: %w", err)
+	}
+
+	result := make([]ResourceType, 0)
+	for _, tag := range tags.Objects {
+		if tag.Name == name {
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+// findOrCreateTag returns a reference to the tag with the name passed as parameter, creating it if it doesn't exist
+func (d CloudsigmaDeployer) findOrCreateTag(ctx context.Context, name string) (ResourceType, error) {
+	tags, err := d.findTags(ctx, name)
+	if err != nil {
+		return ResourceType{}, err
+	}
+
+	if len(tags) > 0 {
+		return ResourceType{UUID: tags[0].UUID}, nil
+	}
+
+	tag, err := d.client.CreateTag(ctx, name, nil)
+	if err != nil {
+		return ResourceType{}, fmt.Errorf("Error creating tag %s: %w", name, err)
+	}
+	return ResourceType{UUID: tag.UUID}, nil
+}
+
+// engineTags returns the tags set on the servers and drives of an infrastructure: the one with its identifier and the one of this instance of the deployment engine
+func (d CloudsigmaDeployer) engineTags(ctx context.Context, infraID string) ([]ResourceType, error) {
+	infraTag, err := d.findOrCreateTag(ctx, infrastructureTagName(infraID))
+	if err != nil {
+		return nil, err
+	}
+
+	instanceTag, err := d.findOrCreateTag(ctx, d.instanceTagName())
+	if err != nil {
+		return nil, err
+	}
+
+	return []ResourceType{infraTag, instanceTag}, nil
+}
+
+// deleteResource deletes a server with its drives or a drive. Resources that no longer exist are considered deleted.
+func (d CloudsigmaDeployer) deleteResource(ctx context.Context, logger *log.Entry, resourceType string, resource ResourceType) error {
+	var err error
+	if resourceType == ServersType {
+		err = d.deleteHost(ctx, logger, model.NodeInfo{Hostname: resource.Name, UUID: resource.UUID})
+	} else {
+		err = d.deleteDrive(ctx, logger, resource.UUID)
+	}
+
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteTaggedResources deletes the servers and drives left with the tag of an infrastructure, and the tag itself if all of them are gone.
+// The errors found are added to the result, indexed by resource type and identifier.
+func (d CloudsigmaDeployer) deleteTaggedResources(ctx context.Context, logger *log.Entry, infraID string, result map[string]error) {
+	tags, err := d.findTags(ctx, infrastructureTagName(infraID))
+	if err != nil {
+		result[infraID] = err
+		return
+	}
+
+	for _, tag := range tags {
+		failed := false
+		// Servers go first since their drives are deleted with them
+		for _, resourceType := range []string{ServersType, DrivesType} {
+			resources, err := d.client.GetByTag(ctx, tag.UUID, resourceType)
+			if err != nil {
+				result[tag.UUID] = fmt.Errorf("Error getting %s tagged with infrastructure %s: %w", resourceType, infraID, err)
+				failed = true
+				continue
+			}
+
+			for _, resource := range resources.Objects {
+				logger.WithField(resourceType, resource.UUID).Info("Deleting resource left with the tag of the infrastructure")
+				err := d.deleteResource(ctx, logger, resourceType, resource)
+				if err != nil {
+					result[resourceType+"/"+resource.UUID] = err
+					failed = true
+				}
+			}
+		}
+
+		if failed {
+			continue
+		}
+
+		err = d.client.DeleteTag(ctx, tag.UUID)
+		if err != nil && !isNotFound(err) {
+			result[tag.UUID] = fmt.Errorf("Error deleting tag of infrastructure %s: %w", infraID, err)
+		}
+	}
+}
+
+// TaggedResources returns the servers and drives tagged by this instance of the deployment engine, with the identifier of the infrastructure they belong to
+func (d CloudsigmaDeployer) TaggedResources(ctx context.Context) ([]model.TaggedResource, error) {
+	tags, err := d.client.ListTags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing tags: %w", err)
+	}
+
+	infraTags := make(map[string]string)
+	instanceTags := make([]string, 0)
+	for _, tag := range tags.Objects {
+		if strings.HasPrefix(tag.Name, InfrastructureTagPrefix) {
+			infraTags[tag.UUID] = strings.TrimPrefix(tag.Name, InfrastructureTagPrefix)
+		} else if tag.Name == d.instanceTagName() {
+			instanceTags = append(instanceTags, tag.UUID)
+		}
+	}
+
+	result := make([]model.TaggedResource, 0)
+	for _, instanceTag := range instanceTags {
+		for _, resourceType := range []string{ServersType, DrivesType} {
+			resources, err := d.client.GetByTag(ctx, instanceTag, resourceType)
+			if err != nil {
+				return nil, fmt.Errorf("Error getting %s tagged by the deployment engine: %w", resourceType, err)
+			}
+
+			for _, resource := range resources.Objects {
+				tagged := model.TaggedResource{
+					Type: resourceType,
+					UUID: resource.UUID,
+					Name: resource.Name,
+				}
+				for _, tag := range resource.Tags {
+					if infraID, ok := infraTags[tag.UUID]; ok {
+						tagged.InfrastructureID = infraID
+					}
+				}
+				result = append(result, tagged)
+			}
+		}
+	}
+	return result, nil
+}
+
+// DeleteResources deletes servers and drives returned by TaggedResources, and the tags of their infrastructures once they are empty
+func (d CloudsigmaDeployer) DeleteResources(ctx context.Context, resources []model.TaggedResource) map[string]error {
+	logger := log.WithField("provider", DeploymentType)
+	result := make(map[string]error)
+
+	sorted := append([]model.TaggedResource{}, resources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Type == ServersType && sorted[j].Type != ServersType
+	})
+
+	infraIDs := make(map[string]bool)
+	for _, resource := range sorted {
+		logger.WithField(resource.Type, resource.UUID).Info("Deleting tagged resource")
+		err := d.deleteResource(ctx, logger, resource.Type, ResourceType{UUID: resource.UUID, Name: resource.Name})
+		if err != nil {
+			result[resource.UUID] = err
+		}
+		if resource.InfrastructureID != "" {
+			infraIDs[resource.InfrastructureID] = true
+		}
+	}
+
+	if len(result) == 0 {
+		for infraID := range infraIDs {
+			d.deleteTaggedResources(ctx, logger, infraID, result)
+		}
+	}
+	return result
+}
@@ -18,28 +18,60 @@
 package infrastructure
 
 import (
-	"deployment-engine/infrastructure/cloudsigma"
-	"deployment-engine/infrastructure/kubernetes"
+	"context"
+	"deployment-engine/infrastructure/edge"
 	"deployment-engine/model"
 	"deployment-engine/persistence"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	AutocleanProperty = "deployment.autoclean"
+
+	AutocleanDefaultValue = false
+
+	// CreatingStatus is the status of the infrastructures whose resources are being created by their provider
+	CreatingStatus = "creating"
+)
+
 type InfrastructureCreationResult struct {
 	Info  model.InfrastructureDeploymentInfo
 	Error error
 }
 
-//Deployer is the main hybrid infrastructure deployer object
+// DeploymentOptions modify the behaviour of the creation of a deployment
+type DeploymentOptions struct {
+	// Progress, if not nil, will receive the state of each infrastructure indexed by name
+	Progress model.ProgressFunc
+	// Autoclean will delete the infrastructures that were successfully created if any other infrastructure in the deployment fails
+	Autoclean bool
+	// DeploymentID, if not empty, is the deployment to which the infrastructures are attached as they are created
+	DeploymentID string
+	// Principal is who requested the deployment. It's recorded in the events of the infrastructures.
+	Principal string
+}
+
+// Deployer is the main hybrid infrastructure deployer object
 type Deployer struct {
-	Repository        persistence.DeploymentRepository
-	Vault             persistence.Vault
+	Repository persistence.DeploymentRepository
+	Vault      persistence.Vault
+	// Events, if not nil, records the lifecycle events of the infrastructures
+	Events persistence.EventRepository
+	// Leases reserves the IPs of the nodes being created so that infrastructures created at the same time don't pick the same ones. Providers which pick the IPs of their nodes, such as CloudSigma, can't be used without it.
+	Leases            persistence.IPLeaseRepository
 	PublicKeyPath     string
 	DeploymentsFolder string
+	// Autoclean is the default behaviour when a deployment fails partially. It can be overriden for each deployment by passing DeploymentOptions
+	Autoclean bool
+	// groupsLock serializes the changes in the membership of deployments
+	groupsLock sync.Mutex
 }
 
 func (c *Deployer) transformCredentials(raw, result interface{}) error {
@@ -50,16 +82,16 @@ func (c *Deployer) transformCredentials(raw, result interface{}) error {
 	return json.Unmarshal(strValue, result)
 }
 
-func (c *Deployer) getProviderCredentials(provider model.CloudProviderInfo, credentials interface{}) error {
+func (c *Deployer) getProviderCredentials(vault persistence.Vault, provider model.CloudProviderInfo, credentials interface{}) error {
 	if provider.Credentials != nil {
 		return c.transformCredentials(provider.Credentials, credentials)
 	}
 
 	if provider.SecretID != "" {
-		if c.Vault == nil {
+		if vault == nil {
 			return errors.New("Found secret identifier but a vault hasn't been configured")
 		}
-		secret, err := c.Vault.GetSecret(provider.SecretID)
+		secret, err := vault.GetSecret(provider.SecretID)
 		if err != nil {
 			return err
 		}
@@ -69,39 +101,71 @@ func (c *Deployer) getProviderCredentials(provider model.CloudProviderInfo, cred
 	return errors.New("Secret ID or credentials are needed for cloud provider")
 }
 
-func (c *Deployer) findProvider(provider model.CloudProviderInfo) (model.Deployer, error) {
+// findProvider initializes the deployer of a provider for an operation of a principal over an infrastructure. The access to the secrets of the vault is recorded.
+func (c *Deployer) findProvider(principal, infraID string, provider model.CloudProviderInfo) (model.Deployer, error) {
 
 	if c.PublicKeyPath == "" {
 		return nil, errors.New("A public key location is needed to initialize a provider")
 	}
 
-	if provider.SecretID == "" && (provider.Credentials == nil || len(provider.Credentials) == 0) {
+	registration, ok := GetProvider(provider.APIType)
+	if !ok {
+		return nil, fmt.Errorf("Can't find a suitable deployer for API type %s", provider.APIType)
+	}
+
+	if !registration.WithoutCredentials && provider.SecretID == "" && (provider.Credentials == nil || len(provider.Credentials) == 0) {
 		return nil, fmt.Errorf("Either secret ID or provider credentials are mandatory for Provider %v", provider)
 	}
 
-	switch provider.APIType {
-	case "cloudsigma":
-		var credentials model.BasicAuthSecret
-		err := c.getProviderCredentials(provider, &credentials)
+	config := DeployerConfig{
+		Provider:          provider,
+		PublicKeyPath:     c.PublicKeyPath,
+		DeploymentsFolder: c.DeploymentsFolder,
+		Vault:             c.vaultFor(principal, infraID),
+		Leases:            c.Leases,
+	}
 
+	if registration.NewCredentials != nil {
+		config.Credentials = registration.NewCredentials()
+		err := c.getProviderCredentials(config.Vault, provider, config.Credentials)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	dep, err := registration.Factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing deployer for %s: %w", provider.APIType, err)
+	}
+	return dep, nil
+}
+
+// ProviderOf returns the provider information of an infrastructure. Edge infrastructures don't need to specify a provider since their hosts already exist.
+func ProviderOf(infra model.InfrastructureType) model.CloudProviderInfo {
+	provider := infra.Provider
+	if provider.APIType == "" && strings.EqualFold(infra.Type, edge.DeploymentType) {
+		provider.APIType = edge.DeploymentType
+	}
+	return provider
+}
 
-		if credentials.Username == "" || credentials.Password == "" {
-			return nil, fmt.Errorf("Invalid credentials specified for cloudsigma provider %s. Username and password are needed", provider.APIEndpoint)
+// ValidateDeployment checks that the provider of each infrastructure is supported and calls its validation function, if any, before creating any resource
+func (c *Deployer) ValidateDeployment(infras []model.InfrastructureType) error {
+	for _, infra := range infras {
+		infra.Provider = ProviderOf(infra)
+		registration, ok := GetProvider(infra.Provider.APIType)
+		if !ok {
+			return fmt.Errorf("Invalid provider type %s found in infrastructure %s. Supported types are: %v", infra.Provider.APIType, infra.Name, ProviderTypes())
 		}
 
-		dep, err := cloudsigma.NewDeployer(provider.APIEndpoint, credentials, c.PublicKeyPath)
-		if err != nil {
-			return nil, fmt.Errorf("Error initializing deployer for %s: %w", provider.APIType, err)
+		if registration.Validate != nil {
+			err := registration.Validate(infra)
+			if err != nil {
+				return fmt.Errorf("Invalid infrastructure %s: %w", infra.Name, err)
+			}
 		}
-		return *dep, err
-	case "kubernetes":
-		return kubernetes.NewKubernetesDeployer(c.DeploymentsFolder, c.Vault), nil
 	}
-
-	return nil, fmt.Errorf("Can't find a suitable deployer for API type %s", provider.APIType)
+	return nil
 }
 
 func (c *Deployer) mergeCustomProperties(source map[string]string, target map[string]string) map[string]string {
@@ -117,17 +181,73 @@ func (c *Deployer) mergeCustomProperties(source map[string]string, target map[st
 	return result
 }
 
-func (c *Deployer) DeployInfrastructure(infra model.InfrastructureType, channel chan InfrastructureCreationResult) {
-	deployer, err := c.findProvider(infra.Provider)
+func (c *Deployer) reportProgress(progress model.ProgressFunc, infraName, state string, err error) {
+	if progress != nil {
+		progress(infraName, state, err)
+	}
+}
+
+func (c *Deployer) DeployInfrastructure(ctx context.Context, principal string, infra model.InfrastructureType, channel chan InfrastructureCreationResult, progress model.ProgressFunc) {
+	c.reportProgress(progress, infra.Name, "creating", nil)
+
+	infra.Provider = ProviderOf(infra)
+	deployer, err := c.findProvider(principal, "", infra.Provider)
 
 	if err != nil {
+		c.recordEvent(principal, model.EventInfrastructureFailed, "", infra.Name, err)
+		c.reportProgress(progress, infra.Name, "failed", err)
 		channel <- InfrastructureCreationResult{
+			Info: model.InfrastructureDeploymentInfo{
+				Name: infra.Name,
+			},
 			Error: err,
 		}
 		return
 	}
-	depInfo, err := deployer.DeployInfrastructure(infra)
+
+	// The infrastructure is saved before its resources are created so they aren't taken as garbage while it's being created
+	infra.ID = uuid.New().String()
+	creating, err := c.Repository.AddInfrastructure(model.InfrastructureDeploymentInfo{
+		ID:              infra.ID,
+		Name:            infra.Name,
+		Type:            infra.Type,
+		Provider:        model.CloudProviderInfo{APIType: infra.Provider.APIType, SecretID: infra.Provider.SecretID},
+		Nodes:           make(map[string][]model.NodeInfo),
+		Status:          CreatingStatus,
+		UserData:        infra.UserData,
+		ExtraProperties: infra.ExtraProperties,
+	})
+	if err != nil {
+		err = fmt.Errorf("Error saving infrastructure %s: %w", infra.Name, err)
+		c.recordEvent(principal, model.EventInfrastructureFailed, "", infra.Name, err)
+		c.reportProgress(progress, infra.Name, "failed", err)
+		channel <- InfrastructureCreationResult{
+			Info: model.InfrastructureDeploymentInfo{
+				Name: infra.Name,
+			},
+			Error: err,
+		}
+		return
+	}
+
+	depInfo, err := deployer.DeployInfrastructure(ctx, infra)
 	depInfo.Provider = infra.Provider
+	recordDefinitions(&depInfo, infra.Resources)
+	if err != nil || depInfo.ID != creating.ID {
+		// The infrastructure failed or the deployer didn't use the assigned identifier, so the saved one is no longer valid
+		_, delErr := c.Repository.DeleteInfrastructure(creating.ID)
+		if delErr != nil {
+			log.WithError(delErr).Errorf("Error deleting infrastructure %s being created", creating.ID)
+		}
+	} else {
+		depInfo.CreationTime = creating.CreationTime
+	}
+	if err != nil {
+		c.recordEvent(principal, model.EventInfrastructureFailed, "", infra.Name, err)
+		c.reportProgress(progress, infra.Name, "failed", err)
+	} else {
+		c.reportProgress(progress, infra.Name, "created", nil)
+	}
 	channel <- InfrastructureCreationResult{
 		Info:  depInfo,
 		Error: err,
@@ -135,17 +255,25 @@ func (c *Deployer) DeployInfrastructure(infra model.InfrastructureType, channel
 	return
 }
 
-//CreateDeployment will create an hybrid deployment with the configuration passed as argument
-func (c *Deployer) CreateDeployment(infras []model.InfrastructureType) ([]model.InfrastructureDeploymentInfo, error) {
+// CreateDeployment will create an hybrid deployment with the configuration passed as argument
+func (c *Deployer) CreateDeployment(ctx context.Context, infras []model.InfrastructureType) ([]model.InfrastructureDeploymentInfo, error) {
+	return c.CreateDeploymentWithOptions(ctx, infras, DeploymentOptions{
+		Autoclean: c.Autoclean,
+	})
+}
+
+// CreateDeploymentWithOptions will create an hybrid deployment with the configuration passed as argument. If autoclean is set in the options and some infrastructure fails, it will return the infrastructures that couldn't be cleaned.
+func (c *Deployer) CreateDeploymentWithOptions(ctx context.Context, infras []model.InfrastructureType, options DeploymentOptions) ([]model.InfrastructureDeploymentInfo, error) {
 
 	result := make([]model.InfrastructureDeploymentInfo, 0, len(infras))
+	failed := make([]model.InfrastructureDeploymentInfo, 0)
 
 	log.Tracef("Starting new deployment")
 
 	channel := make(chan InfrastructureCreationResult, len(infras))
 
 	for _, infra := range infras {
-		go c.DeployInfrastructure(infra, channel)
+		go c.DeployInfrastructure(ctx, options.Principal, infra, channel, options.Progress)
 	}
 
 	var depError error
@@ -154,29 +282,52 @@ func (c *Deployer) CreateDeployment(infras []model.InfrastructureType) ([]model.
 		if infraInfo.Error != nil {
 			log.WithError(infraInfo.Error).Errorf("Error creating infrastructure %s", infraInfo.Info.Name)
 			depError = infraInfo.Error
+			failed = append(failed, infraInfo.Info)
 		} else {
 			infraInfo.Info.Provider.Credentials = nil
-			infra, err := c.Repository.AddInfrastructure(infraInfo.Info)
+			infra, err := c.saveCreatedInfrastructure(infraInfo.Info)
 			if err != nil {
 				log.WithError(err).Errorf("Error adding infrastructure %s", infraInfo.Info.Name)
+			} else {
+				c.recordEvent(options.Principal, model.EventInfrastructureCreated, infra.ID, infra.Name, nil)
+				c.recordNodes(options.Principal, model.EventNodeCreated, infra)
+				if options.DeploymentID != "" {
+					err = c.attachInfrastructure(options.DeploymentID, infra.ID)
+					if err != nil {
+						log.WithError(err).Errorf("Error attaching infrastructure %s to deployment %s", infra.ID, options.DeploymentID)
+					}
+				}
 			}
 			result = append(result, infra)
 		}
 	}
 
-	if depError != nil {
-		// TODO: Remove partial infrastructures if autoclean is on
+	if depError != nil && options.Autoclean {
+		remaining, report := c.cleanPartialDeployment(options.Principal, result, failed, options.Progress)
+		return remaining, DeploymentError{
+			Cause:   depError,
+			Cleanup: &report,
+		}
 	}
 
 	return result, depError
 }
 
-func (c *Deployer) DeleteDeployment(infras []string) error {
+// saveCreatedInfrastructure replaces the infrastructure saved while it was being created, or adds it if it wasn't saved with the same identifier
+func (c *Deployer) saveCreatedInfrastructure(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
+	if infra.CreationTime.IsZero() {
+		return c.Repository.AddInfrastructure(infra)
+	}
+	return c.Repository.UpdateInfrastructure(infra)
+}
+
+// DeleteDeployment deletes a list of infrastructures in parallel on behalf of a principal
+func (c *Deployer) DeleteDeployment(ctx context.Context, principal string, infras []string) error {
 
 	channel := make(chan InfrastructureCreationResult, len(infras))
 
 	for _, infra := range infras {
-		go c.DeleteInfrastructureParallel(infra, channel)
+		go c.DeleteInfrastructureParallel(ctx, principal, infra, channel)
 	}
 
 	var depError error
@@ -192,8 +343,8 @@ func (c *Deployer) DeleteDeployment(infras []string) error {
 
 }
 
-func (c *Deployer) DeleteInfrastructureParallel(infraID string, channel chan InfrastructureCreationResult) error {
-	_, err := c.DeleteInfrastructure(infraID)
+func (c *Deployer) DeleteInfrastructureParallel(ctx context.Context, principal, infraID string, channel chan InfrastructureCreationResult) error {
+	_, err := c.DeleteInfrastructure(ctx, principal, infraID)
 	channel <- InfrastructureCreationResult{
 		Info: model.InfrastructureDeploymentInfo{
 			ID: infraID,
@@ -203,8 +354,8 @@ func (c *Deployer) DeleteInfrastructureParallel(infraID string, channel chan Inf
 	return err
 }
 
-//DeleteInfrastructure will delete an infrastructure from a deployment. It will delete the deployment itself when there aren't infrastructures left.
-func (c *Deployer) DeleteInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error) {
+// DeleteInfrastructure will delete an infrastructure from a deployment. It will delete the deployment itself when there aren't infrastructures left.
+func (c *Deployer) DeleteInfrastructure(ctx context.Context, principal, infraID string) (model.InfrastructureDeploymentInfo, error) {
 
 	infra, err := c.Repository.FindInfrastructure(infraID)
 	if err != nil {
@@ -212,19 +363,32 @@ func (c *Deployer) DeleteInfrastructure(infraID string) (model.InfrastructureDep
 		return infra, err
 	}
 
-	deployer, err := c.findProvider(infra.Provider)
+	deployer, err := c.findProvider(principal, infraID, infra.Provider)
 	if err != nil {
 		log.WithError(err).Errorf("Can't find providers for infrastructure ID %s", infraID)
 		return infra, err
 	}
 
-	delErrors := deployer.DeleteInfrastructure(infra)
+	delErrors := deployer.DeleteInfrastructure(ctx, infra)
 	if delErrors != nil && len(delErrors) > 0 {
 		for k, v := range delErrors {
 			log.WithError(v).Errorf("Error deleting host %s", k)
+			c.recordEvent(principal, model.EventNodeFailed, infraID, k, v)
 		}
 		return infra, fmt.Errorf("Errors found deleting infrastructure: %v", delErrors)
 	}
 
-	return c.Repository.DeleteInfrastructure(infraID)
+	infra, err = c.Repository.DeleteInfrastructure(infraID)
+	if err != nil {
+		return infra, err
+	}
+
+	c.recordEvent(principal, model.EventInfrastructureDeleted, infraID, infra.Name, nil)
+
+	err = c.detachInfrastructure(infraID)
+	if err != nil {
+		log.WithError(err).Errorf("Error detaching deleted infrastructure %s from its deployments", infraID)
+	}
+
+	return infra, nil
 }
@@ -0,0 +1,815 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/persistence/memoryrepo"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const fakeProviderType = "fake"
+
+// unknownProviderType is an API type without deployer, so the infrastructures using it can be neither created nor deleted
+const unknownProviderType = "unknown"
+
+// fakeDeployer creates one node per resource and fails for the infrastructures and nodes whose names are marked. Nodes whose hostnames are marked as missing are reported as such when checked.
+type fakeDeployer struct {
+	failDeploy map[string]bool
+	failDelete map[string]bool
+	missing    map[string]bool
+}
+
+var fake = fakeDeployer{
+	failDeploy: make(map[string]bool),
+	failDelete: make(map[string]bool),
+	missing:    make(map[string]bool),
+}
+
+func (d fakeDeployer) toNode(infraName string, resource model.ResourceType) model.NodeInfo {
+	return model.NodeInfo{
+		Hostname:        fmt.Sprintf("%s-%s", infraName, resource.Name),
+		Role:            resource.Role,
+		UUID:            resource.Name,
+		ResourceName:    resource.Name,
+		ExtraProperties: resource.ExtraProperties,
+	}
+}
+
+func (d fakeDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+	result := model.InfrastructureDeploymentInfo{
+		ID:     "id-" + infra.Name,
+		Name:   infra.Name,
+		Status: RunningStatus,
+	}
+	for _, resource := range infra.Resources {
+		result.AddNode(d.toNode(infra.Name, resource))
+	}
+	if d.failDeploy[infra.Name] {
+		return result, fmt.Errorf("Infrastructure %s failed", infra.Name)
+	}
+	return result, nil
+}
+
+func (d fakeDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	result := make(map[string]error)
+	if d.failDelete[infra.Name] {
+		result[infra.Name] = fmt.Errorf("Can't delete infrastructure %s", infra.Name)
+	}
+	return result
+}
+
+func (d fakeDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	for _, resource := range resources {
+		infra.AddNode(d.toNode(infra.Name, resource))
+	}
+	return infra, nil
+}
+
+func (d fakeDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	for _, hostname := range hostnames {
+		infra.RemoveNode(hostname)
+	}
+	return infra, nil
+}
+
+func (d fakeDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		if d.missing[node.Hostname] {
+			result[node.Hostname] = model.NodeStatus{
+				Status:   model.NodeStatusMissing,
+				Problems: []string{"Server deleted"},
+			}
+		} else {
+			result[node.Hostname] = model.NodeStatus{Status: model.NodeStatusRunning}
+		}
+	})
+	return result, nil
+}
+
+func (d fakeDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	result := model.InfrastructurePlan{}
+	for _, resource := range infra.Resources {
+		result.Nodes = append(result.Nodes, model.NodePlan{
+			Hostname: d.toNode(infra.Name, resource).Hostname,
+			Role:     resource.Role,
+		})
+	}
+	if d.failDeploy[infra.Name] {
+		result.AddError("Infrastructure %s would fail", infra.Name)
+	}
+	return result, nil
+}
+
+func TestMain(m *testing.M) {
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType: fakeProviderType,
+		},
+		Validate: func(infra model.InfrastructureType) error {
+			if len(infra.Resources) == 0 {
+				return errors.New("No resources")
+			}
+			for _, resource := range infra.Resources {
+				if resource.Role == "" {
+					return fmt.Errorf("Resource %s has no role", resource.Name)
+				}
+			}
+			return nil
+		},
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return fake, nil
+		},
+	})
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType: collectingProviderType,
+		},
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return collecting, nil
+		},
+	})
+	os.Exit(m.Run())
+}
+
+// newTestDeployer returns a deployer backed by a memory repository along with the identifier of a secret to use as provider credentials, since inline credentials are not persisted
+func newTestDeployer(t *testing.T) (*Deployer, string) {
+	repo := memoryrepo.CreateMemoryRepository()
+	secretID, err := repo.AddSecret(model.Secret{
+		Content: map[string]interface{}{
+			"user": "test",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating test secret: %s", err.Error())
+	}
+	return &Deployer{
+		Repository:    repo,
+		Vault:         repo,
+		Events:        repo,
+		PublicKeyPath: "/dev/null",
+	}, secretID
+}
+
+func fakeInfra(secretID, name string, resources ...string) model.InfrastructureType {
+	result := model.InfrastructureType{
+		Name: name,
+		Provider: model.CloudProviderInfo{
+			APIType:  fakeProviderType,
+			SecretID: secretID,
+		},
+	}
+	for _, resource := range resources {
+		result.Resources = append(result.Resources, model.ResourceType{
+			Name: resource,
+			Role: "master",
+		})
+	}
+	return result
+}
+
+func TestRegistry(t *testing.T) {
+	err := RegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType: fakeProviderType,
+		},
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return fake, nil
+		},
+	})
+	if err == nil {
+		t.Fatal("Registered the same provider twice")
+	}
+
+	err = RegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType: "nofactory",
+		},
+	})
+	if err == nil {
+		t.Fatal("Registered a provider without factory")
+	}
+
+	found := false
+	for _, provider := range ListProviders() {
+		found = found || provider.APIType == fakeProviderType
+	}
+	if !found {
+		t.Fatalf("Can't find provider %s in the list of providers", fakeProviderType)
+	}
+
+	deployer, secretID := newTestDeployer(t)
+	err = deployer.ValidateDeployment([]model.InfrastructureType{fakeInfra(secretID, "empty")})
+	if err == nil {
+		t.Fatal("Validation hook not called")
+	}
+
+	edgeInfra := model.InfrastructureType{
+		Name: "edge",
+		Type: "edge",
+		Resources: []model.ResourceType{
+			model.ResourceType{Name: "master", IP: "10.0.0.1"},
+		},
+	}
+	if err = deployer.ValidateDeployment([]model.InfrastructureType{edgeInfra}); err != nil {
+		t.Fatalf("Edge infrastructure without provider not accepted: %s", err.Error())
+	}
+
+	invalid := fakeInfra(secretID, "invalid", "master")
+	invalid.Provider.APIType = "unknown"
+	err = deployer.ValidateDeployment([]model.InfrastructureType{invalid})
+	if err == nil {
+		t.Fatal("Validated infrastructure with unknown provider")
+	}
+}
+
+func TestAutoclean(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	fake.failDeploy["failed"] = true
+	fake.failDelete["orphan"] = true
+	defer delete(fake.failDeploy, "failed")
+	defer delete(fake.failDelete, "orphan")
+
+	var progressLock sync.Mutex
+	progress := make(map[string]string)
+	result, err := deployer.CreateDeploymentWithOptions(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "ok", "master"),
+		fakeInfra(secretID, "orphan", "master"),
+		fakeInfra(secretID, "failed", "master"),
+	}, DeploymentOptions{
+		Autoclean: true,
+		Progress: func(target, state string, err error) {
+			progressLock.Lock()
+			defer progressLock.Unlock()
+			progress[target] = state
+		},
+	})
+
+	if err == nil {
+		t.Fatal("Expected deployment error but got nil")
+	}
+
+	var depErr DeploymentError
+	if !errors.As(err, &depErr) || depErr.Cleanup == nil {
+		t.Fatalf("Expected deployment error with cleanup report but got %v", err)
+	}
+
+	if len(depErr.Cleanup.Deleted) != 2 {
+		t.Fatalf("Expected 2 deleted infrastructures but found %v", depErr.Cleanup.Deleted)
+	}
+
+	if _, ok := depErr.Cleanup.Orphaned["id-orphan"]; !ok || len(depErr.Cleanup.Orphaned) != 1 {
+		t.Fatalf("Expected orphan infrastructure in cleanup report but found %v", depErr.Cleanup.Orphaned)
+	}
+
+	if len(result) != 1 || result[0].Status != OrphanedStatus {
+		t.Fatalf("Expected one orphaned infrastructure as result but found %v", result)
+	}
+
+	if _, err := deployer.Repository.FindInfrastructure("id-ok"); err == nil {
+		t.Fatal("Infrastructure id-ok still in repository after autoclean")
+	}
+
+	orphan, err := deployer.Repository.FindInfrastructure("id-orphan")
+	if err != nil || orphan.Status != OrphanedStatus {
+		t.Fatalf("Orphaned infrastructure not found with orphaned status: %v", err)
+	}
+
+	if progress["ok"] != "deleted" || progress["orphan"] != OrphanedStatus || progress["failed"] != "failed" {
+		t.Fatalf("Unexpected progress reported: %v", progress)
+	}
+}
+
+func TestNoAutoclean(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	fake.failDeploy["failed"] = true
+	defer delete(fake.failDeploy, "failed")
+
+	result, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "ok", "master"),
+		fakeInfra(secretID, "failed", "master"),
+	})
+
+	if err == nil {
+		t.Fatal("Expected deployment error but got nil")
+	}
+
+	if len(result) != 1 {
+		t.Fatalf("Expected one infrastructure created but found %v", result)
+	}
+
+	if _, err := deployer.Repository.FindInfrastructure("id-ok"); err != nil {
+		t.Fatalf("Infrastructure removed without autoclean: %s", err.Error())
+	}
+}
+
+func TestAutocleanOrphans(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+
+	// Infrastructures whose provider can't be found can't be deleted
+	created, err := deployer.Repository.AddInfrastructure(model.InfrastructureDeploymentInfo{
+		ID:       "created",
+		Name:     "created",
+		Provider: model.CloudProviderInfo{APIType: unknownProviderType, SecretID: secretID},
+	})
+	if err != nil {
+		t.Fatalf("Error adding infrastructure: %s", err.Error())
+	}
+
+	failed := model.InfrastructureDeploymentInfo{
+		ID:       "failed",
+		Name:     "failed",
+		Provider: model.CloudProviderInfo{APIType: unknownProviderType, SecretID: secretID},
+		Nodes: map[string][]model.NodeInfo{
+			"master": {{Hostname: "failed-master", Role: "master"}},
+		},
+	}
+
+	// Failed infrastructures without nodes have nothing to delete
+	empty := model.InfrastructureDeploymentInfo{ID: "empty", Name: "empty"}
+
+	remaining, report := deployer.cleanPartialDeployment("test", []model.InfrastructureDeploymentInfo{created}, []model.InfrastructureDeploymentInfo{failed, empty}, nil)
+
+	if len(report.Deleted) != 0 || len(report.Orphaned) != 2 || len(remaining) != 2 {
+		t.Fatalf("Expected 2 orphaned infrastructures but found %v and %v remaining", report, remaining)
+	}
+
+	for _, id := range []string{"created", "failed"} {
+		if _, ok := report.Orphaned[id]; !ok {
+			t.Fatalf("Infrastructure %s not reported as orphaned: %v", id, report.Orphaned)
+		}
+
+		orphan, err := deployer.Repository.FindInfrastructure(id)
+		if err != nil || orphan.Status != OrphanedStatus {
+			t.Fatalf("Infrastructure %s not found with orphaned status: %v", id, err)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	result, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "scale", "master", "slave1"),
+	})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	infraID := result[0].ID
+	err = deployer.ValidateNodesPatch(infraID, model.NodesPatch{
+		Remove: []string{"nonexistent"},
+	})
+	if err == nil {
+		t.Fatal("Validated removal of non existing node")
+	}
+
+	err = deployer.ValidateNodesPatch(infraID, model.NodesPatch{
+		Add: []model.ResourceType{
+			model.ResourceType{Name: "norole"},
+		},
+	})
+	if err == nil {
+		t.Fatal("Validation hook not called for nodes to add")
+	}
+
+	infra, err := deployer.ScaleInfrastructure(context.Background(), "test", infraID, model.NodesPatch{
+		Add: []model.ResourceType{
+			model.ResourceType{Name: "slave2", Role: "slave"},
+		},
+		Remove: []string{"scale-slave1"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error scaling infrastructure: %s", err.Error())
+	}
+
+	stored, err := deployer.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Error finding scaled infrastructure: %s", err.Error())
+	}
+
+	for _, check := range []model.InfrastructureDeploymentInfo{infra, stored} {
+		if _, found := check.FindNode("scale-slave1"); found {
+			t.Fatal("Removed node still present in infrastructure")
+		}
+		if _, found := check.FindNode("scale-slave2"); !found {
+			t.Fatal("Added node not present in infrastructure")
+		}
+		if check.Status == ScalingStatus {
+			t.Fatal("Infrastructure left in scaling status")
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	fake.failDeploy["failed"] = true
+	defer delete(fake.failDeploy, "failed")
+
+	inline := fakeInfra("", "inline", "master", "slave")
+	inline.Provider.Credentials = map[string]interface{}{
+		"user": "test",
+	}
+
+	plan := deployer.PlanDeployment(context.Background(), "test", []model.InfrastructureType{
+		inline,
+		fakeInfra(secretID, "vault", "master"),
+	})
+
+	if !plan.Valid || len(plan.Infrastructures) != 2 {
+		t.Fatalf("Expected a valid plan with two infrastructures but found %v", plan.Infrastructures)
+	}
+
+	inlinePlan := plan.Infrastructures[0]
+	if inlinePlan.Name != "inline" || inlinePlan.CredentialsSource != model.CredentialsSourceInline || len(inlinePlan.Nodes) != 2 {
+		t.Fatalf("Unexpected plan for infrastructure with inline credentials: %v", inlinePlan)
+	}
+
+	vaultPlan := plan.Infrastructures[1]
+	if vaultPlan.Name != "vault" || vaultPlan.CredentialsSource != model.CredentialsSourceVault || vaultPlan.Provider != fakeProviderType {
+		t.Fatalf("Unexpected plan for infrastructure with vault credentials: %v", vaultPlan)
+	}
+
+	plan = deployer.PlanDeployment(context.Background(), "test", []model.InfrastructureType{
+		fakeInfra(secretID, "failed", "master"),
+		fakeInfra("", "nocredentials", "master"),
+	})
+
+	if plan.Valid {
+		t.Fatal("Expected an invalid plan")
+	}
+
+	for _, infraPlan := range plan.Infrastructures {
+		if len(infraPlan.Errors) == 0 {
+			t.Fatalf("Expected errors in plan of infrastructure %s", infraPlan.Name)
+		}
+	}
+
+	if _, err := deployer.Repository.FindInfrastructure("id-failed"); err == nil {
+		t.Fatal("Infrastructure saved by a dry run")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	result, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "reconcile", "master", "slave1"),
+	})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+	infraID := result[0].ID
+
+	spec := fakeInfra(secretID, "reconcile", "master", "slave2")
+	spec.ExtraProperties = model.ExtraPropertiesType{"env": "test"}
+	spec.Resources[0].ExtraProperties = model.ExtraPropertiesType{"gpu": "true"}
+
+	renamed := fakeInfra(secretID, "renamed", "master")
+	if _, err := deployer.DiffInfrastructure(infraID, renamed); err == nil {
+		t.Fatal("Infrastructure rename accepted")
+	}
+
+	changes, infra, err := deployer.ReconcileInfrastructure(context.Background(), "test", infraID, spec, nil)
+	if err != nil {
+		t.Fatalf("Error reconciling infrastructure: %s", err.Error())
+	}
+
+	if len(changes.Create) != 1 || changes.Create[0].Name != "slave2" {
+		t.Fatalf("Expected creation of slave2 but found %v", changes.Create)
+	}
+
+	if len(changes.Delete) != 1 || changes.Delete[0] != "reconcile-slave1" {
+		t.Fatalf("Expected deletion of reconcile-slave1 but found %v", changes.Delete)
+	}
+
+	if len(changes.Update) != 1 || changes.Update[0] != "reconcile-master" || !changes.UpdateProperties {
+		t.Fatalf("Expected update of properties of infrastructure and reconcile-master but found %v", changes)
+	}
+
+	stored, err := deployer.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Error finding reconciled infrastructure: %s", err.Error())
+	}
+
+	for _, check := range []model.InfrastructureDeploymentInfo{infra, stored} {
+		if _, found := check.FindNode("reconcile-slave2"); !found {
+			t.Fatal("Created node not found in infrastructure")
+		}
+		if _, found := check.FindNode("reconcile-slave1"); found {
+			t.Fatal("Deleted node found in infrastructure")
+		}
+		master, _ := check.FindNode("reconcile-master")
+		if master.ExtraProperties["gpu"] != "true" || check.ExtraProperties["env"] != "test" {
+			t.Fatal("Extra properties not updated")
+		}
+	}
+
+	changes, _, err = deployer.ReconcileInfrastructure(context.Background(), "test", infraID, spec, nil)
+	if err != nil {
+		t.Fatalf("Error reconciling unchanged infrastructure: %s", err.Error())
+	}
+
+	if !changes.IsEmpty() {
+		t.Fatalf("Expected no changes reconciling the same definition but found %v", changes)
+	}
+}
+
+func TestReconcileLegacyNodes(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+
+	// Nodes saved before resource names were recorded, named as virtual machines and as nodes of kubernetes clusters
+	infra, err := deployer.Repository.AddInfrastructure(model.InfrastructureDeploymentInfo{
+		ID:       "legacy",
+		Name:     "Legacy_Infra",
+		Provider: model.CloudProviderInfo{APIType: fakeProviderType, SecretID: secretID},
+		Nodes: map[string][]model.NodeInfo{
+			"master": {{Hostname: "legacy-infra-master", Role: "master"}},
+			"slave":  {{Hostname: "worker", Role: "slave"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error adding infrastructure: %s", err.Error())
+	}
+
+	changes, err := deployer.DiffInfrastructure(infra.ID, fakeInfra(secretID, "Legacy_Infra", "master", "worker", "slave"))
+	if err != nil {
+		t.Fatalf("Error computing changes of infrastructure: %s", err.Error())
+	}
+
+	if len(changes.Delete) != 0 || len(changes.Update) != 0 {
+		t.Fatalf("Expected existing nodes to be kept but found %v", changes)
+	}
+
+	if len(changes.Create) != 1 || changes.Create[0].Name != "slave" {
+		t.Fatalf("Expected creation of slave but found %v", changes.Create)
+	}
+}
+
+func TestReconcileChangedResource(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	result, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "resized", "master"),
+	})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+	infraID := result[0].ID
+
+	spec := fakeInfra(secretID, "resized", "master")
+	spec.Resources[0].RAM = 4096
+	spec.Resources[0].Drives = []model.Drive{{Name: "data", Size: 1024}}
+	if _, err := deployer.DiffInfrastructure(infraID, spec); err == nil || !strings.Contains(err.Error(), "resized-master (ram, drives)") {
+		t.Fatalf("Expected changes of ram and drives of resized-master to be rejected but got %v", err)
+	}
+
+	spec = fakeInfra(secretID, "resized", "master", "slave")
+	spec.Resources[1].RAM = 4096
+	if _, _, err := deployer.ReconcileInfrastructure(context.Background(), "test", infraID, spec, nil); err != nil {
+		t.Fatalf("Error reconciling infrastructure: %s", err.Error())
+	}
+
+	spec.Resources[1].RAM = 8192
+	if _, err := deployer.DiffInfrastructure(infraID, spec); err == nil {
+		t.Fatal("Change of RAM of added node accepted")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	result, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "refresh", "master", "slave"),
+	})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	infraID := result[0].ID
+	infra, err := deployer.RefreshInfrastructure(context.Background(), "test", infraID)
+	if err != nil {
+		t.Fatalf("Error refreshing infrastructure: %s", err.Error())
+	}
+
+	if infra.Status != RunningStatus || infra.RefreshTime == nil {
+		t.Fatalf("Unexpected status %s or refresh time %v of healthy infrastructure", infra.Status, infra.RefreshTime)
+	}
+
+	fake.missing["refresh-slave"] = true
+	deployer.RefreshAll(context.Background())
+
+	stored, err := deployer.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Error finding refreshed infrastructure: %s", err.Error())
+	}
+
+	if stored.Status != DegradedStatus {
+		t.Fatalf("Expected degraded infrastructure but found status %s", stored.Status)
+	}
+
+	slave, _ := stored.FindNode("refresh-slave")
+	if slave.Status != model.NodeStatusMissing || len(slave.Problems) != 1 {
+		t.Fatalf("Unexpected status of missing node: %v", slave)
+	}
+
+	master, _ := stored.FindNode("refresh-master")
+	if master.Status != model.NodeStatusRunning {
+		t.Fatalf("Unexpected status of running node: %v", master)
+	}
+
+	delete(fake.missing, "refresh-slave")
+	infra, err = deployer.RefreshInfrastructure(context.Background(), "test", infraID)
+	if err != nil {
+		t.Fatalf("Error refreshing recovered infrastructure: %s", err.Error())
+	}
+
+	slave, _ = infra.FindNode("refresh-slave")
+	if infra.Status != RunningStatus || len(slave.Problems) != 0 {
+		t.Fatalf("Infrastructure not recovered after refresh: %v", infra)
+	}
+}
+
+func TestDeploymentGroup(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	group, err := deployer.CreateDeploymentGroup("group", map[string]string{"env": "test"})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	if group.Status != EmptyStatus {
+		t.Fatalf("Expected empty deployment but found status %s", group.Status)
+	}
+
+	_, err = deployer.CreateDeploymentWithOptions(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "group1", "master"),
+		fakeInfra(secretID, "group2", "master"),
+	}, DeploymentOptions{DeploymentID: group.ID})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	group, err = deployer.FindDeploymentGroup(group.ID)
+	if err != nil {
+		t.Fatalf("Error finding deployment: %s", err.Error())
+	}
+
+	if len(group.Infrastructures) != 2 || group.Status != RunningStatus {
+		t.Fatalf("Unexpected deployment after creating its infrastructures: %v", group)
+	}
+
+	other, err := deployer.CreateDeploymentGroup("other", nil)
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	_, err = deployer.MoveInfrastructures(other.ID, model.DeploymentGroupMove{
+		Attach: []string{"nonexistent"},
+	})
+	if err == nil {
+		t.Fatal("Attached non existing infrastructure")
+	}
+
+	other, err = deployer.MoveInfrastructures(other.ID, model.DeploymentGroupMove{
+		Attach: []string{"id-group2"},
+	})
+	if err != nil {
+		t.Fatalf("Error moving infrastructure: %s", err.Error())
+	}
+
+	group, _ = deployer.FindDeploymentGroup(group.ID)
+	if len(other.Infrastructures) != 1 || len(group.Infrastructures) != 1 || group.HasInfrastructure("id-group2") {
+		t.Fatalf("Infrastructure not moved between deployments: %v, %v", group, other)
+	}
+
+	_, err = deployer.Repository.UpdateInfrastructureStatus("id-group2", "failed")
+	if err != nil {
+		t.Fatalf("Error updating infrastructure status: %s", err.Error())
+	}
+
+	other, _ = deployer.FindDeploymentGroup(other.ID)
+	if other.Status != DegradedStatus {
+		t.Fatalf("Expected degraded deployment but found %s", other.Status)
+	}
+
+	fake.failDelete["group1"] = true
+	_, err = deployer.DeleteDeploymentGroup(context.Background(), "test", group.ID)
+	delete(fake.failDelete, "group1")
+	if err == nil {
+		t.Fatal("Deleted deployment whose infrastructures can't be deleted")
+	}
+
+	if _, err = deployer.FindDeploymentGroup(group.ID); err != nil {
+		t.Fatalf("Deployment deleted with remaining infrastructures: %s", err.Error())
+	}
+
+	_, err = deployer.DeleteDeploymentGroup(context.Background(), "test", group.ID)
+	if err != nil {
+		t.Fatalf("Error deleting deployment: %s", err.Error())
+	}
+
+	if _, err = deployer.Repository.FindInfrastructure("id-group1"); err == nil {
+		t.Fatal("Infrastructure of deleted deployment still exists")
+	}
+
+	if _, err = deployer.FindDeploymentGroup(group.ID); err == nil {
+		t.Fatal("Found deleted deployment")
+	}
+
+	_, err = deployer.DeleteInfrastructure(context.Background(), "test", "id-group2")
+	if err != nil {
+		t.Fatalf("Error deleting infrastructure: %s", err.Error())
+	}
+
+	other, _ = deployer.FindDeploymentGroup(other.ID)
+	if len(other.Infrastructures) != 0 || other.Status != EmptyStatus {
+		t.Fatalf("Deleted infrastructure not detached from deployment: %v", other)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+	result, err := deployer.CreateDeploymentWithOptions(context.Background(), []model.InfrastructureType{
+		fakeInfra(secretID, "events", "master", "slave"),
+	}, DeploymentOptions{Principal: "admin"})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	infraID := result[0].ID
+	_, err = deployer.ScaleInfrastructure(context.Background(), "operator", infraID, model.NodesPatch{
+		Remove: []string{"events-slave"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Error scaling infrastructure: %s", err.Error())
+	}
+
+	_, err = deployer.DeleteInfrastructure(context.Background(), "operator", infraID)
+	if err != nil {
+		t.Fatalf("Error deleting infrastructure: %s", err.Error())
+	}
+
+	events, err := deployer.Events.FindEventsByInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Error finding events: %s", err.Error())
+	}
+
+	found := make(map[string]model.Event)
+	for _, event := range events {
+		found[event.Type+":"+event.Target] = event
+	}
+
+	expected := map[string]string{
+		model.EventInfrastructureCreated + ":events": "admin",
+		model.EventNodeCreated + ":events-master":    "admin",
+		model.EventNodeCreated + ":events-slave":     "admin",
+		model.EventNodeDeleted + ":events-slave":     "operator",
+		model.EventInfrastructureDeleted + ":events": "operator",
+	}
+	for key, principal := range expected {
+		event, ok := found[key]
+		if !ok {
+			t.Fatalf("Event %s not found in %v", key, events)
+		}
+		if event.Principal != principal || event.Timestamp.IsZero() {
+			t.Fatalf("Unexpected event %s: %v", key, event)
+		}
+	}
+
+	last := events[len(events)-1]
+	if last.Type != model.EventInfrastructureDeleted {
+		t.Fatalf("Expected deletion to be the last event but found %v", last)
+	}
+
+	_, err = deployer.vaultFor("reader", infraID).GetSecret(secretID)
+	if err != nil {
+		t.Fatalf("Error reading secret: %s", err.Error())
+	}
+
+	events, err = deployer.Events.FindEventsByInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Error finding events: %s", err.Error())
+	}
+	last = events[len(events)-1]
+	if last.Type != model.EventSecretAccessed || last.Target != secretID || last.Principal != "reader" {
+		t.Fatalf("Expected secret access to be recorded but found %v", last)
+	}
+}
@@ -0,0 +1,219 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// EmptyStatus is the status of a deployment without infrastructures
+	EmptyStatus = "empty"
+	// DeletedStatus is reported for the infrastructures of a deployment that can't be found anymore
+	DeletedStatus = "deleted"
+)
+
+// aggregateStatus computes the status of a deployment from the status of its infrastructures. If all of them share the same status, it's the status of the deployment. Otherwise, the deployment is degraded if any of them has failed or is degraded, or it takes the status of the infrastructures which are not running yet.
+func aggregateStatus(group model.DeploymentGroup) string {
+	if len(group.Infrastructures) == 0 {
+		return EmptyStatus
+	}
+
+	first := group.InfrastructureStatus[group.Infrastructures[0]]
+	same := true
+	pending := ""
+	for _, id := range group.Infrastructures {
+		status := group.InfrastructureStatus[id]
+		same = same && status == first
+		switch status {
+		case "failed", OrphanedStatus, DegradedStatus, DeletedStatus:
+			return DegradedStatus
+		case RunningStatus:
+		default:
+			if pending == "" {
+				pending = status
+			}
+		}
+	}
+
+	if same {
+		return first
+	}
+
+	return pending
+}
+
+// withStatus fills the status of each infrastructure of the deployment and the aggregate status
+func (c *Deployer) withStatus(group model.DeploymentGroup) model.DeploymentGroup {
+	group.InfrastructureStatus = make(map[string]string)
+	for _, id := range group.Infrastructures {
+		infra, err := c.Repository.FindInfrastructure(id)
+		if err != nil {
+			group.InfrastructureStatus[id] = DeletedStatus
+		} else {
+			group.InfrastructureStatus[id] = infra.Status
+		}
+	}
+	group.Status = aggregateStatus(group)
+	return group
+}
+
+// CreateDeploymentGroup creates an empty deployment to group infrastructures
+func (c *Deployer) CreateDeploymentGroup(name string, labels map[string]string) (model.DeploymentGroup, error) {
+	group, err := c.Repository.AddDeploymentGroup(model.DeploymentGroup{
+		Name:            name,
+		Labels:          labels,
+		Infrastructures: make([]string, 0),
+	})
+	if err != nil {
+		log.WithError(err).Error("Error creating deployment")
+		return group, err
+	}
+	return c.withStatus(group), nil
+}
+
+// FindDeploymentGroup returns a deployment with the current status of its infrastructures
+func (c *Deployer) FindDeploymentGroup(groupID string) (model.DeploymentGroup, error) {
+	group, err := c.Repository.FindDeploymentGroup(groupID)
+	if err != nil {
+		return group, err
+	}
+	return c.withStatus(group), nil
+}
+
+// attachInfrastructure adds an infrastructure to a deployment, removing it from any other deployment it belonged to
+func (c *Deployer) attachInfrastructure(groupID, infraID string) error {
+	c.groupsLock.Lock()
+	defer c.groupsLock.Unlock()
+
+	err := c.detach(infraID, groupID)
+	if err != nil {
+		return err
+	}
+
+	group, err := c.Repository.FindDeploymentGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	group.AddInfrastructure(infraID)
+	_, err = c.Repository.UpdateDeploymentGroup(group)
+	return err
+}
+
+// detachInfrastructure removes an infrastructure from all the deployments it belongs to
+func (c *Deployer) detachInfrastructure(infraID string) error {
+	c.groupsLock.Lock()
+	defer c.groupsLock.Unlock()
+	return c.detach(infraID, "")
+}
+
+// detach removes an infrastructure from the deployments it belongs to, except the one passed as parameter. It must be called with the groups lock held.
+func (c *Deployer) detach(infraID, exceptGroupID string) error {
+	groups, err := c.Repository.FindDeploymentGroupsByInfrastructure(infraID)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if group.ID == exceptGroupID {
+			continue
+		}
+		group.RemoveInfrastructure(infraID)
+		_, err = c.Repository.UpdateDeploymentGroup(group)
+		if err != nil {
+			return fmt.Errorf("Error detaching infrastructure %s from deployment %s: %w", infraID, group.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// MoveInfrastructures attaches infrastructures to a deployment and detaches others from it. Attached infrastructures are moved from the deployments they belonged to and detached ones are kept without deployment.
+func (c *Deployer) MoveInfrastructures(groupID string, move model.DeploymentGroupMove) (model.DeploymentGroup, error) {
+	logger := log.WithField("deployment", groupID)
+
+	group, err := c.Repository.FindDeploymentGroup(groupID)
+	if err != nil {
+		logger.WithError(err).Error("Deployment not found")
+		return group, err
+	}
+
+	for _, infraID := range move.Attach {
+		if _, err := c.Repository.FindInfrastructure(infraID); err != nil {
+			return group, fmt.Errorf("Can't find infrastructure %s to attach to deployment %s", infraID, groupID)
+		}
+	}
+
+	for _, infraID := range move.Detach {
+		if !group.HasInfrastructure(infraID) {
+			return group, fmt.Errorf("Infrastructure %s to detach doesn't belong to deployment %s", infraID, groupID)
+		}
+	}
+
+	for _, infraID := range move.Detach {
+		err = c.detachInfrastructure(infraID)
+		if err != nil {
+			logger.WithError(err).Errorf("Error detaching infrastructure %s", infraID)
+			return group, err
+		}
+	}
+
+	for _, infraID := range move.Attach {
+		err = c.attachInfrastructure(groupID, infraID)
+		if err != nil {
+			logger.WithError(err).Errorf("Error attaching infrastructure %s", infraID)
+			return group, err
+		}
+	}
+
+	return c.FindDeploymentGroup(groupID)
+}
+
+// DeleteDeploymentGroup deletes all the infrastructures of a deployment and then the deployment itself. If some infrastructure can't be deleted, the deployment is kept with the remaining ones.
+func (c *Deployer) DeleteDeploymentGroup(ctx context.Context, principal, groupID string) (model.DeploymentGroup, error) {
+	logger := log.WithField("deployment", groupID)
+
+	group, err := c.Repository.FindDeploymentGroup(groupID)
+	if err != nil {
+		logger.WithError(err).Error("Deployment not found")
+		return group, err
+	}
+
+	if len(group.Infrastructures) > 0 {
+		err = c.DeleteDeployment(ctx, principal, group.Infrastructures)
+		if err != nil {
+			logger.WithError(err).Error("Error deleting infrastructures of deployment")
+			remaining, findErr := c.FindDeploymentGroup(groupID)
+			if findErr != nil {
+				return group, err
+			}
+			return remaining, err
+		}
+	}
+
+	group, err = c.Repository.DeleteDeploymentGroup(groupID)
+	if err != nil {
+		logger.WithError(err).Error("Error deleting deployment")
+	}
+	return group, err
+}
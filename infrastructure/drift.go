@@ -0,0 +1,189 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RunningStatus  = "running"
+	DegradedStatus = "degraded"
+
+	DriftCheckIntervalProperty = "drift.check_interval"
+
+	DriftCheckIntervalDefaultValue = "0s"
+
+	// DriftCheckInstanceProperty is the name of the instance of the engine which runs the drift checks, so that the instances sharing a repository don't check the same infrastructures
+	DriftCheckInstanceProperty = "drift.instance"
+
+	DriftCheckInstanceDefaultValue = "default"
+)
+
+// RefreshInfrastructure checks the nodes of an infrastructure against its provider and records their real state. The infrastructure is marked as degraded if any node is missing, stopped, unreachable or has drifted from the recorded state.
+func (c *Deployer) RefreshInfrastructure(ctx context.Context, principal, infraID string) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infraID)
+
+	infra, err := c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		logger.WithError(err).Error("Infrastructure not found")
+		return infra, err
+	}
+
+	if infra.Status == ScalingStatus {
+		return infra, fmt.Errorf("Infrastructure %s can't be refreshed while it's being scaled", infraID)
+	}
+
+	deployer, err := c.findProvider(principal, infraID, infra.Provider)
+	if err != nil {
+		logger.WithError(err).Error("Can't find provider for infrastructure")
+		return infra, err
+	}
+
+	statuses, err := deployer.CheckNodes(ctx, infra)
+	if err != nil {
+		logger.WithError(err).Error("Error checking nodes of infrastructure")
+		return infra, fmt.Errorf("Error checking nodes of infrastructure %s: %w", infraID, err)
+	}
+
+	// The check can take a while so the infrastructure is read again to avoid overwriting changes made in the meantime
+	infra, err = c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		logger.WithError(err).Error("Infrastructure not found after checking its nodes")
+		return infra, err
+	}
+
+	if infra.Status == ScalingStatus {
+		return infra, fmt.Errorf("Infrastructure %s started scaling while it was being refreshed", infraID)
+	}
+
+	previousStatus := infra.Status
+	healthy := true
+	for hostname, status := range statuses {
+		node, found := infra.FindNode(hostname)
+		if !found {
+			continue
+		}
+		if !status.IsHealthy() {
+			healthy = false
+			logger.Warnf("Node %s is %s: %v", hostname, status.Status, status.Problems)
+		}
+		node.Status = status.Status
+		node.Problems = status.Problems
+		infra.UpdateNode(node)
+	}
+
+	if !healthy && (infra.Status == RunningStatus || infra.Status == "") {
+		infra.Status = DegradedStatus
+	}
+
+	if healthy && infra.Status == DegradedStatus {
+		infra.Status = RunningStatus
+	}
+
+	now := time.Now()
+	infra.RefreshTime = &now
+
+	infra, err = c.Repository.UpdateInfrastructure(infra)
+	if err != nil {
+		logger.WithError(err).Error("Error saving refreshed infrastructure")
+		return infra, err
+	}
+
+	c.recordStatus(principal, previousStatus, infra)
+
+	return infra, nil
+}
+
+// RefreshAll refreshes every infrastructure which is running or degraded. Infrastructures being created, scaled or deleted are skipped, as well as the ones whose credentials were passed inline, since they aren't saved. It stops when the context is cancelled.
+func (c *Deployer) RefreshAll(ctx context.Context) {
+	infras, err := c.Repository.ListInfrastructures()
+	if err != nil {
+		log.WithError(err).Error("Error listing infrastructures to refresh")
+		return
+	}
+
+	for _, infra := range infras {
+		if ctx.Err() != nil {
+			log.WithError(ctx.Err()).Info("Refresh of infrastructures cancelled")
+			return
+		}
+		if infra.Status != RunningStatus && infra.Status != DegradedStatus && infra.Status != "" {
+			continue
+		}
+		if registration, ok := GetProvider(infra.Provider.APIType); ok && !registration.WithoutCredentials && infra.Provider.SecretID == "" {
+			log.Debugf("Skipping refresh of infrastructure %s without secret", infra.ID)
+			continue
+		}
+		_, err := c.RefreshInfrastructure(ctx, model.SystemPrincipal, infra.ID)
+		if err != nil {
+			log.WithError(err).Errorf("Error refreshing infrastructure %s", infra.ID)
+		}
+	}
+}
+
+// DriftChecker refreshes periodically all the infrastructures of a deployer
+type DriftChecker struct {
+	Deployer *Deployer
+	Interval time.Duration
+	cancel   context.CancelFunc
+}
+
+// NewDriftChecker creates a drift checker which will refresh the infrastructures of the deployer every interval. A zero interval disables the checks.
+func NewDriftChecker(deployer *Deployer, interval time.Duration) *DriftChecker {
+	return &DriftChecker{
+		Deployer: deployer,
+		Interval: interval,
+	}
+}
+
+// Start launches the periodic checks in background
+func (d *DriftChecker) Start() {
+	if d.Interval <= 0 {
+		log.Info("Drift checks are disabled")
+		return
+	}
+
+	var ctx context.Context
+	ctx, d.cancel = context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Deployer.RefreshAll(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop cancels the periodic checks, interrupting the one in progress if any
+func (d *DriftChecker) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+	}
+}
@@ -0,0 +1,296 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package edge
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DeploymentType = "edge"
+
+	DefaultUsername = "root"
+)
+
+// EdgeDeployer registers machines that already exist. It checks that they can be accessed by SSH and records their hardware facts, but it never creates or deletes anything in the hosts.
+type EdgeDeployer struct {
+	inspector HostInspector
+}
+
+type nodeFactsResult struct {
+	Node  model.NodeInfo
+	Error error
+}
+
+func NewEdgeDeployer() *EdgeDeployer {
+	return NewEdgeDeployerWithInspector(SSHInspector{})
+}
+
+// NewEdgeDeployerWithInspector creates an edge deployer which accesses the hosts with the inspector passed as parameter
+func NewEdgeDeployerWithInspector(inspector HostInspector) *EdgeDeployer {
+	return &EdgeDeployer{
+		inspector: inspector,
+	}
+}
+
+// ValidateInfrastructure checks that every resource of an edge infrastructure has an unique name and a valid IP
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if len(infra.Resources) == 0 {
+		return errors.New("At least one resource is needed")
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if resource.Name == "" {
+			return errors.New("Resource with empty name found")
+		}
+
+		if names[resource.Name] {
+			return fmt.Errorf("Name of resource %s is not unique", resource.Name)
+		}
+		names[resource.Name] = true
+
+		if net.ParseIP(resource.IP) == nil {
+			return fmt.Errorf("A valid IP is needed for resource %s but found '%s'", resource.Name, resource.IP)
+		}
+	}
+
+	return nil
+}
+
+func (d EdgeDeployer) toNode(resource model.ResourceType) model.NodeInfo {
+	username := resource.Username
+	if username == "" {
+		username = DefaultUsername
+	}
+
+	return model.NodeInfo{
+		Hostname:        resource.Name,
+		Role:            resource.Role,
+		IP:              resource.IP,
+		Username:        username,
+		ResourceName:    resource.Name,
+		ExtraProperties: resource.ExtraProperties,
+	}
+}
+
+func (d EdgeDeployer) gatherNodeFacts(ctx context.Context, node model.NodeInfo, c chan nodeFactsResult) {
+	facts, err := d.inspector.GatherFacts(ctx, node)
+	if err == nil {
+		facts.Apply(&node)
+	}
+	c <- nodeFactsResult{
+		Node:  node,
+		Error: err,
+	}
+}
+
+// registerNodes waits for the hosts of the resources to be accessible and adds them to the infrastructure with their facts
+func (d EdgeDeployer) registerNodes(ctx context.Context, logger *log.Entry, infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) error {
+	pending := model.InfrastructureDeploymentInfo{
+		ID:   infra.ID,
+		Name: infra.Name,
+	}
+	for _, resource := range resources {
+		pending.AddNode(d.toNode(resource))
+	}
+
+	logger.Info("Waiting for hosts to be accessible by SSH")
+	err := d.inspector.WaitForSSHReady(ctx, pending)
+	if err != nil {
+		logger.WithError(err).Error("Error accessing hosts")
+		return err
+	}
+
+	logger.Info("Gathering facts of hosts")
+	c := make(chan nodeFactsResult, len(resources))
+	pending.ForEachNode(func(node model.NodeInfo) {
+		go d.gatherNodeFacts(ctx, node, c)
+	})
+
+	var factsErr error
+	for remaining := len(resources); remaining > 0; remaining-- {
+		result := <-c
+		if result.Error != nil {
+			logger.WithError(result.Error).Errorf("Error gathering facts of host %s", result.Node.Hostname)
+			factsErr = fmt.Errorf("Error gathering facts of host %s: %w", result.Node.Hostname, result.Error)
+			continue
+		}
+		infra.AddNode(result.Node)
+	}
+
+	return factsErr
+}
+
+func (d EdgeDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+	deployment := model.InfrastructureDeploymentInfo{
+		ID:              infra.InfrastructureID(),
+		Name:            infra.Name,
+		Type:            DeploymentType,
+		Products:        make(map[string]interface{}),
+		ExtraProperties: infra.ExtraProperties,
+		Nodes:           make(map[string][]model.NodeInfo),
+		Status:          "creating",
+	}
+
+	logger := log.WithField("infrastructure", deployment.ID)
+
+	err := ValidateInfrastructure(infra)
+	if err != nil {
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	err = d.registerNodes(ctx, logger, &deployment, infra.Resources)
+	if err != nil {
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	logger.Info("Edge infrastructure registered")
+	deployment.Status = "running"
+	return deployment, nil
+}
+
+// DeleteInfrastructure doesn't do anything since the hosts of an edge infrastructure were not created by the deployment engine. Its record is removed by the caller.
+func (d EdgeDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	return nil
+}
+
+// AddNodes registers new existing hosts in the infrastructure
+func (d EdgeDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	for _, resource := range resources {
+		if _, found := infra.FindNode(resource.Name); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", resource.Name, infra.ID)
+		}
+	}
+
+	err := ValidateInfrastructure(model.InfrastructureType{
+		Name:      infra.Name,
+		Resources: resources,
+	})
+	if err != nil {
+		return infra, err
+	}
+
+	logger := log.WithField("infrastructure", infra.ID)
+	err = d.registerNodes(ctx, logger, &infra, resources)
+	return infra, err
+}
+
+// RemoveNodes removes the records of the hosts from the infrastructure. The hosts are not modified.
+func (d EdgeDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	result := make(map[string]error)
+	for _, hostname := range hostnames {
+		if !infra.RemoveNode(hostname) {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+		}
+	}
+	return infra, result
+}
+
+// checkNode gathers the facts of a host and compares them with the ones recorded
+func (d EdgeDeployer) checkNode(ctx context.Context, node model.NodeInfo) model.NodeStatus {
+	facts, err := d.inspector.GatherFacts(ctx, node)
+	if err != nil {
+		return model.NodeStatus{
+			Status:   model.NodeStatusUnreachable,
+			Problems: []string{err.Error()},
+		}
+	}
+
+	status := model.NodeStatus{
+		Status: model.NodeStatusRunning,
+	}
+
+	if facts.Cores != node.Cores {
+		status.Problems = append(status.Problems, fmt.Sprintf("Expected %d cores but found %d", node.Cores, facts.Cores))
+	}
+
+	if facts.RAM != node.RAM {
+		status.Problems = append(status.Problems, fmt.Sprintf("Expected %d bytes of RAM but found %d", node.RAM, facts.RAM))
+	}
+
+	drives := make(map[string]int64)
+	for _, drive := range facts.DataDrives {
+		drives[drive.Name] = drive.Size
+	}
+
+	for _, drive := range node.DataDrives {
+		size, found := drives[drive.Name]
+		if !found {
+			status.Problems = append(status.Problems, fmt.Sprintf("Data drive %s not found", drive.Name))
+		} else if size != drive.Size {
+			status.Problems = append(status.Problems, fmt.Sprintf("Expected size %d for data drive %s but found %d", drive.Size, drive.Name, size))
+		}
+	}
+
+	if len(status.Problems) > 0 {
+		status.Status = model.NodeStatusDrifted
+	}
+
+	return status
+}
+
+// CheckNodes verifies that the hosts are still accessible and that their facts haven't changed
+func (d EdgeDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		result[node.Hostname] = d.checkNode(ctx, node)
+	})
+	return result, nil
+}
+
+// PlanInfrastructure checks that the hosts can be accessed and returns their facts. Hosts are not waited for, so the ones that are not accessible yet are reported as errors.
+func (d EdgeDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	err := ValidateInfrastructure(infra)
+	if err != nil {
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	for _, resource := range infra.Resources {
+		node := d.toNode(resource)
+		facts, err := d.inspector.GatherFacts(ctx, node)
+		if err != nil {
+			plan.AddError("Can't access host %s: %s", node.Hostname, err.Error())
+		}
+
+		plan.Nodes = append(plan.Nodes, model.NodePlan{
+			Hostname: node.Hostname,
+			Role:     node.Role,
+			IP:       node.IP,
+			CPU:      facts.CPU,
+			Cores:    facts.Cores,
+			RAM:      facts.RAM,
+		})
+	}
+
+	return plan, nil
+}
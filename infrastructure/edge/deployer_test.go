@@ -0,0 +1,206 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package edge
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"testing"
+)
+
+const factsOutput = `cores=4
+cpu=2400
+ram=8589934592
+disk=53687091200
+drive=sdb:107374182400
+drive=sdc:214748364800
+`
+
+// fakeInspector returns the facts of factsOutput for every host except the unreachable ones
+type fakeInspector struct {
+	unreachable map[string]bool
+	inspected   []string
+}
+
+func (i *fakeInspector) WaitForSSHReady(ctx context.Context, infra model.InfrastructureDeploymentInfo) error {
+	var err error
+	infra.ForEachNode(func(node model.NodeInfo) {
+		if i.unreachable[node.IP] {
+			err = errors.New("Timeout connecting to host")
+		}
+	})
+	return err
+}
+
+func (i *fakeInspector) GatherFacts(ctx context.Context, node model.NodeInfo) (HostFacts, error) {
+	if i.unreachable[node.IP] {
+		return HostFacts{}, errors.New("Connection refused")
+	}
+	return ParseFacts(factsOutput)
+}
+
+func TestParseFacts(t *testing.T) {
+	facts, err := ParseFacts(factsOutput)
+	if err != nil {
+		t.Fatalf("Error parsing facts: %s", err.Error())
+	}
+
+	if facts.Cores != 4 || facts.CPU != 2400 || facts.RAM != 8589934592 || facts.DiskSize != 53687091200 {
+		t.Fatalf("Unexpected facts parsed: %v", facts)
+	}
+
+	if len(facts.DataDrives) != 2 || facts.DataDrives[0].Name != "sdb" || facts.DataDrives[1].Size != 214748364800 {
+		t.Fatalf("Unexpected data drives parsed: %v", facts.DataDrives)
+	}
+
+	facts, err = ParseFacts("cores=2\ncpu=\nram=1024\ndisk=2048\n")
+	if err != nil {
+		t.Fatalf("Error parsing facts without CPU speed: %s", err.Error())
+	}
+
+	if _, err = ParseFacts("cores=2\n"); err == nil {
+		t.Fatal("Parsed incomplete facts without error")
+	}
+
+	if _, err = ParseFacts("cores=two\nram=1024\ndisk=2048\n"); err == nil {
+		t.Fatal("Parsed invalid number of cores without error")
+	}
+}
+
+func TestDeployInfrastructure(t *testing.T) {
+	inspector := &fakeInspector{
+		unreachable: map[string]bool{
+			"10.0.0.3": true,
+		},
+	}
+	deployer := NewEdgeDeployerWithInspector(inspector)
+
+	infra := model.InfrastructureType{
+		Name: "edge",
+		Type: DeploymentType,
+		Resources: []model.ResourceType{
+			model.ResourceType{Name: "master", Role: "master", IP: "10.0.0.1"},
+			model.ResourceType{Name: "slave", Role: "slave", IP: "10.0.0.2", Username: "ubuntu"},
+		},
+	}
+
+	result, err := deployer.DeployInfrastructure(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error deploying edge infrastructure: %s", err.Error())
+	}
+
+	if result.Status != "running" || result.NumNodes() != 2 {
+		t.Fatalf("Unexpected edge infrastructure: %v", result)
+	}
+
+	master, _ := result.FindNode("master")
+	if master.Username != DefaultUsername || master.Cores != 4 || master.RAM != 8589934592 || len(master.DataDrives) != 2 {
+		t.Fatalf("Unexpected master node: %v", master)
+	}
+
+	slave, _ := result.FindNode("slave")
+	if slave.Username != "ubuntu" || slave.IP != "10.0.0.2" {
+		t.Fatalf("Unexpected slave node: %v", slave)
+	}
+
+	if errs := deployer.DeleteInfrastructure(context.Background(), result); len(errs) > 0 {
+		t.Fatalf("Unexpected errors deleting edge infrastructure: %v", errs)
+	}
+
+	result, err = deployer.AddNodes(context.Background(), result, []model.ResourceType{
+		model.ResourceType{Name: "unreachable", Role: "slave", IP: "10.0.0.3"},
+	})
+	if err == nil {
+		t.Fatal("Added unreachable node without error")
+	}
+
+	if _, found := result.FindNode("unreachable"); found {
+		t.Fatal("Unreachable node added to infrastructure")
+	}
+
+	infra.Resources[1].IP = "invalid"
+	if _, err := deployer.DeployInfrastructure(context.Background(), infra); err == nil {
+		t.Fatal("Deployed edge infrastructure with invalid IP")
+	}
+}
+
+func TestPlanInfrastructure(t *testing.T) {
+	deployer := NewEdgeDeployerWithInspector(&fakeInspector{
+		unreachable: map[string]bool{
+			"10.0.0.2": true,
+		},
+	})
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), model.InfrastructureType{
+		Name: "edge",
+		Resources: []model.ResourceType{
+			model.ResourceType{Name: "master", Role: "master", IP: "10.0.0.1"},
+			model.ResourceType{Name: "slave", Role: "slave", IP: "10.0.0.2"},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Error planning edge infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) != 1 || len(plan.Nodes) != 2 || plan.Nodes[0].Cores != 4 {
+		t.Fatalf("Unexpected edge infrastructure plan: %v", plan)
+	}
+}
+
+func TestCheckNodes(t *testing.T) {
+	inspector := &fakeInspector{
+		unreachable: map[string]bool{},
+	}
+	deployer := NewEdgeDeployerWithInspector(inspector)
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), model.InfrastructureType{
+		Name: "edge",
+		Type: DeploymentType,
+		Resources: []model.ResourceType{
+			model.ResourceType{Name: "master", Role: "master", IP: "10.0.0.1"},
+			model.ResourceType{Name: "slave", Role: "slave", IP: "10.0.0.2"},
+			model.ResourceType{Name: "other", Role: "slave", IP: "10.0.0.3"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error deploying edge infrastructure: %s", err.Error())
+	}
+
+	slave, _ := infra.FindNode("slave")
+	slave.Cores = 8
+	infra.UpdateNode(slave)
+	inspector.unreachable["10.0.0.3"] = true
+
+	result, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	if result["master"].Status != model.NodeStatusRunning {
+		t.Fatalf("Unexpected status of master node: %v", result["master"])
+	}
+
+	if result["slave"].Status != model.NodeStatusDrifted || len(result["slave"].Problems) != 1 {
+		t.Fatalf("Unexpected status of drifted node: %v", result["slave"])
+	}
+
+	if result["other"].Status != model.NodeStatusUnreachable {
+		t.Fatalf("Unexpected status of unreachable node: %v", result["other"])
+	}
+}
@@ -0,0 +1,139 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package edge
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshTimeout is the maximum time to wait for the hosts to be accessible by SSH
+const sshTimeout = 60 * time.Second
+
+// factsScript prints the hardware facts of a Linux host as key=value lines. Every disk apart from the one holding the root filesystem is reported as a data drive.
+const factsScript = `echo cores=$(nproc)
+echo cpu=$(awk -F: '/cpu MHz/ {printf "%d", $2; exit}' /proc/cpuinfo)
+echo ram=$(awk '/MemTotal/ {printf "%d", $2 * 1024}' /proc/meminfo)
+echo disk=$(df -B1 --output=size / | tail -n 1)
+root=$(lsblk -n -o PKNAME "$(findmnt -n -o SOURCE /)" 2>/dev/null)
+lsblk -b -d -n -o NAME,SIZE,TYPE | awk -v root="$root" '$3 == "disk" && $1 != root {print "drive=" $1 ":" $2}'
+`
+
+// HostFacts are the hardware characteristics of an existing host
+type HostFacts struct {
+	// CPU speed in Mhz
+	CPU int
+	// Number of cores
+	Cores int
+	// RAM in bytes
+	RAM int64
+	// Size of the root filesystem in bytes
+	DiskSize int64
+	// Disks not holding the root filesystem
+	DataDrives []model.DriveInfo
+}
+
+// Apply fills the information of the node with the facts
+func (f HostFacts) Apply(node *model.NodeInfo) {
+	node.CPU = f.CPU
+	node.Cores = f.Cores
+	node.RAM = f.RAM
+	node.DriveSize = f.DiskSize
+	node.DataDrives = f.DataDrives
+}
+
+// HostInspector checks the access to existing hosts and gathers their facts
+type HostInspector interface {
+	WaitForSSHReady(ctx context.Context, infra model.InfrastructureDeploymentInfo) error
+	GatherFacts(ctx context.Context, node model.NodeInfo) (HostFacts, error)
+}
+
+// SSHInspector accesses the hosts by SSH using the private key of the deployment engine
+type SSHInspector struct{}
+
+// WaitForSSHReady waits for the hosts to be accessible. They are only connected to, without modifying them or the known hosts.
+func (i SSHInspector) WaitForSSHReady(ctx context.Context, infra model.InfrastructureDeploymentInfo) error {
+	return utils.WaitForSSHAccess(ctx, infra, sshTimeout)
+}
+
+// GatherFacts runs the facts script in the host and parses its output
+func (i SSHInspector) GatherFacts(ctx context.Context, node model.NodeInfo) (HostFacts, error) {
+	output, err := utils.ExecuteSSHCommand(ctx, node, factsScript)
+	if err != nil {
+		return HostFacts{}, err
+	}
+	return ParseFacts(output)
+}
+
+// ParseFacts reads the output of the facts script
+func ParseFacts(output string) (HostFacts, error) {
+	var facts HostFacts
+	var err error
+	found := make(map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key, value := parts[0], strings.TrimSpace(parts[1])
+		switch key {
+		case "cores":
+			facts.Cores, err = strconv.Atoi(value)
+		case "cpu":
+			// Not all architectures report the CPU speed
+			if value != "" {
+				facts.CPU, err = strconv.Atoi(value)
+			}
+		case "ram":
+			facts.RAM, err = strconv.ParseInt(value, 10, 64)
+		case "disk":
+			facts.DiskSize, err = strconv.ParseInt(value, 10, 64)
+		case "drive":
+			drive := strings.SplitN(value, ":", 2)
+			if len(drive) != 2 {
+				return facts, fmt.Errorf("Invalid drive information %s", value)
+			}
+			var size int64
+			size, err = strconv.ParseInt(drive[1], 10, 64)
+			facts.DataDrives = append(facts.DataDrives, model.DriveInfo{
+				Name: drive[0],
+				Size: size,
+			})
+		default:
+			continue
+		}
+
+		if err != nil {
+			return facts, fmt.Errorf("Invalid value for fact %s: %w", key, err)
+		}
+		found[key] = true
+	}
+
+	if !found["cores"] || !found["ram"] || !found["disk"] {
+		return facts, errors.New("Can't find number of cores, RAM or disk size in the facts of the host")
+	}
+
+	return facts, nil
+}
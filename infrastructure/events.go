@@ -0,0 +1,92 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"deployment-engine/model"
+	"deployment-engine/persistence"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recordEvent saves an event in the event repository, if any. Operations never fail because their events can't be saved. An empty principal is recorded as the system one.
+func (c *Deployer) recordEvent(principal, eventType, infraID, target string, cause error) {
+	if c.Events == nil {
+		return
+	}
+
+	if principal == "" {
+		principal = model.SystemPrincipal
+	}
+
+	event := model.Event{
+		Type:             eventType,
+		InfrastructureID: infraID,
+		Target:           target,
+		Principal:        principal,
+	}
+	if cause != nil {
+		event.Message = cause.Error()
+	}
+
+	_, err := c.Events.AddEvent(event)
+	if err != nil {
+		log.WithError(err).Errorf("Error saving event %s of infrastructure %s", eventType, infraID)
+	}
+}
+
+// recordNodes records an event of the type passed as parameter for each node of the infrastructure
+func (c *Deployer) recordNodes(principal, eventType string, infra model.InfrastructureDeploymentInfo) {
+	infra.ForEachNode(func(node model.NodeInfo) {
+		c.recordEvent(principal, eventType, infra.ID, node.Hostname, nil)
+	})
+}
+
+// recordStatus records a status change if the status of the infrastructure is different from the previous one
+func (c *Deployer) recordStatus(principal string, previous string, infra model.InfrastructureDeploymentInfo) {
+	if infra.Status != previous {
+		c.recordEvent(principal, model.EventStatusChanged, infra.ID, infra.Status, nil)
+	}
+}
+
+// auditedVault records an event every time a secret is read on behalf of a principal
+type auditedVault struct {
+	persistence.Vault
+	deployer  *Deployer
+	principal string
+	infraID   string
+}
+
+func (v auditedVault) GetSecret(secretID string) (model.Secret, error) {
+	secret, err := v.Vault.GetSecret(secretID)
+	v.deployer.recordEvent(v.principal, model.EventSecretAccessed, v.infraID, secretID, err)
+	return secret, err
+}
+
+// vaultFor returns the vault to use in an operation of a principal over an infrastructure
+func (c *Deployer) vaultFor(principal, infraID string) persistence.Vault {
+	if c.Vault == nil {
+		return nil
+	}
+	return auditedVault{
+		Vault:     c.Vault,
+		deployer:  c,
+		principal: principal,
+		infraID:   infraID,
+	}
+}
@@ -0,0 +1,92 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrGarbageCollectionNotSupported is returned when the resources of a provider can't be traced back to their infrastructures
+var ErrGarbageCollectionNotSupported = errors.New("The provider doesn't support garbage collection")
+
+// CollectGarbage finds the resources of a provider tagged by the deployment engine whose infrastructure is not in the repository. If remove is true they are deleted.
+func (c *Deployer) CollectGarbage(ctx context.Context, principal string, provider model.CloudProviderInfo, remove bool) (model.GarbageReport, error) {
+	report := model.GarbageReport{
+		Provider: provider.APIType,
+		Orphans:  make([]model.TaggedResource, 0),
+		Deleted:  make([]string, 0),
+	}
+	logger := log.WithField("provider", provider.APIType)
+
+	deployer, err := c.findProvider(principal, "", provider)
+	if err != nil {
+		return report, err
+	}
+
+	collector, ok := deployer.(model.GarbageCollector)
+	if !ok {
+		return report, fmt.Errorf("%w: %s", ErrGarbageCollectionNotSupported, provider.APIType)
+	}
+
+	infras, err := c.Repository.ListInfrastructures()
+	if err != nil {
+		return report, fmt.Errorf("Error listing infrastructures: %w", err)
+	}
+
+	known := make(map[string]bool, len(infras))
+	for _, infra := range infras {
+		known[infra.ID] = true
+	}
+
+	resources, err := collector.TaggedResources(ctx)
+	if err != nil {
+		return report, fmt.Errorf("Error listing tagged resources: %w", err)
+	}
+
+	for _, resource := range resources {
+		if !known[resource.InfrastructureID] {
+			report.Orphans = append(report.Orphans, resource)
+		}
+	}
+	logger.Infof("Found %d orphan resources", len(report.Orphans))
+
+	if !remove || len(report.Orphans) == 0 {
+		return report, nil
+	}
+
+	errs := collector.DeleteResources(ctx, report.Orphans)
+	if len(errs) > 0 {
+		report.Errors = make(map[string]string, len(errs))
+		for id, err := range errs {
+			report.Errors[id] = err.Error()
+		}
+	}
+
+	for _, resource := range report.Orphans {
+		if _, failed := errs[resource.UUID]; !failed {
+			report.Deleted = append(report.Deleted, resource.UUID)
+		}
+	}
+	logger.Infof("Deleted %d orphan resources", len(report.Deleted))
+
+	return report, nil
+}
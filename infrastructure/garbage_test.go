@@ -0,0 +1,163 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const collectingProviderType = "fake-gc"
+
+// collectingDeployer is a fakeDeployer which also reports tagged resources. Deleting the resources marked as undeletable fails.
+// If release is not nil, deployments send the identifier of the infrastructure to started and wait until release is closed.
+type collectingDeployer struct {
+	fakeDeployer
+	resources   []model.TaggedResource
+	undeletable map[string]bool
+	started     chan string
+	release     chan struct{}
+}
+
+var collecting = &collectingDeployer{
+	fakeDeployer: fake,
+	undeletable:  make(map[string]bool),
+}
+
+func (d *collectingDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+	result, err := d.fakeDeployer.DeployInfrastructure(ctx, infra)
+	if d.release != nil {
+		result.ID = infra.ID
+		d.started <- infra.ID
+		<-d.release
+	}
+	return result, err
+}
+
+func (d *collectingDeployer) TaggedResources(ctx context.Context) ([]model.TaggedResource, error) {
+	return d.resources, nil
+}
+
+func (d *collectingDeployer) DeleteResources(ctx context.Context, resources []model.TaggedResource) map[string]error {
+	result := make(map[string]error)
+	for _, resource := range resources {
+		if d.undeletable[resource.UUID] {
+			result[resource.UUID] = fmt.Errorf("Can't delete resource %s", resource.UUID)
+		}
+	}
+	return result
+}
+
+func TestCollectGarbage(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+
+	kept := fakeInfra(secretID, "kept", "master")
+	kept.Provider.APIType = collectingProviderType
+	_, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{kept})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	collecting.resources = []model.TaggedResource{
+		model.TaggedResource{Type: "servers", UUID: "server-kept", InfrastructureID: "id-kept"},
+		model.TaggedResource{Type: "servers", UUID: "server-gone", InfrastructureID: "id-gone"},
+		model.TaggedResource{Type: "drives", UUID: "drive-untagged"},
+	}
+	collecting.undeletable["drive-untagged"] = true
+	defer func() {
+		collecting.resources = nil
+		delete(collecting.undeletable, "drive-untagged")
+	}()
+
+	provider := model.CloudProviderInfo{APIType: collectingProviderType, SecretID: secretID}
+	report, err := deployer.CollectGarbage(context.Background(), "test", provider, false)
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err.Error())
+	}
+
+	if len(report.Orphans) != 2 || report.Orphans[0].UUID != "server-gone" || report.Orphans[1].UUID != "drive-untagged" || len(report.Deleted) != 0 {
+		t.Fatalf("Unexpected garbage report without deletion: %v", report)
+	}
+
+	report, err = deployer.CollectGarbage(context.Background(), "test", provider, true)
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err.Error())
+	}
+
+	if len(report.Deleted) != 1 || report.Deleted[0] != "server-gone" || report.Errors["drive-untagged"] == "" {
+		t.Fatalf("Unexpected garbage report with deletion: %v", report)
+	}
+
+	provider.APIType = fakeProviderType
+	_, err = deployer.CollectGarbage(context.Background(), "test", provider, false)
+	if !errors.Is(err, ErrGarbageCollectionNotSupported) {
+		t.Fatalf("Expected garbage collection not supported but found %v", err)
+	}
+}
+
+func TestCollectGarbageWhileCreating(t *testing.T) {
+	deployer, secretID := newTestDeployer(t)
+
+	collecting.started = make(chan string)
+	collecting.release = make(chan struct{})
+	defer func() {
+		collecting.started = nil
+		collecting.release = nil
+		collecting.resources = nil
+	}()
+
+	infra := fakeInfra(secretID, "creating", "master")
+	infra.Provider.APIType = collectingProviderType
+	done := make(chan error)
+	go func() {
+		_, err := deployer.CreateDeployment(context.Background(), []model.InfrastructureType{infra})
+		done <- err
+	}()
+
+	infraID := <-collecting.started
+	collecting.resources = []model.TaggedResource{
+		model.TaggedResource{Type: "servers", UUID: "server-creating", InfrastructureID: infraID},
+	}
+
+	provider := model.CloudProviderInfo{APIType: collectingProviderType, SecretID: secretID}
+	report, err := deployer.CollectGarbage(context.Background(), "test", provider, true)
+	close(collecting.release)
+	if err != nil {
+		t.Fatalf("Error collecting garbage: %s", err.Error())
+	}
+
+	if len(report.Orphans) != 0 || len(report.Deleted) != 0 {
+		t.Fatalf("Resources of infrastructure being created taken as garbage: %v", report)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Fatalf("Error creating deployment: %s", err.Error())
+	}
+
+	created, err := deployer.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		t.Fatalf("Can't find created infrastructure: %s", err.Error())
+	}
+
+	if created.Status != RunningStatus || created.NumNodes() != 1 || created.CreationTime.IsZero() {
+		t.Fatalf("Unexpected created infrastructure: %v", created)
+	}
+}
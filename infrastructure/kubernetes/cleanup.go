@@ -0,0 +1,225 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package kubernetes
+
+import (
+	provisioner "deployment-engine/provision/kubernetes"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	persistentVolumeClaimKind = "PersistentVolumeClaim"
+	namespaceKind             = "Namespace"
+)
+
+// labelledKind lists and deletes the objects of a kind which can be created by the deployment engine
+type labelledKind struct {
+	name   string
+	list   func(client kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error)
+	delete func(client kubernetes.Interface, namespace, name string, options *metav1.DeleteOptions) error
+}
+
+// labelledKinds are the kinds of objects deleted with an infrastructure, in deletion order: workloads first and namespaces and cluster objects last
+var labelledKinds = []labelledKind{
+	{"Deployment",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.AppsV1().Deployments(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.AppsV1().Deployments(ns).Delete(name, o)
+		}},
+	{"StatefulSet",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.AppsV1().StatefulSets(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.AppsV1().StatefulSets(ns).Delete(name, o)
+		}},
+	{"DaemonSet",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.AppsV1().DaemonSets(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.AppsV1().DaemonSets(ns).Delete(name, o)
+		}},
+	{"Job",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.BatchV1().Jobs(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.BatchV1().Jobs(ns).Delete(name, o)
+		}},
+	{"Service",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Services(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().Services(ns).Delete(name, o)
+		}},
+	{"ConfigMap",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().ConfigMaps(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().ConfigMaps(ns).Delete(name, o)
+		}},
+	{"Secret",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Secrets(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().Secrets(ns).Delete(name, o)
+		}},
+	{"ServiceAccount",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().ServiceAccounts(ns).Delete(name, o)
+		}},
+	{"RoleBinding",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.RbacV1().RoleBindings(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.RbacV1().RoleBindings(ns).Delete(name, o)
+		}},
+	{"Role",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.RbacV1().Roles(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.RbacV1().Roles(ns).Delete(name, o)
+		}},
+	{persistentVolumeClaimKind,
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().PersistentVolumeClaims(ns).Delete(name, o)
+		}},
+	{namespaceKind,
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Namespaces().List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.CoreV1().Namespaces().Delete(name, o)
+		}},
+	{"ClusterRoleBinding",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.RbacV1().ClusterRoleBindings().List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.RbacV1().ClusterRoleBindings().Delete(name, o)
+		}},
+	{"ClusterRole",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.RbacV1().ClusterRoles().List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.RbacV1().ClusterRoles().Delete(name, o)
+		}},
+	{"StorageClass",
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.StorageV1().StorageClasses().List(o)
+		},
+		func(c kubernetes.Interface, ns, name string, o *metav1.DeleteOptions) error {
+			return c.StorageV1().StorageClasses().Delete(name, o)
+		}},
+}
+
+func objectKey(kind string, object metav1.Object) string {
+	if object.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", kind, object.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", kind, object.GetNamespace(), object.GetName())
+}
+
+// hasVolumeClaims returns true if there are persistent volume claims in the namespace, whether they were created by the deployment engine or not
+func hasVolumeClaims(client kubernetes.Interface, namespace string) (bool, error) {
+	claims, err := client.CoreV1().PersistentVolumeClaims(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	return len(claims.Items) > 0, nil
+}
+
+// deleteLabelledObjects deletes the objects of the cluster labelled with the identifier of the infrastructure. If keepClaims is true the persistent volume claims and the namespaces that contain them are kept.
+// It returns the errors found indexed by kind, namespace and name of the objects.
+func deleteLabelledObjects(logger *log.Entry, client kubernetes.Interface, infraID string, keepClaims bool) map[string]error {
+	result := make(map[string]error)
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", provisioner.InfrastructureLabel, infraID),
+	}
+	propagation := metav1.DeletePropagationBackground
+	deleteOptions := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	for _, kind := range labelledKinds {
+		if keepClaims && kind.name == persistentVolumeClaimKind {
+			logger.Info("Keeping persistent volume claims of infrastructure")
+			continue
+		}
+
+		list, err := kind.list(client, listOptions)
+		if err != nil {
+			result[kind.name] = fmt.Errorf("Error listing objects of kind %s: %w", kind.name, err)
+			continue
+		}
+
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			result[kind.name] = fmt.Errorf("Error reading list of objects of kind %s: %w", kind.name, err)
+			continue
+		}
+
+		for _, object := range objects {
+			accessor, err := meta.Accessor(object)
+			if err != nil {
+				result[kind.name] = fmt.Errorf("Error reading metadata of object of kind %s: %w", kind.name, err)
+				continue
+			}
+
+			key := objectKey(kind.name, accessor)
+			if keepClaims && kind.name == namespaceKind {
+				withData, err := hasVolumeClaims(client, accessor.GetName())
+				if err != nil {
+					result[key] = fmt.Errorf("Error checking persistent volume claims of namespace: %w", err)
+					continue
+				}
+				if withData {
+					logger.WithField("namespace", accessor.GetName()).Info("Keeping namespace with persistent volume claims")
+					continue
+				}
+			}
+
+			err = kind.delete(client, accessor.GetNamespace(), accessor.GetName(), deleteOptions)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				result[key] = fmt.Errorf("Error deleting %s: %w", key, err)
+				continue
+			}
+			logger.WithField("object", key).Info("Object deleted")
+		}
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package kubernetes
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// APITimeout is the maximum time to wait for each request to the API of the cluster
+	APITimeout = 10 * time.Second
+
+	// ExternalIPProperty is the extra property of the nodes with their external IP, if they have both an internal and an external one
+	ExternalIPProperty = "external_ip"
+
+	DefaultRole = "slave"
+
+	roleLabelPrefix = "node-role.kubernetes.io/"
+	roleLabel       = "kubernetes.io/role"
+)
+
+// clientFactory creates a client of a cluster from the contents of a kubectl configuration file
+type clientFactory func(config []byte) (kubernetes.Interface, error)
+
+func newClusterClient(config []byte) (kubernetes.Interface, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid kubernetes configuration: %w", err)
+	}
+	restConfig.Timeout = APITimeout
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// callAPI runs a request to the API of the cluster, returning as soon as the context is done. The client doesn't take a context, so an abandoned request goes on in background until it finishes or reaches APITimeout.
+func callAPI(ctx context.Context, request func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- request()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connect creates a client of the cluster and checks that its API is reachable
+func (d KubernetesDeployer) connect(ctx context.Context, config []byte) (kubernetes.Interface, error) {
+	client, err := d.newClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = callAPI(ctx, func() error {
+		_, err := client.Discovery().ServerVersion()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Can't connect to the kubernetes API: %w", err)
+	}
+	return client, nil
+}
+
+func nodeRole(node corev1.Node) string {
+	if _, ok := node.Labels[roleLabelPrefix+"master"]; ok {
+		return "master"
+	}
+
+	if _, ok := node.Labels[roleLabelPrefix+"control-plane"]; ok {
+		return "master"
+	}
+
+	if role, ok := node.Labels[roleLabel]; ok && role != "" {
+		return strings.ToLower(role)
+	}
+
+	roles := make([]string, 0)
+	for label := range node.Labels {
+		if strings.HasPrefix(label, roleLabelPrefix) && label != roleLabelPrefix {
+			roles = append(roles, strings.TrimPrefix(label, roleLabelPrefix))
+		}
+	}
+
+	if len(roles) == 0 {
+		return DefaultRole
+	}
+	sort.Strings(roles)
+	return strings.ToLower(roles[0])
+}
+
+func nodeAddress(node corev1.Node, addressType corev1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// toNodeInfo builds the information of a node from its state in the cluster
+func toNodeInfo(node corev1.Node) model.NodeInfo {
+	result := model.NodeInfo{
+		Hostname:        node.Name,
+		Role:            nodeRole(node),
+		UUID:            string(node.UID),
+		IP:              nodeAddress(node, corev1.NodeInternalIP),
+		KubeletVersion:  node.Status.NodeInfo.KubeletVersion,
+		ExtraProperties: make(model.ExtraPropertiesType),
+	}
+
+	externalIP := nodeAddress(node, corev1.NodeExternalIP)
+	if result.IP == "" {
+		result.IP = externalIP
+	} else if externalIP != "" {
+		result.ExtraProperties[ExternalIPProperty] = externalIP
+	}
+
+	if cpu, ok := node.Status.Capacity[corev1.ResourceCPU]; ok {
+		result.Cores = int(cpu.Value())
+	}
+
+	if memory, ok := node.Status.Capacity[corev1.ResourceMemory]; ok {
+		result.RAM = memory.Value()
+	}
+
+	if storage, ok := node.Status.Capacity[corev1.ResourceEphemeralStorage]; ok {
+		result.DriveSize = storage.Value()
+	}
+
+	return result
+}
+
+// discoverNodes returns the nodes of the cluster
+func discoverNodes(ctx context.Context, client kubernetes.Interface) ([]model.NodeInfo, error) {
+	var nodes *corev1.NodeList
+	err := callAPI(ctx, func() error {
+		var err error
+		nodes, err = client.CoreV1().Nodes().List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing nodes of the cluster: %w", err)
+	}
+
+	result := make([]model.NodeInfo, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		result = append(result, toNodeInfo(node))
+	}
+	return result, nil
+}
+
+// usedNodePorts returns the sorted list of node ports used by the services of every namespace of the cluster
+func usedNodePorts(ctx context.Context, client kubernetes.Interface) ([]int, error) {
+	var services *corev1.ServiceList
+	err := callAPI(ctx, func() error {
+		var err error
+		services, err = client.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing services of the cluster: %w", err)
+	}
+
+	ports := make([]int, 0)
+	for _, service := range services.Items {
+		for _, port := range service.Spec.Ports {
+			if port.NodePort != 0 {
+				ports = append(ports, int(port.NodePort))
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// selectNodes returns the nodes of the cluster that correspond to the resources, which must match a node by name or IP. Every node is returned if there aren't resources.
+func selectNodes(nodes []model.NodeInfo, resources []model.ResourceType) ([]model.NodeInfo, error) {
+	if len(resources) == 0 {
+		return nodes, nil
+	}
+
+	result := make([]model.NodeInfo, 0, len(resources))
+	for _, resource := range resources {
+		found := false
+		for _, node := range nodes {
+			if node.Hostname == resource.Name || (resource.IP != "" && node.IP == resource.IP) {
+				node.ResourceName = resource.Name
+				for k, v := range resource.ExtraProperties {
+					node.ExtraProperties[k] = v
+				}
+				result = append(result, node)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Can't find node for resource %s in the cluster", resource.Name)
+		}
+	}
+	return result, nil
+}
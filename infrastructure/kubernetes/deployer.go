@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/persistence"
 	"errors"
@@ -28,63 +29,120 @@ import (
 
 	"deployment-engine/utils"
 
-	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
-const availablePortRangeProperty = "available_ports_range"
+const (
+	DeploymentType = "kubernetes"
+
+	// KeepDataProperty is the extra property of the infrastructure which, if true, keeps the persistent volume claims created by the deployment engine when the infrastructure is deleted
+	KeepDataProperty = "kubernetes_keep_data"
+
+	availablePortRangeProperty = "available_ports_range"
+)
 
 type KubernetesDeployer struct {
 	deploymentsFolder string
 	vault             persistence.Vault
+	newClient         clientFactory
 }
 
 func NewKubernetesDeployer(deploymentsFolder string, vault persistence.Vault) *KubernetesDeployer {
 	return &KubernetesDeployer{
 		deploymentsFolder: deploymentsFolder,
 		vault:             vault,
+		newClient:         newClusterClient,
 	}
 }
 
-func (d KubernetesDeployer) transformNode(node model.ResourceType) model.NodeInfo {
-	result := model.NodeInfo{
-		Hostname:   node.Name,
-		Role:       node.Role,
-		CPU:        node.CPU,
-		Cores:      node.Cores,
-		RAM:        node.RAM,
-		IP:         node.IP,
-		DriveSize:  node.Disk,
-		DataDrives: make([]model.DriveInfo, len(node.Drives)),
-	}
-
-	for i := range node.Drives {
-		result.DataDrives[i] = model.DriveInfo{
-			Name: node.Drives[i].Name,
-			Size: node.Drives[i].Size,
-		}
+func (d KubernetesDeployer) transformNodes(nodes []model.NodeInfo) map[string][]model.NodeInfo {
+	result := make(map[string][]model.NodeInfo)
+	for _, node := range nodes {
+		result[node.Role] = append(result[node.Role], node)
 	}
-
 	return result
 }
 
-func (d KubernetesDeployer) transformNodes(nodes []model.ResourceType) map[string][]model.NodeInfo {
-	result := make(map[string][]model.NodeInfo)
-	for _, node := range nodes {
-		roleNodes, ok := result[node.Role]
-		if !ok {
-			roleNodes = make([]model.NodeInfo, 0, 1)
+// getConfig returns the kubectl configuration from the inline credentials or the vault
+func (d KubernetesDeployer) getConfig(provider model.CloudProviderInfo) (interface{}, error) {
+	if provider.Credentials != nil {
+		config, ok := provider.Credentials["config"]
+		if ok {
+			return config, nil
 		}
-		roleNodes = append(roleNodes, d.transformNode(node))
-		result[node.Role] = roleNodes
 	}
-	return result
+
+	if provider.SecretID == "" {
+		return nil, errors.New("Credentials or secret identifier are needed for a kubernetes provider")
+	}
+
+	secret, err := d.vault.GetSecret(provider.SecretID)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving secret with identifier %s: %w", provider.SecretID, err)
+	}
+	kubeSecret, ok := secret.Content.(model.KubernetesConfigSecret)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected secret type for kubernetes secret identifier %s", provider.SecretID)
+	}
+	return kubeSecret.Config, nil
 }
 
-func (d KubernetesDeployer) DeployInfrastructure(infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+// marshalConfig returns the contents of the kubectl configuration file, which can be passed as a string or as an object
+func marshalConfig(config interface{}) ([]byte, error) {
+	if strConfig, ok := config.(string); ok {
+		return []byte(strConfig), nil
+	}
+	return yaml.Marshal(config)
+}
+
+// clusterNodes connects to the cluster and returns the nodes corresponding to the resources and the node ports used by its services
+func (d KubernetesDeployer) clusterNodes(ctx context.Context, config []byte, resources []model.ResourceType) ([]model.NodeInfo, []int, error) {
+	client, err := d.connect(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nodes, err := discoverNodes(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selected, err := selectNodes(nodes, resources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ports, err := usedNodePorts(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return selected, ports, nil
+}
+
+func parsePortRange(portRangeIn string) (int, int, error) {
+	portRange := strings.Split(portRangeIn, "-")
+	if portRange == nil || len(portRange) != 2 {
+		return 0, 0, fmt.Errorf("Port range must be in the format 'portStart-portEnd' but found %s", portRangeIn)
+	}
+
+	minPort, err := strconv.Atoi(portRange[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid port range start value %s: %w", portRange[0], err)
+	}
+
+	maxPort, err := strconv.Atoi(portRange[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid port range end value %s: %w", portRange[1], err)
+	}
+
+	return minPort, maxPort, nil
+}
+
+func (d KubernetesDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
 	deployment := model.InfrastructureDeploymentInfo{
-		ID:              uuid.New().String(),
+		ID:              infra.InfrastructureID(),
 		Name:            infra.Name,
 		Products:        make(map[string]interface{}),
 		ExtraProperties: infra.ExtraProperties,
@@ -93,36 +151,29 @@ func (d KubernetesDeployer) DeployInfrastructure(infra model.InfrastructureType)
 
 	logger := log.WithField("infrastructure", deployment.ID)
 
-	infraFolder := fmt.Sprintf("%s/%s", d.deploymentsFolder, deployment.ID)
-	err := os.Mkdir(infraFolder, os.ModePerm)
-	if err != nil {
-		return deployment, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error creating infrastructure folder %s", infraFolder), err)
+	if _, err := keepData(infra.ExtraProperties); err != nil {
+		return deployment, err
 	}
 
-	if infra.Provider.Credentials == nil {
-		infra.Provider.Credentials = make(map[string]interface{})
+	config, err := d.getConfig(infra.Provider)
+	if err != nil {
+		return deployment, err
 	}
 
-	config, ok := infra.Provider.Credentials["config"]
-	if !ok {
-		if infra.Provider.SecretID == "" {
-			return deployment, errors.New("Credentials or secret identifier are needed for a kubernetes provider")
-		}
+	strConfig, err := marshalConfig(config)
+	if err != nil {
+		return deployment, utils.WrapLogAndReturnError(logger, "Error marshaling kubernetes configuration file", err)
+	}
 
-		secret, err := d.vault.GetSecret(infra.Provider.SecretID)
-		if err != nil {
-			return deployment, fmt.Errorf("Error retrieving secret with identifier %s: %w", infra.Provider.SecretID, err)
-		}
-		kubeSecret, ok := secret.Content.(model.KubernetesConfigSecret)
-		if !ok {
-			return deployment, fmt.Errorf("Unexpected secret type for kubernetes secret identifier %s", infra.Provider.SecretID)
-		}
-		config = kubeSecret.Config
+	nodes, ports, err := d.clusterNodes(ctx, strConfig, infra.Resources)
+	if err != nil {
+		return deployment, utils.WrapLogAndReturnError(logger, "Error getting nodes of the kubernetes cluster", err)
 	}
 
-	strConfig, err := yaml.Marshal(config)
+	infraFolder := fmt.Sprintf("%s/%s", d.deploymentsFolder, deployment.ID)
+	err = os.Mkdir(infraFolder, os.ModePerm)
 	if err != nil {
-		return deployment, utils.WrapLogAndReturnError(logger, "Error marshaling kubernetes configuration file", err)
+		return deployment, utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error creating infrastructure folder %s", infraFolder), err)
 	}
 
 	configPath := fmt.Sprintf("%s/%s", infraFolder, "config")
@@ -147,22 +198,13 @@ func (d KubernetesDeployer) DeployInfrastructure(infra model.InfrastructureType)
 	}
 
 	kubeConfig["managed"] = false
+	kubeConfig["usedports"] = ports
 
 	portRangeIn, ok := infra.ExtraProperties[availablePortRangeProperty]
 	if ok {
-		portRange := strings.Split(portRangeIn, "-")
-		if portRange == nil || len(portRange) != 2 {
-			return deployment, fmt.Errorf("Port range must be in the format 'portStart-portEnd' but found %s", portRangeIn)
-		}
-
-		minPort, err := strconv.Atoi(portRange[0])
+		minPort, maxPort, err := parsePortRange(portRangeIn)
 		if err != nil {
-			return deployment, fmt.Errorf("Invalid port range start value %s: %w", portRange[0], err)
-		}
-
-		maxPort, err := strconv.Atoi(portRange[1])
-		if err != nil {
-			return deployment, fmt.Errorf("Invalid port range end value %s: %w", portRange[1], err)
+			return deployment, err
 		}
 
 		kubeConfig["portrange"] = struct {
@@ -176,11 +218,160 @@ func (d KubernetesDeployer) DeployInfrastructure(infra model.InfrastructureType)
 
 	deployment.Products["kubernetes"] = kubeConfig
 
-	deployment.Nodes = d.transformNodes(infra.Resources)
+	deployment.Nodes = d.transformNodes(nodes)
 
 	return deployment, nil
 }
 
-func (d KubernetesDeployer) DeleteInfrastructure(infra model.InfrastructureDeploymentInfo) map[string]error {
-	return nil
+// keepData returns the value of the KeepDataProperty of the infrastructure, which is false by default
+func keepData(properties model.ExtraPropertiesType) (bool, error) {
+	value, ok := properties[KeepDataProperty]
+	if !ok {
+		return false, nil
+	}
+
+	keep, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %s for property %s. It must be a boolean: %w", value, KeepDataProperty, err)
+	}
+	return keep, nil
+}
+
+// DeleteInfrastructure deletes the objects created by the deployment engine in the cluster, which are labelled with the identifier of the infrastructure, and the infrastructure folder.
+// The persistent volume claims are kept if the KeepDataProperty of the infrastructure is true. The folder with the kubernetes configuration is kept if some object can't be deleted, so the deletion can be retried.
+func (d KubernetesDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	result := make(map[string]error)
+	logger := log.WithField("infrastructure", infra.ID)
+
+	keep, err := keepData(infra.ExtraProperties)
+	if err != nil {
+		result[infra.ID] = err
+		return result
+	}
+
+	infraFolder := fmt.Sprintf("%s/%s", d.deploymentsFolder, infra.ID)
+	config, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", infraFolder, "config"))
+	if err != nil && !os.IsNotExist(err) {
+		result[infra.ID] = fmt.Errorf("Error reading kubernetes configuration file of infrastructure %s: %w", infra.ID, err)
+		return result
+	}
+
+	if err == nil {
+		client, err := d.connect(ctx, config)
+		if err != nil {
+			result[infra.ID] = err
+			return result
+		}
+
+		result = deleteLabelledObjects(logger, client, infra.ID, keep)
+		if len(result) > 0 {
+			logger.WithField("errors", result).Error("Error deleting objects of infrastructure")
+			return result
+		}
+	} else {
+		logger.Warn("Kubernetes configuration file not found. Objects of the infrastructure in the cluster won't be deleted")
+	}
+
+	err = os.RemoveAll(infraFolder)
+	if err != nil {
+		result[infra.ID] = fmt.Errorf("Error removing infrastructure folder %s: %w", infraFolder, err)
+	}
+	return result
+}
+
+// PlanInfrastructure checks the kubernetes configuration, the connection to the cluster and the port range. The nodes in the plan are the ones already in the cluster, since nothing is created.
+func (d KubernetesDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	portRangeIn, ok := infra.ExtraProperties[availablePortRangeProperty]
+	if ok {
+		_, _, err := parsePortRange(portRangeIn)
+		if err != nil {
+			plan.AddError("%s", err.Error())
+		}
+	}
+
+	if _, err := keepData(infra.ExtraProperties); err != nil {
+		plan.AddError("%s", err.Error())
+	}
+
+	config, err := d.getConfig(infra.Provider)
+	if err != nil {
+		plan.AddError("Invalid kubernetes configuration: %s", err.Error())
+		return plan, nil
+	}
+
+	strConfig, err := marshalConfig(config)
+	if err != nil {
+		plan.AddError("Invalid kubernetes configuration: %s", err.Error())
+		return plan, nil
+	}
+
+	nodes, _, err := d.clusterNodes(ctx, strConfig, infra.Resources)
+	if err != nil {
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	for _, node := range nodes {
+		plan.Nodes = append(plan.Nodes, model.NodePlan{
+			Hostname: node.Hostname,
+			Role:     node.Role,
+			IP:       node.IP,
+			CPU:      node.CPU,
+			Cores:    node.Cores,
+			RAM:      node.RAM,
+		})
+	}
+
+	return plan, nil
+}
+
+// CheckNodes doesn't check any node since the nodes of the cluster are not managed by the deployment engine
+func (d KubernetesDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	return make(map[string]model.NodeStatus), nil
+}
+
+// AddNodes adds the information of the nodes of the cluster corresponding to the resources passed as parameter to the infrastructure. The nodes must be already part of the cluster.
+func (d KubernetesDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	if len(resources) == 0 {
+		return infra, nil
+	}
+
+	configPath := fmt.Sprintf("%s/%s/%s", d.deploymentsFolder, infra.ID, "config")
+	config, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return infra, fmt.Errorf("Error reading kubernetes configuration file of infrastructure %s: %w", infra.ID, err)
+	}
+
+	nodes, _, err := d.clusterNodes(ctx, config, resources)
+	if err != nil {
+		return infra, err
+	}
+
+	for _, node := range nodes {
+		if _, found := infra.FindNode(node.Hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", node.Hostname, infra.ID)
+		}
+	}
+
+	for _, node := range nodes {
+		infra.AddNode(node)
+	}
+	return infra, nil
+}
+
+// RemoveNodes removes the information of the nodes from the infrastructure. The nodes in the cluster are not modified.
+func (d KubernetesDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	result := make(map[string]error)
+	for _, hostname := range hostnames {
+		if !infra.RemoveNode(hostname) {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+		}
+	}
+	return infra, result
 }
@@ -0,0 +1,404 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package kubernetes
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	provisioner "deployment-engine/provision/kubernetes"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testConfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: %s
+  name: test
+contexts:
+- context:
+    cluster: test
+    user: test
+  name: test
+current-context: test
+users:
+- name: test
+  user:
+    token: secret
+`
+
+func testNode(name, ip, version string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			UID:    types.UID("uid-" + name),
+			Labels: labels,
+		},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				corev1.NodeAddress{Type: corev1.NodeHostName, Address: name},
+				corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip},
+			},
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:              resource.MustParse("4"),
+				corev1.ResourceMemory:           resource.MustParse("8Gi"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("100Gi"),
+			},
+			NodeInfo: corev1.NodeSystemInfo{KubeletVersion: version},
+		},
+	}
+}
+
+func testService(namespace, name string, nodePorts ...int32) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+	}
+	for _, port := range nodePorts {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{NodePort: port})
+	}
+	return service
+}
+
+func newTestDeployer(t *testing.T) (KubernetesDeployer, string) {
+	folder, err := ioutil.TempDir("", "kubernetes-test")
+	if err != nil {
+		t.Fatalf("Error creating temporary folder: %s", err.Error())
+	}
+
+	master := testNode("master", "10.0.0.1", "v1.15.3", map[string]string{"node-role.kubernetes.io/master": ""})
+	master.Status.Addresses = append(master.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "54.0.0.1"})
+	client := fake.NewSimpleClientset(
+		master,
+		testNode("worker1", "10.0.0.2", "v1.15.3", map[string]string{"node-role.kubernetes.io/worker": ""}),
+		testNode("worker2", "10.0.0.3", "v1.15.2", nil),
+		testService("default", "web", 30080),
+		testService("monitoring", "grafana", 30300, 30000),
+	)
+
+	return KubernetesDeployer{
+		deploymentsFolder: folder,
+		newClient: func(config []byte) (kubernetes.Interface, error) {
+			if !strings.Contains(string(config), "kind: Config") {
+				return nil, fmt.Errorf("Invalid configuration %s", config)
+			}
+			return client, nil
+		},
+	}, folder
+}
+
+func testInfra(config string, resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name: "cluster",
+		Provider: model.CloudProviderInfo{
+			APIType:     DeploymentType,
+			Credentials: map[string]interface{}{"config": config},
+		},
+		Resources: resources,
+	}
+}
+
+func TestDeployInfrastructure(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, "https://cluster")))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if infra.NumNodes() != 3 || len(infra.Nodes["master"]) != 1 || len(infra.Nodes["worker"]) != 1 || len(infra.Nodes[DefaultRole]) != 1 {
+		t.Fatalf("Unexpected nodes discovered: %v", infra.Nodes)
+	}
+
+	master := infra.Nodes["master"][0]
+	if master.IP != "10.0.0.1" || master.ExtraProperties[ExternalIPProperty] != "54.0.0.1" || master.UUID != "uid-master" {
+		t.Fatalf("Unexpected addresses of master node: %v", master)
+	}
+
+	if master.Cores != 4 || master.RAM != 8*1024*1024*1024 || master.DriveSize != 100*1024*1024*1024 || master.KubeletVersion != "v1.15.3" {
+		t.Fatalf("Unexpected capacity of master node: %v", master)
+	}
+
+	kubeConfig := infra.Products["kubernetes"].(map[string]interface{})
+	if !reflect.DeepEqual(kubeConfig["usedports"], []int{30000, 30080, 30300}) {
+		t.Fatalf("Unexpected used ports: %v", kubeConfig["usedports"])
+	}
+
+	config, err := ioutil.ReadFile(kubeConfig["configurationfile"].(string))
+	if err != nil || string(config) != fmt.Sprintf(testConfig, "https://cluster") {
+		t.Fatalf("Configuration file not saved: %s %v", config, err)
+	}
+
+	selected, err := deployer.DeployInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, "https://cluster"),
+		model.ResourceType{Name: "master"}, model.ResourceType{Name: "worker", IP: "10.0.0.3"}))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure with resources: %s", err.Error())
+	}
+
+	worker, found := selected.FindNode("worker2")
+	if selected.NumNodes() != 2 || !found || worker.ResourceName != "worker" || worker.KubeletVersion != "v1.15.2" {
+		t.Fatalf("Unexpected nodes selected by resources: %v", selected.Nodes)
+	}
+
+	selected, err = deployer.AddNodes(context.Background(), selected, []model.ResourceType{model.ResourceType{Name: "worker1"}})
+	if err != nil || selected.NumNodes() != 3 {
+		t.Fatalf("Error adding nodes: %v %v", err, selected.Nodes)
+	}
+
+	_, err = deployer.AddNodes(context.Background(), selected, []model.ResourceType{model.ResourceType{Name: "master"}})
+	if err == nil {
+		t.Fatal("Node added twice to infrastructure")
+	}
+
+	_, err = deployer.AddNodes(context.Background(), selected, []model.ResourceType{model.ResourceType{Name: "unknown"}})
+	if err == nil {
+		t.Fatal("Node not in the cluster added to infrastructure")
+	}
+}
+
+func TestDeployInfrastructureFailure(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	_, err := deployer.DeployInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, "https://cluster"), model.ResourceType{Name: "unknown"}))
+	if err == nil {
+		t.Fatal("Infrastructure with resource not in the cluster deployed")
+	}
+
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra("invalid"))
+	if err == nil {
+		t.Fatal("Infrastructure with invalid configuration deployed")
+	}
+
+	files, _ := ioutil.ReadDir(folder)
+	if len(files) > 0 {
+		t.Fatalf("Infrastructure folders created for failed infrastructures: %v", files)
+	}
+
+	// Real client against an API which is not reachable
+	server := httptest.NewServer(nil)
+	server.Close()
+	deployer.newClient = newClusterClient
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, server.URL)))
+	if err == nil || !strings.Contains(err.Error(), "Can't connect to the kubernetes API") {
+		t.Fatalf("Expected connection error but found %v", err)
+	}
+
+	_, err = deployer.DeployInfrastructure(context.Background(), testInfra("clusters: ["))
+	if err == nil || !strings.Contains(err.Error(), "Invalid kubernetes configuration") {
+		t.Fatalf("Expected invalid configuration error but found %v", err)
+	}
+
+	plan, _ := deployer.PlanInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, server.URL)))
+	if len(plan.Errors) != 1 {
+		t.Fatalf("Expected connection error in plan but found %v", plan.Errors)
+	}
+}
+
+func TestDeployInfrastructureCancelled(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := deployer.DeployInfrastructure(cancelled, testInfra(fmt.Sprintf(testConfig, "https://cluster")))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation error but found %v", err)
+	}
+
+	// API which doesn't answer before the deadline of the context
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	deployer.newClient = newClusterClient
+	start := time.Now()
+	_, err = deployer.DeployInfrastructure(ctx, testInfra(fmt.Sprintf(testConfig, server.URL)))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) >= APITimeout {
+		t.Fatalf("Expected deadline error before the API timeout but found %v after %s", err, time.Since(start))
+	}
+}
+
+func TestPlanInfrastructure(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(fmt.Sprintf(testConfig, "https://cluster"), model.ResourceType{Name: "master"}))
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) > 0 || len(plan.Nodes) != 1 || plan.Nodes[0].IP != "10.0.0.1" || plan.Nodes[0].Cores != 4 {
+		t.Fatalf("Unexpected plan: %v", plan)
+	}
+}
+
+func labelled(namespace, name, infraID string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      name,
+		Labels:    map[string]string{provisioner.InfrastructureLabel: infraID},
+	}
+}
+
+// deployWithObjects deploys an infrastructure and creates some objects labelled with its identifier, and some of another infrastructure, in the cluster
+func deployWithObjects(t *testing.T, deployer KubernetesDeployer, properties model.ExtraPropertiesType) (model.InfrastructureDeploymentInfo, kubernetes.Interface) {
+	definition := testInfra(fmt.Sprintf(testConfig, "https://cluster"))
+	definition.ExtraProperties = properties
+	infra, err := deployer.DeployInfrastructure(context.Background(), definition)
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	client, _ := deployer.newClient([]byte(testConfig))
+	objects := []func() error{
+		func() error {
+			_, err := client.CoreV1().Namespaces().Create(&corev1.Namespace{ObjectMeta: labelled("", "data", infra.ID)})
+			return err
+		},
+		func() error {
+			_, err := client.AppsV1().Deployments("default").Create(&appsv1.Deployment{ObjectMeta: labelled("default", "web", infra.ID)})
+			return err
+		},
+		func() error {
+			_, err := client.CoreV1().PersistentVolumeClaims("data").Create(&corev1.PersistentVolumeClaim{ObjectMeta: labelled("data", "volume", infra.ID)})
+			return err
+		},
+		func() error {
+			_, err := client.RbacV1().ClusterRoles().Create(&rbacv1.ClusterRole{ObjectMeta: labelled("", "operator", infra.ID)})
+			return err
+		},
+		func() error {
+			_, err := client.AppsV1().Deployments("default").Create(&appsv1.Deployment{ObjectMeta: labelled("default", "other", "other-infra")})
+			return err
+		},
+	}
+	for _, create := range objects {
+		if err := create(); err != nil {
+			t.Fatalf("Error creating object in fake cluster: %s", err.Error())
+		}
+	}
+	return infra, client
+}
+
+func TestDeleteInfrastructure(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	infra, client := deployWithObjects(t, deployer, model.ExtraPropertiesType{KeepDataProperty: "true"})
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	if _, err := client.AppsV1().Deployments("default").Get("web", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("Deployment of infrastructure not deleted: %v", err)
+	}
+
+	if _, err := client.RbacV1().ClusterRoles().Get("operator", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("Cluster role of infrastructure not deleted: %v", err)
+	}
+
+	if _, err := client.AppsV1().Deployments("default").Get("other", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Deployment of other infrastructure deleted: %v", err)
+	}
+
+	if _, err := client.CoreV1().PersistentVolumeClaims("data").Get("volume", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Volume claim deleted although data must be kept: %v", err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get("data", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Namespace with volume claims deleted although data must be kept: %v", err)
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/%s", folder, infra.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Infrastructure folder not removed: %v", err)
+	}
+
+	deployer, folder = newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	infra, client = deployWithObjects(t, deployer, nil)
+	errs = deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	if _, err := client.CoreV1().PersistentVolumeClaims("data").Get("volume", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("Volume claim of infrastructure not deleted: %v", err)
+	}
+
+	if _, err := client.CoreV1().Namespaces().Get("data", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("Namespace of infrastructure not deleted: %v", err)
+	}
+
+	if errs := deployer.DeleteInfrastructure(context.Background(), infra); len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure already deleted: %v", errs)
+	}
+}
+
+func TestDeleteInfrastructureFailure(t *testing.T) {
+	deployer, folder := newTestDeployer(t)
+	defer os.RemoveAll(folder)
+
+	infra, _ := deployWithObjects(t, deployer, nil)
+
+	infra.ExtraProperties = model.ExtraPropertiesType{KeepDataProperty: "maybe"}
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if errs[infra.ID] == nil {
+		t.Fatalf("Expected error for invalid keep data property but found %v", errs)
+	}
+
+	infra.ExtraProperties = nil
+	deployer.newClient = func(config []byte) (kubernetes.Interface, error) {
+		return nil, fmt.Errorf("Cluster not available")
+	}
+	errs = deployer.DeleteInfrastructure(context.Background(), infra)
+	if errs[infra.ID] == nil {
+		t.Fatalf("Expected connection error but found %v", errs)
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/%s/config", folder, infra.ID)); err != nil {
+		t.Fatalf("Configuration file removed although objects couldn't be deleted: %v", err)
+	}
+}
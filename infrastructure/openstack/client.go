@@ -0,0 +1,312 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+const (
+	ComputeService = "compute"
+	VolumeService  = "volume"
+	NetworkService = "network"
+	ImageService   = "image"
+
+	DefaultDomain = "Default"
+
+	tokenHeader = "X-Auth-Token"
+)
+
+// serviceTypes are the types that each service can have in the Keystone catalog, in order of preference
+var serviceTypes = map[string][]string{
+	ComputeService: []string{"compute"},
+	VolumeService:  []string{"volumev3", "block-storage", "volumev2", "volume"},
+	NetworkService: []string{"network"},
+	ImageService:   []string{"image"},
+}
+
+type OpenStackError struct {
+	Code        int
+	Description string
+}
+
+func (e OpenStackError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Code, e.Description)
+}
+
+// Client accesses the Nova, Cinder, Neutron and Glance APIs with a token obtained from Keystone. The token is requested with the first operation and renewed when it expires.
+type Client struct {
+	httpClient  *resty.Client
+	authURL     string
+	credentials Credentials
+
+	lock      sync.Mutex
+	token     string
+	endpoints map[string]string
+}
+
+// NewClient creates a client which authenticates in the Keystone v3 API found in the URL passed as parameter
+func NewClient(authURL string, credentials Credentials, debug bool) *Client {
+	authURL = strings.TrimSuffix(authURL, "/")
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL = authURL + "/v3"
+	}
+	if credentials.DomainName == "" {
+		credentials.DomainName = DefaultDomain
+	}
+	return &Client{
+		httpClient:  resty.New().SetDebug(debug),
+		authURL:     authURL,
+		credentials: credentials,
+	}
+}
+
+func toError(response *resty.Response) error {
+	return OpenStackError{
+		Code:        response.StatusCode(),
+		Description: response.String(),
+	}
+}
+
+// Authenticate gets a new token from Keystone and the endpoints of the services from its catalog
+func (c *Client) Authenticate(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.authenticate(ctx)
+}
+
+func (c *Client) authenticate(ctx context.Context) error {
+	domain := AuthDomain{Name: c.credentials.DomainName}
+	var result TokenResponse
+	response, err := c.httpClient.R().SetContext(ctx).SetBody(AuthRequest{
+		Auth: Auth{
+			Identity: AuthIdentity{
+				Methods: []string{"password"},
+				Password: AuthPassword{
+					User: AuthUser{
+						Name:     c.credentials.Username,
+						Domain:   domain,
+						Password: c.credentials.Password,
+					},
+				},
+			},
+			Scope: AuthScope{
+				Project: AuthProject{
+					Name:   c.credentials.ProjectName,
+					Domain: domain,
+				},
+			},
+		},
+	}).SetResult(&result).Post(c.authURL + "/auth/tokens")
+
+	if err != nil {
+		return fmt.Errorf("Error authenticating in %s: %w", c.authURL, err)
+	}
+
+	if response.IsError() {
+		return toError(response)
+	}
+
+	token := response.Header().Get("X-Subject-Token")
+	if token == "" {
+		return fmt.Errorf("Keystone at %s didn't return a token", c.authURL)
+	}
+
+	endpoints := make(map[string]string)
+	for service, types := range serviceTypes {
+		if endpoint, ok := c.findEndpoint(result.Token.Catalog, types); ok {
+			endpoints[service] = strings.TrimSuffix(endpoint, "/")
+		}
+	}
+
+	c.token = token
+	c.endpoints = endpoints
+	return nil
+}
+
+func (c *Client) findEndpoint(catalog []CatalogEntry, types []string) (string, bool) {
+	for _, serviceType := range types {
+		for _, entry := range catalog {
+			if entry.Type != serviceType {
+				continue
+			}
+			for _, endpoint := range entry.Endpoints {
+				if endpoint.Interface == "public" && (c.credentials.Region == "" || endpoint.Region == c.credentials.Region) {
+					return endpoint.URL, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// session returns the current token and the endpoint of a service, authenticating if there isn't a token yet
+func (c *Client) session(ctx context.Context, service string) (string, string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token == "" {
+		if err := c.authenticate(ctx); err != nil {
+			return "", "", err
+		}
+	}
+
+	endpoint, ok := c.endpoints[service]
+	if !ok {
+		return "", "", fmt.Errorf("Can't find a public endpoint for the %s service in the catalog of %s", service, c.authURL)
+	}
+
+	return c.token, endpoint, nil
+}
+
+// expire discards a token that has been rejected so the next request gets a new one
+func (c *Client) expire(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// execute sends a request to a service, authenticating again once if the token has expired
+func (c *Client) execute(ctx context.Context, service, method, path string, body, result interface{}) error {
+	for retry := true; ; retry = false {
+		token, endpoint, err := c.session(ctx, service)
+		if err != nil {
+			return err
+		}
+
+		request := c.httpClient.R().SetContext(ctx).SetHeader(tokenHeader, token)
+		if body != nil {
+			request.SetBody(body)
+		}
+		if result != nil {
+			request.SetResult(result)
+		}
+
+		response, err := request.Execute(method, endpoint+path)
+		if err != nil {
+			return fmt.Errorf("Error executing request to %s %s: %w", method, path, err)
+		}
+
+		if response.StatusCode() == http.StatusUnauthorized && retry {
+			c.expire(token)
+			continue
+		}
+
+		if response.IsError() {
+			return toError(response)
+		}
+
+		return nil
+	}
+}
+
+func (c *Client) GetFlavors(ctx context.Context) ([]Flavor, error) {
+	var result FlavorList
+	err := c.execute(ctx, ComputeService, resty.MethodGet, "/flavors/detail", nil, &result)
+	return result.Flavors, err
+}
+
+func (c *Client) GetKeyPair(ctx context.Context, name string) (KeyPair, error) {
+	var result KeyPairRequest
+	err := c.execute(ctx, ComputeService, resty.MethodGet, "/os-keypairs/"+url.PathEscape(name), nil, &result)
+	return result.KeyPair, err
+}
+
+func (c *Client) CreateKeyPair(ctx context.Context, keyPair KeyPair) (KeyPair, error) {
+	var result KeyPairRequest
+	err := c.execute(ctx, ComputeService, resty.MethodPost, "/os-keypairs", KeyPairRequest{KeyPair: keyPair}, &result)
+	return result.KeyPair, err
+}
+
+func (c *Client) DeleteKeyPair(ctx context.Context, name string) error {
+	return c.execute(ctx, ComputeService, resty.MethodDelete, "/os-keypairs/"+url.PathEscape(name), nil, nil)
+}
+
+func (c *Client) CreateServer(ctx context.Context, server Server) (Server, error) {
+	var result ServerRequest
+	err := c.execute(ctx, ComputeService, resty.MethodPost, "/servers", ServerRequest{Server: server}, &result)
+	return result.Server, err
+}
+
+func (c *Client) GetServer(ctx context.Context, id string) (Server, error) {
+	var result ServerRequest
+	err := c.execute(ctx, ComputeService, resty.MethodGet, "/servers/"+id, nil, &result)
+	return result.Server, err
+}
+
+func (c *Client) DeleteServer(ctx context.Context, id string) error {
+	return c.execute(ctx, ComputeService, resty.MethodDelete, "/servers/"+id, nil, nil)
+}
+
+func (c *Client) CreateVolume(ctx context.Context, volume Volume) (Volume, error) {
+	var result VolumeRequest
+	err := c.execute(ctx, VolumeService, resty.MethodPost, "/volumes", VolumeRequest{Volume: volume}, &result)
+	return result.Volume, err
+}
+
+func (c *Client) GetVolume(ctx context.Context, id string) (Volume, error) {
+	var result VolumeRequest
+	err := c.execute(ctx, VolumeService, resty.MethodGet, "/volumes/"+id, nil, &result)
+	return result.Volume, err
+}
+
+func (c *Client) DeleteVolume(ctx context.Context, id string) error {
+	return c.execute(ctx, VolumeService, resty.MethodDelete, "/volumes/"+id, nil, nil)
+}
+
+func (c *Client) FindNetworks(ctx context.Context, filter url.Values) ([]Network, error) {
+	var result NetworkList
+	err := c.execute(ctx, NetworkService, resty.MethodGet, "/v2.0/networks?"+filter.Encode(), nil, &result)
+	return result.Networks, err
+}
+
+func (c *Client) FindPorts(ctx context.Context, filter url.Values) ([]Port, error) {
+	var result PortList
+	err := c.execute(ctx, NetworkService, resty.MethodGet, "/v2.0/ports?"+filter.Encode(), nil, &result)
+	return result.Ports, err
+}
+
+func (c *Client) CreateFloatingIP(ctx context.Context, ip FloatingIP) (FloatingIP, error) {
+	var result FloatingIPRequest
+	err := c.execute(ctx, NetworkService, resty.MethodPost, "/v2.0/floatingips", FloatingIPRequest{FloatingIP: ip}, &result)
+	return result.FloatingIP, err
+}
+
+func (c *Client) FindFloatingIPs(ctx context.Context, filter url.Values) ([]FloatingIP, error) {
+	var result FloatingIPList
+	err := c.execute(ctx, NetworkService, resty.MethodGet, "/v2.0/floatingips?"+filter.Encode(), nil, &result)
+	return result.FloatingIPs, err
+}
+
+func (c *Client) DeleteFloatingIP(ctx context.Context, id string) error {
+	return c.execute(ctx, NetworkService, resty.MethodDelete, "/v2.0/floatingips/"+id, nil, nil)
+}
+
+func (c *Client) GetImage(ctx context.Context, id string) (Image, error) {
+	var result Image
+	err := c.execute(ctx, ImageService, resty.MethodGet, "/v2/images/"+id, nil, &result)
+	return result, err
+}
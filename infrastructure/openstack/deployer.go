@@ -0,0 +1,925 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package openstack
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DeploymentType    = "openstack"
+	CredentialsFormat = "openstack"
+
+	// NetworkProperty is the infrastructure property with the name or identifier of the network to which the servers are attached. It's optional if the project has a single network.
+	NetworkProperty = "openstack_network"
+	// FloatingNetworkProperty is the infrastructure property with the name or identifier of the external network from which floating IPs are assigned. If it's not set, the fixed IPs of the servers are used.
+	FloatingNetworkProperty = "openstack_floating_network"
+	// UsernameProperty is the infrastructure property with the user name to access the servers. It depends on the images used.
+	UsernameProperty = "openstack_username"
+	// SSDVolumeTypeProperty and HDDVolumeTypeProperty are the infrastructure properties with the Cinder volume types used for SSD and HDD data drives. The default volume type is used if they are not set.
+	SSDVolumeTypeProperty = "openstack_ssd_volume_type"
+	HDDVolumeTypeProperty = "openstack_hdd_volume_type"
+
+	DefaultUsername = "ubuntu"
+
+	// InfrastructureMetadata is the metadata key of the servers and volumes with the identifier of the infrastructure they belong to
+	InfrastructureMetadata = "deployment_engine_infrastructure"
+
+	volumeTimeout = 300 * time.Second
+	serverTimeout = 600 * time.Second
+	deleteTimeout = 300 * time.Second
+)
+
+type OpenStackDeployer struct {
+	publicKey string
+	client    *Client
+}
+
+type NodeCreationResult struct {
+	Info  model.NodeInfo
+	Error error
+}
+
+type volumeCreationResult struct {
+	index  int
+	Volume Volume
+	Error  error
+}
+
+// deploymentSettings are the provider resources, resolved from the infrastructure properties, that are shared by all the nodes of an infrastructure
+type deploymentSettings struct {
+	infraID           string
+	keyName           string
+	networkID         string
+	floatingNetworkID string
+	username          string
+	volumeTypes       map[string]string
+	flavors           []Flavor
+}
+
+func NewDeployer(authURL string, credentials Credentials, publicKeyPath string) (*OpenStackDeployer, error) {
+
+	viper.SetDefault("debug_openstack_client", false)
+
+	pubKeyRaw, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		log.WithError(err).Error("Error reading public key")
+		return nil, err
+	}
+
+	return &OpenStackDeployer{
+		client:    NewClient(authURL, credentials, viper.GetBool("debug_openstack_client")),
+		publicKey: string(pubKeyRaw),
+	}, nil
+}
+
+func isNotFound(err error) bool {
+	var osErr OpenStackError
+	return errors.As(err, &osErr) && osErr.Code == http.StatusNotFound
+}
+
+func isAuthError(err error) bool {
+	var osErr OpenStackError
+	return errors.As(err, &osErr) && (osErr.Code == http.StatusUnauthorized || osErr.Code == http.StatusForbidden)
+}
+
+func keyPairName(infraID string) string {
+	return fmt.Sprintf("deployment-engine-%s", infraID)
+}
+
+// sizeInGb converts a size in Mb to the Gb that Cinder expects, rounding up
+func sizeInGb(size int64) int64 {
+	return (size + 1023) / 1024
+}
+
+func gbToBytes(size int64) int64 {
+	return size * 1024 * 1024 * 1024
+}
+
+// findNetwork returns the identifier of a network given its name or identifier
+func (d OpenStackDeployer) findNetwork(ctx context.Context, nameOrID string) (string, error) {
+	for _, field := range []string{"name", "id"} {
+		networks, err := d.client.FindNetworks(ctx, url.Values{field: []string{nameOrID}})
+		if err != nil {
+			return "", err
+		}
+		if len(networks) > 0 {
+			return networks[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("Network %s not found", nameOrID)
+}
+
+// resolveFlavor finds the flavor named in the type of the resource or, if it's not set, the smallest one with enough cores and RAM
+func resolveFlavor(flavors []Flavor, resource model.ResourceType) (Flavor, error) {
+	if resource.Type != "" {
+		for _, flavor := range flavors {
+			if flavor.Name == resource.Type || flavor.ID == resource.Type {
+				return flavor, nil
+			}
+		}
+		return Flavor{}, fmt.Errorf("Flavor %s not found", resource.Type)
+	}
+
+	cores := resource.Cores
+	if cores < 1 {
+		cores = 1
+	}
+
+	candidates := make([]Flavor, 0, len(flavors))
+	for _, flavor := range flavors {
+		if flavor.VCPUs >= cores && flavor.RAM >= resource.RAM {
+			candidates = append(candidates, flavor)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Flavor{}, fmt.Errorf("Can't find a flavor with at least %d cores and %d Mb of RAM", cores, resource.RAM)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].VCPUs != candidates[j].VCPUs {
+			return candidates[i].VCPUs < candidates[j].VCPUs
+		}
+		return candidates[i].RAM < candidates[j].RAM
+	})
+
+	return candidates[0], nil
+}
+
+// ensureKeyPair creates the key pair with the public key of the deployment engine if it doesn't exist yet
+func (d OpenStackDeployer) ensureKeyPair(ctx context.Context, name string) error {
+	_, err := d.client.GetKeyPair(ctx, name)
+	if err == nil || !isNotFound(err) {
+		return err
+	}
+
+	_, err = d.client.CreateKeyPair(ctx, KeyPair{
+		Name:      name,
+		PublicKey: d.publicKey,
+	})
+	return err
+}
+
+// prepare resolves the networks and flavors to use for the nodes of an infrastructure and creates its key pair
+func (d OpenStackDeployer) prepare(ctx context.Context, logger *log.Entry, infraID string, properties model.ExtraPropertiesType) (deploymentSettings, error) {
+	settings := deploymentSettings{
+		infraID:  infraID,
+		keyName:  keyPairName(infraID),
+		username: DefaultUsername,
+		volumeTypes: map[string]string{
+			"SSD": properties[SSDVolumeTypeProperty],
+			"HDD": properties[HDDVolumeTypeProperty],
+		},
+	}
+
+	if username := properties[UsernameProperty]; username != "" {
+		settings.username = username
+	}
+
+	var err error
+	if network := properties[NetworkProperty]; network != "" {
+		settings.networkID, err = d.findNetwork(ctx, network)
+		if err != nil {
+			logger.WithError(err).Error("Error finding network")
+			return settings, err
+		}
+	}
+
+	if network := properties[FloatingNetworkProperty]; network != "" {
+		settings.floatingNetworkID, err = d.findNetwork(ctx, network)
+		if err != nil {
+			logger.WithError(err).Error("Error finding floating IPs network")
+			return settings, err
+		}
+	}
+
+	settings.flavors, err = d.client.GetFlavors(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Error getting list of flavors")
+		return settings, err
+	}
+
+	logger.Info("Creating key pair")
+	err = d.ensureKeyPair(ctx, settings.keyName)
+	if err != nil {
+		logger.WithError(err).Error("Error creating key pair")
+	}
+
+	return settings, err
+}
+
+func (d OpenStackDeployer) returnError(logger *log.Entry, msg string, result NodeCreationResult, err error, c chan NodeCreationResult) error {
+	logger.WithError(err).Error(msg)
+	result.Error = err
+	deleteError := d.deletePartialDeployment(logger, result)
+	if deleteError != nil {
+		result.Error = deleteError
+	}
+	c <- result
+	return err
+}
+
+// deletePartialDeployment deletes the resources of a node that couldn't be created. It doesn't use the context of the operation so the resources are deleted even if it was cancelled.
+func (d OpenStackDeployer) deletePartialDeployment(logger *log.Entry, result NodeCreationResult) error {
+	logger.Info("Undoing partial deployment...")
+	return d.deleteHost(context.Background(), logger, result.Info)
+}
+
+// waitForVolume waits for a volume to leave a transitional status. Volumes that are attached to a server are considered to be detaching.
+func (d OpenStackDeployer) waitForVolume(ctx context.Context, id, status string, timeout time.Duration) (string, bool, error) {
+	return utils.WaitForStatusChange(ctx, status, timeout, func() (string, error) {
+		volume, err := d.client.GetVolume(ctx, id)
+		if isNotFound(err) {
+			return "deleted", nil
+		}
+		if volume.Status == "in-use" {
+			return "detaching", err
+		}
+		return volume.Status, err
+	})
+}
+
+func (d OpenStackDeployer) createVolume(ctx context.Context, logInput *log.Entry, index int, volume Volume, c chan volumeCreationResult) {
+	logger := logInput.WithField("volume", volume.Name)
+	logger.Info("Creating volume")
+	result := volumeCreationResult{index: index}
+
+	result.Volume, result.Error = d.client.CreateVolume(ctx, volume)
+	if result.Error != nil {
+		logger.WithError(result.Error).Error("Error creating volume")
+		c <- result
+		return
+	}
+
+	logger.Info("Waiting for volume to be ready")
+	status, timedOut, err := d.waitForVolume(ctx, result.Volume.ID, "creating", volumeTimeout)
+	switch {
+	case err != nil:
+		result.Error = err
+	case timedOut:
+		result.Error = fmt.Errorf("Timeout waiting for volume %s to be ready", result.Volume.ID)
+	case status != "available":
+		result.Error = fmt.Errorf("Volume %s in unexpected state: %s", result.Volume.ID, status)
+	}
+
+	if result.Error != nil {
+		logger.WithError(result.Error).Error("Error waiting for volume to be ready")
+	} else {
+		logger.Info("Volume ready")
+	}
+	c <- result
+}
+
+// createHostVolumes creates in parallel the boot volume, from the image of the resource, and the data volumes of a node. The first volume of the result is the boot one.
+func (d OpenStackDeployer) createHostVolumes(ctx context.Context, logger *log.Entry, settings deploymentSettings, hostname string, resource model.ResourceType, flavor Flavor) ([]Volume, error) {
+	bootSize := sizeInGb(resource.Disk)
+	if bootSize == 0 {
+		bootSize = flavor.Disk
+	}
+	if bootSize == 0 {
+		return nil, fmt.Errorf("A boot disk size is needed for resource %s since flavor %s doesn't define one", resource.Name, flavor.Name)
+	}
+
+	metadata := map[string]string{InfrastructureMetadata: settings.infraID}
+	volumes := make([]Volume, 0, len(resource.Drives)+1)
+	volumes = append(volumes, Volume{
+		Name:     utils.BootDriveName(hostname),
+		Size:     bootSize,
+		ImageRef: resource.ImageId,
+		Metadata: metadata,
+	})
+	for _, drive := range resource.Drives {
+		volumes = append(volumes, Volume{
+			Name:       utils.DataDriveName(hostname, drive),
+			Size:       sizeInGb(drive.Size),
+			VolumeType: settings.volumeTypes[strings.ToUpper(drive.Type)],
+			Metadata:   metadata,
+		})
+	}
+
+	c := make(chan volumeCreationResult, len(volumes))
+	for i, volume := range volumes {
+		go d.createVolume(ctx, logger, i, volume, c)
+	}
+
+	result := make([]Volume, len(volumes))
+	var err error
+	for remaining := len(volumes); remaining > 0; remaining-- {
+		created := <-c
+		if created.Error != nil {
+			err = created.Error
+		}
+		result[created.index] = created.Volume
+	}
+
+	return result, err
+}
+
+// serverIP returns the first fixed IPv4 address of a server
+func serverIP(server Server) string {
+	networks := make([]string, 0, len(server.Addresses))
+	for network := range server.Addresses {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+
+	for _, network := range networks {
+		for _, address := range server.Addresses[network] {
+			if address.Version == 4 && address.Type != "floating" {
+				return address.Address
+			}
+		}
+	}
+	return ""
+}
+
+func (d OpenStackDeployer) createServer(ctx context.Context, logger *log.Entry, settings deploymentSettings, hostname string, flavor Flavor, volumes []Volume) (Server, error) {
+	mappings := make([]BlockDeviceMapping, len(volumes))
+	for i, volume := range volumes {
+		mappings[i] = BlockDeviceMapping{
+			BootIndex:       -1,
+			UUID:            volume.ID,
+			SourceType:      "volume",
+			DestinationType: "volume",
+		}
+	}
+	mappings[0].BootIndex = 0
+
+	request := Server{
+		Name:                hostname,
+		FlavorRef:           flavor.ID,
+		KeyName:             settings.keyName,
+		BlockDeviceMappings: mappings,
+		Metadata:            map[string]string{InfrastructureMetadata: settings.infraID},
+	}
+	if settings.networkID != "" {
+		request.Networks = []ServerNetwork{ServerNetwork{UUID: settings.networkID}}
+	}
+
+	server, err := d.client.CreateServer(ctx, request)
+	if err != nil {
+		logger.WithError(err).Error("Error creating server")
+		return server, err
+	}
+
+	if server.ID == "" {
+		err = errors.New("Server created without an identifier")
+		logger.WithError(err).Error("Error creating server")
+		return server, err
+	}
+
+	logger.Info("Waiting for server to be active")
+	var current Server
+	status, timedOut, err := utils.WaitForStatusChange(ctx, "BUILD", serverTimeout, func() (string, error) {
+		var getErr error
+		current, getErr = d.client.GetServer(ctx, server.ID)
+		return current.Status, getErr
+	})
+	current.ID = server.ID
+
+	if err != nil {
+		logger.WithError(err).Error("Error waiting for server to be active")
+		return current, err
+	}
+
+	if timedOut {
+		err = fmt.Errorf("Timeout waiting for server %s to be active", server.ID)
+		logger.WithError(err).Error("Error waiting for server to be active")
+		return current, err
+	}
+
+	if status != "ACTIVE" {
+		err = fmt.Errorf("Server %s in unexpected state: %s", server.ID, status)
+		logger.WithError(err).Error("Error waiting for server to be active")
+		return current, err
+	}
+
+	logger.Info("Server active!")
+	return current, nil
+}
+
+// assignFloatingIP creates a floating IP in the external network and associates it to the port of the server
+func (d OpenStackDeployer) assignFloatingIP(ctx context.Context, logger *log.Entry, settings deploymentSettings, server Server) (string, error) {
+	filter := url.Values{"device_id": []string{server.ID}}
+	if settings.networkID != "" {
+		filter.Set("network_id", settings.networkID)
+	}
+
+	ports, err := d.client.FindPorts(ctx, filter)
+	if err != nil {
+		logger.WithError(err).Error("Error finding server ports")
+		return "", err
+	}
+
+	if len(ports) == 0 {
+		err = fmt.Errorf("Can't find a network port for server %s", server.ID)
+		logger.WithError(err).Error("Error assigning floating IP")
+		return "", err
+	}
+
+	ip, err := d.client.CreateFloatingIP(ctx, FloatingIP{
+		FloatingNetworkID: settings.floatingNetworkID,
+		PortID:            ports[0].ID,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Error creating floating IP")
+		return "", err
+	}
+
+	logger.Infof("Floating IP %s assigned", ip.FloatingIPAddress)
+	return ip.FloatingIPAddress, nil
+}
+
+func (d OpenStackDeployer) CreateServer(ctx context.Context, settings deploymentSettings, resource model.ResourceType, pfx string, c chan NodeCreationResult) error {
+	result := NodeCreationResult{}
+
+	logger := log.WithField("resource", resource.Name)
+
+	if resource.Name == "" {
+		return d.returnError(logger, "", result, fmt.Errorf("Resource with empty name found in infrastructure "+pfx), c)
+	}
+
+	nodeName, err := utils.ClearHostName(pfx + "-" + resource.Name)
+	if err != nil {
+		return d.returnError(logger, fmt.Sprintf("Invalid combination of hostname for infrastructure %s and resource %s", pfx, resource.Name), result, err, c)
+	}
+
+	result.Info = model.NodeInfo{
+		Role:            strings.ToLower(resource.Role),
+		Hostname:        nodeName,
+		Username:        settings.username,
+		ResourceName:    resource.Name,
+		ExtraProperties: resource.ExtraProperties,
+	}
+	logger = logger.WithField("host", nodeName)
+
+	flavor, err := resolveFlavor(settings.flavors, resource)
+	if err != nil {
+		return d.returnError(logger, "Error finding flavor", result, err, c)
+	}
+
+	logger.Info("Creating volumes")
+	volumes, err := d.createHostVolumes(ctx, logger, settings, nodeName, resource, flavor)
+	if len(volumes) > 0 {
+		result.Info.DriveUUID = volumes[0].ID
+		result.Info.DriveSize = gbToBytes(volumes[0].Size)
+		result.Info.DataDrives = make([]model.DriveInfo, 0, len(volumes)-1)
+		for _, volume := range volumes[1:] {
+			if volume.ID != "" {
+				result.Info.DataDrives = append(result.Info.DataDrives, model.DriveInfo{
+					Name: volume.Name,
+					UUID: volume.ID,
+					Size: gbToBytes(volume.Size),
+				})
+			}
+		}
+	}
+
+	if err != nil {
+		return d.returnError(logger, "Error creating volumes", result, err, c)
+	}
+
+	logger.Info("Creating server")
+	server, err := d.createServer(ctx, logger, settings, nodeName, flavor, volumes)
+	result.Info.UUID = server.ID
+	if err != nil {
+		return d.returnError(logger, "Error creating server", result, err, c)
+	}
+
+	result.Info.IP = serverIP(server)
+	if settings.floatingNetworkID != "" {
+		result.Info.IP, err = d.assignFloatingIP(ctx, logger, settings, server)
+		if err != nil {
+			return d.returnError(logger, "Error assigning floating IP", result, err, c)
+		}
+	}
+
+	if result.Info.IP == "" {
+		msg := "Can't find network information for server"
+		return d.returnError(logger, msg, result, errors.New(msg), c)
+	}
+
+	result.Info.Cores = flavor.VCPUs
+	result.Info.RAM = flavor.RAM * 1024 * 1024
+
+	logger.Info("Server deployment complete")
+
+	c <- result
+	return nil
+}
+
+// createNodes creates a node for each resource in parallel, adding the ones that succeed to the infrastructure
+func (d OpenStackDeployer) createNodes(ctx context.Context, logger *log.Entry, infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) error {
+	settings, err := d.prepare(ctx, logger, infra.ID, infra.ExtraProperties)
+	if err != nil {
+		return err
+	}
+
+	c := make(chan NodeCreationResult, len(resources))
+
+	for _, resource := range resources {
+		go d.CreateServer(ctx, settings, resource, infra.Name, c)
+	}
+
+	var failed = false
+
+	for remaining := len(resources); remaining > 0; remaining-- {
+		result := <-c
+		if result.Error == nil {
+			infra.AddNode(result.Info)
+		} else {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("Deployment failed")
+	}
+
+	return nil
+}
+
+// ValidateInfrastructure checks that an infrastructure has the information needed to be created in OpenStack
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if infra.Name == "" {
+		return errors.New("Name is mandatory for each openstack infrastructure")
+	}
+
+	if len(infra.Resources) == 0 {
+		return errors.New("At least one resource is needed")
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if resource.Name == "" {
+			return errors.New("Resource with empty name found")
+		}
+
+		if names[resource.Name] {
+			return fmt.Errorf("Name of resource %s is not unique", resource.Name)
+		}
+		names[resource.Name] = true
+
+		if resource.ImageId == "" {
+			return fmt.Errorf("Empty boot image found for resource %s", resource.Name)
+		}
+
+		if resource.Type == "" && (resource.Cores < 0 || resource.RAM <= 0) {
+			return fmt.Errorf("Either a flavor in the type or the RAM must be specified for resource %s", resource.Name)
+		}
+
+		for _, drive := range resource.Drives {
+			driveType := strings.ToUpper(drive.Type)
+			if driveType != "" && driveType != "SSD" && driveType != "HDD" {
+				return fmt.Errorf("Invalid type %s of drive %s in resource %s. Valid types are SSD and HDD", drive.Type, drive.Name, resource.Name)
+			}
+			if drive.Size <= 0 {
+				return fmt.Errorf("Size of drive %s in resource %s must be greater than 0", drive.Name, resource.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// PlanInfrastructure checks the credentials, the networks, the flavors and the boot images needed to create the infrastructure and describes the servers and volumes that would be created
+func (d OpenStackDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	err := d.client.Authenticate(ctx)
+	if err != nil {
+		if isAuthError(err) {
+			plan.AddError("Invalid credentials: %s", err.Error())
+			return plan, nil
+		}
+		plan.AddError("Error authenticating: %s", err.Error())
+		return plan, nil
+	}
+
+	for _, property := range []string{NetworkProperty, FloatingNetworkProperty} {
+		if network := infra.ExtraProperties[property]; network != "" {
+			if _, err := d.findNetwork(ctx, network); err != nil {
+				plan.AddError("Error finding network %s: %s", network, err.Error())
+			}
+		}
+	}
+
+	flavors, err := d.client.GetFlavors(ctx)
+	if err != nil {
+		plan.AddError("Error getting the list of flavors: %s", err.Error())
+	}
+
+	checkedImages := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			plan.AddError("Invalid hostname for resource %s: %s", resource.Name, err.Error())
+		}
+
+		node := model.NodePlan{
+			Hostname: hostname,
+			Role:     strings.ToLower(resource.Role),
+			Drives:   make([]model.DrivePlan, 0, len(resource.Drives)+1),
+		}
+
+		bootSize := sizeInGb(resource.Disk)
+		if flavors != nil {
+			flavor, err := resolveFlavor(flavors, resource)
+			if err != nil {
+				plan.AddError("Error finding flavor of resource %s: %s", resource.Name, err.Error())
+			} else {
+				node.Cores = flavor.VCPUs
+				node.RAM = flavor.RAM * 1024 * 1024
+				if bootSize == 0 {
+					bootSize = flavor.Disk
+				}
+			}
+		}
+
+		if !checkedImages[resource.ImageId] {
+			checkedImages[resource.ImageId] = true
+			_, err = d.client.GetImage(ctx, resource.ImageId)
+			if isNotFound(err) {
+				plan.AddError("Boot image %s of resource %s not found", resource.ImageId, resource.Name)
+			} else if err != nil {
+				plan.AddError("Error checking boot image of resource %s: %s", resource.Name, err.Error())
+			}
+		}
+
+		node.Drives = append(node.Drives, model.DrivePlan{
+			Name:   utils.BootDriveName(hostname),
+			Action: model.DriveActionClone,
+			Source: resource.ImageId,
+			Size:   gbToBytes(bootSize),
+		})
+
+		for _, drive := range resource.Drives {
+			node.Drives = append(node.Drives, model.DrivePlan{
+				Name:   utils.DataDriveName(hostname, drive),
+				Action: model.DriveActionCreate,
+				Size:   gbToBytes(sizeInGb(drive.Size)),
+			})
+		}
+
+		plan.Nodes = append(plan.Nodes, node)
+	}
+
+	return plan, nil
+}
+
+func (d OpenStackDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+
+	deployment := model.InfrastructureDeploymentInfo{
+		ID:              infra.InfrastructureID(),
+		Products:        make(map[string]interface{}),
+		ExtraProperties: infra.ExtraProperties,
+	}
+
+	if infra.Name == "" {
+		return deployment, errors.New("Name is mandatory for each openstack infrastructure")
+	}
+
+	deployment.Name = infra.Name
+	deployment.Type = DeploymentType
+	deployment.Nodes = make(map[string][]model.NodeInfo)
+	deployment.Status = "creating"
+
+	var logger = log.WithField("deployment", infra.Name)
+
+	err := d.createNodes(ctx, logger, &deployment, infra.Resources)
+	if err != nil {
+		logger.WithError(err).Errorf("Deployment failed")
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	logger.Infof("Nodes successfully created")
+	deployment.Status = "running"
+
+	return deployment, nil
+}
+
+// AddNodes creates new nodes in an existing infrastructure
+func (d OpenStackDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return infra, err
+		}
+		if _, found := infra.FindNode(hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", hostname, infra.ID)
+		}
+	}
+
+	logger.Infof("Adding %d nodes", len(resources))
+	err := d.createNodes(ctx, logger, &infra, resources)
+	if err != nil {
+		logger.WithError(err).Error("Error adding nodes")
+		return infra, err
+	}
+
+	logger.Info("Nodes successfully added")
+	return infra, nil
+}
+
+// RemoveNodes deletes nodes from an existing infrastructure given their hostnames
+func (d OpenStackDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]error)
+
+	for _, hostname := range hostnames {
+		node, found := infra.FindNode(hostname)
+		if !found {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+			continue
+		}
+
+		err := d.deleteHost(ctx, logger, node)
+		if err != nil {
+			logger.WithError(err).Errorf("Error deleting node %s", hostname)
+			result[hostname] = err
+			continue
+		}
+		infra.RemoveNode(hostname)
+	}
+
+	return infra, result
+}
+
+// releaseFloatingIP deletes the floating IPs with the address of the node, if any
+func (d OpenStackDeployer) releaseFloatingIP(ctx context.Context, logger *log.Entry, address string) error {
+	ips, err := d.client.FindFloatingIPs(ctx, url.Values{"floating_ip_address": []string{address}})
+	if err != nil {
+		logger.WithError(err).Error("Error finding floating IP")
+		return err
+	}
+
+	for _, ip := range ips {
+		logger.Infof("Releasing floating IP %s", ip.FloatingIPAddress)
+		err = d.client.DeleteFloatingIP(ctx, ip.ID)
+		if err != nil && !isNotFound(err) {
+			logger.WithError(err).Error("Error releasing floating IP")
+			return err
+		}
+	}
+	return nil
+}
+
+func (d OpenStackDeployer) deleteServer(ctx context.Context, logger *log.Entry, id string) error {
+	logger.Info("Deleting server")
+	err := d.client.DeleteServer(ctx, id)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		logger.WithError(err).Error("Error deleting server")
+		return err
+	}
+
+	logger.Info("Waiting for server to be deleted")
+	_, timedOut, err := utils.WaitForStatusChange(ctx, "deleting", deleteTimeout, func() (string, error) {
+		_, err := d.client.GetServer(ctx, id)
+		if isNotFound(err) {
+			return "deleted", nil
+		}
+		return "deleting", err
+	})
+
+	if err == nil && timedOut {
+		err = fmt.Errorf("Timeout waiting for server %s to be deleted", id)
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Error waiting for server to be deleted")
+	}
+	return err
+}
+
+// deleteVolume deletes a volume once it has been detached from its server
+func (d OpenStackDeployer) deleteVolume(ctx context.Context, logInput *log.Entry, id string) error {
+	logger := logInput.WithField("volume", id)
+	status, timedOut, err := d.waitForVolume(ctx, id, "detaching", deleteTimeout)
+	if err != nil {
+		logger.WithError(err).Error("Error waiting for volume to be detached")
+		return err
+	}
+	if timedOut {
+		err = fmt.Errorf("Timeout waiting for volume %s to be detached", id)
+		logger.WithError(err).Error("Error waiting for volume to be detached")
+		return err
+	}
+	if status == "deleted" {
+		return nil
+	}
+
+	logger.Info("Deleting volume")
+	err = d.client.DeleteVolume(ctx, id)
+	if err != nil && !isNotFound(err) {
+		logger.WithError(err).Error("Error deleting volume")
+		return err
+	}
+	return nil
+}
+
+func (d OpenStackDeployer) deleteHost(ctx context.Context, logInput *log.Entry, host model.NodeInfo) error {
+	logger := logInput.WithField("host", host.Hostname)
+
+	if host.UUID != "" {
+		if host.IP != "" {
+			err := d.releaseFloatingIP(ctx, logger, host.IP)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := d.deleteServer(ctx, logger, host.UUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for _, drive := range host.DataDrives {
+		if err2 := d.deleteVolume(ctx, logger, drive.UUID); err2 != nil {
+			err = err2
+		}
+	}
+
+	if host.DriveUUID != "" {
+		if err2 := d.deleteVolume(ctx, logger, host.DriveUUID); err2 != nil {
+			err = err2
+		}
+	}
+
+	if err == nil {
+		logger.Info("Host successfully deleted")
+	}
+	return err
+}
+
+// DeleteInfrastructure deletes the servers, volumes and floating IPs of the nodes of the infrastructure. The key pair of the infrastructure is deleted once all of them are gone.
+func (d OpenStackDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	logger.Info("Deleting infrastructure")
+
+	result := make(map[string]error)
+
+	logger.Info("Deleting nodes")
+	infra.ForEachNode(func(node model.NodeInfo) {
+		err := d.deleteHost(ctx, logger, node)
+		if err != nil {
+			logger.WithError(err).Errorf("Error deleting node %s", node.Hostname)
+			result[node.Hostname] = err
+		}
+	})
+
+	if len(result) > 0 {
+		return result
+	}
+
+	keyName := keyPairName(infra.ID)
+	err := d.client.DeleteKeyPair(ctx, keyName)
+	if err != nil && !isNotFound(err) {
+		logger.WithError(err).Error("Error deleting key pair")
+		result[keyName] = err
+		return result
+	}
+
+	logger.Info("Nodes deleted. Infrastructure clear")
+	return result
+}
@@ -0,0 +1,526 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package openstack
+
+import (
+	"context"
+	"deployment-engine/model"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeUsername  = "user"
+	fakePassword  = "password"
+	fakeProject   = "project"
+	fakeImage     = "image-uuid"
+	fakeToken     = "token"
+	fakePublicKey = "ssh-rsa AAAA test"
+)
+
+// fakeOpenStack is a minimal stand-in of the Keystone, Nova, Cinder, Neutron and Glance APIs. Volumes are available and servers active as soon as they are created.
+// Identifiers are derived from the names given by the deployer and every server has a single port, so only the resources checked by the tests are kept.
+type fakeOpenStack struct {
+	lock        sync.Mutex
+	url         string
+	flavors     []Flavor
+	keypairs    map[string]KeyPair
+	volumes     map[string]Volume
+	servers     map[string]Server
+	floatingips map[string]FloatingIP
+}
+
+func newFakeOpenStack() *fakeOpenStack {
+	return &fakeOpenStack{
+		flavors: []Flavor{
+			Flavor{ID: "1", Name: "m1.small", VCPUs: 1, RAM: 2048, Disk: 20},
+			Flavor{ID: "2", Name: "m1.medium", VCPUs: 2, RAM: 4096, Disk: 40},
+			Flavor{ID: "3", Name: "m1.large", VCPUs: 4, RAM: 8192, Disk: 80},
+		},
+		keypairs:    make(map[string]KeyPair),
+		volumes:     make(map[string]Volume),
+		servers:     make(map[string]Server),
+		floatingips: make(map[string]FloatingIP),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if payload != nil {
+		json.NewEncoder(w).Encode(payload)
+	}
+}
+
+// catalog returns the endpoints of the services, including an internal one that the client must skip
+func catalog(url string) []CatalogEntry {
+	result := make([]CatalogEntry, 0)
+	for _, service := range []string{"compute", "volumev3", "network", "image"} {
+		result = append(result, CatalogEntry{
+			Type: service,
+			Endpoints: []Endpoint{
+				Endpoint{Interface: "internal", URL: "http://internal"},
+				Endpoint{Interface: "public", Region: "RegionOne", URL: url + "/" + service},
+			},
+		})
+	}
+	return result
+}
+
+func (f *fakeOpenStack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Method == http.MethodPost && r.URL.Path == "/v3/auth/tokens" {
+		var request AuthRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		user := request.Auth.Identity.Password.User
+		if user.Name != fakeUsername || user.Password != fakePassword || request.Auth.Scope.Project.Name != fakeProject {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+			return
+		}
+		w.Header().Set("X-Subject-Token", fakeToken)
+		writeJSON(w, http.StatusCreated, TokenResponse{Token: Token{Catalog: catalog(f.url)}})
+		return
+	}
+
+	if r.Header.Get(tokenHeader) != fakeToken {
+		writeJSON(w, http.StatusUnauthorized, nil)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch path[0] {
+	case "compute":
+		f.serveCompute(w, r, path[1:])
+	case "volumev3":
+		f.serveVolumes(w, r, path[1:])
+	case "network":
+		f.serveNetwork(w, r, path[2:], r.URL.Query().Get)
+	case "image":
+		if path[len(path)-1] == fakeImage {
+			writeJSON(w, http.StatusOK, Image{ID: fakeImage, Name: "Ubuntu", Status: "active"})
+			return
+		}
+		writeJSON(w, http.StatusNotFound, nil)
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+func (f *fakeOpenStack) serveCompute(w http.ResponseWriter, r *http.Request, path []string) {
+	switch {
+	case r.Method == http.MethodGet && path[0] == "flavors":
+		writeJSON(w, http.StatusOK, FlavorList{Flavors: f.flavors})
+		return
+	case r.Method == http.MethodPost && path[0] == "os-keypairs":
+		var request KeyPairRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		f.keypairs[request.KeyPair.Name] = request.KeyPair
+		writeJSON(w, http.StatusOK, request)
+		return
+	case r.Method == http.MethodDelete && path[0] == "os-keypairs":
+		delete(f.keypairs, path[1])
+		writeJSON(w, http.StatusAccepted, nil)
+		return
+	case r.Method == http.MethodPost && path[0] == "servers":
+		var request ServerRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		server := request.Server
+		server.ID = "server-" + server.Name
+		server.Status = "ACTIVE"
+		for _, mapping := range server.BlockDeviceMappings {
+			volume := f.volumes[mapping.UUID]
+			volume.Status = "in-use"
+			f.volumes[mapping.UUID] = volume
+			server.AttachedVolumes = append(server.AttachedVolumes, AttachedVolume{ID: mapping.UUID})
+		}
+		server.Addresses = map[string][]ServerAddress{
+			"private": []ServerAddress{
+				ServerAddress{Address: fmt.Sprintf("192.168.0.%d", len(f.servers)+1), Version: 4, Type: "fixed"},
+			},
+		}
+		f.servers[server.ID] = server
+		writeJSON(w, http.StatusAccepted, ServerRequest{Server: Server{ID: server.ID}})
+		return
+	case path[0] == "servers":
+		server, ok := f.servers[path[1]]
+		if ok && r.Method == http.MethodDelete {
+			for _, attached := range server.AttachedVolumes {
+				volume := f.volumes[attached.ID]
+				volume.Status = "available"
+				f.volumes[attached.ID] = volume
+			}
+			delete(f.servers, server.ID)
+			writeJSON(w, http.StatusNoContent, nil)
+			return
+		}
+		if ok {
+			writeJSON(w, http.StatusOK, ServerRequest{Server: server})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, nil)
+}
+
+func (f *fakeOpenStack) serveVolumes(w http.ResponseWriter, r *http.Request, path []string) {
+	if r.Method == http.MethodPost {
+		var request VolumeRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Volume.ImageRef != "" && request.Volume.ImageRef != fakeImage {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid image"})
+			return
+		}
+		volume := request.Volume
+		volume.ID = "volume-" + volume.Name
+		volume.Status = "available"
+		f.volumes[volume.ID] = volume
+		writeJSON(w, http.StatusAccepted, VolumeRequest{Volume: Volume{ID: volume.ID, Name: volume.Name, Size: volume.Size, Status: "creating"}})
+		return
+	}
+
+	volume, ok := f.volumes[path[len(path)-1]]
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusNotFound, nil)
+	case r.Method == http.MethodDelete && volume.Status == "in-use":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Volume is in use"})
+	case r.Method == http.MethodDelete:
+		delete(f.volumes, volume.ID)
+		writeJSON(w, http.StatusAccepted, nil)
+	default:
+		writeJSON(w, http.StatusOK, VolumeRequest{Volume: volume})
+	}
+}
+
+func (f *fakeOpenStack) serveNetwork(w http.ResponseWriter, r *http.Request, path []string, query func(string) string) {
+	switch {
+	case path[0] == "networks":
+		result := NetworkList{Networks: make([]Network, 0)}
+		for _, network := range []Network{Network{ID: "private-id", Name: "private"}, Network{ID: "public-id", Name: "public"}} {
+			if network.Name == query("name") || network.ID == query("id") {
+				result.Networks = append(result.Networks, network)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case path[0] == "ports":
+		result := PortList{Ports: make([]Port, 0)}
+		if _, ok := f.servers[query("device_id")]; ok {
+			result.Ports = append(result.Ports, Port{ID: "port-" + query("device_id"), NetworkID: "private-id"})
+		}
+		writeJSON(w, http.StatusOK, result)
+	case path[0] == "floatingips" && r.Method == http.MethodPost:
+		var request FloatingIPRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		ip := request.FloatingIP
+		ip.ID = "fip-" + ip.PortID
+		ip.FloatingIPAddress = fmt.Sprintf("172.24.4.%d", len(f.floatingips)+1)
+		f.floatingips[ip.ID] = ip
+		if server, ok := f.servers[strings.TrimPrefix(ip.PortID, "port-")]; ok {
+			server.Addresses["private"] = append(server.Addresses["private"], ServerAddress{Address: ip.FloatingIPAddress, Version: 4, Type: "floating"})
+			f.servers[server.ID] = server
+		}
+		writeJSON(w, http.StatusCreated, FloatingIPRequest{FloatingIP: ip})
+	case path[0] == "floatingips" && r.Method == http.MethodGet:
+		result := FloatingIPList{FloatingIPs: make([]FloatingIP, 0)}
+		for _, ip := range f.floatingips {
+			if ip.FloatingIPAddress == query("floating_ip_address") {
+				result.FloatingIPs = append(result.FloatingIPs, ip)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	case path[0] == "floatingips" && r.Method == http.MethodDelete:
+		delete(f.floatingips, path[1])
+		writeJSON(w, http.StatusNoContent, nil)
+	default:
+		writeJSON(w, http.StatusNotFound, nil)
+	}
+}
+
+func startFake(t *testing.T) (*fakeOpenStack, *httptest.Server) {
+	fake := newFakeOpenStack()
+	server := httptest.NewServer(fake)
+	fake.url = server.URL
+	return fake, server
+}
+
+func newTestDeployer(url, password string) OpenStackDeployer {
+	return OpenStackDeployer{
+		client: NewClient(url, Credentials{
+			Username:    fakeUsername,
+			Password:    password,
+			ProjectName: fakeProject,
+			Region:      "RegionOne",
+		}, false),
+		publicKey: fakePublicKey,
+	}
+}
+
+func testInfra(resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name:      "Test_Infra",
+		Resources: resources,
+		ExtraProperties: model.ExtraPropertiesType{
+			NetworkProperty:         "private",
+			FloatingNetworkProperty: "public",
+			SSDVolumeTypeProperty:   "fast",
+		},
+	}
+}
+
+func TestDeployAndDeleteInfrastructure(t *testing.T) {
+	fake, server := startFake(t)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Role:    "Master",
+			Type:    "m1.medium",
+			Disk:    10240,
+			ImageId: fakeImage,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Type: "SSD", Size: 1536},
+			},
+		},
+		model.ResourceType{
+			Name:    "slave",
+			Role:    "slave",
+			Cores:   3,
+			RAM:     1024,
+			ImageId: fakeImage,
+		}))
+
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if infra.Status != "running" || infra.NumNodes() != 2 {
+		t.Fatalf("Unexpected infrastructure deployed: %v", infra)
+	}
+
+	keypair, ok := fake.keypairs[keyPairName(infra.ID)]
+	if !ok || keypair.PublicKey != fakePublicKey {
+		t.Fatalf("Key pair not created with the public key: %v", fake.keypairs)
+	}
+
+	master, found := infra.FindNode("test-infra-master")
+	if !found {
+		t.Fatalf("Can't find master node in %v", infra.Nodes)
+	}
+
+	if master.Role != "master" || master.Username != DefaultUsername || master.Cores != 2 || master.RAM != 4096*1024*1024 {
+		t.Fatalf("Unexpected master node: %v", master)
+	}
+
+	if !strings.HasPrefix(master.IP, "172.24.4.") {
+		t.Fatalf("Expected floating IP for master but found %s", master.IP)
+	}
+
+	if master.DriveSize != 10*1024*1024*1024 || len(master.DataDrives) != 1 || master.DataDrives[0].Size != 2*1024*1024*1024 {
+		t.Fatalf("Unexpected drives for master: %v", master)
+	}
+
+	serverInfo := fake.servers[master.UUID]
+	if serverInfo.FlavorRef != "2" || serverInfo.KeyName != keyPairName(infra.ID) || len(serverInfo.Networks) != 1 || serverInfo.Networks[0].UUID != "private-id" {
+		t.Fatalf("Unexpected server created for master: %v", serverInfo)
+	}
+
+	if len(serverInfo.BlockDeviceMappings) != 2 || serverInfo.BlockDeviceMappings[0].UUID != master.DriveUUID || serverInfo.BlockDeviceMappings[0].BootIndex != 0 {
+		t.Fatalf("Unexpected block devices for master: %v", serverInfo.BlockDeviceMappings)
+	}
+
+	if fake.volumes[master.DriveUUID].ImageRef != fakeImage || fake.volumes[master.DataDrives[0].UUID].VolumeType != "fast" {
+		t.Fatalf("Unexpected volumes created for master: %v", fake.volumes)
+	}
+
+	if fake.volumes[master.DriveUUID].Metadata[InfrastructureMetadata] != infra.ID {
+		t.Fatalf("Volume not labelled with infrastructure identifier: %v", fake.volumes[master.DriveUUID])
+	}
+
+	slave, _ := infra.FindNode("test-infra-slave")
+	if slave.Cores != 4 || slave.DriveSize != 80*1024*1024*1024 {
+		t.Fatalf("Expected smallest flavor with enough cores and its disk size for slave but found %v", slave)
+	}
+
+	status, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	if status["test-infra-master"].Status != model.NodeStatusRunning {
+		t.Fatalf("Unexpected status of master: %v", status["test-infra-master"])
+	}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	if len(fake.servers) != 0 || len(fake.volumes) != 0 || len(fake.floatingips) != 0 || len(fake.keypairs) != 0 {
+		t.Fatalf("Resources left after deleting infrastructure: %v %v %v %v", fake.servers, fake.volumes, fake.floatingips, fake.keypairs)
+	}
+}
+
+func TestDeployInfrastructureFailure(t *testing.T) {
+	fake, server := startFake(t)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Type:    "m1.small",
+			ImageId: "missing",
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Size: 1024},
+			},
+		}))
+
+	if err == nil {
+		t.Fatal("Infrastructure with missing image deployed")
+	}
+
+	if infra.NumNodes() != 0 || len(fake.volumes) != 0 || len(fake.servers) != 0 {
+		t.Fatalf("Resources of failed node not deleted: %v %v", fake.volumes, fake.servers)
+	}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) > 0 || len(fake.keypairs) != 0 {
+		t.Fatalf("Key pair of failed infrastructure not deleted: %v %v", errs, fake.keypairs)
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	var server *httptest.Server
+	tokens := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/auth/tokens" {
+			tokens++
+			w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", tokens))
+			writeJSON(w, http.StatusCreated, TokenResponse{Token: Token{Catalog: catalog(server.URL)}})
+			return
+		}
+		// Tokens expire after a request
+		if r.Header.Get(tokenHeader) != fmt.Sprintf("token-%d", tokens) {
+			writeJSON(w, http.StatusUnauthorized, nil)
+			return
+		}
+		tokens++
+		writeJSON(w, http.StatusOK, FlavorList{Flavors: []Flavor{Flavor{ID: "1", Name: "m1.small"}}})
+	}))
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	if _, err := deployer.client.GetFlavors(context.Background()); err != nil {
+		t.Fatalf("Error getting flavors: %s", err.Error())
+	}
+
+	flavors, err := deployer.client.GetFlavors(context.Background())
+	if err != nil || len(flavors) != 1 {
+		t.Fatalf("Token not renewed after expiring: %v %v", flavors, err)
+	}
+}
+
+func TestPlanInfrastructure(t *testing.T) {
+	_, server := startFake(t)
+	defer server.Close()
+
+	deployer := newTestDeployer(server.URL, fakePassword)
+	plan, err := deployer.PlanInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:    "master",
+			Role:    "Master",
+			Type:    "m1.small",
+			ImageId: fakeImage,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Size: 1024},
+			},
+		}))
+
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) > 0 || len(plan.Nodes) != 1 {
+		t.Fatalf("Unexpected plan: %v", plan)
+	}
+
+	master := plan.Nodes[0]
+	if master.Hostname != "test-infra-master" || master.Cores != 1 || master.RAM != 2048*1024*1024 || len(master.Drives) != 2 {
+		t.Fatalf("Unexpected plan for master node: %v", master)
+	}
+
+	if master.Drives[0].Source != fakeImage || master.Drives[0].Size != 20*1024*1024*1024 || master.Drives[1].Name != "data-test-infra-master-data" {
+		t.Fatalf("Unexpected drives plan: %v", master.Drives)
+	}
+
+	invalid := testInfra(
+		model.ResourceType{Name: "a", Type: "m1.huge", ImageId: fakeImage},
+		model.ResourceType{Name: "b", Type: "m1.small", ImageId: "missing"})
+	invalid.ExtraProperties[NetworkProperty] = "unknown"
+	plan, err = deployer.PlanInfrastructure(context.Background(), invalid)
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	// Unknown network, flavor and image
+	if len(plan.Errors) != 3 {
+		t.Fatalf("Expected 3 errors in plan but found %v", plan.Errors)
+	}
+
+	deployer = newTestDeployer(server.URL, "wrong")
+	plan, err = deployer.PlanInfrastructure(context.Background(), invalid)
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) != 1 || !strings.HasPrefix(plan.Errors[0], "Invalid credentials") {
+		t.Fatalf("Expected invalid credentials error but found %v", plan.Errors)
+	}
+}
+
+func TestValidateInfrastructure(t *testing.T) {
+	valid := model.ResourceType{Name: "node", Type: "m1.small", ImageId: fakeImage}
+
+	if err := ValidateInfrastructure(testInfra(valid)); err != nil {
+		t.Fatalf("Valid infrastructure rejected: %s", err.Error())
+	}
+
+	noFlavor := valid
+	noFlavor.Type = ""
+
+	badDrive := valid
+	badDrive.Drives = []model.Drive{model.Drive{Name: "data", Type: "tape", Size: 1024}}
+
+	for _, infra := range []model.InfrastructureType{
+		testInfra(),
+		testInfra(valid, valid),
+		testInfra(noFlavor),
+		testInfra(badDrive),
+	} {
+		if err := ValidateInfrastructure(infra); err == nil {
+			t.Fatalf("Invalid infrastructure accepted: %v", infra)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package openstack
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkVolume returns a problem if the volume is not attached to the server, explaining if it has been deleted
+func (d OpenStackDeployer) checkVolume(ctx context.Context, attached map[string]bool, kind, id string) string {
+	if attached[id] {
+		return ""
+	}
+
+	_, err := d.client.GetVolume(ctx, id)
+	if isNotFound(err) {
+		return fmt.Sprintf("%s %s has been deleted", kind, id)
+	}
+	return fmt.Sprintf("%s %s is not attached to the server", kind, id)
+}
+
+func (d OpenStackDeployer) checkNode(ctx context.Context, logger *log.Entry, node model.NodeInfo) model.NodeStatus {
+	if node.UUID == "" {
+		return model.NodeStatus{
+			Status:   model.NodeStatusMissing,
+			Problems: []string{"The node doesn't have a server identifier"},
+		}
+	}
+
+	server, err := d.client.GetServer(ctx, node.UUID)
+	if err != nil {
+		if isNotFound(err) {
+			return model.NodeStatus{
+				Status:   model.NodeStatusMissing,
+				Problems: []string{fmt.Sprintf("Server %s has been deleted", node.UUID)},
+			}
+		}
+		logger.WithError(err).Errorf("Error getting details of server %s", node.UUID)
+		return model.NodeStatus{
+			Status:   model.NodeStatusUnknown,
+			Problems: []string{fmt.Sprintf("Error getting details of server %s: %s", node.UUID, err.Error())},
+		}
+	}
+
+	status := model.NodeStatus{
+		Status: model.NodeStatusRunning,
+	}
+
+	attached := make(map[string]bool)
+	for _, volume := range server.AttachedVolumes {
+		attached[volume.ID] = true
+	}
+
+	if node.DriveUUID != "" {
+		if problem := d.checkVolume(ctx, attached, "Boot volume", node.DriveUUID); problem != "" {
+			status.Problems = append(status.Problems, problem)
+		}
+	}
+
+	for _, drive := range node.DataDrives {
+		if problem := d.checkVolume(ctx, attached, "Data volume", drive.UUID); problem != "" {
+			status.Problems = append(status.Problems, problem)
+		}
+	}
+
+	if node.IP != "" {
+		found := false
+		for _, addresses := range server.Addresses {
+			for _, address := range addresses {
+				found = found || address.Address == node.IP
+			}
+		}
+		if !found {
+			status.Problems = append(status.Problems, fmt.Sprintf("IP %s is not assigned to the server", node.IP))
+		}
+	}
+
+	if len(status.Problems) > 0 {
+		status.Status = model.NodeStatusDrifted
+	}
+
+	if server.Status != "ACTIVE" {
+		status.Status = model.NodeStatusStopped
+		status.Problems = append(status.Problems, fmt.Sprintf("Server is %s", server.Status))
+	}
+
+	return status
+}
+
+// CheckNodes gets the state of the servers of the infrastructure and checks that their volumes and IPs are still attached
+func (d OpenStackDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		result[node.Hostname] = d.checkNode(ctx, logger, node)
+	})
+	return result, nil
+}
@@ -0,0 +1,201 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package openstack
+
+// Credentials are the Keystone credentials used to get a token scoped to a project
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// ProjectName is the name of the project in which the resources will be created
+	ProjectName string `json:"project_name"`
+	// DomainName is the domain of the user and the project. Default is used if it's empty.
+	DomainName string `json:"domain_name"`
+	// Region of the endpoints to use. The first public endpoint of each service is used if it's empty.
+	Region string `json:"region"`
+}
+
+type AuthDomain struct {
+	Name string `json:"name"`
+}
+
+type AuthUser struct {
+	Name     string     `json:"name"`
+	Domain   AuthDomain `json:"domain"`
+	Password string     `json:"password"`
+}
+
+type AuthPassword struct {
+	User AuthUser `json:"user"`
+}
+
+type AuthIdentity struct {
+	Methods  []string     `json:"methods"`
+	Password AuthPassword `json:"password"`
+}
+
+type AuthProject struct {
+	Name   string     `json:"name"`
+	Domain AuthDomain `json:"domain"`
+}
+
+type AuthScope struct {
+	Project AuthProject `json:"project"`
+}
+
+type Auth struct {
+	Identity AuthIdentity `json:"identity"`
+	Scope    AuthScope    `json:"scope"`
+}
+
+type AuthRequest struct {
+	Auth Auth `json:"auth"`
+}
+
+type Endpoint struct {
+	Interface string `json:"interface"`
+	Region    string `json:"region"`
+	URL       string `json:"url"`
+}
+
+type CatalogEntry struct {
+	Type      string     `json:"type"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+type Token struct {
+	Catalog []CatalogEntry `json:"catalog"`
+}
+
+type TokenResponse struct {
+	Token Token `json:"token"`
+}
+
+type Flavor struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	VCPUs int    `json:"vcpus"`
+	// RAM in Mb
+	RAM int64 `json:"ram"`
+	// Disk in Gb
+	Disk int64 `json:"disk"`
+}
+
+type FlavorList struct {
+	Flavors []Flavor `json:"flavors"`
+}
+
+type KeyPair struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+type KeyPairRequest struct {
+	KeyPair KeyPair `json:"keypair"`
+}
+
+type Volume struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status,omitempty"`
+	// Size in Gb
+	Size       int64             `json:"size,omitempty"`
+	ImageRef   string            `json:"imageRef,omitempty"`
+	VolumeType string            `json:"volume_type,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+type VolumeRequest struct {
+	Volume Volume `json:"volume"`
+}
+
+type BlockDeviceMapping struct {
+	BootIndex           int    `json:"boot_index"`
+	UUID                string `json:"uuid"`
+	SourceType          string `json:"source_type"`
+	DestinationType     string `json:"destination_type"`
+	DeleteOnTermination bool   `json:"delete_on_termination"`
+}
+
+type ServerNetwork struct {
+	UUID string `json:"uuid"`
+}
+
+type ServerAddress struct {
+	Address string `json:"addr"`
+	Version int    `json:"version"`
+	Type    string `json:"OS-EXT-IPS:type"`
+}
+
+type AttachedVolume struct {
+	ID string `json:"id"`
+}
+
+type Server struct {
+	ID                  string                     `json:"id,omitempty"`
+	Name                string                     `json:"name,omitempty"`
+	Status              string                     `json:"status,omitempty"`
+	FlavorRef           string                     `json:"flavorRef,omitempty"`
+	KeyName             string                     `json:"key_name,omitempty"`
+	Networks            []ServerNetwork            `json:"networks,omitempty"`
+	BlockDeviceMappings []BlockDeviceMapping       `json:"block_device_mapping_v2,omitempty"`
+	Metadata            map[string]string          `json:"metadata,omitempty"`
+	Addresses           map[string][]ServerAddress `json:"addresses,omitempty"`
+	AttachedVolumes     []AttachedVolume           `json:"os-extended-volumes:volumes_attached,omitempty"`
+}
+
+type ServerRequest struct {
+	Server Server `json:"server"`
+}
+
+type Network struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type NetworkList struct {
+	Networks []Network `json:"networks"`
+}
+
+type Port struct {
+	ID        string `json:"id"`
+	NetworkID string `json:"network_id"`
+}
+
+type PortList struct {
+	Ports []Port `json:"ports"`
+}
+
+type FloatingIP struct {
+	ID                string `json:"id,omitempty"`
+	FloatingNetworkID string `json:"floating_network_id,omitempty"`
+	FloatingIPAddress string `json:"floating_ip_address,omitempty"`
+	PortID            string `json:"port_id,omitempty"`
+}
+
+type FloatingIPRequest struct {
+	FloatingIP FloatingIP `json:"floatingip"`
+}
+
+type FloatingIPList struct {
+	FloatingIPs []FloatingIP `json:"floatingips"`
+}
+
+type Image struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
@@ -0,0 +1,94 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type infrastructurePlanResult struct {
+	Index int
+	Plan  model.InfrastructurePlan
+}
+
+func (c *Deployer) credentialsSource(provider model.CloudProviderInfo) string {
+	if provider.Credentials != nil && len(provider.Credentials) > 0 {
+		return model.CredentialsSourceInline
+	}
+	if provider.SecretID != "" {
+		return model.CredentialsSourceVault
+	}
+	return ""
+}
+
+// PlanInfrastructure asks the provider of an infrastructure to check it and describe what it would create
+func (c *Deployer) PlanInfrastructure(ctx context.Context, principal string, infra model.InfrastructureType) model.InfrastructurePlan {
+	logger := log.WithField("infrastructure", infra.Name)
+
+	infra.Provider = ProviderOf(infra)
+
+	var plan model.InfrastructurePlan
+	deployer, err := c.findProvider(principal, "", infra.Provider)
+	if err != nil {
+		logger.WithError(err).Error("Can't find provider for infrastructure plan")
+		plan.AddError("Error initializing provider: %s", err.Error())
+	} else {
+		plan, err = deployer.PlanInfrastructure(ctx, infra)
+		if err != nil {
+			logger.WithError(err).Error("Error planning infrastructure")
+			plan.AddError("Error planning infrastructure: %s", err.Error())
+		}
+	}
+
+	plan.Name = infra.Name
+	plan.Provider = infra.Provider.APIType
+	plan.CredentialsSource = c.credentialsSource(infra.Provider)
+
+	return plan
+}
+
+// PlanDeployment is a dry run of CreateDeployment. The provider of each infrastructure checks it and describes what it would create, but nothing is created or saved.
+func (c *Deployer) PlanDeployment(ctx context.Context, principal string, infras []model.InfrastructureType) model.DeploymentPlan {
+	result := model.DeploymentPlan{
+		Valid:           true,
+		Infrastructures: make([]model.InfrastructurePlan, len(infras)),
+	}
+
+	channel := make(chan infrastructurePlanResult, len(infras))
+	for i, infra := range infras {
+		go func(index int, infra model.InfrastructureType) {
+			channel <- infrastructurePlanResult{
+				Index: index,
+				Plan:  c.PlanInfrastructure(ctx, principal, infra),
+			}
+		}(i, infra)
+	}
+
+	for remaining := len(infras); remaining > 0; remaining-- {
+		planResult := <-channel
+		result.Infrastructures[planResult.Index] = planResult.Plan
+		if len(planResult.Plan.Errors) > 0 {
+			result.Valid = false
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,182 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"deployment-engine/infrastructure/aws"
+	"deployment-engine/infrastructure/cloudsigma"
+	"deployment-engine/infrastructure/edge"
+	"deployment-engine/infrastructure/kubernetes"
+	"deployment-engine/infrastructure/openstack"
+	"deployment-engine/infrastructure/simulated"
+	"deployment-engine/infrastructure/terraform"
+	"deployment-engine/model"
+	"fmt"
+)
+
+// Registration of the providers included in the deployment engine. Project specific providers can be registered by calling RegisterProvider from their own packages.
+func init() {
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:           cloudsigma.DeploymentType,
+			Description:       "CloudSigma virtual machines",
+			CredentialsFormat: model.BasicAuthType,
+			CredentialsFields: map[string]string{
+				"username": "CloudSigma user name",
+				"password": "CloudSigma password",
+			},
+		},
+		NewCredentials: func() interface{} {
+			return &model.BasicAuthSecret{}
+		},
+		Validate: cloudsigma.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			credentials := config.Credentials.(*model.BasicAuthSecret)
+			if credentials.Username == "" || credentials.Password == "" {
+				return nil, fmt.Errorf("Invalid credentials specified for cloudsigma provider %s. Username and password are needed", config.Provider.APIEndpoint)
+			}
+
+			dep, err := cloudsigma.NewDeployer(config.Provider.APIEndpoint, *credentials, config.PublicKeyPath, config.Leases)
+			if err != nil {
+				return nil, err
+			}
+			return *dep, nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:           openstack.DeploymentType,
+			Description:       "OpenStack virtual machines with Cinder volumes and Neutron floating IPs. The API endpoint is the Keystone URL.",
+			CredentialsFormat: openstack.CredentialsFormat,
+			CredentialsFields: map[string]string{
+				"username":     "OpenStack user name",
+				"password":     "OpenStack password",
+				"project_name": "Name of the project in which the servers will be created",
+				"domain_name":  "Optional domain of the user and the project. Default is used if it's not specified",
+				"region":       "Optional region of the endpoints to use",
+			},
+		},
+		NewCredentials: func() interface{} {
+			return &openstack.Credentials{}
+		},
+		Validate: openstack.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			credentials := config.Credentials.(*openstack.Credentials)
+			if credentials.Username == "" || credentials.Password == "" || credentials.ProjectName == "" {
+				return nil, fmt.Errorf("Invalid credentials specified for openstack provider %s. Username, password and project name are needed", config.Provider.APIEndpoint)
+			}
+
+			dep, err := openstack.NewDeployer(config.Provider.APIEndpoint, *credentials, config.PublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			return *dep, nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:           aws.DeploymentType,
+			Description:       "AWS EC2 instances with EBS volumes. The API endpoint is the region or the URL of an EC2 compatible endpoint.",
+			CredentialsFormat: model.BasicAuthType,
+			CredentialsFields: map[string]string{
+				"username": "AWS access key identifier",
+				"password": "AWS secret access key",
+			},
+		},
+		NewCredentials: func() interface{} {
+			return &model.BasicAuthSecret{}
+		},
+		Validate: aws.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			credentials := config.Credentials.(*model.BasicAuthSecret)
+			if credentials.Username == "" || credentials.Password == "" {
+				return nil, fmt.Errorf("Invalid credentials specified for aws provider %s. Access key identifier and secret access key are needed", config.Provider.APIEndpoint)
+			}
+
+			dep, err := aws.NewDeployer(config.Provider.APIEndpoint, *credentials, config.PublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			return *dep, nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:           terraform.DeploymentType,
+			Description:       "Resources created by applying a terraform template. The API endpoint is the name of the template in the templates folder.",
+			CredentialsFormat: terraform.CredentialsFormat,
+			CredentialsFields: map[string]string{
+				"*": "Environment variables to pass to terraform, such as the credentials of its provider or template variables with the TF_VAR_ prefix",
+			},
+		},
+		NewCredentials: func() interface{} {
+			return &terraform.Credentials{}
+		},
+		Validate: terraform.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			credentials := config.Credentials.(*terraform.Credentials)
+			dep, err := terraform.NewDeployer(config.Provider, *credentials, config.DeploymentsFolder, config.PublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			return *dep, nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:           kubernetes.DeploymentType,
+			Description:       "Existing Kubernetes cluster",
+			CredentialsFormat: model.KubernetesType,
+			CredentialsFields: map[string]string{
+				"config":            "Kubernetes configuration file contents, as used by kubectl",
+				"registries_secret": "Optional name of the secret with the credentials of the private docker registries",
+			},
+		},
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return kubernetes.NewKubernetesDeployer(config.DeploymentsFolder, config.Vault), nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:     simulated.DeploymentType,
+			Description: "Simulated virtual machines for development and testing. Nothing is created but the nodes get deterministic IPs and identifiers, and their creation takes the configured latency and fails with the configured rate.",
+		},
+		WithoutCredentials: true,
+		Validate:           simulated.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return *simulated.NewDeployer(), nil
+		},
+	})
+
+	MustRegisterProvider(ProviderRegistration{
+		ProviderDescription: model.ProviderDescription{
+			APIType:     edge.DeploymentType,
+			Description: "Pre-existing machines accessed by SSH with the key of the deployment engine. Only their information is recorded. It's the default provider for infrastructures of type edge.",
+		},
+		WithoutCredentials: true,
+		Validate:           edge.ValidateInfrastructure,
+		Factory: func(config DeployerConfig) (model.Deployer, error) {
+			return edge.NewEdgeDeployer(), nil
+		},
+	})
+}
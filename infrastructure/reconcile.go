@@ -0,0 +1,278 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func sameProperties(a, b model.ExtraPropertiesType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeResourceNames returns a function that gives the name of the resource a node was created from.
+// Nodes saved before resource names were recorded are matched by the hostname given by virtual machine providers or, as done for kubernetes clusters, by the resource name.
+func nodeResourceNames(infraName string, resources []model.ResourceType) func(node model.NodeInfo) string {
+	legacy := make(map[string]string)
+	for _, resource := range resources {
+		if hostname, err := utils.ClearHostName(infraName + "-" + resource.Name); err == nil {
+			legacy[hostname] = resource.Name
+		}
+	}
+	for _, resource := range resources {
+		if _, found := legacy[resource.Name]; !found {
+			legacy[resource.Name] = resource.Name
+		}
+	}
+
+	return func(node model.NodeInfo) string {
+		if node.ResourceName != "" {
+			return node.ResourceName
+		}
+		if name, found := legacy[node.Hostname]; found {
+			return name
+		}
+		return node.Hostname
+	}
+}
+
+// recordDefinitions saves in the nodes that don't have one the definition of the resource they were created from
+func recordDefinitions(infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) {
+	definitions := make(map[string]*model.NodeDefinition)
+	for _, resource := range resources {
+		definitions[resource.Name] = resource.Definition()
+	}
+
+	resourceName := nodeResourceNames(infra.Name, resources)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		if definition, found := definitions[resourceName(node)]; found && node.Definition == nil {
+			node.Definition = definition
+			infra.UpdateNode(node)
+		}
+	})
+}
+
+// definitionChanges returns the fields of a node definition that are different in a resource
+func definitionChanges(definition *model.NodeDefinition, resource model.ResourceType) []string {
+	changed := make([]string, 0)
+	if definition == nil {
+		return changed
+	}
+
+	current := resource.Definition()
+	if current.Type != definition.Type {
+		changed = append(changed, "type")
+	}
+	if current.CPU != definition.CPU {
+		changed = append(changed, "cpu")
+	}
+	if current.Cores != definition.Cores {
+		changed = append(changed, "cores")
+	}
+	if current.RAM != definition.RAM {
+		changed = append(changed, "ram")
+	}
+	if current.Disk != definition.Disk {
+		changed = append(changed, "disk")
+	}
+	if current.ImageId != definition.ImageId {
+		changed = append(changed, "image_id")
+	}
+	if !sameImage(current.Image, definition.Image) {
+		changed = append(changed, "image")
+	}
+	if !sameDrives(current.Drives, definition.Drives) {
+		changed = append(changed, "drives")
+	}
+	return changed
+}
+
+func sameImage(a, b *model.Image) bool {
+	if a == nil || b == nil {
+		return (a == nil || a.IsEmpty()) && (b == nil || b.IsEmpty())
+	}
+	return *a == *b
+}
+
+func sameDrives(a, b []model.Drive) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffInfrastructure computes the changes needed to make an infrastructure match its definition
+func (c *Deployer) diffInfrastructure(infra model.InfrastructureDeploymentInfo, spec model.InfrastructureType) (model.ChangeSet, error) {
+	changes := model.ChangeSet{
+		InfrastructureID: infra.ID,
+		Create:           make([]model.ResourceType, 0),
+		Delete:           make([]string, 0),
+		Update:           make([]string, 0),
+	}
+
+	spec.Provider = ProviderOf(spec)
+	if spec.Name != infra.Name {
+		return changes, fmt.Errorf("Infrastructure %s can't be renamed to %s", infra.Name, spec.Name)
+	}
+
+	if spec.Provider.APIType != infra.Provider.APIType {
+		return changes, fmt.Errorf("Provider of infrastructure %s can't be changed from %s to %s", infra.ID, infra.Provider.APIType, spec.Provider.APIType)
+	}
+
+	err := c.ValidateDeployment([]model.InfrastructureType{spec})
+	if err != nil {
+		return changes, err
+	}
+
+	resources := make(map[string]model.ResourceType)
+	for _, resource := range spec.Resources {
+		if _, found := resources[resource.Name]; found {
+			return changes, fmt.Errorf("Name of resource %s is not unique", resource.Name)
+		}
+		resources[resource.Name] = resource
+	}
+
+	resourceName := nodeResourceNames(infra.Name, spec.Resources)
+	existing := make(map[string]bool)
+	unsupported := make([]string, 0)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		name := resourceName(node)
+		resource, found := resources[name]
+		if !found {
+			changes.Delete = append(changes.Delete, node.Hostname)
+			return
+		}
+		existing[name] = true
+		if changed := definitionChanges(node.Definition, resource); len(changed) > 0 {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", node.Hostname, strings.Join(changed, ", ")))
+		}
+		if !sameProperties(node.ExtraProperties, resource.ExtraProperties) {
+			changes.Update = append(changes.Update, node.Hostname)
+		}
+	})
+
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return changes, fmt.Errorf("Nodes can't be changed once created, rename their resources to replace them: %s", strings.Join(unsupported, "; "))
+	}
+
+	for _, resource := range spec.Resources {
+		if !existing[resource.Name] {
+			changes.Create = append(changes.Create, resource)
+		}
+	}
+
+	sort.Strings(changes.Delete)
+	sort.Strings(changes.Update)
+	changes.UpdateProperties = !sameProperties(infra.ExtraProperties, spec.ExtraProperties)
+
+	return changes, nil
+}
+
+// DiffInfrastructure compares the definition of an infrastructure with the one stored in the repository and returns the changes needed to reconcile them
+func (c *Deployer) DiffInfrastructure(infraID string, spec model.InfrastructureType) (model.ChangeSet, error) {
+	infra, err := c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		return model.ChangeSet{}, err
+	}
+	return c.diffInfrastructure(infra, spec)
+}
+
+// ReconcileInfrastructure makes an existing infrastructure match its definition. Resources that don't have a node are created, nodes whose resource is not in the definition are deleted and the extra properties of the infrastructure and its nodes are updated.
+// Changes to the size, image or drives of the resource of an existing node are rejected since they would need it to be recreated.
+// Reconciling an infrastructure which already matches its definition does nothing. It returns the changes applied.
+func (c *Deployer) ReconcileInfrastructure(ctx context.Context, principal, infraID string, spec model.InfrastructureType, progress model.ProgressFunc) (model.ChangeSet, model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infraID)
+
+	infra, err := c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		logger.WithError(err).Error("Infrastructure not found")
+		return model.ChangeSet{}, infra, err
+	}
+
+	changes, err := c.diffInfrastructure(infra, spec)
+	if err != nil {
+		return changes, infra, err
+	}
+
+	if changes.IsEmpty() {
+		logger.Info("Infrastructure already matches its definition")
+		c.reportProgress(progress, infraID, "unchanged", nil)
+		return changes, infra, nil
+	}
+
+	if len(changes.Create) > 0 || len(changes.Delete) > 0 {
+		infra, err = c.ScaleInfrastructure(ctx, principal, infraID, model.NodesPatch{
+			Add:         changes.Create,
+			Remove:      changes.Delete,
+			Credentials: spec.Provider.Credentials,
+		}, progress)
+		if err != nil {
+			return changes, infra, err
+		}
+	}
+
+	if len(changes.Update) > 0 || changes.UpdateProperties {
+		c.reportProgress(progress, infraID, "updating properties", nil)
+
+		resources := make(map[string]model.ResourceType)
+		for _, resource := range spec.Resources {
+			resources[resource.Name] = resource
+		}
+
+		resourceName := nodeResourceNames(infra.Name, spec.Resources)
+		for _, hostname := range changes.Update {
+			node, found := infra.FindNode(hostname)
+			if found {
+				node.ExtraProperties = resources[resourceName(node)].ExtraProperties
+				infra.UpdateNode(node)
+			}
+		}
+
+		infra.ExtraProperties = spec.ExtraProperties
+		infra, err = c.Repository.UpdateInfrastructure(infra)
+		if err != nil {
+			logger.WithError(err).Error("Error saving reconciled infrastructure")
+			c.reportProgress(progress, infraID, "failed", err)
+			return changes, infra, err
+		}
+	}
+
+	c.reportProgress(progress, infraID, "reconciled", nil)
+	return changes, infra, nil
+}
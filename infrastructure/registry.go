@@ -0,0 +1,125 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"deployment-engine/model"
+	"deployment-engine/persistence"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DeployerConfig is the information passed to a deployer factory to create a deployer for a particular provider
+type DeployerConfig struct {
+	// Provider information of the infrastructure
+	Provider model.CloudProviderInfo
+	// Credentials of the provider, already read from the provider information or the vault. It's the value returned by NewCredentials in the provider registration or nil if it's not defined.
+	Credentials interface{}
+	// Path to the public key to inject in the created nodes
+	PublicKeyPath string
+	// Folder in which deployers can save infrastructure related files
+	DeploymentsFolder string
+	// Vault in which secrets are stored
+	Vault persistence.Vault
+	// Leases reserves the IPs of the nodes being created, shared by the deployers of every infrastructure. It can be nil if the repository doesn't support them.
+	Leases persistence.IPLeaseRepository
+}
+
+// DeployerFactory creates a deployer for a particular provider
+type DeployerFactory func(config DeployerConfig) (model.Deployer, error)
+
+// ProviderRegistration has the information needed to use a provider type in deployments
+type ProviderRegistration struct {
+	model.ProviderDescription
+	// WithoutCredentials must be set for providers that don't need credentials, such as edge infrastructures whose hosts are accessed with the key of the deployment engine
+	WithoutCredentials bool
+	// NewCredentials returns a pointer to an empty credentials object in which the credentials of the provider will be decoded. If it's nil, the deployer will need to read the credentials by itself from the provider information.
+	NewCredentials func() interface{}
+	// Validate checks an infrastructure for this provider before any resource is created. It's optional.
+	Validate func(infra model.InfrastructureType) error
+	// Factory creates deployers for this provider
+	Factory DeployerFactory
+}
+
+var (
+	providers     = make(map[string]ProviderRegistration)
+	providersLock sync.RWMutex
+)
+
+// RegisterProvider adds a new provider type that can be used in deployments. It returns an error if the provider type is already registered.
+func RegisterProvider(registration ProviderRegistration) error {
+	if registration.APIType == "" {
+		return errors.New("API type is mandatory to register a provider")
+	}
+
+	if registration.Factory == nil {
+		return fmt.Errorf("A factory is needed to register provider %s", registration.APIType)
+	}
+
+	providersLock.Lock()
+	defer providersLock.Unlock()
+
+	if _, ok := providers[registration.APIType]; ok {
+		return fmt.Errorf("Provider %s is already registered", registration.APIType)
+	}
+
+	providers[registration.APIType] = registration
+	return nil
+}
+
+// MustRegisterProvider registers a provider type and panics if it can't be done. It's intended to be used in package initialization.
+func MustRegisterProvider(registration ProviderRegistration) {
+	if err := RegisterProvider(registration); err != nil {
+		panic(err)
+	}
+}
+
+// GetProvider returns the registration of a provider type
+func GetProvider(apiType string) (ProviderRegistration, bool) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	registration, ok := providers[apiType]
+	return registration, ok
+}
+
+// ProviderTypes returns the sorted list of registered provider types
+func ProviderTypes() []string {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	result := make([]string, 0, len(providers))
+	for apiType := range providers {
+		result = append(result, apiType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ListProviders returns the description of the registered providers sorted by API type
+func ListProviders() []model.ProviderDescription {
+	types := ProviderTypes()
+	result := make([]model.ProviderDescription, 0, len(types))
+	for _, apiType := range types {
+		registration, ok := GetProvider(apiType)
+		if ok {
+			result = append(result, registration.ProviderDescription)
+		}
+	}
+	return result
+}
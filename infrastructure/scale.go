@@ -0,0 +1,181 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package infrastructure
+
+import (
+	"context"
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ScalingStatus = "scaling"
+)
+
+func (c *Deployer) validateNodesPatch(infra model.InfrastructureDeploymentInfo, patch model.NodesPatch) error {
+	if len(patch.Add) == 0 && len(patch.Remove) == 0 {
+		return errors.New("No nodes to add or remove have been specified")
+	}
+
+	for _, hostname := range patch.Remove {
+		if _, found := infra.FindNode(hostname); !found {
+			return fmt.Errorf("Can't find node %s to remove in infrastructure %s", hostname, infra.ID)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range patch.Add {
+		if resource.Name == "" {
+			return fmt.Errorf("Resource with empty name found in nodes to add to infrastructure %s", infra.ID)
+		}
+		if names[resource.Name] {
+			return fmt.Errorf("Name of resource %s is not unique in nodes to add to infrastructure %s", resource.Name, infra.ID)
+		}
+		names[resource.Name] = true
+	}
+
+	if len(patch.Add) > 0 {
+		registration, ok := GetProvider(infra.Provider.APIType)
+		if ok && registration.Validate != nil {
+			err := registration.Validate(model.InfrastructureType{
+				Name:            infra.Name,
+				Type:            infra.Type,
+				Provider:        infra.Provider,
+				ExtraProperties: infra.ExtraProperties,
+				Resources:       patch.Add,
+			})
+			if err != nil {
+				return fmt.Errorf("Invalid nodes to add to infrastructure %s: %w", infra.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateNodesPatch checks that a set of changes can be applied to the nodes of an existing infrastructure. The resources to add are checked with the validation function of its provider, if any.
+func (c *Deployer) ValidateNodesPatch(infraID string, patch model.NodesPatch) error {
+	infra, err := c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		return err
+	}
+	return c.validateNodesPatch(infra, patch)
+}
+
+// ScaleInfrastructure removes and adds nodes to an existing infrastructure, in this order, and saves the result in the repository so provisioners can act on the new nodes.
+// Nodes that are successfully created or deleted are saved even if some other node fails.
+func (c *Deployer) ScaleInfrastructure(ctx context.Context, principal, infraID string, patch model.NodesPatch, progress model.ProgressFunc) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infraID)
+
+	infra, err := c.Repository.FindInfrastructure(infraID)
+	if err != nil {
+		logger.WithError(err).Error("Infrastructure not found")
+		return infra, err
+	}
+
+	err = c.validateNodesPatch(infra, patch)
+	if err != nil {
+		return infra, err
+	}
+
+	provider := infra.Provider
+	if patch.Credentials != nil {
+		provider.Credentials = patch.Credentials
+	}
+
+	deployer, err := c.findProvider(principal, infraID, provider)
+	if err != nil {
+		logger.WithError(err).Error("Can't find provider for infrastructure")
+		return infra, err
+	}
+
+	previousStatus := infra.Status
+	infra, err = c.Repository.UpdateInfrastructureStatus(infraID, ScalingStatus)
+	if err != nil {
+		return infra, err
+	}
+
+	var scaleErr error
+	if len(patch.Remove) > 0 {
+		c.reportProgress(progress, infraID, "removing nodes", nil)
+		var delErrors map[string]error
+		infra, delErrors = deployer.RemoveNodes(ctx, infra, patch.Remove)
+		for _, hostname := range patch.Remove {
+			if delErr, failed := delErrors[hostname]; failed {
+				c.recordEvent(principal, model.EventNodeFailed, infraID, hostname, delErr)
+			} else {
+				c.recordEvent(principal, model.EventNodeDeleted, infraID, hostname, nil)
+			}
+		}
+		if delErrors != nil && len(delErrors) > 0 {
+			for k, v := range delErrors {
+				logger.WithError(v).Errorf("Error removing node %s", k)
+			}
+			scaleErr = fmt.Errorf("Errors found removing nodes: %v", delErrors)
+		}
+	}
+
+	if scaleErr == nil && len(patch.Add) > 0 {
+		c.reportProgress(progress, infraID, "adding nodes", nil)
+		previous := infra
+		infra, scaleErr = deployer.AddNodes(ctx, infra, patch.Add)
+		if scaleErr != nil {
+			logger.WithError(scaleErr).Error("Error adding nodes")
+		}
+		recordDefinitions(&infra, patch.Add)
+		c.recordAddedNodes(principal, previous, infra, patch.Add, scaleErr)
+	}
+
+	infra.Status = previousStatus
+	infra, err = c.Repository.UpdateInfrastructure(infra)
+	if err != nil {
+		logger.WithError(err).Error("Error saving scaled infrastructure")
+		if scaleErr == nil {
+			scaleErr = err
+		}
+	}
+
+	if scaleErr != nil {
+		c.reportProgress(progress, infraID, "failed", scaleErr)
+		return infra, scaleErr
+	}
+
+	c.reportProgress(progress, infraID, "scaled", nil)
+	return infra, nil
+}
+
+// recordAddedNodes records the creation of the nodes that are new in the infrastructure and the failure of the resources which don't have a node
+func (c *Deployer) recordAddedNodes(principal string, previous, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType, cause error) {
+	resourceName := nodeResourceNames(infra.Name, resources)
+	created := make(map[string]bool)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		if _, found := previous.FindNode(node.Hostname); !found {
+			created[resourceName(node)] = true
+			c.recordEvent(principal, model.EventNodeCreated, infra.ID, node.Hostname, nil)
+		}
+	})
+
+	for _, resource := range resources {
+		if !created[resource.Name] {
+			c.recordEvent(principal, model.EventNodeFailed, infra.ID, resource.Name, cause)
+		}
+	}
+}
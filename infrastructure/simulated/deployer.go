@@ -0,0 +1,452 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package simulated
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DeploymentType = "simulated"
+
+	LatencyProperty     = "simulated.latency"
+	FailureRateProperty = "simulated.failure_rate"
+
+	LatencyDefaultValue     = "0s"
+	FailureRateDefaultValue = 0.0
+
+	// Extra properties of the infrastructure to override the configured latency and failure rate
+	InfraLatencyProperty     = "simulated_latency"
+	InfraFailureRateProperty = "simulated_failure_rate"
+
+	// FailProperty is an extra property of the resources to force the failure of their nodes
+	FailProperty = "simulated_fail"
+
+	DefaultUsername = "ubuntu"
+	DefaultCPU      = 2000
+	DefaultCores    = 1
+	DefaultRAM      = 1024
+	DefaultDisk     = 10240
+)
+
+// namespace is used to generate the identifiers of the simulated resources from their names
+var namespace = uuid.MustParse("6f0a4ad4-3b9e-4c55-9a1e-0f6b0c1d5e7a")
+
+var (
+	// servers are the nodes which have been simulated, indexed by infrastructure identifier and hostname. They are lost when the deployment engine is restarted.
+	servers     = make(map[string]map[string]model.NodeInfo)
+	serversLock sync.Mutex
+)
+
+type NodeCreationResult struct {
+	Info  model.NodeInfo
+	Error error
+}
+
+// SimulatedDeployer pretends to create virtual machines without accessing any provider. Identifiers and IPs of the nodes are derived from their hostnames, so they are the same every time an infrastructure is deployed.
+// Creating and deleting nodes takes the configured latency and nodes fail with the configured failure rate, always the same ones for the same rate.
+type SimulatedDeployer struct {
+	latency     time.Duration
+	failureRate float64
+}
+
+// NewDeployer creates a simulated deployer with the latency and failure rate of the configuration
+func NewDeployer() *SimulatedDeployer {
+	viper.SetDefault(LatencyProperty, LatencyDefaultValue)
+	viper.SetDefault(FailureRateProperty, FailureRateDefaultValue)
+	return &SimulatedDeployer{
+		latency:     viper.GetDuration(LatencyProperty),
+		failureRate: viper.GetFloat64(FailureRateProperty),
+	}
+}
+
+// withSettings returns a deployer with the latency and failure rate overriden by the extra properties of an infrastructure
+func (d SimulatedDeployer) withSettings(properties model.ExtraPropertiesType) (SimulatedDeployer, error) {
+	result := d
+	if value, ok := properties[InfraLatencyProperty]; ok {
+		latency, err := time.ParseDuration(value)
+		if err != nil {
+			return result, fmt.Errorf("Invalid value %s for %s: %w", value, InfraLatencyProperty, err)
+		}
+		result.latency = latency
+	}
+
+	if value, ok := properties[InfraFailureRateProperty]; ok {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return result, fmt.Errorf("Invalid value %s for %s. It must be a number between 0 and 1", value, InfraFailureRateProperty)
+		}
+		result.failureRate = rate
+	}
+
+	return result, nil
+}
+
+func (d SimulatedDeployer) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d.latency):
+		return nil
+	}
+}
+
+func (d SimulatedDeployer) fails(hostname string, resource model.ResourceType) bool {
+	return resource.ExtraProperties.GetBool(FailProperty) || utils.Fails(hostname, d.failureRate)
+}
+
+func identifier(name string) string {
+	return uuid.NewSHA1(namespace, []byte(name)).String()
+}
+
+// ipFor returns an IP of the 10.0.0.0/8 network derived from the hostname
+func ipFor(hostname string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(hostname))
+	sum := hash.Sum32()
+	return fmt.Sprintf("10.%d.%d.%d", (sum>>16)&0xff, (sum>>8)&0xff, sum%254+1)
+}
+
+func valueOrDefault(value, defaultValue int64) int64 {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func (d SimulatedDeployer) toNode(hostname string, resource model.ResourceType) model.NodeInfo {
+	result := model.NodeInfo{
+		Hostname:        hostname,
+		Role:            strings.ToLower(resource.Role),
+		CPU:             int(valueOrDefault(int64(resource.CPU), DefaultCPU)),
+		Cores:           int(valueOrDefault(int64(resource.Cores), DefaultCores)),
+		RAM:             valueOrDefault(resource.RAM, DefaultRAM) * 1024 * 1024,
+		IP:              resource.IP,
+		Username:        DefaultUsername,
+		UUID:            identifier(hostname),
+		DriveUUID:       identifier("boot-" + hostname),
+		DriveSize:       valueOrDefault(resource.Disk, DefaultDisk) * 1024 * 1024,
+		DataDrives:      make([]model.DriveInfo, len(resource.Drives)),
+		ResourceName:    resource.Name,
+		ExtraProperties: resource.ExtraProperties,
+	}
+
+	if result.IP == "" {
+		result.IP = ipFor(hostname)
+	}
+
+	for i, drive := range resource.Drives {
+		name := fmt.Sprintf("data-%s-%s", hostname, drive.Name)
+		result.DataDrives[i] = model.DriveInfo{
+			Name: name,
+			UUID: identifier(name),
+			Size: drive.Size * 1024 * 1024,
+		}
+	}
+
+	return result
+}
+
+// CreateServer simulates the creation of the node of a resource and sends the result to the channel
+func (d SimulatedDeployer) CreateServer(ctx context.Context, infraID, hostname string, resource model.ResourceType, c chan NodeCreationResult) {
+	node := d.toNode(hostname, resource)
+	err := d.wait(ctx)
+	if err == nil && d.fails(hostname, resource) {
+		err = fmt.Errorf("Simulated failure creating node %s", hostname)
+	}
+
+	if err == nil {
+		serversLock.Lock()
+		if servers[infraID] == nil {
+			servers[infraID] = make(map[string]model.NodeInfo)
+		}
+		servers[infraID][hostname] = node
+		serversLock.Unlock()
+	}
+
+	c <- NodeCreationResult{
+		Info:  node,
+		Error: err,
+	}
+}
+
+func (d SimulatedDeployer) createNodes(ctx context.Context, logger *log.Entry, infra *model.InfrastructureDeploymentInfo, resources []model.ResourceType) error {
+	resources, err := utils.ResolveFlavors(DeploymentType, resources)
+	if err != nil {
+		return err
+	}
+
+	c := make(chan NodeCreationResult, len(resources))
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return err
+		}
+		go d.CreateServer(ctx, infra.ID, hostname, resource, c)
+	}
+
+	var lastError error
+	for range resources {
+		result := <-c
+		if result.Error != nil {
+			logger.WithError(result.Error).Errorf("Error creating node %s", result.Info.Hostname)
+			lastError = result.Error
+			continue
+		}
+		infra.AddNode(result.Info)
+	}
+
+	return lastError
+}
+
+// ValidateInfrastructure checks the resources of the infrastructure and the simulation settings
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if infra.Name == "" {
+		return errors.New("Name is mandatory for each simulated infrastructure")
+	}
+
+	if len(infra.Resources) == 0 {
+		return fmt.Errorf("Infrastructure %s doesn't have any resources", infra.Name)
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if resource.Name == "" {
+			return errors.New("Name is mandatory for each resource")
+		}
+		if names[resource.Name] {
+			return fmt.Errorf("Resource name %s is duplicated", resource.Name)
+		}
+		names[resource.Name] = true
+	}
+
+	_, err := utils.ResolveFlavors(DeploymentType, infra.Resources)
+	if err != nil {
+		return err
+	}
+
+	_, err = SimulatedDeployer{}.withSettings(infra.ExtraProperties)
+	return err
+}
+
+// PlanInfrastructure returns the nodes that would be created. Since failures are deterministic, the nodes that would fail are reported as errors.
+func (d SimulatedDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	settings, err := d.withSettings(infra.ExtraProperties)
+	if err != nil {
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	resources, err := utils.ResolveFlavors(DeploymentType, infra.Resources)
+	if err != nil {
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			plan.AddError("%s", err.Error())
+			continue
+		}
+
+		if settings.fails(hostname, resource) {
+			plan.AddError("Creation of node %s will fail", hostname)
+		}
+
+		node := settings.toNode(hostname, resource)
+		nodePlan := model.NodePlan{
+			Hostname: node.Hostname,
+			Role:     node.Role,
+			IP:       node.IP,
+			CPU:      node.CPU,
+			Cores:    node.Cores,
+			RAM:      node.RAM,
+			Drives: []model.DrivePlan{
+				model.DrivePlan{
+					Name:   "boot-" + hostname,
+					Action: "clone",
+					Source: resource.ImageId,
+					Size:   node.DriveSize,
+				},
+			},
+		}
+		for _, drive := range node.DataDrives {
+			nodePlan.Drives = append(nodePlan.Drives, model.DrivePlan{
+				Name:   drive.Name,
+				Action: "create",
+				Size:   drive.Size,
+			})
+		}
+		plan.Nodes = append(plan.Nodes, nodePlan)
+	}
+
+	return plan, nil
+}
+
+func (d SimulatedDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+	deployment := model.InfrastructureDeploymentInfo{
+		ID:              infra.InfrastructureID(),
+		Name:            infra.Name,
+		Type:            DeploymentType,
+		Products:        make(map[string]interface{}),
+		ExtraProperties: infra.ExtraProperties,
+		Nodes:           make(map[string][]model.NodeInfo),
+		Status:          "creating",
+	}
+
+	if infra.Name == "" {
+		return deployment, errors.New("Name is mandatory for each simulated infrastructure")
+	}
+
+	logger := log.WithField("infrastructure", deployment.ID)
+
+	settings, err := d.withSettings(infra.ExtraProperties)
+	if err != nil {
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	err = settings.createNodes(ctx, logger, &deployment, infra.Resources)
+	if err != nil {
+		logger.WithError(err).Errorf("Deployment failed")
+		deployment.Status = "failed"
+		return deployment, err
+	}
+
+	logger.Infof("Nodes successfully simulated")
+	deployment.Status = "running"
+	return deployment, nil
+}
+
+// AddNodes simulates new nodes in an existing infrastructure
+func (d SimulatedDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return infra, err
+		}
+		if _, found := infra.FindNode(hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", hostname, infra.ID)
+		}
+	}
+
+	settings, err := d.withSettings(infra.ExtraProperties)
+	if err != nil {
+		return infra, err
+	}
+
+	err = settings.createNodes(ctx, log.WithField("infrastructure", infra.ID), &infra, resources)
+	return infra, err
+}
+
+func (d SimulatedDeployer) deleteNode(infraID, hostname string) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	delete(servers[infraID], hostname)
+	if len(servers[infraID]) == 0 {
+		delete(servers, infraID)
+	}
+}
+
+// RemoveNodes deletes the simulated nodes from an existing infrastructure given their hostnames
+func (d SimulatedDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	result := make(map[string]error)
+	settings, err := d.withSettings(infra.ExtraProperties)
+	if err != nil {
+		settings = d
+	}
+
+	for _, hostname := range hostnames {
+		if _, found := infra.FindNode(hostname); !found {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+			continue
+		}
+
+		if err := settings.wait(ctx); err != nil {
+			result[hostname] = err
+			continue
+		}
+
+		d.deleteNode(infra.ID, hostname)
+		infra.RemoveNode(hostname)
+	}
+
+	return infra, result
+}
+
+// DeleteInfrastructure deletes every simulated node of the infrastructure
+func (d SimulatedDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	settings, err := d.withSettings(infra.ExtraProperties)
+	if err != nil {
+		settings = d
+	}
+
+	if err := settings.wait(ctx); err != nil {
+		return map[string]error{infra.ID: err}
+	}
+
+	serversLock.Lock()
+	delete(servers, infra.ID)
+	serversLock.Unlock()
+	return nil
+}
+
+// CheckNodes reports as missing the nodes which are not simulated anymore, for example because the deployment engine has been restarted
+func (d SimulatedDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		server, found := servers[infra.ID][node.Hostname]
+		switch {
+		case !found:
+			result[node.Hostname] = model.NodeStatus{
+				Status:   model.NodeStatusMissing,
+				Problems: []string{fmt.Sprintf("Node %s is not simulated", node.Hostname)},
+			}
+		case server.IP != node.IP || server.UUID != node.UUID:
+			result[node.Hostname] = model.NodeStatus{
+				Status:   model.NodeStatusDrifted,
+				Problems: []string{fmt.Sprintf("Simulated node has IP %s and identifier %s", server.IP, server.UUID)},
+			}
+		default:
+			result[node.Hostname] = model.NodeStatus{Status: model.NodeStatusRunning}
+		}
+	})
+	return result, nil
+}
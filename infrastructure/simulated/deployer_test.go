@@ -0,0 +1,161 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package simulated
+
+import (
+	"context"
+	"deployment-engine/model"
+	"testing"
+	"time"
+)
+
+func testInfra(name string, properties model.ExtraPropertiesType, resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name:            name,
+		Provider:        model.CloudProviderInfo{APIType: DeploymentType},
+		Resources:       resources,
+		ExtraProperties: properties,
+	}
+}
+
+func TestDeployAndDeleteInfrastructure(t *testing.T) {
+	deployer := SimulatedDeployer{}
+	definition := testInfra("Test_Infra", model.ExtraPropertiesType{InfraLatencyProperty: "10ms"},
+		model.ResourceType{Name: "master", Role: "Master", Cores: 2, Drives: []model.Drive{model.Drive{Name: "data", Size: 1024}}},
+		model.ResourceType{Name: "slave", Role: "slave", IP: "192.168.1.10"})
+
+	start := time.Now()
+	infra, err := deployer.DeployInfrastructure(context.Background(), definition)
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("Latency of the infrastructure not simulated")
+	}
+
+	if infra.Status != "running" || infra.NumNodes() != 2 {
+		t.Fatalf("Unexpected infrastructure deployed: %v", infra)
+	}
+
+	master, _ := infra.FindNode("test-infra-master")
+	slave, _ := infra.FindNode("test-infra-slave")
+	if master.Role != "master" || master.Cores != 2 || master.RAM != DefaultRAM*1024*1024 || len(master.DataDrives) != 1 || master.DataDrives[0].Size != 1024*1024*1024 {
+		t.Fatalf("Unexpected master node: %v", master)
+	}
+
+	if slave.IP != "192.168.1.10" || master.IP == "" || master.UUID == slave.UUID {
+		t.Fatalf("Unexpected IPs or identifiers of nodes: %v %v", master, slave)
+	}
+
+	again, err := deployer.DeployInfrastructure(context.Background(), definition)
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure again: %s", err.Error())
+	}
+
+	masterAgain, _ := again.FindNode("test-infra-master")
+	if masterAgain.IP != master.IP || masterAgain.UUID != master.UUID || masterAgain.DataDrives[0].UUID != master.DataDrives[0].UUID || again.ID == infra.ID {
+		t.Fatalf("Simulated nodes are not deterministic: %v %v", master, masterAgain)
+	}
+
+	status, _ := deployer.CheckNodes(context.Background(), infra)
+	if status["test-infra-master"].Status != model.NodeStatusRunning {
+		t.Fatalf("Unexpected status of simulated node: %v", status)
+	}
+
+	infra, err = deployer.AddNodes(context.Background(), infra, []model.ResourceType{model.ResourceType{Name: "slave2"}})
+	if err != nil || infra.NumNodes() != 3 {
+		t.Fatalf("Error adding nodes: %v %v", err, infra.Nodes)
+	}
+
+	infra, errs := deployer.RemoveNodes(context.Background(), infra, []string{"test-infra-slave", "missing"})
+	if len(errs) != 1 || errs["missing"] == nil || infra.NumNodes() != 2 {
+		t.Fatalf("Unexpected result removing nodes: %v %v", errs, infra.Nodes)
+	}
+
+	if errs := deployer.DeleteInfrastructure(context.Background(), infra); len(errs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", errs)
+	}
+
+	status, _ = deployer.CheckNodes(context.Background(), infra)
+	if status["test-infra-master"].Status != model.NodeStatusMissing {
+		t.Fatalf("Deleted node still simulated: %v", status)
+	}
+
+	status, _ = deployer.CheckNodes(context.Background(), again)
+	if status["test-infra-master"].Status != model.NodeStatusRunning {
+		t.Fatalf("Nodes of other infrastructure deleted: %v", status)
+	}
+	deployer.DeleteInfrastructure(context.Background(), again)
+}
+
+func TestFailures(t *testing.T) {
+	deployer := SimulatedDeployer{}
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra("failed", nil,
+		model.ResourceType{Name: "ok"},
+		model.ResourceType{Name: "ko", ExtraProperties: model.ExtraPropertiesType{FailProperty: "true"}}))
+
+	if err == nil || infra.Status != "failed" || infra.NumNodes() != 1 {
+		t.Fatalf("Expected failure of one node but found %v %v", err, infra)
+	}
+	deployer.DeleteInfrastructure(context.Background(), infra)
+
+	resources := make([]model.ResourceType, 0)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		resources = append(resources, model.ResourceType{Name: name})
+	}
+
+	always := testInfra("always", model.ExtraPropertiesType{InfraFailureRateProperty: "1"}, resources...)
+	infra, err = deployer.DeployInfrastructure(context.Background(), always)
+	if err == nil || infra.NumNodes() != 0 {
+		t.Fatalf("Expected failure of every node but found %v %v", err, infra.Nodes)
+	}
+
+	half := testInfra("half", model.ExtraPropertiesType{InfraFailureRateProperty: "0.5"}, resources...)
+	plan, _ := deployer.PlanInfrastructure(context.Background(), half)
+	infra, _ = deployer.DeployInfrastructure(context.Background(), half)
+	if len(plan.Errors)+infra.NumNodes() != len(resources) {
+		t.Fatalf("Failures of plan %v don't match deployed nodes %v", plan.Errors, infra.Nodes)
+	}
+	deployer.DeleteInfrastructure(context.Background(), infra)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = deployer.DeployInfrastructure(ctx, testInfra("cancelled", model.ExtraPropertiesType{InfraLatencyProperty: "1h"}, model.ResourceType{Name: "node"}))
+	if err != context.Canceled {
+		t.Fatalf("Expected cancellation error but found %v", err)
+	}
+}
+
+func TestValidateInfrastructure(t *testing.T) {
+	if err := ValidateInfrastructure(testInfra("valid", nil, model.ResourceType{Name: "node"})); err != nil {
+		t.Fatalf("Valid infrastructure rejected: %s", err.Error())
+	}
+
+	for _, infra := range []model.InfrastructureType{
+		testInfra("", nil, model.ResourceType{Name: "node"}),
+		testInfra("empty", nil),
+		testInfra("duplicated", nil, model.ResourceType{Name: "node"}, model.ResourceType{Name: "node"}),
+		testInfra("latency", model.ExtraPropertiesType{InfraLatencyProperty: "soon"}, model.ResourceType{Name: "node"}),
+		testInfra("rate", model.ExtraPropertiesType{InfraFailureRateProperty: "2"}, model.ResourceType{Name: "node"}),
+		testInfra("flavor", nil, model.ResourceType{Name: "node", Type: "unknown"}),
+	} {
+		if err := ValidateInfrastructure(infra); err == nil {
+			t.Fatalf("Invalid infrastructure accepted: %v", infra)
+		}
+	}
+}
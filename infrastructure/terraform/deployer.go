@@ -0,0 +1,556 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package terraform
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	DeploymentType    = "terraform"
+	CredentialsFormat = "terraform"
+
+	TemplatesFolderProperty     = "terraform.folders.templates"
+	TemplatesFolderDefaultValue = "terraform"
+
+	UsernameProperty = "terraform_username"
+	DefaultUsername  = "root"
+)
+
+// Credentials are passed as environment variables to the terraform commands, so they can be read by the terraform providers or as variables of the templates if they have the TF_VAR_ prefix
+type Credentials map[string]string
+
+// TerraformDeployer creates infrastructures by rendering a template of terraform files for a provider and applying them. The working folder and the state of each infrastructure are kept in the deployments folder.
+type TerraformDeployer struct {
+	templateFolder    string
+	deploymentsFolder string
+	credentials       Credentials
+	publicKey         string
+	execute           commandExecutor
+}
+
+// TemplatesFolder returns the folder in which the templates of the providers are, as configured in the deployment engine
+func TemplatesFolder() string {
+	viper.SetDefault(TemplatesFolderProperty, TemplatesFolderDefaultValue)
+	return viper.GetString(TemplatesFolderProperty)
+}
+
+// templateFolder returns the folder of the template of a provider, whose API endpoint is the name of the template
+func templateFolder(provider model.CloudProviderInfo) (string, error) {
+	name := provider.APIEndpoint
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return "", fmt.Errorf("Invalid template name %s. The API endpoint of terraform providers must be the name of a folder of the templates folder", name)
+	}
+
+	folder := filepath.Join(TemplatesFolder(), name)
+	info, err := os.Stat(folder)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("Can't find template %s in folder %s", name, TemplatesFolder())
+	}
+	return folder, nil
+}
+
+// NewDeployer creates a deployer for the template of the provider
+func NewDeployer(provider model.CloudProviderInfo, credentials Credentials, deploymentsFolder, publicKeyPath string) (*TerraformDeployer, error) {
+	folder, err := templateFolder(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyRaw, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		log.WithError(err).Error("Error reading public key")
+		return nil, err
+	}
+
+	return &TerraformDeployer{
+		templateFolder:    folder,
+		deploymentsFolder: deploymentsFolder,
+		credentials:       credentials,
+		publicKey:         string(pubKeyRaw),
+		execute:           executeTerraform,
+	}, nil
+}
+
+func (d TerraformDeployer) workingFolder(infraID string) string {
+	return filepath.Join(d.deploymentsFolder, infraID, workingFolderName)
+}
+
+func (d TerraformDeployer) environment() map[string]string {
+	env := map[string]string{
+		"TF_IN_AUTOMATION": "1",
+		"TF_INPUT":         "0",
+	}
+	for k, v := range d.credentials {
+		env[k] = v
+	}
+	return env
+}
+
+func (d TerraformDeployer) run(ctx context.Context, logger *log.Entry, folder string, args ...string) ([]byte, error) {
+	output, err := d.execute(ctx, logger, folder, d.environment(), args...)
+	if err != nil {
+		return output, fmt.Errorf("Error executing terraform %s: %w", args[0], err)
+	}
+	return output, nil
+}
+
+func (d TerraformDeployer) templateData(infraID string, infra model.InfrastructureType) (templateData, error) {
+	data := templateData{
+		ID:              infraID,
+		Name:            infra.Name,
+		PublicKey:       d.publicKey,
+		ExtraProperties: infra.ExtraProperties,
+		Resources:       make([]templateResource, len(infra.Resources)),
+	}
+
+	for i, resource := range infra.Resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return data, err
+		}
+		data.Resources[i] = templateResource{
+			ResourceType: resource,
+			Hostname:     hostname,
+		}
+	}
+
+	return data, nil
+}
+
+// render saves the definition of the infrastructure in its working folder and renders the template with it
+func (d TerraformDeployer) render(infraID string, infra model.InfrastructureType) error {
+	folder := d.workingFolder(infraID)
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("Error creating working folder %s: %w", folder, err)
+	}
+
+	data, err := d.templateData(infraID, infra)
+	if err != nil {
+		return err
+	}
+
+	definition, err := json.Marshal(infra)
+	if err != nil {
+		return fmt.Errorf("Error marshaling infrastructure definition: %w", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(folder, definitionFileName), definition, 0644)
+	if err != nil {
+		return fmt.Errorf("Error saving infrastructure definition: %w", err)
+	}
+
+	return renderTemplate(d.templateFolder, folder, data)
+}
+
+// readDefinition reads the definition of the infrastructure saved in its working folder
+func (d TerraformDeployer) readDefinition(infraID string) (model.InfrastructureType, error) {
+	var infra model.InfrastructureType
+	content, err := ioutil.ReadFile(filepath.Join(d.workingFolder(infraID), definitionFileName))
+	if err != nil {
+		return infra, fmt.Errorf("Error reading definition of infrastructure %s: %w", infraID, err)
+	}
+
+	err = json.Unmarshal(content, &infra)
+	if err != nil {
+		return infra, fmt.Errorf("Error decoding definition of infrastructure %s: %w", infraID, err)
+	}
+	return infra, nil
+}
+
+// apply runs terraform init and apply in the working folder of the infrastructure
+func (d TerraformDeployer) apply(ctx context.Context, logger *log.Entry, infraID string) error {
+	folder := d.workingFolder(infraID)
+	_, err := d.run(ctx, logger, folder, "init", "-input=false")
+	if err != nil {
+		return err
+	}
+
+	output, err := d.run(ctx, logger, folder, "apply", "-input=false", "-auto-approve")
+	logger.Debug(string(output))
+	return err
+}
+
+// readOutputs reads the outputs of the state of the infrastructure
+func (d TerraformDeployer) readOutputs(ctx context.Context, logger *log.Entry, infraID string) ([]nodeOutput, error) {
+	output, err := d.run(ctx, logger, d.workingFolder(infraID), "output", "-json")
+	if err != nil {
+		return nil, err
+	}
+	return parseNodes(output)
+}
+
+// transformNode builds the information of a node from the terraform output, completing it with the resource of the infrastructure definition from which it was created
+func (d TerraformDeployer) transformNode(infra model.InfrastructureType, data templateData, output nodeOutput) model.NodeInfo {
+	result := model.NodeInfo{
+		Hostname:     output.Hostname,
+		Role:         output.Role,
+		IP:           output.IP,
+		UUID:         output.UUID,
+		Username:     output.Username,
+		DriveUUID:    output.DriveUUID,
+		DataDrives:   make([]model.DriveInfo, len(output.DataDrives)),
+		ResourceName: output.Resource,
+	}
+
+	if result.Username == "" {
+		result.Username = DefaultUsername
+		if username, ok := infra.ExtraProperties[UsernameProperty]; ok && username != "" {
+			result.Username = username
+		}
+	}
+
+	var resource *model.ResourceType
+	for i := range data.Resources {
+		if data.Resources[i].Name == output.Resource || data.Resources[i].Hostname == output.Hostname {
+			resource = &data.Resources[i].ResourceType
+		}
+	}
+
+	drives := make(map[string]model.Drive)
+	if resource != nil {
+		result.ResourceName = resource.Name
+		result.CPU = resource.CPU
+		result.Cores = resource.Cores
+		result.RAM = resource.RAM * 1024 * 1024
+		result.DriveSize = resource.Disk * 1024 * 1024
+		result.ExtraProperties = resource.ExtraProperties
+		if result.Role == "" {
+			result.Role = resource.Role
+		}
+		for _, drive := range resource.Drives {
+			drives[drive.Name] = drive
+		}
+	}
+
+	for i, drive := range output.DataDrives {
+		result.DataDrives[i] = model.DriveInfo{
+			Name: drive.Name,
+			UUID: drive.UUID,
+			Size: drives[drive.Name].Size * 1024 * 1024,
+		}
+	}
+
+	result.Role = strings.ToLower(result.Role)
+	return result
+}
+
+// refreshNodes replaces the nodes of the infrastructure with the ones in the outputs of its state
+func (d TerraformDeployer) refreshNodes(ctx context.Context, logger *log.Entry, deployment *model.InfrastructureDeploymentInfo, infra model.InfrastructureType) error {
+	outputs, err := d.readOutputs(ctx, logger, deployment.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := d.templateData(deployment.ID, infra)
+	if err != nil {
+		return err
+	}
+
+	deployment.Nodes = make(map[string][]model.NodeInfo)
+	for _, output := range outputs {
+		deployment.AddNode(d.transformNode(infra, data, output))
+	}
+	return nil
+}
+
+// ValidateInfrastructure checks that the template of the provider exists and that the resources of the infrastructure can be rendered
+func ValidateInfrastructure(infra model.InfrastructureType) error {
+	if infra.Name == "" {
+		return errors.New("Name is mandatory for each terraform infrastructure")
+	}
+
+	if len(infra.Resources) == 0 {
+		return fmt.Errorf("Infrastructure %s doesn't have any resources", infra.Name)
+	}
+
+	_, err := templateFolder(infra.Provider)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, resource := range infra.Resources {
+		if resource.Name == "" {
+			return errors.New("Name is mandatory for each resource")
+		}
+		if names[resource.Name] {
+			return fmt.Errorf("Resource name %s is duplicated", resource.Name)
+		}
+		names[resource.Name] = true
+	}
+
+	return nil
+}
+
+// PlanInfrastructure renders the template in a temporary folder to check that it's valid. Nothing is run with terraform since it would need to download the providers of the template.
+func (d TerraformDeployer) PlanInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructurePlan, error) {
+	plan := model.InfrastructurePlan{
+		Name:     infra.Name,
+		Provider: DeploymentType,
+		Nodes:    make([]model.NodePlan, 0, len(infra.Resources)),
+	}
+
+	data, err := d.templateData("plan", infra)
+	if err != nil {
+		plan.AddError("%s", err.Error())
+		return plan, nil
+	}
+
+	folder, err := ioutil.TempDir("", "terraform-plan")
+	if err != nil {
+		return plan, fmt.Errorf("Error creating temporary folder to render template: %w", err)
+	}
+	defer os.RemoveAll(folder)
+
+	err = renderTemplate(d.templateFolder, folder, data)
+	if err != nil {
+		plan.AddError("Error rendering template: %s", err.Error())
+	}
+
+	for _, resource := range data.Resources {
+		node := model.NodePlan{
+			Hostname: resource.Hostname,
+			Role:     strings.ToLower(resource.Role),
+			IP:       resource.IP,
+			CPU:      resource.CPU,
+			Cores:    resource.Cores,
+			RAM:      resource.RAM * 1024 * 1024,
+			Drives: []model.DrivePlan{
+				model.DrivePlan{
+					Name:   resource.Hostname,
+					Action: "clone",
+					Source: resource.ImageId,
+					Size:   resource.Disk * 1024 * 1024,
+				},
+			},
+		}
+		for _, drive := range resource.Drives {
+			node.Drives = append(node.Drives, model.DrivePlan{
+				Name:   drive.Name,
+				Action: "create",
+				Size:   drive.Size * 1024 * 1024,
+			})
+		}
+		plan.Nodes = append(plan.Nodes, node)
+	}
+
+	return plan, nil
+}
+
+// DeployInfrastructure renders the template in the working folder of a new infrastructure and applies it. The nodes are read from the nodes output of the template.
+func (d TerraformDeployer) DeployInfrastructure(ctx context.Context, infra model.InfrastructureType) (model.InfrastructureDeploymentInfo, error) {
+	deployment := model.InfrastructureDeploymentInfo{
+		ID:              infra.InfrastructureID(),
+		Name:            infra.Name,
+		Type:            DeploymentType,
+		Products:        make(map[string]interface{}),
+		ExtraProperties: infra.ExtraProperties,
+		Nodes:           make(map[string][]model.NodeInfo),
+		Status:          "creating",
+	}
+
+	logger := log.WithField("infrastructure", deployment.ID)
+
+	err := d.render(deployment.ID, infra)
+	if err != nil {
+		deployment.Status = "failed"
+		return deployment, utils.WrapLogAndReturnError(logger, "Error rendering terraform template", err)
+	}
+
+	err = d.apply(ctx, logger, deployment.ID)
+	if err != nil {
+		deployment.Status = "failed"
+		d.destroyFailed(logger, &deployment, infra)
+		return deployment, utils.WrapLogAndReturnError(logger, "Error applying terraform template", err)
+	}
+
+	err = d.refreshNodes(ctx, logger, &deployment, infra)
+	if err != nil {
+		deployment.Status = "failed"
+		return deployment, utils.WrapLogAndReturnError(logger, "Error reading nodes of terraform state", err)
+	}
+
+	logger.Infof("Nodes successfully created")
+	deployment.Status = "running"
+	return deployment, nil
+}
+
+// destroyFailed destroys the resources created by a failed apply of a new infrastructure and removes its working folder. If they can't be destroyed, the nodes in the partial state are recorded in the infrastructure so they can be deleted later.
+// The context of the deployment is not used so the resources are destroyed even if it was cancelled.
+func (d TerraformDeployer) destroyFailed(logger *log.Entry, deployment *model.InfrastructureDeploymentInfo, infra model.InfrastructureType) {
+	ctx := context.Background()
+	errs := d.DeleteInfrastructure(ctx, *deployment)
+	if len(errs) == 0 {
+		err := os.RemoveAll(d.workingFolder(deployment.ID))
+		if err != nil {
+			logger.WithError(err).Error("Error removing working folder of failed infrastructure")
+		}
+		return
+	}
+
+	err := d.refreshNodes(ctx, logger, deployment, infra)
+	if err != nil {
+		logger.WithError(err).Error("Error reading nodes of partial terraform state")
+	}
+}
+
+// update renders the template with a new definition of the infrastructure and applies it. If it fails, the previous definition is rendered again so that the next apply removes any resource created partially.
+func (d TerraformDeployer) update(ctx context.Context, logger *log.Entry, deployment *model.InfrastructureDeploymentInfo, previous, updated model.InfrastructureType) error {
+	err := d.render(deployment.ID, updated)
+	if err == nil {
+		err = d.apply(ctx, logger, deployment.ID)
+	}
+
+	if err != nil {
+		renderErr := d.render(deployment.ID, previous)
+		if renderErr != nil {
+			logger.WithError(renderErr).Error("Error restoring previous infrastructure definition")
+		}
+		return err
+	}
+
+	return d.refreshNodes(ctx, logger, deployment, updated)
+}
+
+// AddNodes adds the resources to the definition of the infrastructure and applies the template again
+func (d TerraformDeployer) AddNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, resources []model.ResourceType) (model.InfrastructureDeploymentInfo, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	definition, err := d.readDefinition(infra.ID)
+	if err != nil {
+		return infra, err
+	}
+
+	for _, resource := range resources {
+		hostname, err := utils.ClearHostName(infra.Name + "-" + resource.Name)
+		if err != nil {
+			return infra, err
+		}
+		if _, found := infra.FindNode(hostname); found {
+			return infra, fmt.Errorf("A node with hostname %s already exists in infrastructure %s", hostname, infra.ID)
+		}
+	}
+
+	updated := definition
+	updated.Resources = append(append(make([]model.ResourceType, 0, len(definition.Resources)+len(resources)), definition.Resources...), resources...)
+
+	logger.Infof("Adding %d nodes", len(resources))
+	err = d.update(ctx, logger, &infra, definition, updated)
+	if err != nil {
+		logger.WithError(err).Error("Error adding nodes")
+		return infra, err
+	}
+
+	logger.Info("Nodes successfully added")
+	return infra, nil
+}
+
+// RemoveNodes removes the resources of the nodes from the definition of the infrastructure and applies the template again
+func (d TerraformDeployer) RemoveNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo, hostnames []string) (model.InfrastructureDeploymentInfo, map[string]error) {
+	logger := log.WithField("infrastructure", infra.ID)
+	result := make(map[string]error)
+
+	definition, err := d.readDefinition(infra.ID)
+	if err != nil {
+		for _, hostname := range hostnames {
+			result[hostname] = err
+		}
+		return infra, result
+	}
+
+	data, err := d.templateData(infra.ID, definition)
+	if err != nil {
+		for _, hostname := range hostnames {
+			result[hostname] = err
+		}
+		return infra, result
+	}
+
+	removed := make(map[string]bool)
+	for _, hostname := range hostnames {
+		node, found := infra.FindNode(hostname)
+		if !found {
+			result[hostname] = fmt.Errorf("Can't find node %s in infrastructure %s", hostname, infra.ID)
+			continue
+		}
+		removed[node.ResourceName] = true
+		removed[node.Hostname] = true
+	}
+
+	updated := definition
+	updated.Resources = make([]model.ResourceType, 0, len(definition.Resources))
+	for _, resource := range data.Resources {
+		if !removed[resource.Name] && !removed[resource.Hostname] {
+			updated.Resources = append(updated.Resources, resource.ResourceType)
+		}
+	}
+
+	if len(updated.Resources) == len(definition.Resources) {
+		return infra, result
+	}
+
+	err = d.update(ctx, logger, &infra, definition, updated)
+	if err != nil {
+		logger.WithError(err).Error("Error removing nodes")
+		for _, hostname := range hostnames {
+			if _, ok := result[hostname]; !ok {
+				result[hostname] = err
+			}
+		}
+	}
+
+	return infra, result
+}
+
+// DeleteInfrastructure destroys the resources in the terraform state of the infrastructure. The working folder is kept with the empty state.
+func (d TerraformDeployer) DeleteInfrastructure(ctx context.Context, infra model.InfrastructureDeploymentInfo) map[string]error {
+	logger := log.WithField("infrastructure", infra.ID)
+	folder := d.workingFolder(infra.ID)
+
+	if _, err := os.Stat(folder); os.IsNotExist(err) {
+		logger.Info("Infrastructure doesn't have a terraform working folder. Nothing to destroy")
+		return nil
+	}
+
+	_, err := d.run(ctx, logger, folder, "init", "-input=false")
+	if err == nil {
+		var output []byte
+		output, err = d.run(ctx, logger, folder, "destroy", "-input=false", "-auto-approve")
+		logger.Debug(string(output))
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Error destroying infrastructure")
+		return map[string]error{infra.ID: err}
+	}
+
+	return nil
+}
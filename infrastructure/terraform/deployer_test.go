@@ -0,0 +1,365 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package terraform
+
+import (
+	"context"
+	"deployment-engine/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	testTemplate  = "fake"
+	testPublicKey = "ssh-rsa AAAA test"
+
+	// nodesTemplate renders the nodes that the fake terraform will output after applying the template
+	nodesTemplate = `[{{range $i, $r := .Resources}}{{if $i}},{{end}}
+{"hostname": "{{$r.Hostname}}", "resource": "{{$r.Name}}", "ip": "10.0.0.{{$i}}", "uuid": "{{$r.Hostname}}-{{$.ID}}", "type": "{{$r.Type}}", "key": {{json $.PublicKey}}
+{{- if $r.Drives}}, "data_drives": [{{range $j, $d := $r.Drives}}{{if $j}},{{end}}{"name": "{{$d.Name}}", "uuid": "{{$r.Hostname}}-{{$d.Name}}"}{{end}}]{{end}}}
+{{- end}}]
+`
+)
+
+// fakeTerraform simulates terraform commands keeping as state the nodes rendered by the test template. Applying a template with a node of type fail keeps the other nodes in the state and returns an error. Destroying fails if failDestroy is set.
+type fakeTerraform struct {
+	commands    []string
+	failDestroy bool
+}
+
+func (f *fakeTerraform) execute(ctx context.Context, logger *log.Entry, dir string, env map[string]string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, args[0])
+
+	if env["TF_VAR_token"] != "secret" || env["TF_IN_AUTOMATION"] != "1" {
+		return nil, errors.New("Credentials not passed in environment")
+	}
+
+	statePath := filepath.Join(dir, "terraform.tfstate")
+	switch args[0] {
+	case "init", "refresh":
+		return nil, nil
+	case "apply":
+		nodes, err := ioutil.ReadFile(filepath.Join(dir, "nodes.json"))
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(nodes), `"type": "fail"`) {
+			return nil, writePartialState(statePath, nodes)
+		}
+		return nil, ioutil.WriteFile(statePath, nodes, 0644)
+	case "destroy":
+		if f.failDestroy {
+			return nil, errors.New("exit status 1")
+		}
+		return nil, ioutil.WriteFile(statePath, []byte("[]"), 0644)
+	case "output":
+		state, err := ioutil.ReadFile(statePath)
+		if os.IsNotExist(err) {
+			return []byte("{}"), nil
+		}
+		return []byte(fmt.Sprintf(`{"nodes": {"sensitive": false, "type": ["list"], "value": %s}}`, state)), err
+	}
+	return nil, fmt.Errorf("Unexpected command %v", args)
+}
+
+// writePartialState writes in the state the nodes whose type isn't fail and returns the error of the failed apply
+func writePartialState(statePath string, nodes []byte) error {
+	var all []map[string]interface{}
+	err := json.Unmarshal(nodes, &all)
+	if err != nil {
+		return err
+	}
+
+	created := make([]map[string]interface{}, 0, len(all))
+	for _, node := range all {
+		if node["type"] != "fail" {
+			created = append(created, node)
+		}
+	}
+
+	state, err := json.Marshal(created)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(statePath, state, 0644)
+	if err != nil {
+		return err
+	}
+	return errors.New("exit status 1")
+}
+
+func setupTemplates(t *testing.T) (string, func()) {
+	baseFolder, err := ioutil.TempDir("", "terraform-test")
+	if err != nil {
+		t.Fatalf("Error creating temporary folder: %s", err.Error())
+	}
+
+	folder := filepath.Join(baseFolder, "templates", testTemplate)
+	os.MkdirAll(filepath.Join(folder, "modules"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(folder, "modules", "main.tf"), []byte("# static file"), 0644)
+	ioutil.WriteFile(filepath.Join(folder, "nodes.json.tmpl"), []byte(nodesTemplate), 0644)
+
+	previous := viper.GetString(TemplatesFolderProperty)
+	viper.Set(TemplatesFolderProperty, filepath.Join(baseFolder, "templates"))
+	return baseFolder, func() {
+		viper.Set(TemplatesFolderProperty, previous)
+		os.RemoveAll(baseFolder)
+	}
+}
+
+func newTestDeployer(baseFolder string) (TerraformDeployer, *fakeTerraform) {
+	fake := &fakeTerraform{}
+	return TerraformDeployer{
+		templateFolder:    filepath.Join(baseFolder, "templates", testTemplate),
+		deploymentsFolder: filepath.Join(baseFolder, "deployments"),
+		credentials:       Credentials{"TF_VAR_token": "secret"},
+		publicKey:         testPublicKey,
+		execute:           fake.execute,
+	}, fake
+}
+
+func testInfra(resources ...model.ResourceType) model.InfrastructureType {
+	return model.InfrastructureType{
+		Name:      "Test_Infra",
+		Provider:  model.CloudProviderInfo{APIType: DeploymentType, APIEndpoint: testTemplate},
+		Resources: resources,
+	}
+}
+
+func TestDeployInfrastructure(t *testing.T) {
+	baseFolder, cleanup := setupTemplates(t)
+	defer cleanup()
+
+	deployer, fake := newTestDeployer(baseFolder)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{
+			Name:  "master",
+			Role:  "Master",
+			Cores: 2,
+			RAM:   2048,
+			Disk:  10240,
+			Drives: []model.Drive{
+				model.Drive{Name: "data", Size: 1024},
+			},
+		},
+		model.ResourceType{Name: "slave", Role: "slave"}))
+
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	if infra.Status != "running" || infra.NumNodes() != 2 {
+		t.Fatalf("Unexpected infrastructure deployed: %v", infra)
+	}
+
+	master, found := infra.FindNode("test-infra-master")
+	if !found {
+		t.Fatalf("Can't find master node in %v", infra.Nodes)
+	}
+
+	if master.Role != "master" || master.IP != "10.0.0.0" || master.UUID != "test-infra-master-"+infra.ID || master.Username != DefaultUsername {
+		t.Fatalf("Unexpected master node: %v", master)
+	}
+
+	if master.Cores != 2 || master.RAM != 2048*1024*1024 || master.DriveSize != 10240*1024*1024 || master.ResourceName != "master" {
+		t.Fatalf("Master node not completed with its resource: %v", master)
+	}
+
+	if len(master.DataDrives) != 1 || master.DataDrives[0].UUID != "test-infra-master-data" || master.DataDrives[0].Size != 1024*1024*1024 {
+		t.Fatalf("Unexpected data drives of master node: %v", master.DataDrives)
+	}
+
+	workingFolder := filepath.Join(baseFolder, "deployments", infra.ID, "terraform")
+	if _, err := os.Stat(filepath.Join(workingFolder, "modules", "main.tf")); err != nil {
+		t.Fatalf("Static file of template not copied to working folder: %s", err.Error())
+	}
+
+	rendered, _ := ioutil.ReadFile(filepath.Join(workingFolder, "nodes.json"))
+	if !strings.Contains(string(rendered), `"key": "ssh-rsa AAAA test"`) {
+		t.Fatalf("Public key not rendered in template: %s", rendered)
+	}
+
+	if strings.Join(fake.commands, ",") != "init,apply,output" {
+		t.Fatalf("Unexpected terraform commands executed: %v", fake.commands)
+	}
+
+	infra, err = deployer.AddNodes(context.Background(), infra, []model.ResourceType{model.ResourceType{Name: "slave2", Role: "slave"}})
+	if err != nil {
+		t.Fatalf("Error adding nodes: %s", err.Error())
+	}
+
+	if _, found := infra.FindNode("test-infra-slave2"); !found || infra.NumNodes() != 3 {
+		t.Fatalf("Node not added to infrastructure: %v", infra.Nodes)
+	}
+
+	_, err = deployer.AddNodes(context.Background(), infra, []model.ResourceType{model.ResourceType{Name: "slave3", Type: "fail"}})
+	if err == nil {
+		t.Fatal("Expected error adding node that fails")
+	}
+
+	definition, err := deployer.readDefinition(infra.ID)
+	if err != nil || len(definition.Resources) != 3 {
+		t.Fatalf("Definition of infrastructure not restored after failure: %v %v", definition.Resources, err)
+	}
+
+	infra, errs := deployer.RemoveNodes(context.Background(), infra, []string{"test-infra-slave", "missing"})
+	if len(errs) != 1 || errs["missing"] == nil {
+		t.Fatalf("Unexpected errors removing nodes: %v", errs)
+	}
+
+	if _, found := infra.FindNode("test-infra-slave"); found || infra.NumNodes() != 2 {
+		t.Fatalf("Node not removed from infrastructure: %v", infra.Nodes)
+	}
+
+	deleteErrs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(deleteErrs) > 0 {
+		t.Fatalf("Errors deleting infrastructure: %v", deleteErrs)
+	}
+
+	state, _ := ioutil.ReadFile(filepath.Join(workingFolder, "terraform.tfstate"))
+	if string(state) != "[]" {
+		t.Fatalf("Resources left in state after deleting infrastructure: %s", state)
+	}
+}
+
+func TestDeployInfrastructureFailure(t *testing.T) {
+	baseFolder, cleanup := setupTemplates(t)
+	defer cleanup()
+
+	deployer, fake := newTestDeployer(baseFolder)
+	definition := testInfra(model.ResourceType{Name: "master"}, model.ResourceType{Name: "slave", Type: "fail"})
+	infra, err := deployer.DeployInfrastructure(context.Background(), definition)
+	if err == nil {
+		t.Fatal("Expected error deploying failing infrastructure")
+	}
+
+	if infra.ID == "" || infra.Status != "failed" || infra.NumNodes() != 0 {
+		t.Fatalf("Unexpected failed infrastructure: %v", infra)
+	}
+
+	if fake.commands[len(fake.commands)-1] != "destroy" {
+		t.Fatalf("Resources created by failed apply not destroyed: %v", fake.commands)
+	}
+
+	if _, err := os.Stat(deployer.workingFolder(infra.ID)); !os.IsNotExist(err) {
+		t.Fatalf("Working folder of failed infrastructure not removed: %v", err)
+	}
+
+	fake.failDestroy = true
+	infra, err = deployer.DeployInfrastructure(context.Background(), definition)
+	if err == nil {
+		t.Fatal("Expected error deploying failing infrastructure")
+	}
+
+	if _, found := infra.FindNode("test-infra-master"); !found || infra.NumNodes() != 1 {
+		t.Fatalf("Nodes of partial state not recorded in failed infrastructure: %v", infra)
+	}
+
+	errs := deployer.DeleteInfrastructure(context.Background(), infra)
+	if len(errs) != 1 || errs[infra.ID] == nil {
+		t.Fatalf("Expected error destroying infrastructure but found %v", errs)
+	}
+
+	errs = deployer.DeleteInfrastructure(context.Background(), model.InfrastructureDeploymentInfo{ID: "unknown"})
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors deleting infrastructure without working folder: %v", errs)
+	}
+}
+
+func TestCheckNodes(t *testing.T) {
+	baseFolder, cleanup := setupTemplates(t)
+	defer cleanup()
+
+	deployer, _ := newTestDeployer(baseFolder)
+	infra, err := deployer.DeployInfrastructure(context.Background(), testInfra(
+		model.ResourceType{Name: "running"},
+		model.ResourceType{Name: "drifted"},
+		model.ResourceType{Name: "missing"}))
+	if err != nil {
+		t.Fatalf("Error deploying infrastructure: %s", err.Error())
+	}
+
+	statePath := filepath.Join(baseFolder, "deployments", infra.ID, "terraform", "terraform.tfstate")
+	state := fmt.Sprintf(`[{"hostname": "test-infra-running", "ip": "10.0.0.0", "uuid": "test-infra-running-%s"},
+		{"hostname": "test-infra-drifted", "ip": "10.0.0.10", "uuid": "test-infra-drifted-%s"}]`, infra.ID, infra.ID)
+	ioutil.WriteFile(statePath, []byte(state), 0644)
+
+	result, err := deployer.CheckNodes(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error checking nodes: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"test-infra-running": model.NodeStatusRunning,
+		"test-infra-drifted": model.NodeStatusDrifted,
+		"test-infra-missing": model.NodeStatusMissing,
+	}
+	for hostname, status := range expected {
+		if result[hostname].Status != status {
+			t.Fatalf("Expected status %s for node %s but found %v", status, hostname, result[hostname])
+		}
+	}
+}
+
+func TestPlanAndValidateInfrastructure(t *testing.T) {
+	baseFolder, cleanup := setupTemplates(t)
+	defer cleanup()
+
+	deployer, _ := newTestDeployer(baseFolder)
+	infra := testInfra(model.ResourceType{Name: "master", RAM: 1024, Drives: []model.Drive{model.Drive{Name: "data", Size: 1024}}})
+	plan, err := deployer.PlanInfrastructure(context.Background(), infra)
+	if err != nil {
+		t.Fatalf("Error planning infrastructure: %s", err.Error())
+	}
+
+	if len(plan.Errors) > 0 || len(plan.Nodes) != 1 || plan.Nodes[0].Hostname != "test-infra-master" || len(plan.Nodes[0].Drives) != 2 {
+		t.Fatalf("Unexpected plan: %v", plan)
+	}
+
+	ioutil.WriteFile(filepath.Join(deployer.templateFolder, "broken.tf.tmpl"), []byte("{{.Unknown}}"), 0644)
+	plan, _ = deployer.PlanInfrastructure(context.Background(), infra)
+	if len(plan.Errors) != 1 {
+		t.Fatalf("Expected error rendering broken template but found %v", plan.Errors)
+	}
+
+	if err := ValidateInfrastructure(infra); err != nil {
+		t.Fatalf("Valid infrastructure rejected: %s", err.Error())
+	}
+
+	for _, endpoint := range []string{"", "missing", "../templates", ".."} {
+		invalid := infra
+		invalid.Provider.APIEndpoint = endpoint
+		if err := ValidateInfrastructure(invalid); err == nil {
+			t.Fatalf("Infrastructure with template %s accepted", endpoint)
+		}
+	}
+
+	duplicated := testInfra(model.ResourceType{Name: "node"}, model.ResourceType{Name: "node"})
+	if err := ValidateInfrastructure(duplicated); err == nil {
+		t.Fatal("Infrastructure with duplicated resources accepted")
+	}
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package terraform
+
+import (
+	"context"
+	"deployment-engine/model"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func checkNode(node model.NodeInfo, output nodeOutput, found bool) model.NodeStatus {
+	if !found {
+		return model.NodeStatus{
+			Status:   model.NodeStatusMissing,
+			Problems: []string{fmt.Sprintf("Node %s is not in the terraform state anymore", node.Hostname)},
+		}
+	}
+
+	status := model.NodeStatus{
+		Status: model.NodeStatusRunning,
+	}
+
+	if output.UUID != node.UUID {
+		status.Problems = append(status.Problems, fmt.Sprintf("Node has been replaced by %s", output.UUID))
+	}
+
+	if output.IP != node.IP {
+		status.Problems = append(status.Problems, fmt.Sprintf("IP of the node has changed to %s", output.IP))
+	}
+
+	if len(status.Problems) > 0 {
+		status.Status = model.NodeStatusDrifted
+	}
+
+	return status
+}
+
+// CheckNodes refreshes the terraform state of the infrastructure and compares its nodes output with the nodes recorded
+func (d TerraformDeployer) CheckNodes(ctx context.Context, infra model.InfrastructureDeploymentInfo) (map[string]model.NodeStatus, error) {
+	logger := log.WithField("infrastructure", infra.ID)
+
+	_, err := d.run(ctx, logger, d.workingFolder(infra.ID), "refresh", "-input=false")
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := d.readOutputs(ctx, logger, infra.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]nodeOutput)
+	for _, output := range outputs {
+		current[output.Hostname] = output
+	}
+
+	result := make(map[string]model.NodeStatus)
+	infra.ForEachNode(func(node model.NodeInfo) {
+		output, found := current[node.Hostname]
+		result[node.Hostname] = checkNode(node, output, found)
+	})
+	return result, nil
+}
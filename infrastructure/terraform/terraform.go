@@ -0,0 +1,167 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/utils"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// NodesOutput is the output of the templates with the list of nodes created
+	NodesOutput = "nodes"
+
+	workingFolderName  = "terraform"
+	definitionFileName = "infrastructure.json"
+	templateSuffix     = ".tmpl"
+)
+
+// commandExecutor runs a terraform command in a folder with some extra environment variables and returns its standard output
+type commandExecutor func(ctx context.Context, logger *log.Entry, dir string, env map[string]string, args ...string) ([]byte, error)
+
+func executeTerraform(ctx context.Context, logger *log.Entry, dir string, env map[string]string, args ...string) ([]byte, error) {
+	cmd := utils.CreateCommand(ctx, logger, env, true, "terraform", args...)
+	cmd.Dir = dir
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	err := utils.RunCommand(cmd)
+	return output.Bytes(), err
+}
+
+// templateResource is a resource of the infrastructure with the hostname that the node created from it must have
+type templateResource struct {
+	model.ResourceType
+	Hostname string
+}
+
+// templateData are the variables available in the templates
+type templateData struct {
+	ID              string
+	Name            string
+	PublicKey       string
+	ExtraProperties model.ExtraPropertiesType
+	Resources       []templateResource
+}
+
+type outputValue struct {
+	Sensitive bool            `json:"sensitive"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// nodeOutput is each of the elements of the nodes output of the templates
+type nodeOutput struct {
+	Hostname   string        `json:"hostname"`
+	Resource   string        `json:"resource"`
+	Role       string        `json:"role"`
+	IP         string        `json:"ip"`
+	UUID       string        `json:"uuid"`
+	Username   string        `json:"username"`
+	DriveUUID  string        `json:"drive_uuid"`
+	DataDrives []driveOutput `json:"data_drives"`
+}
+
+type driveOutput struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		result, err := json.Marshal(value)
+		return string(result), err
+	},
+}
+
+// renderTemplate writes the files of a template folder to the working folder. Files with the .tmpl suffix are rendered with the data passed as parameter and saved without the suffix, the rest are copied as they are.
+func renderTemplate(templateFolder, workingFolder string, data templateData) error {
+	return filepath.Walk(templateFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(templateFolder, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(workingFolder, relative)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error reading template file %s: %w", path, err)
+		}
+
+		if strings.HasSuffix(path, templateSuffix) {
+			fileTemplate, err := template.New(info.Name()).Funcs(templateFuncs).Parse(string(content))
+			if err != nil {
+				return fmt.Errorf("Error parsing template file %s: %w", path, err)
+			}
+
+			var rendered bytes.Buffer
+			err = fileTemplate.Execute(&rendered, data)
+			if err != nil {
+				return fmt.Errorf("Error executing template %s: %w", path, err)
+			}
+			content = rendered.Bytes()
+			target = strings.TrimSuffix(target, templateSuffix)
+		}
+
+		return ioutil.WriteFile(target, content, info.Mode())
+	})
+}
+
+// parseNodes reads the nodes output from the result of terraform output -json
+func parseNodes(rawOutputs []byte) ([]nodeOutput, error) {
+	outputs := make(map[string]outputValue)
+	err := json.Unmarshal(rawOutputs, &outputs)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding terraform outputs: %w", err)
+	}
+
+	nodesOutput, ok := outputs[NodesOutput]
+	if !ok {
+		return nil, fmt.Errorf("The template doesn't have a %s output", NodesOutput)
+	}
+
+	var nodes []nodeOutput
+	err = json.Unmarshal(nodesOutput.Value, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding %s output. It must be a list of objects with hostname and ip fields: %w", NodesOutput, err)
+	}
+
+	for _, node := range nodes {
+		if node.Hostname == "" || node.IP == "" {
+			return nil, fmt.Errorf("Found node without hostname or IP in %s output: %v", NodesOutput, node)
+		}
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,243 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package jobs
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/persistence"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TimeoutProperty is the maximum duration of a job. A zero value means that jobs can run forever.
+	TimeoutProperty = "jobs.timeout"
+
+	TimeoutDefaultValue = "0s"
+
+	// InstanceProperty is the name of this instance of the deployment engine. Instances sharing the same repository must have different names so each one only recovers its own jobs.
+	InstanceProperty = "jobs.instance"
+
+	InstanceDefaultValue = "default"
+
+	DeploymentJobType = "deployment"
+	ProductJobType    = "product"
+	ScaleJobType      = "scale"
+	ReconcileJobType  = "reconcile"
+)
+
+// Work is the operation that a job executes. It can report the progress of the elements it's working on with the function passed as parameter and it should return the infrastructures it created or modified.
+// The context is cancelled when the job is cancelled or it exceeds the timeout of the manager, and the work should stop as soon as possible then.
+type Work func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error)
+
+// Manager runs long operations in background, saving their state in the repository so they can be polled by clients.
+type Manager struct {
+	Repository persistence.JobRepository
+	// Instance is the name of this instance of the deployment engine, recorded as the owner of the jobs it runs
+	Instance string
+	// Timeout, if greater than zero, is the maximum time that a job can run before being cancelled
+	Timeout time.Duration
+	lock    sync.Mutex
+	// cancels holds the functions which cancel the jobs running in this instance, indexed by job identifier
+	cancels     map[string]context.CancelFunc
+	cancelsLock sync.Mutex
+}
+
+// NewManager creates a job manager for an instance of the deployment engine, marking as failed the jobs of the instance that were interrupted by a restart
+func NewManager(repository persistence.JobRepository, instance string) *Manager {
+	result := &Manager{
+		Repository: repository,
+		Instance:   instance,
+		cancels:    make(map[string]context.CancelFunc),
+	}
+
+	err := result.RecoverInterrupted()
+	if err != nil {
+		log.WithError(err).Error("Error marking interrupted jobs as failed")
+	}
+
+	return result
+}
+
+// Submit saves a new job in pending state and starts executing it in background. The targets are the elements that the job will be working on and they will appear as pending in its progress.
+func (m *Manager) Submit(jobType string, targets []string, work Work) (model.Job, error) {
+	return m.SubmitForDeployment("", jobType, targets, work)
+}
+
+// SubmitForDeployment submits a new job which works on the infrastructures of the deployment passed as parameter
+func (m *Manager) SubmitForDeployment(deploymentID, jobType string, targets []string, work Work) (model.Job, error) {
+	job := model.Job{
+		Type:         jobType,
+		State:        model.JobStatePending,
+		Progress:     make([]model.JobProgress, 0, len(targets)),
+		DeploymentID: deploymentID,
+		Owner:        m.Instance,
+	}
+
+	for _, target := range targets {
+		job.SetProgress(target, model.JobStatePending, nil)
+	}
+
+	job, err := m.Repository.AddJob(job)
+	if err != nil {
+		log.WithError(err).Error("Error saving new job")
+		return job, err
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if m.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), m.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	m.setCancel(job.ID, cancel)
+
+	go m.run(ctx, job.ID, work)
+
+	return job, nil
+}
+
+// GetJob returns the current status of a job
+func (m *Manager) GetJob(jobID string) (model.Job, error) {
+	return m.Repository.FindJob(jobID)
+}
+
+// Cancel stops a job which is pending or running in this instance of the deployment engine. The job is marked as cancelled once its work returns.
+func (m *Manager) Cancel(jobID string) (model.Job, error) {
+	job, err := m.Repository.FindJob(jobID)
+	if err != nil {
+		return job, err
+	}
+
+	if job.IsFinished() {
+		return job, fmt.Errorf("Job %s is already %s", jobID, job.State)
+	}
+
+	m.cancelsLock.Lock()
+	cancel, ok := m.cancels[jobID]
+	m.cancelsLock.Unlock()
+	if !ok {
+		return job, fmt.Errorf("Job %s is not running in this instance", jobID)
+	}
+
+	log.WithField("job", jobID).Info("Cancelling job")
+	cancel()
+	return job, nil
+}
+
+func (m *Manager) setCancel(jobID string, cancel context.CancelFunc) {
+	m.cancelsLock.Lock()
+	defer m.cancelsLock.Unlock()
+	m.cancels[jobID] = cancel
+}
+
+func (m *Manager) removeCancel(jobID string) {
+	m.cancelsLock.Lock()
+	defer m.cancelsLock.Unlock()
+	if cancel, ok := m.cancels[jobID]; ok {
+		cancel()
+		delete(m.cancels, jobID)
+	}
+}
+
+// RecoverInterrupted marks as failed the jobs of this instance that were pending or running when the engine stopped, since nobody is going to finish them.
+// Jobs without owner, saved before owners were recorded, belong to the default instance.
+func (m *Manager) RecoverInterrupted() error {
+	interrupted, err := m.Repository.FindJobsByState(model.JobStatePending, model.JobStateRunning)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range interrupted {
+		owner := job.Owner
+		if owner == "" {
+			owner = InstanceDefaultValue
+		}
+		if owner != m.Instance {
+			continue
+		}
+
+		log.Warnf("Marking job %s as failed since it was interrupted", job.ID)
+		m.finish(job.ID, nil, errors.New("The job was interrupted by a restart of the deployment engine"))
+	}
+
+	return nil
+}
+
+func (m *Manager) run(ctx context.Context, jobID string, work Work) {
+	defer m.removeCancel(jobID)
+
+	m.update(jobID, func(job *model.Job) {
+		now := time.Now()
+		job.State = model.JobStateRunning
+		job.StartTime = &now
+	})
+
+	result, err := work(ctx, func(target, state string, err error) {
+		m.update(jobID, func(job *model.Job) {
+			job.SetProgress(target, state, err)
+		})
+	})
+
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		err = fmt.Errorf("%w: %s", ctx.Err(), err.Error())
+	}
+
+	m.finish(jobID, result, err)
+}
+
+func (m *Manager) finish(jobID string, result model.DeploymentInfo, err error) {
+	m.update(jobID, func(job *model.Job) {
+		now := time.Now()
+		job.FinishTime = &now
+		job.Result = result
+		job.State = model.JobStateCompleted
+		if err != nil {
+			job.State = model.JobStateFailed
+			if errors.Is(err, context.Canceled) {
+				job.State = model.JobStateCancelled
+			}
+			job.Error = err.Error()
+		}
+	})
+}
+
+// update applies a modification to the stored job. Updates are serialized since the progress of a job can be reported from different goroutines.
+func (m *Manager) update(jobID string, apply func(*model.Job)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	logger := log.WithField("job", jobID)
+	job, err := m.Repository.FindJob(jobID)
+	if err != nil {
+		logger.WithError(err).Error("Error retrieving job to update")
+		return
+	}
+
+	apply(&job)
+
+	_, err = m.Repository.UpdateJob(job)
+	if err != nil {
+		logger.WithError(err).Error("Error updating job")
+	}
+}
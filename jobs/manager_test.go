@@ -0,0 +1,193 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package jobs
+
+import (
+	"context"
+	"deployment-engine/model"
+	"deployment-engine/persistence/memoryrepo"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitForJob(t *testing.T, manager *Manager, jobID string) model.Job {
+	for i := 0; i < 100; i++ {
+		job, err := manager.GetJob(jobID)
+		if err != nil {
+			t.Fatalf("Error getting job %s: %s", jobID, err.Error())
+		}
+		if job.IsFinished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for job %s to finish", jobID)
+	return model.Job{}
+}
+
+func TestJobCompleted(t *testing.T) {
+	manager := NewManager(memoryrepo.CreateMemoryRepository(), InstanceDefaultValue)
+
+	release := make(chan bool)
+	job, err := manager.Submit(DeploymentJobType, []string{"infra1", "infra2"}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		<-release
+		progress("infra1", "created", nil)
+		progress("infra2", "created", nil)
+		return model.DeploymentInfo{model.InfrastructureDeploymentInfo{ID: "id1"}}, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error submitting job: %s", err.Error())
+	}
+
+	if job.State != model.JobStatePending || len(job.Progress) != 2 {
+		t.Fatalf("Unexpected initial job state: %v", job)
+	}
+
+	close(release)
+	job = waitForJob(t, manager, job.ID)
+
+	if job.State != model.JobStateCompleted {
+		t.Fatalf("Expected job to be completed but found %s", job.State)
+	}
+
+	if job.StartTime == nil || job.FinishTime == nil {
+		t.Fatal("Start or finish time not set for finished job")
+	}
+
+	for _, progress := range job.Progress {
+		if progress.State != "created" {
+			t.Fatalf("Unexpected state %s for %s", progress.State, progress.Target)
+		}
+	}
+
+	if len(job.Result) != 1 || job.Result[0].ID != "id1" {
+		t.Fatalf("Unexpected job result %v", job.Result)
+	}
+}
+
+func TestJobFailed(t *testing.T) {
+	manager := NewManager(memoryrepo.CreateMemoryRepository(), InstanceDefaultValue)
+
+	job, err := manager.Submit(ProductJobType, []string{"infra1"}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		return nil, errors.New("Product failed")
+	})
+
+	if err != nil {
+		t.Fatalf("Error submitting job: %s", err.Error())
+	}
+
+	job = waitForJob(t, manager, job.ID)
+
+	if job.State != model.JobStateFailed || job.Error != "Product failed" {
+		t.Fatalf("Expected failed job but found %v", job)
+	}
+}
+
+func TestJobCancelled(t *testing.T) {
+	manager := NewManager(memoryrepo.CreateMemoryRepository(), InstanceDefaultValue)
+
+	started := make(chan bool)
+	job, err := manager.Submit(DeploymentJobType, []string{"infra1"}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	if err != nil {
+		t.Fatalf("Error submitting job: %s", err.Error())
+	}
+
+	<-started
+	_, err = manager.Cancel(job.ID)
+	if err != nil {
+		t.Fatalf("Error cancelling job: %s", err.Error())
+	}
+
+	job = waitForJob(t, manager, job.ID)
+
+	if job.State != model.JobStateCancelled {
+		t.Fatalf("Expected cancelled job but found %v", job)
+	}
+
+	_, err = manager.Cancel(job.ID)
+	if err == nil {
+		t.Fatal("Cancelled a job which was already finished")
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	manager := NewManager(memoryrepo.CreateMemoryRepository(), InstanceDefaultValue)
+	manager.Timeout = 10 * time.Millisecond
+
+	job, err := manager.Submit(ProductJobType, []string{"infra1"}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		<-ctx.Done()
+		return nil, errors.New("Product interrupted")
+	})
+
+	if err != nil {
+		t.Fatalf("Error submitting job: %s", err.Error())
+	}
+
+	job = waitForJob(t, manager, job.ID)
+
+	if job.State != model.JobStateFailed || job.Error != "context deadline exceeded: Product interrupted" {
+		t.Fatalf("Expected job failed by timeout but found %v", job)
+	}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	repo := memoryrepo.CreateMemoryRepository()
+	owned := make([]model.Job, 0)
+	for _, owner := range []string{"test", "other", ""} {
+		job, err := repo.AddJob(model.Job{
+			Type:  DeploymentJobType,
+			State: model.JobStateRunning,
+			Owner: owner,
+		})
+		if err != nil {
+			t.Fatalf("Error adding job: %s", err.Error())
+		}
+		owned = append(owned, job)
+	}
+
+	manager := NewManager(repo, "test")
+
+	expected := []string{model.JobStateFailed, model.JobStateRunning, model.JobStateRunning}
+	for i, job := range owned {
+		job, err := manager.GetJob(job.ID)
+		if err != nil {
+			t.Fatalf("Error getting job: %s", err.Error())
+		}
+
+		if job.State != expected[i] {
+			t.Fatalf("Expected job of instance '%s' to be %s but found %s", job.Owner, expected[i], job.State)
+		}
+	}
+
+	job, err := manager.Submit(DeploymentJobType, nil, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Error submitting job: %s", err.Error())
+	}
+
+	if job.Owner != "test" {
+		t.Fatalf("Expected job owned by the instance of the manager but found '%s'", job.Owner)
+	}
+}
@@ -65,6 +65,7 @@ func main() {
 	viper.ReadInConfig()
 
 	log.Infof("Read configuration values: %v", viper.AllSettings())
+	utils.ConfigureCommandExecutor()
 	/*repository, err := getRepository(viper.GetString(RepositoryProperty))
 	if err != nil {
 		log.WithError(err).Error("Error getting repository")
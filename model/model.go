@@ -17,10 +17,15 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cast"
 )
 
@@ -29,6 +34,19 @@ const (
 	OAuth2Type     = "oauth"
 	PKIType        = "PKI"
 	KubernetesType = "kubernetes"
+
+	JobStatePending   = "pending"
+	JobStateRunning   = "running"
+	JobStateCompleted = "completed"
+	JobStateFailed    = "failed"
+	JobStateCancelled = "cancelled"
+
+	DeliveryStatePending   = "pending"
+	DeliveryStateDelivered = "delivered"
+	DeliveryStateFailed    = "failed"
+
+	CloudInitStatusDone  = "done"
+	CloudInitStatusError = "error"
 )
 
 // ExtraPropertiesType represents extra properties to define for resources, infrastructures or deployments. This properties are provisioner or deployment specific and they should document them when they expect any.
@@ -50,6 +68,146 @@ type Drive struct {
 	Size int64 `json:"size"`
 }
 
+// Flavor is a named size of virtual machine of the flavor catalog of the configuration
+// swagger:model
+type Flavor struct {
+	// CPU speed in Mhz
+	CPU int `json:"cpu"`
+	// Number of cores
+	Cores int `json:"cores"`
+	// RAM quantity in Mb
+	RAM int64 `json:"ram"`
+	// Boot disk size in Mb. Used if the resource doesn't specify one.
+	Disk int64 `json:"disk"`
+}
+
+// Apply returns a resource with the CPU, cores and RAM of the flavor and its disk size if the resource doesn't have one
+func (f Flavor) Apply(resource ResourceType) ResourceType {
+	resource.CPU = f.CPU
+	resource.Cores = f.Cores
+	resource.RAM = f.RAM
+	if resource.Disk == 0 {
+		resource.Disk = f.Disk
+	}
+	return resource
+}
+
+// Image selects a boot image in the library of the provider by its name or by the distribution and version of its OS. The fields which are set must match.
+// swagger:model
+type Image struct {
+	// Name of the image in the library
+	// example: Ubuntu 18.04 Cloud Image
+	Name string `json:"name,omitempty"`
+	// Distribution of the OS of the image, as named in the library of the provider
+	// example: Ubuntu
+	Distribution string `json:"distribution,omitempty"`
+	// Version of the distribution
+	// example: 18.04
+	Version string `json:"version,omitempty"`
+}
+
+// IsEmpty returns true if the image doesn't select anything
+func (i Image) IsEmpty() bool {
+	return i.Name == "" && i.Distribution == "" && i.Version == ""
+}
+
+func (i Image) String() string {
+	return strings.TrimSpace(strings.Join([]string{i.Name, i.Distribution, i.Version}, " "))
+}
+
+// osFamilies maps the distributions to their family, named as the os_family fact of Ansible
+var osFamilies = map[string]string{
+	"ubuntu":    "Debian",
+	"debian":    "Debian",
+	"centos":    "RedHat",
+	"rhel":      "RedHat",
+	"redhat":    "RedHat",
+	"fedora":    "RedHat",
+	"rocky":     "RedHat",
+	"almalinux": "RedHat",
+	"opensuse":  "Suse",
+	"sles":      "Suse",
+	"windows":   "Windows",
+}
+
+// OSFamily returns the family of an OS distribution, such as Debian for Ubuntu or RedHat for CentOS. Unknown distributions are their own family.
+func OSFamily(distribution string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(distribution), ""))
+	for prefix, family := range osFamilies {
+		if strings.HasPrefix(normalized, prefix) {
+			return family
+		}
+	}
+	return distribution
+}
+
+// Network is a private network to which a node is attached besides its public network
+// swagger:model
+type Network struct {
+	// Identifier of the network in the provider, such as the UUID of a CloudSigma VLAN
+	// required:true
+	ID string `json:"id"`
+	// Private IP of the node in the network. Private networks may not have DHCP, so it may need to be configured in the node by the provisioners.
+	// example: 192.168.0.10
+	IP string `json:"ip,omitempty"`
+	// Prefix length of the private network, used to configure the IP in the node. By default it's 24.
+	// example: 24
+	Netmask int `json:"netmask,omitempty"`
+}
+
+// UserData is the cloud-init configuration that nodes run on their first boot. Only one of inline and template can be set.
+// swagger:model
+type UserData struct {
+	// User-data passed to the nodes as it is, such as a cloud-config document or a script
+	Inline string `json:"inline,omitempty"`
+	// Go template of the user-data, rendered for each node with the fields of UserDataVars
+	Template string `json:"template,omitempty"`
+}
+
+// UserDataVars are the variables of a node available to the user-data templates
+type UserDataVars struct {
+	InfrastructureID   string
+	InfrastructureName string
+	Hostname           string
+	Role               string
+	ResourceName       string
+	IP                 string
+	Networks           []Network
+	ExtraProperties    ExtraPropertiesType
+}
+
+// Validate checks that the user-data is either inline or a valid template
+func (u UserData) Validate() error {
+	if u.Inline != "" && u.Template != "" {
+		return errors.New("User-data can be either inline or a template, but not both")
+	}
+	if u.Template != "" {
+		if _, err := template.New("user-data").Parse(u.Template); err != nil {
+			return fmt.Errorf("Invalid user-data template: %w", err)
+		}
+	}
+	return nil
+}
+
+// Render returns the user-data of a node, rendering the template with its variables if it's not inline
+func (u UserData) Render(vars UserDataVars) (string, error) {
+	if u.Template == "" {
+		return u.Inline, nil
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=error").Parse(u.Template)
+	if err != nil {
+		return "", fmt.Errorf("Invalid user-data template: %w", err)
+	}
+
+	var result strings.Builder
+	err = tmpl.Execute(&result, vars)
+	if err != nil {
+		return "", fmt.Errorf("Error rendering user-data of node %s: %w", vars.Hostname, err)
+	}
+	return result.String(), nil
+}
+
 // ResourceType has information about a node that needs to be created by a deployer.
 // swagger:model
 type ResourceType struct {
@@ -57,7 +215,7 @@ type ResourceType struct {
 	// required:true
 	// unique:true
 	Name string `json:"name"`
-	// Type of the VM to create i.e. n1-small
+	// Type of the VM to create i.e. n1-small. It's the native instance type or flavor in AWS and OpenStack and a flavor of the catalog of the configuration in other providers.
 	// example: n1-small
 	Type string `json:"type"`
 	// CPU speed in Mhz. Ignored if type is provided
@@ -71,17 +229,38 @@ type ResourceType struct {
 	Disk int64 `json:"disk"`
 	// Role that this VM plays. In case of a Kubernetes deployment at least one "master" is needed.
 	Role string `json:"role"`
-	// Boot image ID to use
-	// required:true
+	// Boot image ID to use. It's mandatory unless the image is selected with image.
 	ImageId string `json:"image_id"`
-	// IP to assign this VM. In case it's not specified, the first available one will be used.
+	// Boot image to look up in the library of the provider, if image_id is not specified
+	Image *Image `json:"image,omitempty"`
+	// Public IP to assign this VM. In case it's not specified, the first available one will be used.
 	IP string `json:"ip,omitempty"`
+	// Username to access the machine. Only used for pre-existing machines in edge infrastructures. If not present, root will be used.
+	Username string `json:"username,omitempty"`
 	// List of data drives to attach to this VM
 	Drives []Drive `json:"drives"`
+	// Private networks to attach this VM to, besides the public one
+	Networks []Network `json:"networks,omitempty"`
+	// Cloud-init user-data of this VM. It overrides the one of the infrastructure.
+	UserData *UserData `json:"user_data,omitempty"`
 	// Extra properties to pass to the provider or the provisioner
 	ExtraProperties ExtraPropertiesType `json:"extra_properties"`
 }
 
+// Definition returns the part of the resource that can't be changed once its node is created
+func (r ResourceType) Definition() *NodeDefinition {
+	return &NodeDefinition{
+		Type:    r.Type,
+		CPU:     r.CPU,
+		Cores:   r.Cores,
+		RAM:     r.RAM,
+		Disk:    r.Disk,
+		ImageId: r.ImageId,
+		Image:   r.Image,
+		Drives:  r.Drives,
+	}
+}
+
 // CloudProviderInfo contains information about a cloud provider
 // swagger:model
 type CloudProviderInfo struct {
@@ -105,15 +284,38 @@ type InfrastructureType struct {
 	Name string `json:"name"`
 	// Optional description for the infrastructure
 	Description string `json:"description"`
-	// Type of the infrastructure: Cloud or Edge: Cloud infrastructures mean that the resources will be VMs that need to be instantiated. Edge means that the infrastructure is already in place and its information will be added to the database but no further work will be done by a deployer.
+	// Type of the infrastructure: Cloud or Edge: Cloud infrastructures mean that the resources will be VMs that need to be instantiated. Edge means that the infrastructure is already in place: the edge deployer will check that its hosts are accessible by SSH and record their hardware facts, but they will never be modified or deleted. Edge infrastructures don't need a provider.
 	Type string `json:"type"`
-	// Provider information. Required in case of Cloud type
+	// Provider information. Required in case of Cloud type. Edge infrastructures use the edge provider by default.
 	Provider CloudProviderInfo `json:"provider"`
 	// List of resources to deploy
 	// required:true
 	Resources []ResourceType `json:"resources"`
+	// Cloud-init user-data of the VMs whose resource doesn't have its own
+	UserData *UserData `json:"user_data,omitempty"`
 	// Extra properties to pass to the provider or the provisioner
 	ExtraProperties ExtraPropertiesType `json:"extra_properties"`
+	// Identifier assigned by the deployment engine before creating the infrastructure, so it's known while its resources are being created
+	ID string `json:"-" bson:"-"`
+}
+
+// InfrastructureID returns the identifier assigned to the infrastructure or a new one if it hasn't been assigned any
+func (i InfrastructureType) InfrastructureID() string {
+	if i.ID != "" {
+		return i.ID
+	}
+	return uuid.New().String()
+}
+
+// NodesPatch describes a set of changes in the nodes of an existing infrastructure
+// swagger:model
+type NodesPatch struct {
+	// Resources to add to the infrastructure. They will be created by the deployer of the infrastructure.
+	Add []ResourceType `json:"add"`
+	// Hostnames of the nodes to remove from the infrastructure
+	Remove []string `json:"remove"`
+	// Credentials to access the cloud provider. Only needed if they weren't provided as a secret when the infrastructure was created, since inline credentials are not saved.
+	Credentials map[string]interface{} `json:"credentials"`
 }
 
 // Deployment is a list of infrastructures to initialize.
@@ -136,6 +338,20 @@ type DriveInfo struct {
 	Size int64 `json:"size"`
 }
 
+// NetworkInfo is the information of a private network interface of a node
+// swagger:model
+type NetworkInfo struct {
+	// Identifier of the network in the provider
+	// required:true
+	ID string `json:"id"`
+	// Private IP of the node in the network, if it was requested
+	IP string `json:"ip,omitempty"`
+	// Prefix length of the private network, if an IP was requested
+	Netmask int `json:"netmask,omitempty"`
+	// MAC address of the interface, which can be used to find it in the node
+	MAC string `json:"mac,omitempty"`
+}
+
 // NodeInfo is the information of a virtual machine that has been instantiated or a physical one that was pre-existing
 // swagger:model
 type NodeInfo struct {
@@ -172,10 +388,51 @@ type NodeInfo struct {
 	DriveSize int64 `json:"drive_size" bson:"drive_size"`
 	// Data drives information
 	DataDrives []DriveInfo `json:"data_drives" bson:"data_drives"`
+	// Private network interfaces of the node
+	Networks []NetworkInfo `json:"networks,omitempty" bson:"networks,omitempty"`
+	// Name of the resource in the infrastructure definition that this node was created from
+	ResourceName string `json:"resource_name,omitempty" bson:"resource_name,omitempty"`
+	// Version of the kubelet of the node, for nodes of existing kubernetes clusters
+	KubeletVersion string `json:"kubelet_version,omitempty" bson:"kubelet_version,omitempty"`
+	// Identifier of the boot image of the node in the provider
+	ImageID string `json:"image_id,omitempty" bson:"image_id,omitempty"`
+	// Family of the OS of the boot image, named as the os_family fact of Ansible, such as Debian or RedHat
+	OSFamily string `json:"os_family,omitempty" bson:"os_family,omitempty"`
+	// State of cloud-init once the node was created: done or error. It's empty if the node has no user-data.
+	// pattern:done|error
+	CloudInitStatus string `json:"cloud_init_status,omitempty" bson:"cloud_init_status,omitempty"`
+	// Real status of the node in the provider the last time it was checked
+	// pattern:running|stopped|missing|drifted|unreachable|unknown
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	// Differences found between the node information and its real state in the provider
+	Problems []string `json:"problems,omitempty" bson:"problems,omitempty"`
+	// Definition of the resource the node was created from, used to find the changes that can't be applied to it
+	Definition *NodeDefinition `json:"definition,omitempty" bson:"definition,omitempty"`
 	// Extra properties to pass to the provider or the provisioner
 	ExtraProperties ExtraPropertiesType `json:"extra_properties"`
 }
 
+// NodeDefinition is the part of the definition of a resource that can't be changed once its node is created
+// swagger:model
+type NodeDefinition struct {
+	// Type of the VM
+	Type string `json:"type,omitempty" bson:"type,omitempty"`
+	// CPU speed in Mhz
+	CPU int `json:"cpu,omitempty" bson:"cpu,omitempty"`
+	// Number of cores
+	Cores int `json:"cores,omitempty" bson:"cores,omitempty"`
+	// RAM quantity in Mb
+	RAM int64 `json:"ram,omitempty" bson:"ram,omitempty"`
+	// Boot disk size in Mb
+	Disk int64 `json:"disk,omitempty" bson:"disk,omitempty"`
+	// Boot image ID
+	ImageId string `json:"image_id,omitempty" bson:"image_id,omitempty"`
+	// Boot image looked up in the library of the provider
+	Image *Image `json:"image,omitempty" bson:"image,omitempty"`
+	// Data drives of the VM
+	Drives []Drive `json:"drives,omitempty" bson:"drives,omitempty"`
+}
+
 // InfrastructureDeploymentInfo contains information about a cluster of nodes that has been instantiated or were already existing.
 // swagger:model
 type InfrastructureDeploymentInfo struct {
@@ -203,14 +460,147 @@ type InfrastructureDeploymentInfo struct {
 	CreationTime time.Time `json:"creation_time"`
 	// UpdateTime is the last time this infrastructure has been updated
 	UpdateTime time.Time `json:"update_time"`
+	// RefreshTime is the last time the nodes of this infrastructure were checked against the provider
+	RefreshTime *time.Time `json:"refresh_time,omitempty" bson:"refresh_time,omitempty"`
+	// Cloud-init user-data of the nodes added to the infrastructure whose resource doesn't have its own
+	UserData *UserData `json:"user_data,omitempty" bson:"user_data,omitempty"`
 	// Extra properties to pass to the provider or the provisioner
 	ExtraProperties ExtraPropertiesType `json:"extra_properties"`
 }
 
+// ChangeSet is the list of changes needed to make an existing infrastructure match its desired definition
+// swagger:model
+type ChangeSet struct {
+	// Identifier of the infrastructure to change
+	InfrastructureID string `json:"infrastructure_id"`
+	// Resources of the definition that will be created
+	Create []ResourceType `json:"create"`
+	// Hostnames of the nodes that will be deleted since they are not in the definition anymore
+	Delete []string `json:"delete"`
+	// Hostnames of the nodes whose extra properties will be updated
+	Update []string `json:"update"`
+	// True if the extra properties of the infrastructure will be updated
+	UpdateProperties bool `json:"update_properties"`
+}
+
+// IsEmpty returns true if the infrastructure already matches its definition
+func (c ChangeSet) IsEmpty() bool {
+	return len(c.Create) == 0 && len(c.Delete) == 0 && len(c.Update) == 0 && !c.UpdateProperties
+}
+
+// ReconcileResult is returned when an infrastructure is reconciled with its definition. If there are changes to apply, they are applied by the returned job.
+// swagger:model
+type ReconcileResult struct {
+	// Changes to apply to the infrastructure
+	Changes ChangeSet `json:"changes"`
+	// Job applying the changes. Empty if there is nothing to change.
+	Job *Job `json:"job,omitempty"`
+}
+
+const (
+	NodeStatusRunning     = "running"
+	NodeStatusStopped     = "stopped"
+	NodeStatusMissing     = "missing"
+	NodeStatusDrifted     = "drifted"
+	NodeStatusUnreachable = "unreachable"
+	NodeStatusUnknown     = "unknown"
+)
+
+// NodeStatus is the real state of a node in its provider
+// swagger:model
+type NodeStatus struct {
+	// Status of the node
+	// pattern:running|stopped|missing|drifted|unreachable|unknown
+	Status string `json:"status"`
+	// Differences found between the node information and its real state
+	Problems []string `json:"problems,omitempty"`
+}
+
+// IsHealthy returns false if the node is known to be missing, stopped or different from what was recorded
+func (s NodeStatus) IsHealthy() bool {
+	return s.Status == NodeStatusRunning || s.Status == NodeStatusUnknown
+}
+
 // DeploymentInfo is a list of infrastructures that have been initialized.
 // swagger:model
 type DeploymentInfo []InfrastructureDeploymentInfo
 
+// DeploymentGroup groups the infrastructures that were created together so they can be managed as a whole
+// swagger:model
+type DeploymentGroup struct {
+	// Unique deployment ID
+	// required:true
+	// unique:true
+	ID string `json:"id" bson:"_id"`
+	// Name of the deployment
+	Name string `json:"name"`
+	// Labels to classify the deployment
+	Labels map[string]string `json:"labels,omitempty"`
+	// Identifiers of the infrastructures that belong to the deployment
+	Infrastructures []string `json:"infrastructures"`
+	// Aggregate status of the infrastructures of the deployment. It's computed when the deployment is retrieved.
+	Status string `json:"status" bson:"-"`
+	// Status of each infrastructure indexed by identifier. It's computed when the deployment is retrieved.
+	InfrastructureStatus map[string]string `json:"infrastructure_status,omitempty" bson:"-"`
+	// CreationTime is the time this deployment has been created
+	CreationTime time.Time `json:"creation_time"`
+	// UpdateTime is the last time this deployment has been updated
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// HasInfrastructure returns true if the infrastructure belongs to the deployment
+func (g DeploymentGroup) HasInfrastructure(infraID string) bool {
+	for _, id := range g.Infrastructures {
+		if id == infraID {
+			return true
+		}
+	}
+	return false
+}
+
+// AddInfrastructure adds an infrastructure to the deployment if it doesn't belong to it yet
+func (g *DeploymentGroup) AddInfrastructure(infraID string) {
+	if !g.HasInfrastructure(infraID) {
+		g.Infrastructures = append(g.Infrastructures, infraID)
+	}
+}
+
+// RemoveInfrastructure removes an infrastructure from the deployment, returning false if it didn't belong to it
+func (g *DeploymentGroup) RemoveInfrastructure(infraID string) bool {
+	for i, id := range g.Infrastructures {
+		if id == infraID {
+			g.Infrastructures = append(g.Infrastructures[:i], g.Infrastructures[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DeploymentGroupMove is the list of infrastructures to attach to a deployment and to detach from it. Infrastructures that belong to another deployment are moved from it.
+// swagger:model
+type DeploymentGroupMove struct {
+	// Identifiers of the infrastructures to add to the deployment
+	Attach []string `json:"attach"`
+	// Identifiers of the infrastructures to remove from the deployment. They are not deleted.
+	Detach []string `json:"detach"`
+}
+
+// ProviderDescription describes a type of cloud provider supported by the deployment engine
+// swagger:model
+type ProviderDescription struct {
+	// API type that must be used in the provider information of an infrastructure to use this provider
+	// required:true
+	// unique:true
+	APIType string `json:"api_type"`
+	// Description of the provider in natural language
+	Description string `json:"description"`
+	// Format of the secret expected when credentials are passed as a secret identifier, if any
+	// example:basic
+	CredentialsFormat string `json:"credentials_format,omitempty"`
+	// Fields expected in the credentials of the provider and their description
+	CredentialsFields map[string]string `json:"credentials_fields,omitempty"`
+}
+
 // Secret is a structure that will be saved as cyphered data in the database. Once saved it will receive an identifier and deployments, infrastructures, providers and provisioners can make reference to it by ID.
 // swagger:model
 type Secret struct {
@@ -250,7 +640,7 @@ type PKISecret struct {
 // KubernetesConfigSecret is a representation of a configuration file to grant access to Kubernetes through kubectl
 // swagger:model
 type KubernetesConfigSecret struct {
-	Config interface{} `json:config`
+	Config interface{} `json:"config"`
 }
 
 // DockerRegistry is the information to pull images from a private docker registry
@@ -262,17 +652,287 @@ type DockerRegistry struct {
 	Certificate string
 }
 
+const (
+	DriveActionClone  = "clone"
+	DriveActionCreate = "create"
+
+	CredentialsSourceInline = "inline"
+	CredentialsSourceVault  = "vault"
+)
+
+// DrivePlan describes a drive that would be created for a node
+// swagger:model
+type DrivePlan struct {
+	// Name that the drive would receive
+	Name string `json:"name"`
+	// Action to perform to get the drive
+	// pattern:clone|create
+	Action string `json:"action"`
+	// Image or drive to clone, in case the drive is cloned
+	Source string `json:"source,omitempty"`
+	// Size of the drive in bytes. Zero means the size of the source image.
+	Size int64 `json:"size"`
+}
+
+// NodePlan describes a node that would be created in an infrastructure
+// swagger:model
+type NodePlan struct {
+	// Hostname that the node would receive
+	Hostname string `json:"hostname"`
+	// Role of the node
+	Role string `json:"role"`
+	// IP that would be assigned to the node, if it can be known in advance
+	IP string `json:"ip,omitempty"`
+	// CPU speed in Mhz.
+	CPU int `json:"cpu"`
+	// Number of cores.
+	Cores int `json:"cores"`
+	// RAM quantity in bytes.
+	RAM int64 `json:"ram"`
+	// Boot and data drives of the node
+	Drives []DrivePlan `json:"drives"`
+}
+
+// InfrastructurePlan describes what a deployer would do to create an infrastructure and the problems found when checking it against the provider
+// swagger:model
+type InfrastructurePlan struct {
+	// Name of the infrastructure
+	Name string `json:"name"`
+	// API type of the provider that would create the infrastructure
+	Provider string `json:"provider"`
+	// Where the credentials of the provider come from
+	// pattern:inline|vault
+	CredentialsSource string `json:"credentials_source"`
+	// Nodes that would be created
+	Nodes []NodePlan `json:"nodes"`
+	// Problems found that would make the creation of the infrastructure fail
+	Errors []string `json:"errors,omitempty"`
+}
+
+// AddError records a problem found while planning the infrastructure
+func (p *InfrastructurePlan) AddError(format string, args ...interface{}) {
+	p.Errors = append(p.Errors, fmt.Sprintf(format, args...))
+}
+
+// DeploymentPlan is the result of a dry run of a deployment. Nothing is created to build it.
+// swagger:model
+type DeploymentPlan struct {
+	// True if no problems were found in any infrastructure
+	Valid bool `json:"valid"`
+	// Plan for each infrastructure of the deployment
+	Infrastructures []InfrastructurePlan `json:"infrastructures"`
+}
+
 type Parameters map[string]interface{}
 
-// Deployer is the interface that a module that can deploy virtual resources in a cloud provider must implement.
+// JobProgress is the state of one of the elements, usually an infrastructure, that a job is working on
+// swagger:model
+type JobProgress struct {
+	// Element that the job is working on. Infrastructure name or identifier.
+	Target string `json:"target"`
+	// State of the element
+	// example:creating
+	State string `json:"state"`
+	// Error found while working on the element, if any
+	Error string `json:"error,omitempty"`
+	// Last time the state of this element changed
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// Job is a long running operation executed asynchronously by the deployment engine. It can be polled until it finishes to get its result.
+// swagger:model
+type Job struct {
+	// Unique job identifier
+	// required:true
+	// unique:true
+	ID string `json:"id" bson:"_id"`
+	// Type of the operation performed by the job
+	// example:deployment
+	Type string `json:"type"`
+	// State of the job
+	// pattern:pending|running|completed|failed|cancelled
+	State string `json:"state"`
+	// Progress of each one of the elements the job is working on
+	Progress []JobProgress `json:"progress"`
+	// Infrastructures created or modified by the job once it's completed
+	Result DeploymentInfo `json:"result,omitempty"`
+	// Error that made the job fail
+	Error string `json:"error,omitempty"`
+	// CreationTime is the time this job has been submitted
+	CreationTime time.Time `json:"creation_time"`
+	// UpdateTime is the last time this job has been updated
+	UpdateTime time.Time `json:"update_time"`
+	// StartTime is the time the job started running
+	StartTime *time.Time `json:"start_time,omitempty"`
+	// FinishTime is the time the job completed, failed or was cancelled
+	FinishTime *time.Time `json:"finish_time,omitempty"`
+	// DeploymentID is the identifier of the deployment which groups the infrastructures created by the job, if any
+	DeploymentID string `json:"deployment_id,omitempty" bson:"deployment_id,omitempty"`
+	// Owner is the name of the instance of the deployment engine running the job
+	Owner string `json:"owner,omitempty" bson:"owner,omitempty"`
+}
+
+const (
+	EventInfrastructureCreated = "infrastructure.created"
+	EventInfrastructureFailed  = "infrastructure.failed"
+	EventInfrastructureDeleted = "infrastructure.deleted"
+	EventNodeCreated           = "node.created"
+	EventNodeFailed            = "node.failed"
+	EventNodeDeleted           = "node.deleted"
+	EventProductProvisioned    = "product.provisioned"
+	EventProductFailed         = "product.failed"
+	EventSecretAccessed        = "secret.accessed"
+	EventStatusChanged         = "status.changed"
+
+	// SystemPrincipal is the principal of the operations started by the deployment engine itself, such as periodic checks
+	SystemPrincipal = "system"
+)
+
+// Event is a record of something that happened to an infrastructure
+// swagger:model
+type Event struct {
+	// Unique event identifier
+	// required:true
+	// unique:true
+	ID string `json:"id" bson:"_id"`
+	// Type of the event
+	// example:infrastructure.created
+	Type string `json:"type"`
+	// Identifier of the infrastructure affected, if any
+	InfrastructureID string `json:"infrastructure_id,omitempty" bson:"infrastructure_id,omitempty"`
+	// Element of the infrastructure affected, such as the hostname of a node, the name of a product, the identifier of a secret or the new status
+	Target string `json:"target,omitempty" bson:"target,omitempty"`
+	// Principal that performed the operation which caused the event
+	Principal string `json:"principal"`
+	// Timestamp is the time the event happened
+	Timestamp time.Time `json:"timestamp"`
+	// Additional information, such as the error that made an operation fail
+	Message string `json:"message,omitempty" bson:"message,omitempty"`
+}
+
+// Webhook is a subscription to the events of the deployment engine. Events are sent to the URL of the webhook as a JSON document signed with its secret.
+// swagger:model
+type Webhook struct {
+	// Unique webhook identifier
+	// required:true
+	// unique:true
+	ID string `json:"id" bson:"_id"`
+	// URL that will receive the events in POST requests
+	// required:true
+	// example:https://orchestrator.example.com/hooks/deployments
+	URL string `json:"url"`
+	// Types of the events to send. If empty, the changes of state of the infrastructures and the results of the product installations are sent.
+	// example:["infrastructure.created", "product.failed"]
+	Events []string `json:"events,omitempty"`
+	// Secret used to sign the events with HMAC-SHA256. It's generated if not provided and it's only returned when the webhook is created, since it's saved in the vault.
+	Secret string `json:"secret,omitempty" bson:"-"`
+	// Identifier of the secret in the vault
+	SecretID string `json:"secret_id" bson:"secret_id"`
+	// CreationTime is the time this webhook was created
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// WebhookDelivery is the record of an event sent to a webhook
+// swagger:model
+type WebhookDelivery struct {
+	// Unique delivery identifier
+	// required:true
+	// unique:true
+	ID string `json:"id" bson:"_id"`
+	// Identifier of the webhook the event is sent to
+	WebhookID string `json:"webhook_id" bson:"webhook_id"`
+	// Event sent
+	Event Event `json:"event"`
+	// State of the delivery
+	// pattern:pending|delivered|failed
+	State string `json:"state"`
+	// Number of attempts made to send the event
+	Attempts int `json:"attempts"`
+	// HTTP status code of the last response of the receiver, if any
+	ResponseCode int `json:"response_code,omitempty" bson:"response_code,omitempty"`
+	// Error found in the last attempt, if any
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// CreationTime is the time the delivery was created
+	CreationTime time.Time `json:"creation_time"`
+	// UpdateTime is the time of the last attempt
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// IPLease is the reservation of a public IP of a provider for an infrastructure while its node is being created, so that infrastructures deployed at the same time don't pick the same IP
+type IPLease struct {
+	// Identifier of the lease, made of the pool and the IP
+	ID string `json:"id" bson:"_id"`
+	// Pool of IPs the lease belongs to, such as the account of the provider
+	Pool string `json:"pool"`
+	// IP reserved
+	IP string `json:"ip"`
+	// Identifier of the infrastructure which holds the lease
+	InfrastructureID string `json:"infrastructure_id" bson:"infrastructure_id"`
+	// CreationTime is the time the IP was reserved
+	CreationTime time.Time `json:"creation_time" bson:"creation_time"`
+	// ExpirationTime is the time after which the lease is no longer valid if it hasn't been released, in case the engine stopped while creating the node
+	ExpirationTime time.Time `json:"expiration_time" bson:"expiration_time"`
+}
+
+// IPLeaseID returns the identifier of the lease of an IP in a pool
+func IPLeaseID(pool, ip string) string {
+	return pool + "/" + ip
+}
+
+// ProgressFunc is used by long running operations to report the state of each one of the elements they are working on
+type ProgressFunc func(target, state string, err error)
+
+// Deployer is the interface that a module that can deploy virtual resources in a cloud provider must implement. Operations should stop as soon as possible when their context is cancelled.
 type Deployer interface {
-	DeployInfrastructure(infra InfrastructureType) (InfrastructureDeploymentInfo, error)
-	DeleteInfrastructure(infra InfrastructureDeploymentInfo) map[string]error
+	DeployInfrastructure(ctx context.Context, infra InfrastructureType) (InfrastructureDeploymentInfo, error)
+	DeleteInfrastructure(ctx context.Context, infra InfrastructureDeploymentInfo) map[string]error
+	// AddNodes creates new nodes in an existing infrastructure. It must return the infrastructure with the nodes that could be created even if some of them failed.
+	AddNodes(ctx context.Context, infra InfrastructureDeploymentInfo, resources []ResourceType) (InfrastructureDeploymentInfo, error)
+	// RemoveNodes deletes nodes from an existing infrastructure given their hostnames. It returns the infrastructure without the nodes that were deleted and the errors found, indexed by hostname.
+	RemoveNodes(ctx context.Context, infra InfrastructureDeploymentInfo, hostnames []string) (InfrastructureDeploymentInfo, map[string]error)
+	// CheckNodes asks the provider for the real state of the nodes of an infrastructure, including their drives. It returns the status of each node indexed by hostname. Nodes that the provider can't check are not included.
+	CheckNodes(ctx context.Context, infra InfrastructureDeploymentInfo) (map[string]NodeStatus, error)
+	// PlanInfrastructure checks an infrastructure against the provider and describes what would be created, without creating anything. Problems that would make the creation fail are added to the plan and the error is reserved for failures computing the plan itself.
+	PlanInfrastructure(ctx context.Context, infra InfrastructureType) (InfrastructurePlan, error)
+}
+
+// TaggedResource is a resource of a cloud provider tagged by the deployment engine with the infrastructure it was created for
+// swagger:model
+type TaggedResource struct {
+	// Type of resource, such as servers or drives
+	Type string `json:"type"`
+	// Identifier of the resource in the provider
+	UUID string `json:"uuid"`
+	// Name of the resource
+	Name string `json:"name"`
+	// Identifier of the infrastructure of the resource. It's empty if the resource isn't tagged with any infrastructure.
+	InfrastructureID string `json:"infrastructure_id"`
 }
 
-//Provisioner is the interface that must implement custom provisioners such as ansible, etc. If some configuration needs to be passed to other provisioners or saved in the database, it should be done by setting them in the Products field of the passed infrastructure
+// GarbageCollector is implemented by the deployers that tag the resources they create, so the ones left behind by infrastructures that are no longer known can be found
+type GarbageCollector interface {
+	// TaggedResources returns the resources of the provider tagged by this instance of the deployment engine
+	TaggedResources(ctx context.Context) ([]TaggedResource, error)
+	// DeleteResources deletes resources returned by TaggedResources. It returns the errors found indexed by resource identifier.
+	DeleteResources(ctx context.Context, resources []TaggedResource) map[string]error
+}
+
+// GarbageReport is the result of looking for resources of a provider whose infrastructure is not in the repository
+// swagger:model
+type GarbageReport struct {
+	// API type of the provider
+	Provider string `json:"provider"`
+	// Resources tagged with an infrastructure that doesn't exist
+	Orphans []TaggedResource `json:"orphans"`
+	// Identifiers of the orphan resources that were deleted
+	Deleted []string `json:"deleted"`
+	// Errors found deleting orphan resources, indexed by resource identifier
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+//Provisioner is the interface that must implement custom provisioners such as ansible, etc. If some configuration needs to be passed to other provisioners or saved in the database, it should be done by setting them in the Products field of the passed infrastructure. Provisioners should stop as soon as possible when the context is cancelled, killing any external process they started.
 type Provisioner interface {
-	Provision(infra *InfrastructureDeploymentInfo, product string, args Parameters) (Parameters, error)
+	Provision(ctx context.Context, infra *InfrastructureDeploymentInfo, product string, args Parameters) (Parameters, error)
 }
 
 // Frontend is the interface that must be implemented for any frontend that will serve an API around the functionality of the deployment engine
@@ -280,6 +940,31 @@ type Frontend interface {
 	Run(addr string) error
 }
 
+// SetProgress sets the state of a target element of the job, adding it if it's not present yet
+func (j *Job) SetProgress(target, state string, err error) {
+	progress := JobProgress{
+		Target:     target,
+		State:      state,
+		UpdateTime: time.Now(),
+	}
+	if err != nil {
+		progress.Error = err.Error()
+	}
+
+	for i := range j.Progress {
+		if j.Progress[i].Target == target {
+			j.Progress[i] = progress
+			return
+		}
+	}
+	j.Progress = append(j.Progress, progress)
+}
+
+// IsFinished returns true if the job has completed, failed or was cancelled
+func (j Job) IsFinished() bool {
+	return j.State == JobStateCompleted || j.State == JobStateFailed || j.State == JobStateCancelled
+}
+
 // GetBool is an utility function to extract a boolean value from an extra property
 func (p ExtraPropertiesType) GetBool(property string) bool {
 	if p == nil {
@@ -299,6 +984,16 @@ func (p ExtraPropertiesType) GetBool(property string) bool {
 	return boolVal
 }
 
+// PrivateIP returns the IP of the node in the first private network it's attached to with a known address, or an empty string if there isn't any
+func (n NodeInfo) PrivateIP() string {
+	for _, network := range n.Networks {
+		if network.IP != "" {
+			return network.IP
+		}
+	}
+	return ""
+}
+
 // ForEachNode executes the function passed as parameter for each node in the infrastructure
 func (i InfrastructureDeploymentInfo) ForEachNode(apply func(NodeInfo)) {
 	for _, nodes := range i.Nodes {
@@ -317,6 +1012,57 @@ func (i InfrastructureDeploymentInfo) NumNodes() int {
 	return n
 }
 
+// FindNode returns the node with the given hostname, if it exists in the infrastructure
+func (i InfrastructureDeploymentInfo) FindNode(hostname string) (NodeInfo, bool) {
+	for _, nodes := range i.Nodes {
+		for _, node := range nodes {
+			if node.Hostname == hostname {
+				return node, true
+			}
+		}
+	}
+	return NodeInfo{}, false
+}
+
+// AddNode adds a node to the infrastructure, indexing it by its role
+func (i *InfrastructureDeploymentInfo) AddNode(node NodeInfo) {
+	if i.Nodes == nil {
+		i.Nodes = make(map[string][]NodeInfo)
+	}
+	i.Nodes[node.Role] = append(i.Nodes[node.Role], node)
+}
+
+// RemoveNode removes the node with the given hostname from the infrastructure. It returns false if it wasn't found.
+func (i *InfrastructureDeploymentInfo) RemoveNode(hostname string) bool {
+	for role, nodes := range i.Nodes {
+		for j, node := range nodes {
+			if node.Hostname == hostname {
+				remaining := append(nodes[:j:j], nodes[j+1:]...)
+				if len(remaining) == 0 {
+					delete(i.Nodes, role)
+				} else {
+					i.Nodes[role] = remaining
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpdateNode replaces the node with the same hostname in the infrastructure. It returns false if it wasn't found.
+func (i *InfrastructureDeploymentInfo) UpdateNode(updated NodeInfo) bool {
+	for _, nodes := range i.Nodes {
+		for j, node := range nodes {
+			if node.Hostname == updated.Hostname {
+				nodes[j] = updated
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GetFirstNodeOfRole is an utility function that returns the first node of a given role. Used mostly to get the master of a kubernetes cluster.
 func (i InfrastructureDeploymentInfo) GetFirstNodeOfRole(role string) (NodeInfo, error) {
 	nodes, ok := i.Nodes[role]
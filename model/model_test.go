@@ -0,0 +1,112 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package model
+
+import (
+	"testing"
+)
+
+func TestAddRemoveNodes(t *testing.T) {
+	infra := InfrastructureDeploymentInfo{}
+
+	infra.AddNode(NodeInfo{Hostname: "master", Role: "master"})
+	infra.AddNode(NodeInfo{Hostname: "slave1", Role: "slave"})
+	infra.AddNode(NodeInfo{Hostname: "slave2", Role: "slave"})
+
+	if infra.NumNodes() != 3 {
+		t.Fatalf("Expected 3 nodes but found %d", infra.NumNodes())
+	}
+
+	if _, found := infra.FindNode("slave2"); !found {
+		t.Fatal("Can't find added node slave2")
+	}
+
+	if !infra.RemoveNode("slave1") {
+		t.Fatal("Node slave1 not found when removing it")
+	}
+
+	if _, found := infra.FindNode("slave1"); found {
+		t.Fatal("Node slave1 found after removing it")
+	}
+
+	if node, _ := infra.FindNode("slave2"); node.Hostname != "slave2" {
+		t.Fatalf("Unexpected node %v found after removing slave1", node)
+	}
+
+	if !infra.RemoveNode("master") {
+		t.Fatal("Node master not found when removing it")
+	}
+
+	if _, ok := infra.Nodes["master"]; ok {
+		t.Fatal("Empty role kept after removing its last node")
+	}
+
+	if infra.RemoveNode("master") {
+		t.Fatal("Removed a node that didn't exist")
+	}
+
+	if infra.NumNodes() != 1 {
+		t.Fatalf("Expected 1 node but found %d", infra.NumNodes())
+	}
+}
+
+func TestUserData(t *testing.T) {
+	vars := UserDataVars{Hostname: "infra-master", Role: "master", IP: "10.0.0.2"}
+
+	inline := UserData{Inline: "#cloud-config\nhostname: {{.Hostname}}"}
+	rendered, err := inline.Render(vars)
+	if err != nil || rendered != inline.Inline {
+		t.Fatalf("Inline user-data modified: %q %v", rendered, err)
+	}
+
+	tmpl := UserData{Template: "#cloud-config\nhostname: {{.Hostname}}\nwrite_files:\n- path: /etc/role\n  content: {{.Role}} {{.IP}}"}
+	if err := tmpl.Validate(); err != nil {
+		t.Fatalf("Valid template rejected: %s", err.Error())
+	}
+
+	rendered, err = tmpl.Render(vars)
+	if err != nil || rendered != "#cloud-config\nhostname: infra-master\nwrite_files:\n- path: /etc/role\n  content: master 10.0.0.2" {
+		t.Fatalf("Unexpected rendered user-data: %q %v", rendered, err)
+	}
+
+	for _, invalid := range []UserData{
+		UserData{Inline: "#cloud-config", Template: "#cloud-config"},
+		UserData{Template: "{{.Hostname"},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Invalid user-data accepted: %v", invalid)
+		}
+	}
+
+	if _, err := (UserData{Template: "{{.Unknown}}"}).Render(vars); err == nil {
+		t.Fatal("Template with unknown variable rendered")
+	}
+}
+
+func TestOSFamily(t *testing.T) {
+	for distribution, family := range map[string]string{
+		"Ubuntu":                   "Debian",
+		"debian":                   "Debian",
+		"CentOS":                   "RedHat",
+		"Red Hat Enterprise Linux": "RedHat",
+		"FreeBSD":                  "FreeBSD",
+	} {
+		if result := OSFamily(distribution); result != family {
+			t.Fatalf("Expected family %s for distribution %s but got %s", family, distribution, result)
+		}
+	}
+}
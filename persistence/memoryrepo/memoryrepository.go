@@ -20,6 +20,8 @@ import (
 	"deployment-engine/model"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -35,19 +37,35 @@ const (
 // WARNING: When used as vault, it stores credentials and private keys in memory and UNENCRYPTED which is a VERY bad practice and it's strongly discouraged to be used in production. Use it for development and test but change later for a secure vault implementation.
 type MemoryRepository struct {
 	infrastructures map[string]model.InfrastructureDeploymentInfo
+	infrasLock      sync.RWMutex
 	vault           map[string]model.Secret
+	jobs            map[string]model.Job
+	jobsLock        sync.RWMutex
+	groups          map[string]model.DeploymentGroup
+	groupsLock      sync.RWMutex
+	events          []model.Event
+	eventsLock      sync.RWMutex
+	webhooks        map[string]model.Webhook
+	deliveries      map[string]model.WebhookDelivery
+	webhooksLock    sync.RWMutex
+	leases          map[string]model.IPLease
+	leasesLock      sync.Mutex
 }
 
 func CreateMemoryRepository() *MemoryRepository {
 	return &MemoryRepository{
 		infrastructures: make(map[string]model.InfrastructureDeploymentInfo),
 		vault:           make(map[string]model.Secret),
+		jobs:            make(map[string]model.Job),
+		groups:          make(map[string]model.DeploymentGroup),
+		events:          make([]model.Event, 0),
+		webhooks:        make(map[string]model.Webhook),
+		deliveries:      make(map[string]model.WebhookDelivery),
+		leases:          make(map[string]model.IPLease),
 	}
 }
 
-//UpdateInfrastructure updates as a whole an existing infrastructure in a deployment
-func (m *MemoryRepository) UpdateInfrastructure(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
-
+func (m *MemoryRepository) updateInfrastructure(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
 	if infra.ID == "" {
 		return model.InfrastructureDeploymentInfo{}, errors.New("Trying to update infrastructure without identifier")
 	}
@@ -56,39 +74,72 @@ func (m *MemoryRepository) UpdateInfrastructure(infra model.InfrastructureDeploy
 	return infra, nil
 }
 
+func (m *MemoryRepository) findInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error) {
+	infra, ok := m.infrastructures[infraID]
+	if !ok {
+		return infra, fmt.Errorf("Can't find infrastructure with identifier %s", infraID)
+	}
+	return infra, nil
+}
+
+//UpdateInfrastructure updates as a whole an existing infrastructure in a deployment
+func (m *MemoryRepository) UpdateInfrastructure(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
+	m.infrasLock.Lock()
+	defer m.infrasLock.Unlock()
+	return m.updateInfrastructure(infra)
+}
+
 // UpdateInfrastructureStatus updates the status of a infrastructure in a deployment
 func (m *MemoryRepository) UpdateInfrastructureStatus(infrastructureID, status string) (model.InfrastructureDeploymentInfo, error) {
-	infra, err := m.FindInfrastructure(infrastructureID)
+	m.infrasLock.Lock()
+	defer m.infrasLock.Unlock()
+
+	infra, err := m.findInfrastructure(infrastructureID)
 	if err != nil {
 		return infra, err
 	}
 
 	infra.Status = status
-	return m.UpdateInfrastructure(infra)
+	return m.updateInfrastructure(infra)
 }
 
 //AddInfrastructure adds a new infrastructure to an existing deployment
 func (m *MemoryRepository) AddInfrastructure(infra model.InfrastructureDeploymentInfo) (model.InfrastructureDeploymentInfo, error) {
+	m.infrasLock.Lock()
+	defer m.infrasLock.Unlock()
+
 	if infra.ID == "" {
 		infra.ID = uuid.New().String()
 	}
 	infra.CreationTime = time.Now()
-	return m.UpdateInfrastructure(infra)
+	return m.updateInfrastructure(infra)
 }
 
 //FindInfrastructure finds an infrastructure in a deployment given their identifiers
 func (m *MemoryRepository) FindInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error) {
+	m.infrasLock.RLock()
+	defer m.infrasLock.RUnlock()
+	return m.findInfrastructure(infraID)
+}
 
-	infra, ok := m.infrastructures[infraID]
-	if !ok {
-		return infra, fmt.Errorf("Can't find infrastructure with identifier %s", infraID)
+// ListInfrastructures returns all the infrastructures in the repository
+func (m *MemoryRepository) ListInfrastructures() ([]model.InfrastructureDeploymentInfo, error) {
+	m.infrasLock.RLock()
+	defer m.infrasLock.RUnlock()
+
+	result := make([]model.InfrastructureDeploymentInfo, 0, len(m.infrastructures))
+	for _, infra := range m.infrastructures {
+		result = append(result, infra)
 	}
-	return infra, nil
+	return result, nil
 }
 
 //DeleteInfrastructure will delete an infrastructure from a deployment given their identifiers
 func (m *MemoryRepository) DeleteInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error) {
-	infra, err := m.FindInfrastructure(infraID)
+	m.infrasLock.Lock()
+	defer m.infrasLock.Unlock()
+
+	infra, err := m.findInfrastructure(infraID)
 	if err != nil {
 		return infra, err
 	}
@@ -99,8 +150,10 @@ func (m *MemoryRepository) DeleteInfrastructure(infraID string) (model.Infrastru
 
 // AddProductToInfrastructure adds a new product to an existing infrastructure
 func (m *MemoryRepository) AddProductToInfrastructure(infrastructureID, product string, config interface{}) (model.InfrastructureDeploymentInfo, error) {
+	m.infrasLock.Lock()
+	defer m.infrasLock.Unlock()
 
-	infra, err := m.FindInfrastructure(infrastructureID)
+	infra, err := m.findInfrastructure(infrastructureID)
 	if err != nil {
 		return infra, err
 	}
@@ -110,7 +163,7 @@ func (m *MemoryRepository) AddProductToInfrastructure(infrastructureID, product
 	}
 
 	infra.Products[product] = config
-	return m.UpdateInfrastructure(infra)
+	return m.updateInfrastructure(infra)
 }
 
 // AddSecret adds a new secret to the vault, returning its identifier
@@ -147,3 +200,342 @@ func (v *MemoryRepository) DeleteSecret(secretID string) error {
 	delete(v.vault, secretID)
 	return nil
 }
+
+// copyJob returns a copy of the job which doesn't share the progress list with the original one, since jobs are updated concurrently
+func copyJob(job model.Job) model.Job {
+	if job.Progress != nil {
+		progress := make([]model.JobProgress, len(job.Progress))
+		copy(progress, job.Progress)
+		job.Progress = progress
+	}
+	return job
+}
+
+//AddJob adds a new job, assigning it an identifier if it doesn't have one
+func (m *MemoryRepository) AddJob(job model.Job) (model.Job, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	job.CreationTime = time.Now()
+	return m.UpdateJob(job)
+}
+
+//UpdateJob updates as a whole an existing job
+func (m *MemoryRepository) UpdateJob(job model.Job) (model.Job, error) {
+	if job.ID == "" {
+		return model.Job{}, errors.New("Trying to update job without identifier")
+	}
+	job.UpdateTime = time.Now()
+
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+	m.jobs[job.ID] = copyJob(job)
+	return job, nil
+}
+
+//FindJob finds a job given its identifier
+func (m *MemoryRepository) FindJob(jobID string) (model.Job, error) {
+	m.jobsLock.RLock()
+	defer m.jobsLock.RUnlock()
+
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return job, fmt.Errorf("Can't find job with identifier %s", jobID)
+	}
+	return copyJob(job), nil
+}
+
+//FindJobsByState returns the jobs which are in any of the states passed as parameter
+func (m *MemoryRepository) FindJobsByState(states ...string) ([]model.Job, error) {
+	m.jobsLock.RLock()
+	defer m.jobsLock.RUnlock()
+
+	result := make([]model.Job, 0)
+	for _, job := range m.jobs {
+		for _, state := range states {
+			if job.State == state {
+				result = append(result, copyJob(job))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// copyGroup returns a copy of the deployment which doesn't share the list of infrastructures with the original one
+func copyGroup(group model.DeploymentGroup) model.DeploymentGroup {
+	infras := make([]string, len(group.Infrastructures))
+	copy(infras, group.Infrastructures)
+	group.Infrastructures = infras
+	return group
+}
+
+//AddDeploymentGroup adds a new deployment, assigning it an identifier if it doesn't have one
+func (m *MemoryRepository) AddDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error) {
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	group.CreationTime = time.Now()
+	return m.UpdateDeploymentGroup(group)
+}
+
+//UpdateDeploymentGroup updates as a whole an existing deployment
+func (m *MemoryRepository) UpdateDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error) {
+	if group.ID == "" {
+		return model.DeploymentGroup{}, errors.New("Trying to update deployment without identifier")
+	}
+	group.UpdateTime = time.Now()
+
+	m.groupsLock.Lock()
+	defer m.groupsLock.Unlock()
+	m.groups[group.ID] = copyGroup(group)
+	return group, nil
+}
+
+//FindDeploymentGroup finds a deployment given its identifier
+func (m *MemoryRepository) FindDeploymentGroup(groupID string) (model.DeploymentGroup, error) {
+	m.groupsLock.RLock()
+	defer m.groupsLock.RUnlock()
+
+	group, ok := m.groups[groupID]
+	if !ok {
+		return group, fmt.Errorf("Can't find deployment with identifier %s", groupID)
+	}
+	return copyGroup(group), nil
+}
+
+//FindDeploymentGroupsByInfrastructure returns the deployments which the infrastructure belongs to
+func (m *MemoryRepository) FindDeploymentGroupsByInfrastructure(infraID string) ([]model.DeploymentGroup, error) {
+	m.groupsLock.RLock()
+	defer m.groupsLock.RUnlock()
+
+	result := make([]model.DeploymentGroup, 0)
+	for _, group := range m.groups {
+		if group.HasInfrastructure(infraID) {
+			result = append(result, copyGroup(group))
+		}
+	}
+	return result, nil
+}
+
+//DeleteDeploymentGroup deletes a deployment given its identifier. Its infrastructures are not deleted.
+func (m *MemoryRepository) DeleteDeploymentGroup(groupID string) (model.DeploymentGroup, error) {
+	m.groupsLock.Lock()
+	defer m.groupsLock.Unlock()
+
+	group, ok := m.groups[groupID]
+	if !ok {
+		return group, fmt.Errorf("Can't find deployment with identifier %s", groupID)
+	}
+	delete(m.groups, groupID)
+	return group, nil
+}
+
+//AddEvent adds a new event, assigning it an identifier if it doesn't have one and a timestamp if it's not set
+func (m *MemoryRepository) AddEvent(event model.Event) (model.Event, error) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	m.eventsLock.Lock()
+	defer m.eventsLock.Unlock()
+	m.events = append(m.events, event)
+	return event, nil
+}
+
+func (m *MemoryRepository) findEvents(filter func(model.Event) bool) []model.Event {
+	m.eventsLock.RLock()
+	defer m.eventsLock.RUnlock()
+
+	result := make([]model.Event, 0)
+	for _, event := range m.events {
+		if filter(event) {
+			result = append(result, event)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
+}
+
+//FindEventsByInfrastructure returns the events of an infrastructure sorted by timestamp
+func (m *MemoryRepository) FindEventsByInfrastructure(infraID string) ([]model.Event, error) {
+	return m.findEvents(func(event model.Event) bool {
+		return event.InfrastructureID == infraID
+	}), nil
+}
+
+//FindEventsSince returns the events which happened after the time passed as parameter sorted by timestamp
+func (m *MemoryRepository) FindEventsSince(since time.Time) ([]model.Event, error) {
+	return m.findEvents(func(event model.Event) bool {
+		return event.Timestamp.After(since)
+	}), nil
+}
+
+// copyWebhook returns a copy of the webhook which doesn't share the list of events with the original one
+func copyWebhook(hook model.Webhook) model.Webhook {
+	if hook.Events != nil {
+		events := make([]string, len(hook.Events))
+		copy(events, hook.Events)
+		hook.Events = events
+	}
+	return hook
+}
+
+//AddWebhook adds a new webhook, assigning it an identifier if it doesn't have one
+func (m *MemoryRepository) AddWebhook(hook model.Webhook) (model.Webhook, error) {
+	if hook.ID == "" {
+		hook.ID = uuid.New().String()
+	}
+	hook.CreationTime = time.Now()
+
+	m.webhooksLock.Lock()
+	defer m.webhooksLock.Unlock()
+	m.webhooks[hook.ID] = copyWebhook(hook)
+	return hook, nil
+}
+
+//FindWebhook finds a webhook given its identifier
+func (m *MemoryRepository) FindWebhook(hookID string) (model.Webhook, error) {
+	m.webhooksLock.RLock()
+	defer m.webhooksLock.RUnlock()
+
+	hook, ok := m.webhooks[hookID]
+	if !ok {
+		return hook, fmt.Errorf("Can't find webhook with identifier %s", hookID)
+	}
+	return copyWebhook(hook), nil
+}
+
+//ListWebhooks returns all the webhooks in the repository
+func (m *MemoryRepository) ListWebhooks() ([]model.Webhook, error) {
+	m.webhooksLock.RLock()
+	defer m.webhooksLock.RUnlock()
+
+	result := make([]model.Webhook, 0, len(m.webhooks))
+	for _, hook := range m.webhooks {
+		result = append(result, copyWebhook(hook))
+	}
+	return result, nil
+}
+
+//DeleteWebhook deletes a webhook given its identifier. Its deliveries are kept.
+func (m *MemoryRepository) DeleteWebhook(hookID string) (model.Webhook, error) {
+	m.webhooksLock.Lock()
+	defer m.webhooksLock.Unlock()
+
+	hook, ok := m.webhooks[hookID]
+	if !ok {
+		return hook, fmt.Errorf("Can't find webhook with identifier %s", hookID)
+	}
+	delete(m.webhooks, hookID)
+	return hook, nil
+}
+
+//AddDelivery adds a new delivery, assigning it an identifier if it doesn't have one
+func (m *MemoryRepository) AddDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error) {
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	delivery.CreationTime = time.Now()
+	delivery.UpdateTime = delivery.CreationTime
+
+	m.webhooksLock.Lock()
+	defer m.webhooksLock.Unlock()
+	m.deliveries[delivery.ID] = delivery
+	return delivery, nil
+}
+
+//UpdateDelivery updates as a whole an existing delivery
+func (m *MemoryRepository) UpdateDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error) {
+	m.webhooksLock.Lock()
+	defer m.webhooksLock.Unlock()
+
+	if _, ok := m.deliveries[delivery.ID]; !ok {
+		return delivery, fmt.Errorf("Can't find delivery with identifier %s", delivery.ID)
+	}
+	delivery.UpdateTime = time.Now()
+	m.deliveries[delivery.ID] = delivery
+	return delivery, nil
+}
+
+//FindDeliveriesByWebhook returns the deliveries of a webhook sorted by creation time
+func (m *MemoryRepository) FindDeliveriesByWebhook(hookID string) ([]model.WebhookDelivery, error) {
+	m.webhooksLock.RLock()
+	defer m.webhooksLock.RUnlock()
+
+	result := make([]model.WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookID == hookID {
+			result = append(result, delivery)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreationTime.Before(result[j].CreationTime)
+	})
+	return result, nil
+}
+
+//AcquireIPLease reserves an IP for an infrastructure. It returns false if the IP is already leased to another infrastructure and the lease hasn't expired. Leases already held by the same infrastructure are renewed.
+func (m *MemoryRepository) AcquireIPLease(lease model.IPLease) (bool, error) {
+	lease.ID = model.IPLeaseID(lease.Pool, lease.IP)
+	now := time.Now()
+
+	m.leasesLock.Lock()
+	defer m.leasesLock.Unlock()
+
+	current, ok := m.leases[lease.ID]
+	if ok && current.InfrastructureID != lease.InfrastructureID && current.ExpirationTime.After(now) {
+		return false, nil
+	}
+
+	lease.CreationTime = now
+	m.leases[lease.ID] = lease
+	return true, nil
+}
+
+//ReleaseIPLease releases the lease of an IP if it's held by the infrastructure passed as parameter
+func (m *MemoryRepository) ReleaseIPLease(pool, ip, infraID string) error {
+	id := model.IPLeaseID(pool, ip)
+
+	m.leasesLock.Lock()
+	defer m.leasesLock.Unlock()
+
+	if current, ok := m.leases[id]; ok && current.InfrastructureID == infraID {
+		delete(m.leases, id)
+	}
+	return nil
+}
+
+//ReleaseIPLeases releases every lease held by an infrastructure
+func (m *MemoryRepository) ReleaseIPLeases(infraID string) error {
+	m.leasesLock.Lock()
+	defer m.leasesLock.Unlock()
+
+	for id, lease := range m.leases {
+		if lease.InfrastructureID == infraID {
+			delete(m.leases, id)
+		}
+	}
+	return nil
+}
+
+//FindIPLeases returns the leases of a pool which haven't expired
+func (m *MemoryRepository) FindIPLeases(pool string) ([]model.IPLease, error) {
+	now := time.Now()
+
+	m.leasesLock.Lock()
+	defer m.leasesLock.Unlock()
+
+	result := make([]model.IPLease, 0)
+	for _, lease := range m.leases {
+		if lease.Pool == pool && lease.ExpirationTime.After(now) {
+			result = append(result, lease)
+		}
+	}
+	return result, nil
+}
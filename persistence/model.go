@@ -18,6 +18,7 @@ package persistence
 
 import (
 	"deployment-engine/model"
+	"time"
 )
 
 //DeploymentRepository is the interface that must be implemented by persistence providers for deployments.
@@ -32,6 +33,9 @@ type DeploymentRepository interface {
 	//FindInfrastructure finds an infrastructure in a deployment given their identifiers
 	FindInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error)
 
+	//ListInfrastructures returns all the infrastructures in the repository
+	ListInfrastructures() ([]model.InfrastructureDeploymentInfo, error)
+
 	//DeleteInfrastructure will delete an infrastructure from a deployment given their identifiers
 	DeleteInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error)
 
@@ -40,6 +44,91 @@ type DeploymentRepository interface {
 
 	// AddProductToInfrastructure adds a new product to an existing infrastructure
 	AddProductToInfrastructure(infrastructureID, product string, configuration interface{}) (model.InfrastructureDeploymentInfo, error)
+
+	//AddDeploymentGroup adds a new deployment, assigning it an identifier if it doesn't have one
+	AddDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error)
+
+	//UpdateDeploymentGroup updates as a whole an existing deployment
+	UpdateDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error)
+
+	//FindDeploymentGroup finds a deployment given its identifier
+	FindDeploymentGroup(groupID string) (model.DeploymentGroup, error)
+
+	//FindDeploymentGroupsByInfrastructure returns the deployments which the infrastructure belongs to
+	FindDeploymentGroupsByInfrastructure(infraID string) ([]model.DeploymentGroup, error)
+
+	//DeleteDeploymentGroup deletes a deployment given its identifier. Its infrastructures are not deleted.
+	DeleteDeploymentGroup(groupID string) (model.DeploymentGroup, error)
+}
+
+// JobRepository is the interface that must be implemented by persistence providers for asynchronous jobs.
+type JobRepository interface {
+
+	//AddJob adds a new job, assigning it an identifier if it doesn't have one
+	AddJob(job model.Job) (model.Job, error)
+
+	//UpdateJob updates as a whole an existing job
+	UpdateJob(job model.Job) (model.Job, error)
+
+	//FindJob finds a job given its identifier
+	FindJob(jobID string) (model.Job, error)
+
+	//FindJobsByState returns the jobs which are in any of the states passed as parameter
+	FindJobsByState(states ...string) ([]model.Job, error)
+}
+
+// EventRepository is the interface that must be implemented by persistence providers for the events of infrastructures.
+type EventRepository interface {
+
+	//AddEvent adds a new event, assigning it an identifier if it doesn't have one and a timestamp if it's not set
+	AddEvent(event model.Event) (model.Event, error)
+
+	//FindEventsByInfrastructure returns the events of an infrastructure sorted by timestamp
+	FindEventsByInfrastructure(infraID string) ([]model.Event, error)
+
+	//FindEventsSince returns the events which happened after the time passed as parameter sorted by timestamp
+	FindEventsSince(since time.Time) ([]model.Event, error)
+}
+
+// WebhookRepository is the interface that must be implemented by persistence providers for webhook subscriptions and their deliveries.
+type WebhookRepository interface {
+
+	//AddWebhook adds a new webhook, assigning it an identifier if it doesn't have one
+	AddWebhook(hook model.Webhook) (model.Webhook, error)
+
+	//FindWebhook finds a webhook given its identifier
+	FindWebhook(hookID string) (model.Webhook, error)
+
+	//ListWebhooks returns all the webhooks in the repository
+	ListWebhooks() ([]model.Webhook, error)
+
+	//DeleteWebhook deletes a webhook given its identifier. Its deliveries are kept.
+	DeleteWebhook(hookID string) (model.Webhook, error)
+
+	//AddDelivery adds a new delivery, assigning it an identifier if it doesn't have one
+	AddDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error)
+
+	//UpdateDelivery updates as a whole an existing delivery
+	UpdateDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error)
+
+	//FindDeliveriesByWebhook returns the deliveries of a webhook sorted by creation time
+	FindDeliveriesByWebhook(hookID string) ([]model.WebhookDelivery, error)
+}
+
+// IPLeaseRepository is the interface that must be implemented by persistence providers for the reservations of IPs. Leases must be acquired atomically, since they are shared by every goroutine and instance of the deployment engine using the repository.
+type IPLeaseRepository interface {
+
+	//AcquireIPLease reserves an IP for an infrastructure. It returns false if the IP is already leased to another infrastructure and the lease hasn't expired. Leases already held by the same infrastructure are renewed.
+	AcquireIPLease(lease model.IPLease) (bool, error)
+
+	//ReleaseIPLease releases the lease of an IP if it's held by the infrastructure passed as parameter
+	ReleaseIPLease(pool, ip, infraID string) error
+
+	//ReleaseIPLeases releases every lease held by an infrastructure
+	ReleaseIPLeases(infraID string) error
+
+	//FindIPLeases returns the leases of a pool which haven't expired
+	FindIPLeases(pool string) ([]model.IPLease, error)
 }
 
 // Vault will be implemented by components that store authentication information. They can do so locally or they can be remote vaults like Hashicorp Vault.
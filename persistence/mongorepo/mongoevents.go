@@ -0,0 +1,61 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package mongorepo
+
+import (
+	"deployment-engine/model"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const eventsCollection = "events"
+
+func (m *MongoRepository) findEvents(filter interface{}) ([]model.Event, error) {
+	result := make([]model.Event, 0)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	cursor, err := m.database.Collection(eventsCollection).Find(ctx, filter, options.Find().SetSort(bson.M{"timestamp": 1}))
+	if err != nil {
+		return result, err
+	}
+	return result, cursor.All(ctx, &result)
+}
+
+//AddEvent adds a new event, assigning it an identifier if it doesn't have one and a timestamp if it's not set
+func (m *MongoRepository) AddEvent(event model.Event) (model.Event, error) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return event, m.insert(eventsCollection, event)
+}
+
+//FindEventsByInfrastructure returns the events of an infrastructure sorted by timestamp
+func (m *MongoRepository) FindEventsByInfrastructure(infraID string) ([]model.Event, error) {
+	return m.findEvents(bson.M{"infrastructure_id": infraID})
+}
+
+//FindEventsSince returns the events which happened after the time passed as parameter sorted by timestamp
+func (m *MongoRepository) FindEventsSince(since time.Time) ([]model.Event, error) {
+	return m.findEvents(bson.M{"timestamp": bson.M{"$gt": since}})
+}
@@ -0,0 +1,72 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package mongorepo
+
+import (
+	"deployment-engine/model"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const groupsCollection = "deployment_groups"
+
+//AddDeploymentGroup adds a new deployment, assigning it an identifier if it doesn't have one
+func (m *MongoRepository) AddDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error) {
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	if group.Infrastructures == nil {
+		group.Infrastructures = make([]string, 0)
+	}
+	group.CreationTime = time.Now()
+	group.UpdateTime = time.Now()
+	return group, m.insert(groupsCollection, group)
+}
+
+//UpdateDeploymentGroup updates as a whole an existing deployment
+func (m *MongoRepository) UpdateDeploymentGroup(group model.DeploymentGroup) (model.DeploymentGroup, error) {
+	var updated model.DeploymentGroup
+	group.UpdateTime = time.Now()
+	err := m.replace(groupsCollection, group.ID, group, &updated)
+	return updated, err
+}
+
+//FindDeploymentGroup finds a deployment given its identifier
+func (m *MongoRepository) FindDeploymentGroup(groupID string) (model.DeploymentGroup, error) {
+	var result model.DeploymentGroup
+	err := m.get(groupsCollection, groupID, &result)
+	return result, err
+}
+
+//FindDeploymentGroupsByInfrastructure returns the deployments which the infrastructure belongs to
+func (m *MongoRepository) FindDeploymentGroupsByInfrastructure(infraID string) ([]model.DeploymentGroup, error) {
+	result := make([]model.DeploymentGroup, 0)
+	err := m.findAll(groupsCollection, bson.M{"infrastructures": infraID}, &result)
+	return result, err
+}
+
+//DeleteDeploymentGroup deletes a deployment given its identifier. Its infrastructures are not deleted.
+func (m *MongoRepository) DeleteDeploymentGroup(groupID string) (model.DeploymentGroup, error) {
+	result, err := m.FindDeploymentGroup(groupID)
+	if err != nil {
+		return result, err
+	}
+	err = m.delete(groupsCollection, groupID)
+	return result, err
+}
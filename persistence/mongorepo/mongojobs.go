@@ -0,0 +1,59 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package mongorepo
+
+import (
+	"deployment-engine/model"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const jobsCollection = "jobs"
+
+//AddJob adds a new job, assigning it an identifier if it doesn't have one
+func (m *MongoRepository) AddJob(job model.Job) (model.Job, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	job.CreationTime = time.Now()
+	job.UpdateTime = time.Now()
+	return job, m.insert(jobsCollection, job)
+}
+
+//UpdateJob updates as a whole an existing job
+func (m *MongoRepository) UpdateJob(job model.Job) (model.Job, error) {
+	var updated model.Job
+	job.UpdateTime = time.Now()
+	err := m.replace(jobsCollection, job.ID, job, &updated)
+	return updated, err
+}
+
+//FindJob finds a job given its identifier
+func (m *MongoRepository) FindJob(jobID string) (model.Job, error) {
+	var result model.Job
+	err := m.get(jobsCollection, jobID, &result)
+	return result, err
+}
+
+//FindJobsByState returns the jobs which are in any of the states passed as parameter
+func (m *MongoRepository) FindJobsByState(states ...string) ([]model.Job, error) {
+	result := make([]model.Job, 0)
+	err := m.findAll(jobsCollection, bson.M{"state": bson.M{"$in": states}}, &result)
+	return result, err
+}
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package mongorepo
+
+import (
+	"deployment-engine/model"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	leasesCollection = "ipleases"
+
+	duplicateKeyCode = 11000
+)
+
+// isDuplicateKey returns true if the error is caused by inserting a document whose identifier already exists
+func isDuplicateKey(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == duplicateKeyCode
+}
+
+//AcquireIPLease reserves an IP for an infrastructure. It returns false if the IP is already leased to another infrastructure and the lease hasn't expired. Leases already held by the same infrastructure are renewed.
+func (m *MongoRepository) AcquireIPLease(lease model.IPLease) (bool, error) {
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	lease.ID = model.IPLeaseID(lease.Pool, lease.IP)
+	lease.CreationTime = time.Now()
+
+	// The upsert only matches free, expired or own leases. If the lease is held by another infrastructure the insertion fails with a duplicate key, so two engines can't acquire it at the same time.
+	filter := bson.M{
+		"_id": lease.ID,
+		"$or": bson.A{
+			bson.M{"expiration_time": bson.M{"$lte": lease.CreationTime}},
+			bson.M{"infrastructure_id": lease.InfrastructureID},
+		},
+	}
+	_, err := m.database.Collection(leasesCollection).ReplaceOne(ctx, filter, lease, options.Replace().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//ReleaseIPLease releases the lease of an IP if it's held by the infrastructure passed as parameter
+func (m *MongoRepository) ReleaseIPLease(pool, ip, infraID string) error {
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	_, err := m.database.Collection(leasesCollection).DeleteOne(ctx, bson.M{
+		"_id":               model.IPLeaseID(pool, ip),
+		"infrastructure_id": infraID,
+	})
+	return err
+}
+
+//ReleaseIPLeases releases every lease held by an infrastructure
+func (m *MongoRepository) ReleaseIPLeases(infraID string) error {
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	_, err := m.database.Collection(leasesCollection).DeleteMany(ctx, bson.M{"infrastructure_id": infraID})
+	return err
+}
+
+//FindIPLeases returns the leases of a pool which haven't expired
+func (m *MongoRepository) FindIPLeases(pool string) ([]model.IPLease, error) {
+	result := make([]model.IPLease, 0)
+	err := m.findAll(leasesCollection, bson.M{
+		"pool":            pool,
+		"expiration_time": bson.M{"$gt": time.Now()},
+	}, &result)
+	return result, err
+}
@@ -40,6 +40,9 @@ const (
 
 	VaultPassphraseName = "mongodb.vault.passphrase"
 
+	MongoDBTimeoutName    = "mongodb.timeout"
+	MongoDBTimeoutDefault = "30s"
+
 	deploymentCollection = "deployments"
 )
 
@@ -48,6 +51,8 @@ type MongoRepository struct {
 	database                    *mongo.Database
 	cipher                      cipher.AEAD
 	defaultFindAndUpdateOptions *options.FindOneAndUpdateOptions
+	// timeout is the maximum time that an operation can take before being cancelled
+	timeout time.Duration
 }
 
 func initializeCipher(passphrase string) (cipher.AEAD, error) {
@@ -63,8 +68,13 @@ func initializeCipher(passphrase string) (cipher.AEAD, error) {
 
 func CreateRepositoryNative() (*MongoRepository, error) {
 	viper.SetDefault(MongoDBURLName, MongoDBURLDefault)
+	viper.SetDefault(MongoDBTimeoutName, MongoDBTimeoutDefault)
 	mongoConnectionURL := viper.GetString(MongoDBURLName)
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoConnectionURL), nil)
+	timeout := viper.GetDuration(MongoDBTimeoutName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoConnectionURL), nil)
 	if err != nil {
 		log.WithError(err).Errorf("Error connecting to MongoDB server %s", mongoConnectionURL)
 		return nil, err
@@ -73,6 +83,7 @@ func CreateRepositoryNative() (*MongoRepository, error) {
 	repo := MongoRepository{
 		client:                      client,
 		defaultFindAndUpdateOptions: options.FindOneAndUpdate().SetReturnDocument(options.After),
+		timeout:                     timeout,
 	}
 	repo.SetDatabase("deployment_engine")
 
@@ -93,38 +104,58 @@ func (m *MongoRepository) SetDatabase(db string) {
 	m.database = m.client.Database(db)
 }
 
+// operationContext returns a context which cancels the operations that take longer than the configured timeout, so a hung database doesn't block the engine forever
+func (m *MongoRepository) operationContext() (context.Context, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), m.timeout)
+}
+
 func (m *MongoRepository) ClearDatabase() error {
-	return m.database.Drop(context.Background())
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	return m.database.Drop(ctx)
 }
 
 func (m *MongoRepository) insert(collection string, object interface{}) error {
-	_, err := m.database.Collection(collection).InsertOne(context.Background(), object)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	_, err := m.database.Collection(collection).InsertOne(ctx, object)
 	return err
 }
 
 func (m *MongoRepository) replace(collection string, id string, object interface{}, result interface{}) error {
-	return m.database.Collection(collection).FindOneAndReplace(context.Background(), bson.M{"_id": id}, object, options.FindOneAndReplace().SetReturnDocument(options.After)).Decode(result)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	return m.database.Collection(collection).FindOneAndReplace(ctx, bson.M{"_id": id}, object, options.FindOneAndReplace().SetReturnDocument(options.After)).Decode(result)
 }
 
 func (m *MongoRepository) update(collection string, id string, update bson.M, updated interface{}) error {
-	result := m.database.Collection(collection).FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, m.defaultFindAndUpdateOptions)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	result := m.database.Collection(collection).FindOneAndUpdate(ctx, bson.M{"_id": id}, update, m.defaultFindAndUpdateOptions)
 	return result.Decode(updated)
 }
 
 func (m *MongoRepository) get(collection string, id string, result interface{}) error {
-	return m.database.Collection(collection).FindOne(context.Background(), bson.M{"_id": id}).Decode(result)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+	return m.database.Collection(collection).FindOne(ctx, bson.M{"_id": id}).Decode(result)
 }
 
 func (m *MongoRepository) list(collection string, appender func(interface{}), current interface{}) error {
-	cursor, err := m.database.Collection(collection).Find(context.Background(), bson.M{})
-
-	defer cursor.Close(context.Background())
+	ctx, cancel := m.operationContext()
+	defer cancel()
 
+	cursor, err := m.database.Collection(collection).Find(ctx, bson.M{})
 	if err != nil {
 		return err
 	}
 
-	for cursor.Next(context.Background()) {
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
 		err = cursor.Decode(current)
 
 		if err != nil {
@@ -138,8 +169,22 @@ func (m *MongoRepository) list(collection string, appender func(interface{}), cu
 	return nil
 }
 
+func (m *MongoRepository) findAll(collection string, filter interface{}, results interface{}) error {
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	cursor, err := m.database.Collection(collection).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
 func (m *MongoRepository) delete(collection, ID string) error {
-	result, err := m.database.Collection(collection).DeleteOne(context.Background(), bson.M{"_id": ID})
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	result, err := m.database.Collection(collection).DeleteOne(ctx, bson.M{"_id": ID})
 	if err != nil {
 		return err
 	}
@@ -179,6 +224,13 @@ func (m *MongoRepository) FindInfrastructure(infraID string) (model.Infrastructu
 	return result, err
 }
 
+// ListInfrastructures returns all the infrastructures in the repository
+func (m *MongoRepository) ListInfrastructures() ([]model.InfrastructureDeploymentInfo, error) {
+	result := make([]model.InfrastructureDeploymentInfo, 0)
+	err := m.findAll(deploymentCollection, bson.M{}, &result)
+	return result, err
+}
+
 //DeleteInfrastructure will delete an infrastructure from a deployment given their identifiers
 func (m *MongoRepository) DeleteInfrastructure(infraID string) (model.InfrastructureDeploymentInfo, error) {
 	result, err := m.FindInfrastructure(infraID)
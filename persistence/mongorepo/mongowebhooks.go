@@ -0,0 +1,96 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package mongorepo
+
+import (
+	"deployment-engine/model"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	webhooksCollection   = "webhooks"
+	deliveriesCollection = "webhook_deliveries"
+)
+
+//AddWebhook adds a new webhook, assigning it an identifier if it doesn't have one
+func (m *MongoRepository) AddWebhook(hook model.Webhook) (model.Webhook, error) {
+	if hook.ID == "" {
+		hook.ID = uuid.New().String()
+	}
+	hook.CreationTime = time.Now()
+	return hook, m.insert(webhooksCollection, hook)
+}
+
+//FindWebhook finds a webhook given its identifier
+func (m *MongoRepository) FindWebhook(hookID string) (model.Webhook, error) {
+	var result model.Webhook
+	err := m.get(webhooksCollection, hookID, &result)
+	return result, err
+}
+
+//ListWebhooks returns all the webhooks in the repository
+func (m *MongoRepository) ListWebhooks() ([]model.Webhook, error) {
+	result := make([]model.Webhook, 0)
+	err := m.findAll(webhooksCollection, bson.M{}, &result)
+	return result, err
+}
+
+//DeleteWebhook deletes a webhook given its identifier. Its deliveries are kept.
+func (m *MongoRepository) DeleteWebhook(hookID string) (model.Webhook, error) {
+	result, err := m.FindWebhook(hookID)
+	if err != nil {
+		return result, err
+	}
+	err = m.delete(webhooksCollection, hookID)
+	return result, err
+}
+
+//AddDelivery adds a new delivery, assigning it an identifier if it doesn't have one
+func (m *MongoRepository) AddDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error) {
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	delivery.CreationTime = time.Now()
+	delivery.UpdateTime = delivery.CreationTime
+	return delivery, m.insert(deliveriesCollection, delivery)
+}
+
+//UpdateDelivery updates as a whole an existing delivery
+func (m *MongoRepository) UpdateDelivery(delivery model.WebhookDelivery) (model.WebhookDelivery, error) {
+	var updated model.WebhookDelivery
+	delivery.UpdateTime = time.Now()
+	err := m.replace(deliveriesCollection, delivery.ID, delivery, &updated)
+	return updated, err
+}
+
+//FindDeliveriesByWebhook returns the deliveries of a webhook sorted by creation time
+func (m *MongoRepository) FindDeliveriesByWebhook(hookID string) ([]model.WebhookDelivery, error) {
+	result := make([]model.WebhookDelivery, 0)
+	ctx, cancel := m.operationContext()
+	defer cancel()
+
+	cursor, err := m.database.Collection(deliveriesCollection).Find(ctx, bson.M{"webhook_id": hookID}, options.Find().SetSort(bson.M{"creationtime": 1}))
+	if err != nil {
+		return result, err
+	}
+	return result, cursor.All(ctx, &result)
+}
@@ -21,13 +21,21 @@ import (
 var integrationMongo = flag.Bool("mongo", false, "run MongoDB integration tests")
 
 var depRepos []DeploymentRepository
+var jobRepos []JobRepository
 var vaults []Vault
+var eventRepos []EventRepository
+var webhookRepos []WebhookRepository
+var leaseRepos []IPLeaseRepository
 
 func TestMain(m *testing.M) {
 
 	memRepo := memoryrepo.CreateMemoryRepository()
 	depRepos = append(depRepos, memRepo)
+	jobRepos = append(jobRepos, memRepo)
 	vaults = append(vaults, memRepo)
+	eventRepos = append(eventRepos, memRepo)
+	webhookRepos = append(webhookRepos, memRepo)
+	leaseRepos = append(leaseRepos, memRepo)
 
 	os.Exit(m.Run())
 }
@@ -46,9 +54,18 @@ func TestRepository(t *testing.T) {
 			log.Fatalf("Error clearing database")
 		}
 		depRepos = append(depRepos, repo)
+		jobRepos = append(jobRepos, repo)
 		vaults = append(vaults, repo)
+		eventRepos = append(eventRepos, repo)
+		webhookRepos = append(webhookRepos, repo)
+		leaseRepos = append(leaseRepos, repo)
 	}
 	t.Run("Deployments", testDeployment)
+	t.Run("Jobs", testJobs)
+	t.Run("DeploymentGroups", testDeploymentGroups)
+	t.Run("Events", testEvents)
+	t.Run("Webhooks", testWebhooks)
+	t.Run("IPLeases", testIPLeases)
 	t.Run("Vault", testVault)
 }
 
@@ -162,6 +179,15 @@ func testDeployment(t *testing.T) {
 
 		testTime(t, "adding product", beforeUpdate, after.UpdateTime)
 
+		all, err := repo.ListInfrastructures()
+		if err != nil {
+			t.Fatalf("Error listing infrastructures: %s", err.Error())
+		}
+
+		if len(all) != 1 || all[0].ID != infra.ID {
+			t.Fatalf("Expected infrastructure %s in list but found %v", infra.ID, all)
+		}
+
 		after = testInfra(t, func() (model.InfrastructureDeploymentInfo, error) {
 			return repo.DeleteInfrastructure(infra.ID)
 		}, infra, "Error adding product to infrastructure")
@@ -173,6 +199,339 @@ func testDeployment(t *testing.T) {
 	}
 }
 
+func testJobs(t *testing.T) {
+	t.Logf("Testing %d job repositories", len(jobRepos))
+	for _, repo := range jobRepos {
+		job := model.Job{
+			Type:  "deployment",
+			State: model.JobStatePending,
+		}
+		job.SetProgress("infra1", model.JobStatePending, nil)
+
+		timeBefore := time.Now()
+		after, err := repo.AddJob(job)
+		if err != nil {
+			t.Fatalf("Error inserting job: %s", err.Error())
+		}
+
+		if after.ID == "" {
+			t.Fatal("Job inserted without identifier")
+		}
+
+		testTime(t, "job creation", timeBefore, after.CreationTime)
+
+		after.State = model.JobStateRunning
+		after.SetProgress("infra1", "creating", nil)
+		after, err = repo.UpdateJob(after)
+		if err != nil {
+			t.Fatalf("Error updating job: %s", err.Error())
+		}
+
+		found, err := repo.FindJob(after.ID)
+		if err != nil {
+			t.Fatalf("Error finding job %s: %s", after.ID, err.Error())
+		}
+
+		if found.State != model.JobStateRunning || len(found.Progress) != 1 || found.Progress[0].State != "creating" {
+			t.Fatalf("Unexpected job state found after update: %v", found)
+		}
+
+		running, err := repo.FindJobsByState(model.JobStatePending, model.JobStateRunning)
+		if err != nil {
+			t.Fatalf("Error finding jobs by state: %s", err.Error())
+		}
+
+		if len(running) != 1 || running[0].ID != after.ID {
+			t.Fatalf("Expected to find running job %s but found %v", after.ID, running)
+		}
+
+		found.State = model.JobStateCompleted
+		_, err = repo.UpdateJob(found)
+		if err != nil {
+			t.Fatalf("Error updating job: %s", err.Error())
+		}
+
+		running, err = repo.FindJobsByState(model.JobStatePending, model.JobStateRunning)
+		if err != nil {
+			t.Fatalf("Error finding jobs by state: %s", err.Error())
+		}
+
+		if len(running) != 0 {
+			t.Fatalf("Found running jobs after completing them: %v", running)
+		}
+	}
+}
+
+func testDeploymentGroups(t *testing.T) {
+	for _, repo := range depRepos {
+		group, err := repo.AddDeploymentGroup(model.DeploymentGroup{
+			Name:            "group",
+			Labels:          map[string]string{"env": "test"},
+			Infrastructures: []string{"infra1", "infra2"},
+		})
+		if err != nil {
+			t.Fatalf("Error inserting deployment: %s", err.Error())
+		}
+
+		if group.ID == "" {
+			t.Fatal("Deployment inserted without identifier")
+		}
+
+		group.RemoveInfrastructure("infra1")
+		group.AddInfrastructure("infra3")
+		_, err = repo.UpdateDeploymentGroup(group)
+		if err != nil {
+			t.Fatalf("Error updating deployment: %s", err.Error())
+		}
+
+		found, err := repo.FindDeploymentGroup(group.ID)
+		if err != nil {
+			t.Fatalf("Error finding deployment %s: %s", group.ID, err.Error())
+		}
+
+		if found.Name != "group" || found.Labels["env"] != "test" || len(found.Infrastructures) != 2 || found.Infrastructures[1] != "infra3" {
+			t.Fatalf("Unexpected deployment found after update: %v", found)
+		}
+
+		groups, err := repo.FindDeploymentGroupsByInfrastructure("infra3")
+		if err != nil {
+			t.Fatalf("Error finding deployments by infrastructure: %s", err.Error())
+		}
+
+		if len(groups) != 1 || groups[0].ID != group.ID {
+			t.Fatalf("Expected to find deployment %s but found %v", group.ID, groups)
+		}
+
+		groups, err = repo.FindDeploymentGroupsByInfrastructure("infra1")
+		if err != nil || len(groups) != 0 {
+			t.Fatalf("Found deployments of detached infrastructure: %v, %v", groups, err)
+		}
+
+		_, err = repo.DeleteDeploymentGroup(group.ID)
+		if err != nil {
+			t.Fatalf("Error deleting deployment: %s", err.Error())
+		}
+
+		if _, err = repo.FindDeploymentGroup(group.ID); err == nil {
+			t.Fatal("Found deployment after deleting it")
+		}
+	}
+}
+
+func testEvents(t *testing.T) {
+	for _, repo := range eventRepos {
+		start := time.Now().Add(-time.Hour)
+		created, err := repo.AddEvent(model.Event{
+			Type:             model.EventInfrastructureCreated,
+			InfrastructureID: "infra1",
+			Principal:        "admin",
+			Timestamp:        start,
+		})
+		if err != nil {
+			t.Fatalf("Error inserting event: %s", err.Error())
+		}
+
+		if created.ID == "" {
+			t.Fatal("Event inserted without identifier")
+		}
+
+		deleted, err := repo.AddEvent(model.Event{
+			Type:             model.EventInfrastructureDeleted,
+			InfrastructureID: "infra1",
+			Principal:        "operator",
+		})
+		if err != nil {
+			t.Fatalf("Error inserting event: %s", err.Error())
+		}
+
+		testTime(t, "event creation", start, deleted.Timestamp)
+
+		_, err = repo.AddEvent(model.Event{
+			Type:             model.EventInfrastructureCreated,
+			InfrastructureID: "infra2",
+			Principal:        "admin",
+		})
+		if err != nil {
+			t.Fatalf("Error inserting event: %s", err.Error())
+		}
+
+		events, err := repo.FindEventsByInfrastructure("infra1")
+		if err != nil {
+			t.Fatalf("Error finding events of infrastructure: %s", err.Error())
+		}
+
+		if len(events) != 2 || events[0].ID != created.ID || events[1].Principal != "operator" {
+			t.Fatalf("Unexpected events found for infrastructure: %v", events)
+		}
+
+		events, err = repo.FindEventsSince(start)
+		if err != nil {
+			t.Fatalf("Error finding events since %s: %s", start, err.Error())
+		}
+
+		if len(events) != 2 || events[0].ID != deleted.ID {
+			t.Fatalf("Unexpected events found since %s: %v", start, events)
+		}
+	}
+}
+
+func testWebhooks(t *testing.T) {
+	for _, repo := range webhookRepos {
+		hook, err := repo.AddWebhook(model.Webhook{
+			URL:      "http://localhost/hook",
+			Events:   []string{model.EventInfrastructureCreated},
+			Secret:   "not saved",
+			SecretID: "secret1",
+		})
+		if err != nil {
+			t.Fatalf("Error inserting webhook: %s", err.Error())
+		}
+
+		found, err := repo.FindWebhook(hook.ID)
+		if err != nil {
+			t.Fatalf("Error finding webhook: %s", err.Error())
+		}
+
+		if found.URL != hook.URL || found.SecretID != "secret1" || len(found.Events) != 1 {
+			t.Fatalf("Unexpected webhook found: %v", found)
+		}
+
+		hooks, err := repo.ListWebhooks()
+		if err != nil {
+			t.Fatalf("Error listing webhooks: %s", err.Error())
+		}
+
+		if len(hooks) != 1 {
+			t.Fatalf("Unexpected webhooks found: %v", hooks)
+		}
+
+		delivery, err := repo.AddDelivery(model.WebhookDelivery{
+			WebhookID: hook.ID,
+			Event: model.Event{
+				ID:   "event1",
+				Type: model.EventInfrastructureCreated,
+			},
+			State: model.DeliveryStatePending,
+		})
+		if err != nil {
+			t.Fatalf("Error inserting delivery: %s", err.Error())
+		}
+
+		delivery.State = model.DeliveryStateDelivered
+		delivery.Attempts = 2
+		delivery.ResponseCode = 200
+		_, err = repo.UpdateDelivery(delivery)
+		if err != nil {
+			t.Fatalf("Error updating delivery: %s", err.Error())
+		}
+
+		_, err = repo.AddDelivery(model.WebhookDelivery{
+			WebhookID: "other",
+			State:     model.DeliveryStatePending,
+		})
+		if err != nil {
+			t.Fatalf("Error inserting delivery: %s", err.Error())
+		}
+
+		deliveries, err := repo.FindDeliveriesByWebhook(hook.ID)
+		if err != nil {
+			t.Fatalf("Error finding deliveries: %s", err.Error())
+		}
+
+		if len(deliveries) != 1 || deliveries[0].State != model.DeliveryStateDelivered || deliveries[0].Attempts != 2 || deliveries[0].Event.ID != "event1" {
+			t.Fatalf("Unexpected deliveries found: %v", deliveries)
+		}
+
+		_, err = repo.DeleteWebhook(hook.ID)
+		if err != nil {
+			t.Fatalf("Error deleting webhook: %s", err.Error())
+		}
+
+		if _, err = repo.FindWebhook(hook.ID); err == nil {
+			t.Fatal("Found webhook after deleting it")
+		}
+	}
+}
+
+func testIPLeases(t *testing.T) {
+	for _, repo := range leaseRepos {
+		lease := model.IPLease{
+			Pool:             "pool1",
+			IP:               "10.0.0.1",
+			InfrastructureID: "infra1",
+			ExpirationTime:   time.Now().Add(time.Hour),
+		}
+
+		acquired, err := repo.AcquireIPLease(lease)
+		if err != nil || !acquired {
+			t.Fatalf("Error acquiring free IP: %v", err)
+		}
+
+		// The same infrastructure renews its lease but others can't get it
+		acquired, err = repo.AcquireIPLease(lease)
+		if err != nil || !acquired {
+			t.Fatalf("Error renewing IP lease: %v", err)
+		}
+
+		other := lease
+		other.InfrastructureID = "infra2"
+		acquired, err = repo.AcquireIPLease(other)
+		if err != nil || acquired {
+			t.Fatalf("Leased IP acquired by another infrastructure: %v", err)
+		}
+
+		// Expired leases can be taken by other infrastructures
+		expired := model.IPLease{Pool: "pool1", IP: "10.0.0.2", InfrastructureID: "infra1", ExpirationTime: time.Now().Add(-time.Minute)}
+		_, err = repo.AcquireIPLease(expired)
+		if err != nil {
+			t.Fatalf("Error acquiring IP: %s", err.Error())
+		}
+		expired.InfrastructureID = "infra2"
+		expired.ExpirationTime = time.Now().Add(time.Hour)
+		acquired, err = repo.AcquireIPLease(expired)
+		if err != nil || !acquired {
+			t.Fatalf("Error acquiring expired IP lease: %v", err)
+		}
+
+		leases, err := repo.FindIPLeases("pool1")
+		if err != nil {
+			t.Fatalf("Error finding IP leases: %s", err.Error())
+		}
+		if len(leases) != 2 {
+			t.Fatalf("Unexpected IP leases found: %v", leases)
+		}
+
+		// Only the infrastructure which holds a lease can release it
+		err = repo.ReleaseIPLease("pool1", "10.0.0.1", "infra2")
+		if err != nil {
+			t.Fatalf("Error releasing IP lease: %s", err.Error())
+		}
+		acquired, _ = repo.AcquireIPLease(other)
+		if acquired {
+			t.Fatal("IP lease released by another infrastructure")
+		}
+
+		err = repo.ReleaseIPLease("pool1", "10.0.0.1", "infra1")
+		if err != nil {
+			t.Fatalf("Error releasing IP lease: %s", err.Error())
+		}
+		acquired, err = repo.AcquireIPLease(other)
+		if err != nil || !acquired {
+			t.Fatalf("Error acquiring released IP: %v", err)
+		}
+
+		err = repo.ReleaseIPLeases("infra2")
+		if err != nil {
+			t.Fatalf("Error releasing IP leases of infrastructure: %s", err.Error())
+		}
+
+		leases, _ = repo.FindIPLeases("pool1")
+		if len(leases) != 0 {
+			t.Fatalf("IP leases left after releasing them: %v", leases)
+		}
+	}
+}
+
 func testVault(t *testing.T) {
 	t.Logf("Testing %d vaults", len(vaults))
 	for _, repo := range vaults {
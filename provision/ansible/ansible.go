@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"fmt"
 	"os"
@@ -61,7 +62,7 @@ type Inventory struct {
 
 type ProductProvisioner interface {
 	BuildInventory(infra *model.InfrastructureDeploymentInfo, args model.Parameters) (Inventory, error)
-	DeployProduct(inventory string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error)
+	DeployProduct(ctx context.Context, inventory string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error)
 }
 
 func New() (*Provisioner, error) {
@@ -103,7 +104,7 @@ func (p *Provisioner) AddProvisioner(name string, provisioner ProductProvisioner
 	p.Provisioners[name] = provisioner
 }
 
-func (p *Provisioner) WaitForSSHPortReady(infra *model.InfrastructureDeploymentInfo, args model.Parameters) error {
+func (p *Provisioner) WaitForSSHPortReady(ctx context.Context, infra *model.InfrastructureDeploymentInfo, args model.Parameters) error {
 	logger := log.WithField("infrastructure", infra.ID)
 	logger.Info("Waiting for port 22 to be ready")
 
@@ -117,7 +118,7 @@ func (p *Provisioner) WaitForSSHPortReady(infra *model.InfrastructureDeploymentI
 		return err
 	}
 
-	return ExecutePlaybook(logger, p.ScriptsFolder+"/common/wait_ssh_ready.yml", inventoryPath, nil)
+	return ExecutePlaybook(ctx, logger, p.ScriptsFolder+"/common/wait_ssh_ready.yml", inventoryPath, nil)
 }
 
 func (p Provisioner) WriteGroup(inventoryFile *os.File, group InventoryGroup) error {
@@ -176,53 +177,45 @@ func (p Provisioner) WriteHost(inventoryFile *os.File, host InventoryHost) error
 	return err
 }
 
+// WriteInventory writes the inventory of a product for an infrastructure. It's written every time since nodes can be added or removed from the infrastructure between provisions.
 func (p Provisioner) WriteInventory(infraID, product string, inventory Inventory) (string, error) {
 	path := p.GetInventoryFolder(infraID)
 	filePath := fmt.Sprintf("%s_%s", p.GetInventoryPath(infraID), product)
 
-	if _, err := os.Stat(filePath); err == nil {
-
-		return filePath, nil
-
-	} else if os.IsNotExist(err) {
-
-		err := os.MkdirAll(path, os.ModePerm)
-		if err != nil {
-			log.WithError(err).Errorf("Error creating inventory folder %s", path)
-			return path, err
-		}
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		log.WithError(err).Errorf("Error creating inventory folder %s", path)
+		return path, err
+	}
 
-		log.Infof("Creating inventory at %s", filePath)
-		inventoryFile, err := os.Create(filePath)
-		defer inventoryFile.Close()
+	log.Infof("Creating inventory at %s", filePath)
+	inventoryFile, err := os.Create(filePath)
+	if err != nil {
+		log.WithError(err).Errorf("Error creating inventory file %s", filePath)
+		return path, err
+	}
+	defer inventoryFile.Close()
 
+	for _, host := range inventory.Hosts {
+		err = p.WriteHost(inventoryFile, host)
 		if err != nil {
-			log.WithError(err).Errorf("Error creating inventory file %s", filePath)
-			return path, err
+			return filePath, err
 		}
+	}
 
-		for _, host := range inventory.Hosts {
-			err = p.WriteHost(inventoryFile, host)
+	if inventory.Groups != nil {
+		for _, group := range inventory.Groups {
+			err = p.WriteGroup(inventoryFile, group)
 			if err != nil {
 				return filePath, err
 			}
 		}
-
-		if inventory.Groups != nil {
-			for _, group := range inventory.Groups {
-				err = p.WriteGroup(inventoryFile, group)
-				if err != nil {
-					return filePath, err
-				}
-			}
-		}
-
 	}
 
 	return filePath, nil
 }
 
-func (p Provisioner) Provision(infra *model.InfrastructureDeploymentInfo, product string, args model.Parameters) (model.Parameters, error) {
+func (p Provisioner) Provision(ctx context.Context, infra *model.InfrastructureDeploymentInfo, product string, args model.Parameters) (model.Parameters, error) {
 
 	if args == nil {
 		args = make(model.Parameters)
@@ -246,7 +239,7 @@ func (p Provisioner) Provision(infra *model.InfrastructureDeploymentInfo, produc
 		return result, err
 	}
 
-	res, err := provisioner.DeployProduct(inventoryPath, infra, args)
+	res, err := provisioner.DeployProduct(ctx, inventoryPath, infra, args)
 	if res == nil {
 		return result, err
 	}
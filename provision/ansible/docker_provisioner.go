@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 
@@ -37,13 +38,13 @@ func (p DockerProvisioner) BuildInventory(infra *model.InfrastructureDeploymentI
 	return DefaultAllInventory(*infra), nil
 }
 
-func (p DockerProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p DockerProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithField("product", "docker")
-	err := utils.ExecuteCommand(logger, "ansible-galaxy", "install", "geerlingguy.docker")
+	err := utils.ExecuteCommand(ctx, logger, "ansible-galaxy", "install", "geerlingguy.docker")
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, ExecutePlaybook(logger, p.parent.ScriptsFolder+"/docker/main.yml", inventoryPath, nil)
+	return nil, ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/docker/main.yml", inventoryPath, nil)
 }
@@ -19,6 +19,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"fmt"
 	"strings"
@@ -41,7 +42,7 @@ func (p FluentdProvisioner) BuildInventory(infra *model.InfrastructureDeployment
 	return DefaultKubernetesInventory(*infra), nil
 }
 
-func (p FluentdProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p FluentdProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
@@ -61,7 +62,7 @@ func (p FluentdProvisioner) DeployProduct(inventoryPath string, infra *model.Inf
 		return nil, fmt.Errorf("Error marshalling elasticsearch parameters: %w", err)
 	}
 
-	return nil, ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/deploy_fluentd.yml", inventoryPath, map[string]string{
+	return nil, ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/deploy_fluentd.yml", inventoryPath, map[string]string{
 		"values": string(vals),
 	})
 }
@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"encoding/json"
 	"fmt"
@@ -110,7 +111,7 @@ func (p GlusterfsProvisioner) generateGlusterFSTopology(infra model.Infrastructu
 	return string(result), nil
 }
 
-func (p GlusterfsProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p GlusterfsProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
@@ -128,7 +129,7 @@ func (p GlusterfsProvisioner) DeployProduct(inventoryPath string, infra *model.I
 		singleNode = "--single-node"
 	}
 
-	return nil, ExecutePlaybook(logger, p.scriptsFolder+"/kubernetes/glusterfs/deploy_glusterfs.yml", inventoryPath, map[string]string{
+	return nil, ExecutePlaybook(ctx, logger, p.scriptsFolder+"/kubernetes/glusterfs/deploy_glusterfs.yml", inventoryPath, map[string]string{
 		"topology":       topology,
 		"single_node":    singleNode,
 		"install_client": string(strconv.AppendBool([]byte{}, installClient)),
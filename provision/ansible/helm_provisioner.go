@@ -19,6 +19,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 
 	"github.com/sirupsen/logrus"
@@ -38,12 +39,12 @@ func (p HelmProvisioner) BuildInventory(infra *model.InfrastructureDeploymentInf
 	return DefaultKubernetesInventory(*infra), nil
 }
 
-func (p HelmProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p HelmProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
 	})
 	infra.Products["helm"] = true
 
-	return nil, ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/deploy_helm.yml", inventoryPath, nil)
+	return nil, ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/deploy_helm.yml", inventoryPath, nil)
 }
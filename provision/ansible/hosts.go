@@ -1,6 +1,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 
 	"github.com/sirupsen/logrus"
@@ -19,11 +20,11 @@ func (p HostsProvisioner) BuildInventory(infra *model.InfrastructureDeploymentIn
 	return DefaultAllInventory(*infra), nil
 }
 
-func (p HostsProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p HostsProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
 	})
 
-	return nil, ExecutePlaybook(logger, p.parent.ScriptsFolder+"/common/add_hostname.yml", inventoryPath, nil)
+	return nil, ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/common/add_hostname.yml", inventoryPath, nil)
 }
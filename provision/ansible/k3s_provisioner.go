@@ -19,6 +19,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 
 	"strconv"
@@ -44,7 +45,7 @@ func (p K3sProvisioner) BuildInventory(infra *model.InfrastructureDeploymentInfo
 	return DefaultKubernetesInventory(*infra), nil
 }
 
-func (p K3sProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p K3sProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
@@ -57,7 +58,7 @@ func (p K3sProvisioner) DeployProduct(inventoryPath string, infra *model.Infrast
 		return nil, err
 	}
 
-	err = ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/deploy_k3s.yml", inventoryPath, map[string]string{
+	err = ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/deploy_k3s.yml", inventoryPath, map[string]string{
 		"master_ip":        master.IP,
 		"inventory_folder": inventoryFolder,
 		"install_curl":     strconv.FormatBool(!infra.ExtraProperties.GetBool(K3sCurlInstalled)),
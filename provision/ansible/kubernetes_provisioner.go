@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 
@@ -66,14 +67,14 @@ func (p KubernetesProvisioner) BuildInventory(infra *model.InfrastructureDeploym
 	return DefaultKubernetesInventory(*infra), nil
 }
 
-func (p KubernetesProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p KubernetesProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	logger := logrus.WithField("product", "kubernetes").WithField("infrastructure", infra.ID)
 
 	if infra.ExtraProperties.GetBool(KubeadmPreinstalledProperty) {
 
-		err := ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/kubeadm.yml", inventoryPath, nil)
+		err := ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/kubeadm.yml", inventoryPath, nil)
 		if err != nil {
 			return result, err
 		}
@@ -82,7 +83,7 @@ func (p KubernetesProvisioner) DeployProduct(inventoryPath string, infra *model.
 
 		if !infra.ExtraProperties.GetBool(DockerPresentProperty) {
 			args["wait"] = []string{"false"}
-			out, err := p.parent.Provision(infra, "docker", args)
+			out, err := p.parent.Provision(ctx, infra, "docker", args)
 			if err != nil {
 				return out, err
 			}
@@ -90,19 +91,19 @@ func (p KubernetesProvisioner) DeployProduct(inventoryPath string, infra *model.
 		}
 
 		logger := logrus.WithField("product", "kubernetes")
-		err := utils.ExecuteCommand(logger, "ansible-galaxy", "install", "geerlingguy.kubernetes")
+		err := utils.ExecuteCommand(ctx, logger, "ansible-galaxy", "install", "geerlingguy.kubernetes")
 		if err != nil {
 			return result, err
 		}
 
-		err = ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/main.yml", inventoryPath, nil)
+		err = ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/main.yml", inventoryPath, nil)
 		if err != nil {
 			return result, err
 		}
 	}
 
 	inventoryFolder := p.parent.GetInventoryFolder(infra.ID)
-	err := ExecutePlaybook(logger, p.parent.ScriptsFolder+"/kubernetes/get_k8s_config.yml", inventoryPath, map[string]string{
+	err := ExecutePlaybook(ctx, logger, p.parent.ScriptsFolder+"/kubernetes/get_k8s_config.yml", inventoryPath, map[string]string{
 		"inventory_folder": inventoryFolder,
 	})
 
@@ -118,7 +119,7 @@ func (p KubernetesProvisioner) DeployProduct(inventoryPath string, infra *model.
 	repos := utils.GetDockerRepositories()
 	if repos != nil && len(repos) > 0 {
 		args[AnsibleWaitForSSHReadyProperty] = []string{"false"}
-		out, err := p.parent.Provision(infra, "private_registries", args)
+		out, err := p.parent.Provision(ctx, infra, "private_registries", args)
 		if err != nil {
 			return result, err
 		}
@@ -19,6 +19,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 
 	"github.com/sirupsen/logrus"
@@ -74,10 +75,10 @@ func (p KubesprayProvisioner) BuildInventory(infra *model.InfrastructureDeployme
 	return baseInventory, err
 }
 
-func (p KubesprayProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p KubesprayProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(logrus.Fields{
 		"infrastructure": infra.ID,
 	})
-	return nil, ExecutePlaybook(logger, p.kubesprayFolder+"/cluster.yml", inventoryPath, nil)
+	return nil, ExecutePlaybook(ctx, logger, p.kubesprayFolder+"/cluster.yml", inventoryPath, nil)
 }
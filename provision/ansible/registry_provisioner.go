@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"fmt"
@@ -44,7 +45,7 @@ func (p RegistryProvisioner) BuildInventory(infra *model.InfrastructureDeploymen
 	return DefaultKubernetesInventory(*infra), nil
 }
 
-func (p RegistryProvisioner) DeployProduct(inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p RegistryProvisioner) DeployProduct(ctx context.Context, inventoryPath string, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	logger := logrus.WithFields(map[string]interface{}{
 		"infrastructure": infra.ID,
@@ -74,14 +75,14 @@ func (p RegistryProvisioner) DeployProduct(inventoryPath string, infra *model.In
 			args["cert_file"] = repo.Certificate
 		}
 
-		err = ExecutePlaybook(logger, p.scriptsFolder+"/kubernetes/docker_repository.yml", inventoryPath, args)
+		err = ExecutePlaybook(ctx, logger, p.scriptsFolder+"/kubernetes/docker_repository.yml", inventoryPath, args)
 		if err != nil {
 			return nil, fmt.Errorf("Error configuring repository %s: %w", repo.Name, err)
 		}
 	}
 
 	if len(repos) > 0 {
-		err = ExecutePlaybook(logger, p.scriptsFolder+"/kubernetes/docker_repository_secret.yml", inventoryPath, map[string]string{
+		err = ExecutePlaybook(ctx, logger, p.scriptsFolder+"/kubernetes/docker_repository_secret.yml", inventoryPath, map[string]string{
 			"secret_name": KubernetesRegistriesSecretName,
 		})
 		if err != nil {
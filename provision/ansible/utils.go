@@ -17,6 +17,7 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"encoding/json"
@@ -29,17 +30,28 @@ import (
 const (
 	ansibleHostProperty    = "ansible_host"
 	ansibleUserProperty    = "ansible_user"
+	privateIPProperty      = "private_ip"
+	osFamilyProperty       = "os_family"
 	kuberneterRoleProperty = "kubernetes_role"
 )
 
 func DefaultInventoryHost(node model.NodeInfo) InventoryHost {
-	return InventoryHost{
+	result := InventoryHost{
 		Name: node.Hostname,
 		Vars: map[string]string{
 			ansibleHostProperty: node.IP,
 			ansibleUserProperty: node.Username,
 		},
 	}
+	// Address to use for the traffic between nodes, if they share a private network
+	if privateIP := node.PrivateIP(); privateIP != "" {
+		result.Vars[privateIPProperty] = privateIP
+	}
+	// Family of the OS of the boot image, so playbooks can choose their tasks before gathering facts
+	if node.OSFamily != "" {
+		result.Vars[osFamilyProperty] = node.OSFamily
+	}
+	return result
 }
 
 func DefaultKubernetesInventoryHost(node model.NodeInfo) InventoryHost {
@@ -85,7 +97,7 @@ func DefaultKubernetesInventory(infra model.InfrastructureDeploymentInfo) Invent
 	return result
 }
 
-func ExecutePlaybook(logger *log.Entry, script string, inventory string, extravars map[string]string) error {
+func ExecutePlaybook(ctx context.Context, logger *log.Entry, script string, inventory string, extravars map[string]string) error {
 	args := make([]string, 1)
 	args[0] = script
 
@@ -102,5 +114,5 @@ func ExecutePlaybook(logger *log.Entry, script string, inventory string, extrava
 		args = append(args, string(vars))
 	}
 
-	return utils.ExecuteCommand(logger, "ansible-playbook", args...)
+	return utils.ExecuteCommand(ctx, logger, "ansible-playbook", args...)
 }
@@ -1,10 +1,15 @@
 package ansible
 
 import (
+	"context"
 	"deployment-engine/model"
+	"deployment-engine/utils"
 	"fmt"
+	"strings"
 	"testing"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/go-test/deep"
 )
 
@@ -104,6 +109,23 @@ func TestInventoryNode(t *testing.T) {
 	testNodeEquality(t, "test-node", "127.0.0.1", "clouduser", "", "", DefaultInventoryHost)
 }
 
+func TestInventoryNodePrivateIP(t *testing.T) {
+	node, expected := buildNode("test-node", "127.0.0.1", "clouduser", "", "")
+	node.Networks = []model.NetworkInfo{
+		model.NetworkInfo{ID: "unaddressed"},
+		model.NetworkInfo{ID: "private", IP: "192.168.0.1"},
+	}
+	expected.Vars[privateIPProperty] = "192.168.0.1"
+	testHostEquality(t, DefaultInventoryHost(node), expected)
+}
+
+func TestInventoryNodeOSFamily(t *testing.T) {
+	node, expected := buildNode("test-node", "127.0.0.1", "clouduser", "", "")
+	node.OSFamily = "Debian"
+	expected.Vars[osFamilyProperty] = "Debian"
+	testHostEquality(t, DefaultInventoryHost(node), expected)
+}
+
 func TestKubernetesInventoryNode(t *testing.T) {
 	testNodeEquality(t, "test-node", "127.0.0.1", "clouduser", "master", "master", DefaultKubernetesInventoryHost)
 	testNodeEquality(t, "test-node", "127.0.0.1", "clouduser", "mAstEr", "master", DefaultKubernetesInventoryHost)
@@ -175,3 +197,27 @@ func TestKubernetesInventory(t *testing.T) {
 		}
 	}
 }
+
+func TestSimulatedPlaybook(t *testing.T) {
+	simulated := utils.NewSimulatedExecutor(0, 0, "ansible-playbook")
+	utils.SetCommandExecutor(simulated)
+	defer utils.SetCommandExecutor(utils.SystemExecutor{})
+
+	err := ExecutePlaybook(context.Background(), log.WithField("test", "simulated"), "scripts/common/wait_ssh_ready.yml", "/tmp/inventory", map[string]string{
+		"var": "value",
+	})
+	if err != nil {
+		t.Fatalf("Error executing simulated playbook: %s", err.Error())
+	}
+
+	executed := simulated.Executed()
+	if len(executed) != 1 || strings.Join(executed[0], " ") != `ansible-playbook scripts/common/wait_ssh_ready.yml --inventory=/tmp/inventory --extra-vars {"var":"value"}` {
+		t.Fatalf("Unexpected simulated commands: %v", executed)
+	}
+
+	simulated.FailureRate = 1
+	err = ExecutePlaybook(context.Background(), log.WithField("test", "simulated"), "scripts/common/wait_ssh_ready.yml", "/tmp/inventory", nil)
+	if err == nil {
+		t.Fatal("Expected simulated failure of playbook")
+	}
+}
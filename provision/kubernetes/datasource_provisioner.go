@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"errors"
@@ -57,7 +58,7 @@ type DatasourceProvisioner struct {
 	InternalPort    int32
 }
 
-func (p DatasourceProvisioner) Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p DatasourceProvisioner) Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	logger := logrus.WithFields(logrus.Fields{
@@ -100,7 +101,7 @@ func (p DatasourceProvisioner) Provision(config *KubernetesConfiguration, infra
 		Extra: make(model.Parameters),
 	}
 
-	kubernetesClient, err := NewClient(config.ConfigurationFile)
+	kubernetesClient, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		logger.WithError(err).Error("Error getting kubernetes client")
 		return result, err
@@ -121,7 +122,7 @@ func (p DatasourceProvisioner) Provision(config *KubernetesConfiguration, infra
 	secret := GetSecretDescription(secretData)
 
 	logger.Infof("Creating Secret %s", secretData.SecretID)
-	secretOut, err := kubernetesClient.CreateOrUpdateSecret(logger, namespace, &secret)
+	secretOut, err := kubernetesClient.CreateOrUpdateSecret(ctx, logger, namespace, &secret)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error creating secret", err)
 	}
@@ -159,7 +160,7 @@ func (p DatasourceProvisioner) Provision(config *KubernetesConfiguration, infra
 	}
 
 	logger.Info("Creating datasource pod")
-	podOut, err := kubernetesClient.CreateOrUpdateStatefulSet(logger, namespace, &podDescription)
+	podOut, err := kubernetesClient.CreateOrUpdateStatefulSet(ctx, logger, namespace, &podDescription)
 	if err != nil {
 
 		kubernetesClient.Client.CoreV1().Secrets(secretOut.GetNamespace()).Delete(secretOut.GetName(), &defaultDeleteOptions)
@@ -186,7 +187,7 @@ func (p DatasourceProvisioner) Provision(config *KubernetesConfiguration, infra
 	}
 
 	logger.Info("Creating datasource service")
-	_, err = kubernetesClient.CreateOrUpdateService(logger, namespace, &dsService)
+	_, err = kubernetesClient.CreateOrUpdateService(ctx, logger, namespace, &dsService)
 
 	if err != nil {
 		kubernetesClient.Client.AppsV1().StatefulSets(podOut.GetNamespace()).Delete(podOut.GetName(), &defaultDeleteOptions)
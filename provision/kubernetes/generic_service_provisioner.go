@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"errors"
@@ -40,7 +41,7 @@ func (p GenericServiceProvisioner) ValidateExternalPort(port int, config *Kubern
 
 }
 
-func (p GenericServiceProvisioner) Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p GenericServiceProvisioner) Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	name, ok := args.GetString("name")
@@ -76,7 +77,7 @@ func (p GenericServiceProvisioner) Provision(config *KubernetesConfiguration, in
 		return result, fmt.Errorf("Service %s already exists", name)
 	}
 
-	client, err := NewClient(config.ConfigurationFile)
+	client, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, err
 	}
@@ -114,7 +115,7 @@ func (p GenericServiceProvisioner) Provision(config *KubernetesConfiguration, in
 
 	pod := GetDeploymentDescription(fmt.Sprintf("%s-deployment", name), int32(replicas), terminationPeriod, labels, images, "", "", repoSecrets, nil)
 
-	_, err = client.CreateOrUpdateDeployment(logger, apiv1.NamespaceDefault, &pod)
+	_, err = client.CreateOrUpdateDeployment(ctx, logger, apiv1.NamespaceDefault, &pod)
 	if err != nil {
 		return result, fmt.Errorf("Error creating pod for service %s: %w", name, err)
 	}
@@ -139,7 +140,7 @@ func (p GenericServiceProvisioner) Provision(config *KubernetesConfiguration, in
 		},
 	}
 
-	_, err = client.CreateOrUpdateService(logger, apiv1.NamespaceDefault, &service)
+	_, err = client.CreateOrUpdateService(ctx, logger, apiv1.NamespaceDefault, &service)
 	if err != nil {
 		return result, fmt.Errorf("Error creating service %s: %w", name, err)
 	}
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 
@@ -27,20 +28,20 @@ type KSMProvisioner struct {
 	scriptsFolder string
 }
 
-func (p KSMProvisioner) Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p KSMProvisioner) Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 	result := make(model.Parameters)
 	logger := logrus.WithFields(logrus.Fields{
 		"product": "kube-state-metrics",
 		"infra":   infra.ID,
 	})
 
-	kubeClient, err := NewClient(config.ConfigurationFile)
+	kubeClient, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
 	}
 
 	logger.Info("Creating kube-state-metrics monitoring")
-	err = kubeClient.ExecuteDeployScript(logger, p.scriptsFolder+"/kube-state-metrics/deploy.yml")
+	err = kubeClient.ExecuteDeployScript(ctx, logger, p.scriptsFolder+"/kube-state-metrics/deploy.yml")
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error deploying kube-state-metrics monitoring", err)
 	}
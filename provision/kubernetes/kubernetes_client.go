@@ -18,6 +18,7 @@ package kubernetes
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -104,10 +105,16 @@ type ImageInfo struct {
 // swagger:model
 type ImageSet map[string]ImageInfo
 
+// InfrastructureLabel is the label of the objects created by the deployment engine in a cluster. Its value is the identifier of the infrastructure they belong to.
+const InfrastructureLabel = "deployment-engine/infrastructure"
+
 type KubernetesClient struct {
 	ConfigPath string
 	Config     *rest.Config
 	Client     *kubernetes.Clientset
+
+	// InfrastructureID is the value of the InfrastructureLabel set on the objects created by the client. They aren't labelled if it's empty.
+	InfrastructureID string
 }
 
 func NewClient(configFilePath string) (*KubernetesClient, error) {
@@ -125,6 +132,27 @@ func NewClient(configFilePath string) (*KubernetesClient, error) {
 	return &result, err
 }
 
+// NewInfrastructureClient creates a client that labels the objects it creates with the identifier of the infrastructure, so they can be deleted with it
+func NewInfrastructureClient(configFilePath string, infraID string) (*KubernetesClient, error) {
+	result, err := NewClient(configFilePath)
+	result.InfrastructureID = infraID
+	return result, err
+}
+
+// setInfrastructureLabel adds the InfrastructureLabel to the metadata of an object. The labels are copied since they are usually shared with selectors and pod templates.
+func (c KubernetesClient) setInfrastructureLabel(meta *metav1.ObjectMeta) {
+	if c.InfrastructureID == "" {
+		return
+	}
+
+	labels := make(map[string]string, len(meta.Labels)+1)
+	for k, v := range meta.Labels {
+		labels[k] = v
+	}
+	labels[InfrastructureLabel] = c.InfrastructureID
+	meta.Labels = labels
+}
+
 func GetConfigMapDataFromFolder(configFolder string, vars map[string]interface{}) (map[string]string, error) {
 	result := make(map[string]string)
 	files, err := ioutil.ReadDir(configFolder)
@@ -419,7 +447,7 @@ func GetStatefulSetDescription(name string, replicas int32, terminationPeriod in
 	}, nil
 }
 
-func CreateOrUpdateResource(logger *logrus.Entry, name string, getter func() (interface{}, error), deleter func(string, *metav1.DeleteOptions) error, creater func() (interface{}, error)) (interface{}, error) {
+func CreateOrUpdateResource(ctx context.Context, logger *logrus.Entry, name string, getter func() (interface{}, error), deleter func(string, *metav1.DeleteOptions) error, creater func() (interface{}, error)) (interface{}, error) {
 	log := logger
 	existing, err := getter()
 	if err != nil && !k8serrors.IsNotFound(err) {
@@ -430,7 +458,7 @@ func CreateOrUpdateResource(logger *logrus.Entry, name string, getter func() (in
 		log.Info("Resource exists. Deleting")
 		deleter(name, &metav1.DeleteOptions{})
 		log.Info("Waiting for resource to be deleted")
-		_, timeout, err := utils.WaitForStatusChange("Deleting", 2*time.Minute, func() (string, error) {
+		_, timeout, err := utils.WaitForStatusChange(ctx, "Deleting", 2*time.Minute, func() (string, error) {
 			exist, err := getter()
 			if err != nil && k8serrors.IsNotFound(err) {
 				return "Deleted", nil
@@ -466,10 +494,11 @@ func CreateOrUpdateResource(logger *logrus.Entry, name string, getter func() (in
 	return result, nil
 }
 
-func (c KubernetesClient) CreateOrUpdateDeployment(logger *logrus.Entry, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
+func (c KubernetesClient) CreateOrUpdateDeployment(ctx context.Context, logger *logrus.Entry, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
 	depClient := c.Client.AppsV1().Deployments(namespace)
+	c.setInfrastructureLabel(&deployment.ObjectMeta)
 	name := deployment.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "Deployment").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "Deployment").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -480,10 +509,11 @@ func (c KubernetesClient) CreateOrUpdateDeployment(logger *logrus.Entry, namespa
 	return result.(*appsv1.Deployment), err
 }
 
-func (c KubernetesClient) CreateOrUpdateConfigMap(logger *logrus.Entry, namespace string, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+func (c KubernetesClient) CreateOrUpdateConfigMap(ctx context.Context, logger *logrus.Entry, namespace string, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
 	depClient := c.Client.CoreV1().ConfigMaps(namespace)
+	c.setInfrastructureLabel(&configMap.ObjectMeta)
 	name := configMap.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "ConfigMap").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "ConfigMap").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -494,10 +524,11 @@ func (c KubernetesClient) CreateOrUpdateConfigMap(logger *logrus.Entry, namespac
 	return result.(*corev1.ConfigMap), err
 }
 
-func (c KubernetesClient) CreateOrUpdateService(logger *logrus.Entry, namespace string, service *corev1.Service) (*corev1.Service, error) {
+func (c KubernetesClient) CreateOrUpdateService(ctx context.Context, logger *logrus.Entry, namespace string, service *corev1.Service) (*corev1.Service, error) {
 	depClient := c.Client.CoreV1().Services(namespace)
+	c.setInfrastructureLabel(&service.ObjectMeta)
 	name := service.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "Service").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "Service").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -508,10 +539,11 @@ func (c KubernetesClient) CreateOrUpdateService(logger *logrus.Entry, namespace
 	return result.(*corev1.Service), err
 }
 
-func (c KubernetesClient) CreateOrUpdateSecret(logger *logrus.Entry, namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
+func (c KubernetesClient) CreateOrUpdateSecret(ctx context.Context, logger *logrus.Entry, namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
 	depClient := c.Client.CoreV1().Secrets(namespace)
+	c.setInfrastructureLabel(&secret.ObjectMeta)
 	name := secret.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "Secret").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "Secret").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -522,10 +554,14 @@ func (c KubernetesClient) CreateOrUpdateSecret(logger *logrus.Entry, namespace s
 	return result.(*corev1.Secret), err
 }
 
-func (c KubernetesClient) CreateOrUpdateStatefulSet(logger *logrus.Entry, namespace string, set *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
+func (c KubernetesClient) CreateOrUpdateStatefulSet(ctx context.Context, logger *logrus.Entry, namespace string, set *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
 	depClient := c.Client.AppsV1().StatefulSets(namespace)
+	c.setInfrastructureLabel(&set.ObjectMeta)
+	for i := range set.Spec.VolumeClaimTemplates {
+		c.setInfrastructureLabel(&set.Spec.VolumeClaimTemplates[i].ObjectMeta)
+	}
 	name := set.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "StatefulSet").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "StatefulSet").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -536,10 +572,11 @@ func (c KubernetesClient) CreateOrUpdateStatefulSet(logger *logrus.Entry, namesp
 	return result.(*appsv1.StatefulSet), err
 }
 
-func (c KubernetesClient) CreateOrUpdatePVC(logger *logrus.Entry, namespace string, pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+func (c KubernetesClient) CreateOrUpdatePVC(ctx context.Context, logger *logrus.Entry, namespace string, pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
 	depClient := c.Client.CoreV1().PersistentVolumeClaims(namespace)
+	c.setInfrastructureLabel(&pvc.ObjectMeta)
 	name := pvc.ObjectMeta.Name
-	result, err := CreateOrUpdateResource(logger.WithField("resource", "PVC").WithField("name", name), name,
+	result, err := CreateOrUpdateResource(ctx, logger.WithField("resource", "PVC").WithField("name", name), name,
 		func() (interface{}, error) {
 			return depClient.Get(name, metav1.GetOptions{})
 		},
@@ -551,9 +588,9 @@ func (c KubernetesClient) CreateOrUpdatePVC(logger *logrus.Entry, namespace stri
 	return result.(*corev1.PersistentVolumeClaim), err
 }
 
-func (c KubernetesClient) CreateKubectlCommand(logger *logrus.Entry, action string, args ...string) *exec.Cmd {
+func (c KubernetesClient) CreateKubectlCommand(ctx context.Context, logger *logrus.Entry, action string, args ...string) *exec.Cmd {
 	finalArgs := append([]string{action}, args...)
-	return utils.CreateCommand(logger, map[string]string{
+	return utils.CreateCommand(ctx, logger, map[string]string{
 		"KUBECONFIG": c.ConfigPath,
 	}, true, "kubectl", finalArgs...)
 }
@@ -593,38 +630,59 @@ func (c KubernetesClient) GetUsedNodePorts() ([]int, error) {
 	return ports, nil
 }
 
-func (c KubernetesClient) ExecuteKubectlCommand(logger *logrus.Entry, action string, args ...string) error {
-	return c.CreateKubectlCommand(logger, action, args...).Run()
+func (c KubernetesClient) ExecuteKubectlCommand(ctx context.Context, logger *logrus.Entry, action string, args ...string) error {
+	return utils.RunCommand(c.CreateKubectlCommand(ctx, logger, action, args...))
+}
+
+// labelManifest sets the InfrastructureLabel on the objects of a manifest already created with kubectl
+func (c KubernetesClient) labelManifest(ctx context.Context, logger *logrus.Entry, manifest string, input []byte) error {
+	if c.InfrastructureID == "" {
+		return nil
+	}
+
+	cmd := c.CreateKubectlCommand(ctx, logger, "label", "--overwrite", "-f", manifest, fmt.Sprintf("%s=%s", InfrastructureLabel, c.InfrastructureID))
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	return utils.RunCommand(cmd)
 }
 
-func (c KubernetesClient) ExecuteDeployScript(logger *logrus.Entry, script string) error {
-	return c.ExecuteKubectlCommand(logger, "create", "-f", script)
+func (c KubernetesClient) ExecuteDeployScript(ctx context.Context, logger *logrus.Entry, script string) error {
+	err := c.ExecuteKubectlCommand(ctx, logger, "create", "-f", script)
+	if err != nil {
+		return err
+	}
+
+	err = c.labelManifest(ctx, logger, script, nil)
+	if err != nil {
+		return utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error labelling objects of script %s", script), err)
+	}
+	return nil
 }
 
-func (c KubernetesClient) ExecuteDeployTemplate(logger *logrus.Entry, name, templateFile string, vars map[string]interface{}) error {
+func (c KubernetesClient) ExecuteDeployTemplate(ctx context.Context, logger *logrus.Entry, name, templateFile string, vars map[string]interface{}) error {
 	clusterDefinition, err := template.New(name).ParseFiles(templateFile)
 	if err != nil {
 		return utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error reading template file %s", templateFile), err)
 	}
 
-	cmd := c.CreateKubectlCommand(logger, "create", "-f", "-")
-	writer, err := cmd.StdinPipe()
+	var manifest bytes.Buffer
+	err = clusterDefinition.Execute(&manifest, vars)
 	if err != nil {
-		return utils.WrapLogAndReturnError(logger, "Error getting input pipe for command", err)
+		return utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error executing template file %s", templateFile), err)
 	}
 
-	go func() {
-		defer writer.Close()
-		err = clusterDefinition.Execute(writer, vars)
-		if err != nil {
-			logger.WithError(err).Error("Error executing deployment template")
-		}
-	}()
-
-	err = cmd.Run()
+	cmd := c.CreateKubectlCommand(ctx, logger, "create", "-f", "-")
+	cmd.Stdin = bytes.NewReader(manifest.Bytes())
+	err = utils.RunCommand(cmd)
 	if err != nil {
 		return utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error executing template file %s", templateFile), err)
 	}
 
+	err = c.labelManifest(ctx, logger, "-", manifest.Bytes())
+	if err != nil {
+		return utils.WrapLogAndReturnError(logger, fmt.Sprintf("Error labelling objects of template file %s", templateFile), err)
+	}
+
 	return nil
 }
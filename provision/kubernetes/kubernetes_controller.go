@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"errors"
@@ -157,7 +158,7 @@ func (c *KubernetesConfiguration) SetUsedPorts(ports []int) {
 }
 
 type KubernetesProvisioner interface {
-	Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error)
+	Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error)
 }
 
 type KubernetesController struct {
@@ -220,7 +221,7 @@ func (p KubernetesController) initializeConfig(config *KubernetesConfiguration)
 	}
 }
 
-func (p KubernetesController) Provision(infra *model.InfrastructureDeploymentInfo, product string, args model.Parameters) (model.Parameters, error) {
+func (p KubernetesController) Provision(ctx context.Context, infra *model.InfrastructureDeploymentInfo, product string, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	rawKubeConfig, ok := infra.Products["kubernetes"]
@@ -249,7 +250,7 @@ func (p KubernetesController) Provision(infra *model.InfrastructureDeploymentInf
 
 	p.initializeConfig(&kubeConfig)
 
-	out, err := provisioner.Provision(&kubeConfig, infra, args)
+	out, err := provisioner.Provision(ctx, &kubeConfig, infra, args)
 	if err != nil {
 		return result, err
 	}
@@ -17,9 +17,17 @@
 package kubernetes
 
 import (
+	"context"
+	"deployment-engine/utils"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func TestPorts(t *testing.T) {
@@ -109,3 +117,45 @@ func TestPorts(t *testing.T) {
 	}
 
 }
+
+func TestInfrastructureLabel(t *testing.T) {
+	client := KubernetesClient{ConfigPath: "/tmp/config", InfrastructureID: "infra-id"}
+	labels := map[string]string{"app": "web"}
+	deployment := GetDeploymentDescription("web", 1, 10, labels, ImageSet{}, "", "", nil, nil)
+
+	client.setInfrastructureLabel(&deployment.ObjectMeta)
+	if deployment.ObjectMeta.Labels[InfrastructureLabel] != "infra-id" || deployment.ObjectMeta.Labels["app"] != "web" {
+		t.Fatalf("Unexpected labels of deployment: %v", deployment.ObjectMeta.Labels)
+	}
+
+	if _, ok := deployment.Spec.Selector.MatchLabels[InfrastructureLabel]; ok || len(labels) != 1 {
+		t.Fatalf("Infrastructure label added to shared labels: %v", deployment.Spec.Selector.MatchLabels)
+	}
+
+	simulated := utils.NewSimulatedExecutor(0, 0, "kubectl")
+	utils.SetCommandExecutor(simulated)
+	defer utils.SetCommandExecutor(utils.SystemExecutor{})
+
+	template, err := ioutil.TempFile("", "template")
+	if err != nil {
+		t.Fatalf("Error creating template file: %s", err.Error())
+	}
+	defer os.Remove(template.Name())
+	template.WriteString("name: {{.name}}")
+	template.Close()
+
+	err = client.ExecuteDeployTemplate(context.Background(), log.WithField("test", "label"), filepath.Base(template.Name()), template.Name(), map[string]interface{}{"name": "web"})
+	if err != nil {
+		t.Fatalf("Error executing template: %s", err.Error())
+	}
+
+	executed := simulated.Executed()
+	if len(executed) != 2 || strings.Join(executed[1], " ") != "kubectl label --overwrite -f - "+InfrastructureLabel+"=infra-id" {
+		t.Fatalf("Unexpected kubectl commands: %v", executed)
+	}
+
+	err = KubernetesClient{ConfigPath: "/tmp/config"}.ExecuteDeployScript(context.Background(), log.WithField("test", "label"), "deploy.yml")
+	if err != nil || len(simulated.Executed()) != 3 {
+		t.Fatalf("Unexpected result of script without infrastructure: %v %v", err, simulated.Executed())
+	}
+}
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"encoding/json"
@@ -53,7 +54,7 @@ func (p RookProvisioner) GetHostCapacity(node model.NodeInfo) (int64, error) {
 	return capacity, nil
 }
 
-func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p RookProvisioner) Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	result := make(model.Parameters)
 	logger := logrus.WithFields(logrus.Fields{
@@ -83,21 +84,21 @@ func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model
 
 	logger.Infof("Total capacity of the cluster: %f Gb", float32(capacity)/float32(1024*1024*1024))
 
-	kubeClient, err := NewClient(config.ConfigurationFile)
+	kubeClient, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		logger.WithError(err).Error("Error getting kubernetes client")
 		return result, err
 	}
 
 	logger.Info("Creating Rook operator")
-	err = kubeClient.ExecuteDeployScript(logger, p.scriptsFolder+"/rook/rook_operator.yaml")
+	err = kubeClient.ExecuteDeployScript(ctx, logger, p.scriptsFolder+"/rook/rook_operator.yaml")
 	if err != nil {
 		logger.WithError(err).Errorf("Error creating Rook operator plane")
 		return result, err
 	}
 
 	logger.Info("Waiting for Rook operator to be ready")
-	err = kubeClient.ExecuteKubectlCommand(logger, "wait", "deployment/rook-ceph-operator", "--for", "condition=available", "--timeout=120s", "--namespace", "rook-ceph-system")
+	err = kubeClient.ExecuteKubectlCommand(ctx, logger, "wait", "deployment/rook-ceph-operator", "--for", "condition=available", "--timeout=120s", "--namespace", "rook-ceph-system")
 	if err != nil {
 		logger.WithError(err).Errorf("Error waiting for Rook operator plane to be ready")
 		return result, err
@@ -111,7 +112,7 @@ func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model
 
 	logger.Info("Creating Ceph cluster in Rook")
 	fileName := "cluster.yaml.tmpl"
-	err = kubeClient.ExecuteDeployTemplate(logger, fileName, p.scriptsFolder+"/rook/"+fileName, map[string]interface{}{
+	err = kubeClient.ExecuteDeployTemplate(ctx, logger, fileName, p.scriptsFolder+"/rook/"+fileName, map[string]interface{}{
 		"num_mons": numMons,
 	})
 	if err != nil {
@@ -119,7 +120,7 @@ func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model
 	}
 
 	logger.Info("Creating Non-High Available storage class")
-	err = kubeClient.ExecuteDeployScript(logger, p.scriptsFolder+"/rook/storageclass_rook_single.yml")
+	err = kubeClient.ExecuteDeployScript(ctx, logger, p.scriptsFolder+"/rook/storageclass_rook_single.yml")
 	if err != nil {
 		logger.WithError(err).Errorf("Error creating Non-High Available storage class")
 		return result, err
@@ -127,7 +128,7 @@ func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model
 
 	if haAvailable {
 		logger.Info("Creating High Available storage class")
-		err = kubeClient.ExecuteDeployScript(logger, p.scriptsFolder+"/rook/storageclass_rook_ha.yml")
+		err = kubeClient.ExecuteDeployScript(ctx, logger, p.scriptsFolder+"/rook/storageclass_rook_ha.yml")
 		if err != nil {
 			logger.WithError(err).Errorf("Error creating High Available storage class")
 			return result, err
@@ -147,7 +148,7 @@ func (p RookProvisioner) Provision(config *KubernetesConfiguration, infra *model
 
 	logger.Info("Waiting for cluster to be ready")
 
-	finalStatus, timeout, err := utils.WaitForStatusChange("Creating", 5*time.Minute, func() (string, error) {
+	finalStatus, timeout, err := utils.WaitForStatusChange(ctx, "Creating", 5*time.Minute, func() (string, error) {
 		status, err := p.getClusterStatus(logger, resClient)
 		if err != nil {
 			return "", err
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/utils"
 	"fmt"
@@ -90,7 +91,7 @@ func (p TraefikProvisioner) GetPort(name string, port int32, targetPort int, nod
 	return result
 }
 
-func (p TraefikProvisioner) ProvisionTraefik(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p TraefikProvisioner) ProvisionTraefik(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 	httpPort := viper.GetInt(TraefikHTTPPortProperty)
 	sslPort := viper.GetInt(TraefikSslPortProperty)
 	adminPort := viper.GetInt(TraefikAdminPortProperty)
@@ -113,13 +114,13 @@ func (p TraefikProvisioner) ProvisionTraefik(config *KubernetesConfiguration, in
 		"infra":   infra.ID,
 	})
 
-	kubeClient, err := NewClient(config.ConfigurationFile)
+	kubeClient, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
 	}
 
 	logger.Info("Creating Traefik ingress controller")
-	err = kubeClient.ExecuteDeployScript(logger, p.scriptsFolder+"/traefik/deploy.yaml")
+	err = kubeClient.ExecuteDeployScript(ctx, logger, p.scriptsFolder+"/traefik/deploy.yaml")
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error creating Traefik ingress controller", err)
 	}
@@ -168,14 +169,14 @@ func (p TraefikProvisioner) ProvisionTraefik(config *KubernetesConfiguration, in
 	}
 
 	logger.Info("Creating or updating Traefik service")
-	_, err = kubeClient.CreateOrUpdateService(logger, corev1.NamespaceDefault, &service)
+	_, err = kubeClient.CreateOrUpdateService(ctx, logger, corev1.NamespaceDefault, &service)
 	if err != nil {
 		return result, err
 	}
 
 	return result, err
 }
-func (p TraefikProvisioner) Redirect(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p TraefikProvisioner) Redirect(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 	var result model.Parameters
 
 	logger := logrus.WithFields(logrus.Fields{
@@ -184,22 +185,22 @@ func (p TraefikProvisioner) Redirect(config *KubernetesConfiguration, infra *mod
 		"mode":    "redirect",
 	})
 
-	kubeClient, err := NewClient(config.ConfigurationFile)
+	kubeClient, err := NewInfrastructureClient(config.ConfigurationFile, infra.ID)
 	if err != nil {
 		return result, utils.WrapLogAndReturnError(logger, "Error getting kubernetes client", err)
 	}
 
 	redirectFilePath := p.scriptsFolder + "/traefik/" + traefikRedirectFilename
 
-	err = kubeClient.ExecuteDeployTemplate(logger, traefikRedirectFilename, redirectFilePath, args)
+	err = kubeClient.ExecuteDeployTemplate(ctx, logger, traefikRedirectFilename, redirectFilePath, args)
 	return result, err
 }
 
-func (p TraefikProvisioner) Provision(config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
+func (p TraefikProvisioner) Provision(ctx context.Context, config *KubernetesConfiguration, infra *model.InfrastructureDeploymentInfo, args model.Parameters) (model.Parameters, error) {
 
 	mode, ok := args.GetString(TraefikProvisionMode)
 	if ok && mode == TraefikRedirectMode {
-		return p.Redirect(config, infra, args)
+		return p.Redirect(ctx, config, infra, args)
 	}
-	return p.ProvisionTraefik(config, infra, args)
+	return p.ProvisionTraefik(ctx, config, infra, args)
 }
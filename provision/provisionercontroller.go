@@ -17,6 +17,7 @@
 package provision
 
 import (
+	"context"
 	"deployment-engine/model"
 	"deployment-engine/persistence"
 	"deployment-engine/provision/kubernetes"
@@ -32,6 +33,8 @@ const (
 type ProvisionerController struct {
 	Repository   persistence.DeploymentRepository
 	Provisioners map[string]model.Provisioner
+	// Events, if not nil, records the products provisioned and the ones that failed
+	Events persistence.EventRepository
 }
 
 func NewProvisionerController(defaultProvisioner model.Provisioner, repo persistence.DeploymentRepository) *ProvisionerController {
@@ -45,7 +48,34 @@ func NewProvisionerController(defaultProvisioner model.Provisioner, repo persist
 	return &result
 }
 
-func (p *ProvisionerController) Provision(infraID, product string, args model.Parameters, framework string) (model.InfrastructureDeploymentInfo, model.Parameters, error) {
+// recordEvent saves an event in the event repository, if any. An empty principal is recorded as the system one.
+func (p *ProvisionerController) recordEvent(principal, eventType, infraID, product string, cause error) {
+	if p.Events == nil {
+		return
+	}
+
+	if principal == "" {
+		principal = model.SystemPrincipal
+	}
+
+	event := model.Event{
+		Type:             eventType,
+		InfrastructureID: infraID,
+		Target:           product,
+		Principal:        principal,
+	}
+	if cause != nil {
+		event.Message = cause.Error()
+	}
+
+	_, err := p.Events.AddEvent(event)
+	if err != nil {
+		log.WithError(err).Errorf("Error saving event %s of infrastructure %s", eventType, infraID)
+	}
+}
+
+// Provision deploys a product in an infrastructure on behalf of a principal. The installation is interrupted if the context is cancelled.
+func (p *ProvisionerController) Provision(ctx context.Context, principal, infraID, product string, args model.Parameters, framework string) (model.InfrastructureDeploymentInfo, model.Parameters, error) {
 
 	result := make(model.Parameters)
 	infra, err := p.Repository.FindInfrastructure(infraID)
@@ -74,13 +104,20 @@ func (p *ProvisionerController) Provision(infraID, product string, args model.Pa
 		args = make(model.Parameters)
 	}
 
-	out, err := provisioner.Provision(&infra, product, args)
+	out, err := provisioner.Provision(ctx, &infra, product, args)
 	if err != nil {
 		log.WithError(err).Errorf("Error provisioning product %s", product)
+		p.recordEvent(principal, model.EventProductFailed, infraID, product, err)
 		return infra, out, err
 	}
 	result.AddAll(out)
 
 	infra, err = p.Repository.UpdateInfrastructure(infra)
-	return infra, result, err
+	if err != nil {
+		p.recordEvent(principal, model.EventProductFailed, infraID, product, err)
+		return infra, result, err
+	}
+
+	p.recordEvent(principal, model.EventProductProvisioned, infraID, product, nil)
+	return infra, result, nil
 }
@@ -16,18 +16,26 @@
 package restfrontend
 
 import (
+	"context"
 	"deployment-engine/infrastructure"
+	"deployment-engine/jobs"
 	"deployment-engine/model"
 	"deployment-engine/persistence"
 	"deployment-engine/provision"
 	"deployment-engine/provision/ansible"
+	"deployment-engine/webhooks"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 type App struct {
@@ -35,24 +43,75 @@ type App struct {
 	DeploymentController  *infrastructure.Deployer
 	ProvisionerController *provision.ProvisionerController
 	Vault                 persistence.Vault
+	JobManager            *jobs.Manager
+	Events                persistence.EventRepository
+	Webhooks              *webhooks.Dispatcher
+	// TrustForwardedUser makes the principal of the requests the user set by an authenticating proxy in front of the engine
+	TrustForwardedUser bool
 }
 
-func New(repository persistence.DeploymentRepository, vault persistence.Vault, publicKeyPath string) (*App, error) {
+const (
+	// AnonymousPrincipal is recorded in the events of the requests without user information
+	AnonymousPrincipal = "anonymous"
+
+	// ForwardedUserHeader is the header set by authenticating proxies with the user of the request
+	ForwardedUserHeader = "X-Forwarded-User"
+
+	// TrustForwardedUserProperty enables the use of the forwarded user header as principal. It must only be enabled if the requests can only come through an authenticating proxy, since any client can set the header.
+	TrustForwardedUserProperty = "frontend.trust_forwarded_user"
+
+	TrustForwardedUserDefaultValue = false
+)
+
+func New(repository persistence.DeploymentRepository, jobRepository persistence.JobRepository, vault persistence.Vault, events persistence.EventRepository, hooks persistence.WebhookRepository, publicKeyPath string) (*App, error) {
 	ansibleProvisioner, err := ansible.New()
 	if err != nil {
 		return nil, err
 	}
 
+	var dispatcher *webhooks.Dispatcher
+	if hooks != nil && events != nil {
+		dispatcher = webhooks.NewDispatcher(hooks, vault)
+		events = dispatcher.Notifying(events)
+	}
+
+	viper.SetDefault(infrastructure.AutocleanProperty, infrastructure.AutocleanDefaultValue)
+	viper.SetDefault(jobs.InstanceProperty, jobs.InstanceDefaultValue)
+	viper.SetDefault(TrustForwardedUserProperty, TrustForwardedUserDefaultValue)
+
 	result := App{
 		Router: httprouter.New(),
 		DeploymentController: &infrastructure.Deployer{
 			Repository:    repository,
 			Vault:         vault,
+			Events:        events,
 			PublicKeyPath: publicKeyPath,
+			Autoclean:     viper.GetBool(infrastructure.AutocleanProperty),
 		},
 		ProvisionerController: provision.NewProvisionerController(ansibleProvisioner, repository),
 		Vault:                 vault,
+		JobManager:            jobs.NewManager(jobRepository, viper.GetString(jobs.InstanceProperty)),
+		Events:                events,
+		Webhooks:              dispatcher,
+		TrustForwardedUser:    viper.GetBool(TrustForwardedUserProperty),
 	}
+	result.ProvisionerController.Events = events
+
+	if leases, ok := repository.(persistence.IPLeaseRepository); ok {
+		result.DeploymentController.Leases = leases
+	}
+
+	viper.SetDefault(jobs.TimeoutProperty, jobs.TimeoutDefaultValue)
+	result.JobManager.Timeout = viper.GetDuration(jobs.TimeoutProperty)
+
+	viper.SetDefault(infrastructure.DriftCheckIntervalProperty, infrastructure.DriftCheckIntervalDefaultValue)
+	viper.SetDefault(infrastructure.DriftCheckInstanceProperty, infrastructure.DriftCheckInstanceDefaultValue)
+	driftInterval := viper.GetDuration(infrastructure.DriftCheckIntervalProperty)
+	if viper.GetString(infrastructure.DriftCheckInstanceProperty) != result.JobManager.Instance {
+		driftInterval = 0
+	}
+	infrastructure.NewDriftChecker(result.DeploymentController, driftInterval).Start()
+
 	result.InitializeRoutes()
 	return &result, nil
 }
@@ -65,8 +124,36 @@ func (a *App) InitializeRoutes() {
 	a.Router.POST("/infra", a.CreateDep)
 	a.Router.DELETE("/infra", a.DeleteDeployment)
 	a.Router.DELETE("/infra/:infraId", a.DeleteInfra)
+	a.Router.PUT("/infra/:infraId", a.ReconcileInfra)
+	a.Router.PATCH("/infra/:infraId/nodes", a.ScaleInfra)
+	a.Router.POST("/infra/:infraId/:framework", a.InfraAction)
 	a.Router.POST("/infra/:infraId/:framework/:product", a.DeployProduct)
+	a.Router.GET("/deployments/:deploymentId", a.GetDeploymentGroup)
+	a.Router.DELETE("/deployments/:deploymentId", a.DeleteDeploymentGroup)
+	a.Router.PATCH("/deployments/:deploymentId/infrastructures", a.MoveInfrastructures)
 	a.Router.POST("/secrets", a.CreateSecret)
+	a.Router.GET("/infra/:infraId/events", a.GetInfraEvents)
+	a.Router.GET("/events", a.GetEvents)
+	a.Router.POST("/webhooks", a.CreateWebhook)
+	a.Router.GET("/webhooks", a.ListWebhooks)
+	a.Router.GET("/webhooks/:webhookId", a.GetWebhook)
+	a.Router.DELETE("/webhooks/:webhookId", a.DeleteWebhook)
+	a.Router.GET("/webhooks/:webhookId/deliveries", a.GetWebhookDeliveries)
+	a.Router.GET("/jobs/:jobId", a.GetJob)
+	a.Router.POST("/jobs/:jobId/cancel", a.CancelJob)
+	a.Router.GET("/providers", a.GetProviders)
+	a.Router.POST("/providers/garbage", a.CollectGarbage)
+	a.Router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+}
+
+// principalOf returns who performs a request: the user set by an authenticating proxy, if it's trusted, or the anonymous principal. The user of the basic authentication header is not used since the engine doesn't verify it.
+func (a *App) principalOf(r *http.Request) string {
+	if a.TrustForwardedUser {
+		if user := r.Header.Get(ForwardedUserHeader); user != "" {
+			return user
+		}
+	}
+	return AnonymousPrincipal
 }
 
 func (a *App) ReadBody(r *http.Request, result interface{}) error {
@@ -83,6 +170,12 @@ func (a *App) ReadBody(r *http.Request, result interface{}) error {
 //
 // Creates a multi-cluster deployment with the by instantiating the infrastructures passed as parameter.
 //
+// The infrastructures are created asynchronously. The returned job can be polled at /jobs/{jobId} to know when the deployment has finished and to get its result.
+//
+// The infrastructures are grouped in a new deployment whose identifier is returned in the deployment_id field of the job. They are added to it as they are created and the deployment can be managed as a whole at /deployments/{deploymentId}.
+//
+// If dryRun is true nothing is created. Instead, each provider checks its infrastructure and the plan of what would be created is returned, along with the problems found.
+//
 // ---
 // consumes:
 // - application/json
@@ -98,12 +191,35 @@ func (a *App) ReadBody(r *http.Request, result interface{}) error {
 //   required: true
 //   schema:
 //     $ref: "#/definitions/Deployment"
+// - name: autoclean
+//   in: query
+//   type: boolean
+//   description: If true, the infrastructures that were successfully created will be deleted if any other one fails. The default value is taken from the deployment.autoclean configuration property.
+// - name: dryRun
+//   in: query
+//   type: boolean
+//   description: If true, the deployment is validated against the providers and its plan is returned without creating anything
+// - name: name
+//   in: query
+//   type: string
+//   description: Name of the deployment that groups the infrastructures
+// - name: label
+//   in: query
+//   type: array
+//   items:
+//     type: string
+//   collectionFormat: multi
+//   description: Label of the deployment in key=value format. It can be repeated to set several labels.
 //
 // responses:
-//   201:
-//     description: Deployment successfully created
+//   200:
+//     description: Dry run finished. Returns the plan of the deployment
 //     schema:
-//       $ref: "#/definitions/DeploymentInfo"
+//       $ref: "#/definitions/DeploymentPlan"
+//   202:
+//     description: Deployment accepted. Returns the job which is creating it
+//     schema:
+//       $ref: "#/definitions/Job"
 //   400:
 //     description: Bad request
 //   500:
@@ -118,14 +234,68 @@ func (a *App) CreateDep(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
-	result, err := a.DeploymentController.CreateDeployment(deployment)
+	if err := a.DeploymentController.ValidateDeployment(deployment); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun")
+	if dryRun != "" {
+		value, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid dryRun value %s: %s", dryRun, err.Error()))
+			return
+		}
+		if value {
+			RespondWithJSON(w, http.StatusOK, a.DeploymentController.PlanDeployment(r.Context(), a.principalOf(r), deployment))
+			return
+		}
+	}
+
+	options := infrastructure.DeploymentOptions{
+		Autoclean: a.DeploymentController.Autoclean,
+		Principal: a.principalOf(r),
+	}
+
+	autoclean := r.URL.Query().Get("autoclean")
+	if autoclean != "" {
+		value, err := strconv.ParseBool(autoclean)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid autoclean value %s: %s", autoclean, err.Error()))
+			return
+		}
+		options.Autoclean = value
+	}
+
+	labels, err := parseLabels(r.URL.Query()["label"])
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	group, err := a.DeploymentController.CreateDeploymentGroup(r.URL.Query().Get("name"), labels)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	options.DeploymentID = group.ID
+
+	targets := make([]string, len(deployment))
+	for i, infra := range deployment {
+		targets[i] = infra.Name
+	}
+
+	job, err := a.JobManager.SubmitForDeployment(group.ID, jobs.DeploymentJobType, targets, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		options.Progress = progress
+		return a.DeploymentController.CreateDeploymentWithOptions(ctx, deployment, options)
+	})
 
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	RespondWithJSON(w, http.StatusCreated, result)
+	RespondWithJob(w, job)
 	return
 }
 
@@ -163,7 +333,7 @@ func (a *App) DeleteDeployment(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	err := a.DeploymentController.DeleteDeployment(strings.Split(depIds, ","))
+	err := a.DeploymentController.DeleteDeployment(r.Context(), a.principalOf(r), strings.Split(depIds, ","))
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting deployment: %s", err.Error()))
 		return
@@ -173,6 +343,166 @@ func (a *App) DeleteDeployment(w http.ResponseWriter, r *http.Request, ps httpro
 	return
 }
 
+// parseLabels transforms a list of key=value strings into a map of labels
+func parseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid label %s. Labels must be in key=value format", value)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+// GetDeploymentGroup returns a deployment
+// swagger:operation GET /deployments/{deploymentId} deployment getDeploymentGroup
+//
+// Returns a deployment with the identifiers of its infrastructures, their status and the aggregate status of the deployment.
+//
+// The aggregate status is the status shared by all the infrastructures, "degraded" if any of them has failed, is degraded or has been deleted, the status of the ones that are not running yet otherwise, or "empty" if the deployment doesn't have infrastructures.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: deploymentId
+//   in: path
+//   required: true
+//   type: string
+//   description: The deployment identifier
+//
+// responses:
+//   200:
+//     description: The deployment
+//     schema:
+//       $ref: "#/definitions/DeploymentGroup"
+//   404:
+//     description: Deployment not found
+func (a *App) GetDeploymentGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	groupID := ps.ByName("deploymentId")
+	group, err := a.DeploymentController.FindDeploymentGroup(groupID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, group)
+}
+
+// DeleteDeploymentGroup deletes a deployment and all its infrastructures
+// swagger:operation DELETE /deployments/{deploymentId} deployment deleteDeploymentGroup
+//
+// Deletes all the infrastructures of a deployment and the deployment itself.
+//
+// If some infrastructure can't be deleted, the deployment is kept with the remaining infrastructures so the deletion can be retried.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: deploymentId
+//   in: path
+//   required: true
+//   type: string
+//   description: The deployment identifier
+//
+// responses:
+//   204:
+//     description: Deployment successfully deleted
+//   404:
+//     description: Deployment not found
+//   500:
+//     description: Internal error
+func (a *App) DeleteDeploymentGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	groupID := ps.ByName("deploymentId")
+	if _, err := a.DeploymentController.Repository.FindDeploymentGroup(groupID); err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	_, err := a.DeploymentController.DeleteDeploymentGroup(r.Context(), a.principalOf(r), groupID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting deployment: %s", err.Error()))
+		return
+	}
+
+	Respond(w, http.StatusNoContent, []byte{}, "plain/text")
+}
+
+// MoveInfrastructures attaches and detaches infrastructures of a deployment
+// swagger:operation PATCH /deployments/{deploymentId}/infrastructures deployment moveInfrastructures
+//
+// Attaches existing infrastructures to a deployment and detaches others from it.
+//
+// Infrastructures attached that belonged to another deployment are moved from it. Detached infrastructures are not deleted.
+//
+// ---
+// consumes:
+// - application/json
+//
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: deploymentId
+//   in: path
+//   required: true
+//   type: string
+//   description: The deployment identifier
+// - name: request
+//   in: body
+//   description: The infrastructures to attach and detach
+//   required: true
+//   schema:
+//     $ref: "#/definitions/DeploymentGroupMove"
+//
+// responses:
+//   200:
+//     description: The updated deployment
+//     schema:
+//       $ref: "#/definitions/DeploymentGroup"
+//   400:
+//     description: Bad request
+//   404:
+//     description: Deployment not found
+func (a *App) MoveInfrastructures(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	defer r.Body.Close()
+
+	groupID := ps.ByName("deploymentId")
+	var move model.DeploymentGroupMove
+	if err := a.ReadBody(r, &move); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := a.DeploymentController.Repository.FindDeploymentGroup(groupID); err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	group, err := a.DeploymentController.MoveInfrastructures(groupID, move)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, group)
+}
+
 // DeleteInfra deletes an existing infrastructure
 // swagger:operation DELETE /infra/{infraId} deployment deleteInfrastructure
 //
@@ -210,7 +540,7 @@ func (a *App) DeleteInfra(w http.ResponseWriter, r *http.Request, ps httprouter.
 		return
 	}
 
-	dep, err := a.DeploymentController.DeleteInfrastructure(infraId)
+	dep, err := a.DeploymentController.DeleteInfrastructure(r.Context(), a.principalOf(r), infraId)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting infrastructure: %s", err.Error()))
 		return
@@ -220,10 +550,12 @@ func (a *App) DeleteInfra(w http.ResponseWriter, r *http.Request, ps httprouter.
 	return
 }
 
-// DeployProduct deploys a new product in an infrastructure
-// swagger:operation POST /infra/{infrastructureId}/{framework}/{product} deployment createProduct
+// ScaleInfra adds or removes nodes of an existing infrastructure
+// swagger:operation PATCH /infra/{infraId}/nodes deployment scaleInfrastructure
+//
+// Adds new nodes to an infrastructure and removes existing ones from it.
 //
-// Creates a Deployment with the by instantiating the infrastructures passed as parameter.
+// The nodes are added and removed asynchronously. The returned job can be polled at /jobs/{jobId} to know when the infrastructure has been updated. Products must be provisioned again to take into account the new nodes.
 //
 // ---
 // consumes:
@@ -234,29 +566,30 @@ func (a *App) DeleteInfra(w http.ResponseWriter, r *http.Request, ps httprouter.
 // - text/plain
 //
 // parameters:
-// - name: deploymentId
-//   in: path
-//   description: The deployment in which deploy the product
 // - name: infraId
 //   in: path
-//   description: The infrastructure inside the deployment in which to deploy the product
-// - name: framework
-//   in: path
-//   description: The framework to deploy the product to. It can be either "baremetal" or "kubernetes"
-// - name: product
-//   in: path
-//   description: The software product to deploy
+//   required: true
+//   type: string
+//   description: The infrastructure identifier to scale
+// - name: request
+//   in: body
+//   description: The nodes to add and remove
+//   required: true
+//   schema:
+//     $ref: "#/definitions/NodesPatch"
 //
 // responses:
-//   200:
-//     description: The product has been successfully deployed and the updated deployment is returned.
+//   202:
+//     description: Scaling accepted. Returns the job which is updating the infrastructure
 //     schema:
-//       $ref: "#/definitions/DeploymentInfo"
+//       $ref: "#/definitions/Job"
 //   400:
 //     description: Bad request
 //   500:
 //     description: Internal error
-func (a *App) DeployProduct(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) ScaleInfra(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	defer r.Body.Close()
 
 	infraId := ps.ByName("infraId")
 	if infraId == "" {
@@ -264,34 +597,38 @@ func (a *App) DeployProduct(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
-	product := ps.ByName("product")
-	if product == "" {
-		RespondWithError(w, http.StatusBadRequest, "Can't find product parameter")
+	var patch model.NodesPatch
+	if err := a.ReadBody(r, &patch); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	framework := ps.ByName("framework")
-	if framework == "" {
-		RespondWithError(w, http.StatusBadRequest, "Can't find framework parameter")
+	if err := a.DeploymentController.ValidateNodesPatch(infraId, patch); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	params := r.URL.Query()
+	principal := a.principalOf(r)
+	job, err := a.JobManager.Submit(jobs.ScaleJobType, []string{infraId}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		infra, err := a.DeploymentController.ScaleInfrastructure(ctx, principal, infraId, patch, progress)
+		return model.DeploymentInfo{infra}, err
+	})
 
-	deployment, _, err := a.ProvisionerController.Provision(infraId, product, GetParameters(params), framework)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error deploying product: %s", err.Error()))
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, deployment)
+	RespondWithJob(w, job)
 	return
 }
 
-// CreateSecret creates a secret in the configured vault
-// swagger:operation POST /secrets secret createSecret
+// ReconcileInfra makes an infrastructure match its definition
+// swagger:operation PUT /infra/{infraId} deployment reconcileInfrastructure
 //
-// Stores a new secret in the configured vault
+// Reconciles an existing infrastructure with its desired definition.
+//
+// The definition is compared with the stored infrastructure. Resources without a node are created, nodes whose resource is not in the definition anymore are deleted and extra properties are updated. If the infrastructure already matches the definition nothing is done. Otherwise, the changes are applied asynchronously by the returned job, which can be polled at /jobs/{jobId}.
 //
 // ---
 // consumes:
@@ -302,74 +639,827 @@ func (a *App) DeployProduct(w http.ResponseWriter, r *http.Request, ps httproute
 // - text/plain
 //
 // parameters:
-// - name: secret
+// - name: infraId
+//   in: path
+//   description: The infrastructure to reconcile
+// - name: request
 //   in: body
-//   description: The secret description
+//   description: The desired definition of the infrastructure
 //   required: true
 //   schema:
-//     $ref: "#/definitions/Secret"
+//     $ref: "#/definitions/InfrastructureType"
 //
 // responses:
-//   201:
-//     description: The secret has been saved. Returns the secret Identifier
+//   200:
+//     description: The infrastructure already matches the definition. Returns an empty set of changes
 //     schema:
-//       type: string
+//       $ref: "#/definitions/ReconcileResult"
+//   202:
+//     description: Changes accepted. Returns the set of changes and the job which is applying them
+//     schema:
+//       $ref: "#/definitions/ReconcileResult"
 //   400:
 //     description: Bad request
+//   404:
+//     description: Infrastructure not found
 //   500:
 //     description: Internal error
-func (a *App) CreateSecret(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (a *App) ReconcileInfra(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	defer r.Body.Close()
 
-	var secret model.Secret
-	if err := a.ReadBody(r, &secret); err != nil {
-		RespondWithError(w, http.StatusBadRequest, err.Error())
+	infraId := ps.ByName("infraId")
+	if infraId == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find infrastructure ID parameter")
 		return
 	}
 
-	if a.Vault != nil {
-		secretID, err := a.Vault.AddSecret(secret)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+	var spec model.InfrastructureType
+	if err := a.ReadBody(r, &spec); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-		Respond(w, http.StatusCreated, []byte(secretID), "plain/text")
+	if _, err := a.DeploymentController.Repository.FindInfrastructure(infraId); err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
 		return
-	} else {
-		Respond(w, http.StatusNotFound, []byte("No vault configured in this instance so this operation is not available"), "plain/text")
+	}
+
+	changes, err := a.DeploymentController.DiffInfrastructure(infraId, spec)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-}
+	if changes.IsEmpty() {
+		RespondWithJSON(w, http.StatusOK, model.ReconcileResult{
+			Changes: changes,
+		})
+		return
+	}
 
-func GetParameters(args map[string][]string) model.Parameters {
-	result := make(model.Parameters)
-	for k, v := range args {
-		if v != nil {
-			if len(v) == 1 {
-				result[k] = v[0]
-			}
+	principal := a.principalOf(r)
+	job, err := a.JobManager.Submit(jobs.ReconcileJobType, []string{infraId}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		_, infra, err := a.DeploymentController.ReconcileInfrastructure(ctx, principal, infraId, spec, progress)
+		return model.DeploymentInfo{infra}, err
+	})
 
-			if len(v) > 1 {
-				result[k] = v
-			}
-		}
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	return result
+
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%s", job.ID))
+	RespondWithJSON(w, http.StatusAccepted, model.ReconcileResult{
+		Changes: changes,
+		Job:     &job,
+	})
+	return
 }
 
-func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJSON(w, code, map[string]string{"error": message})
+// InfraAction dispatches the actions on an infrastructure. They share the route with the products deployment, so they can't be registered on their own.
+func (a *App) InfraAction(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch ps.ByName("framework") {
+	case "refresh":
+		a.RefreshInfra(w, r, ps)
+	default:
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Unknown action %s", ps.ByName("framework")))
+	}
 }
 
-func Respond(w http.ResponseWriter, code int, payload []byte, contentType string) {
-	w.Header().Set("Content-Type", contentType)
+// RefreshInfra checks the nodes of an infrastructure against its provider
+// swagger:operation POST /infra/{infraId}/refresh deployment refreshInfrastructure
+//
+// Checks the nodes of an infrastructure against its provider.
+//
+// The real state of each node is recorded in its status along with the problems found, such as deleted servers or detached drives. If any node is not healthy, the infrastructure is marked as degraded. The same check is run periodically for all the infrastructures with the interval set in the drift.check_interval configuration property.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: infraId
+//   in: path
+//   description: The infrastructure to refresh
+//
+// responses:
+//   200:
+//     description: The refreshed infrastructure
+//     schema:
+//       $ref: "#/definitions/InfrastructureDeploymentInfo"
+//   404:
+//     description: Infrastructure not found
+//   409:
+//     description: The infrastructure is being scaled
+//   500:
+//     description: Internal error
+func (a *App) RefreshInfra(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	infraId := ps.ByName("infraId")
+	if infraId == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find infrastructure ID parameter")
+		return
+	}
+
+	infra, err := a.DeploymentController.Repository.FindInfrastructure(infraId)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if infra.Status == infrastructure.ScalingStatus {
+		RespondWithError(w, http.StatusConflict, fmt.Sprintf("Infrastructure %s is being scaled", infraId))
+		return
+	}
+
+	infra, err = a.DeploymentController.RefreshInfrastructure(r.Context(), a.principalOf(r), infraId)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, infra)
+}
+
+// DeployProduct deploys a new product in an infrastructure
+// swagger:operation POST /infra/{infrastructureId}/{framework}/{product} deployment createProduct
+//
+// Deploys a product in an existing infrastructure.
+//
+// The product is deployed asynchronously. The returned job can be polled at /jobs/{jobId} to know when the product has been deployed and to get the updated infrastructure.
+//
+// ---
+// consumes:
+// - application/json
+//
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: infraId
+//   in: path
+//   description: The infrastructure in which to deploy the product
+// - name: framework
+//   in: path
+//   description: The framework to deploy the product to. It can be either "baremetal" or "kubernetes"
+// - name: product
+//   in: path
+//   description: The software product to deploy
+//
+// responses:
+//   202:
+//     description: The product deployment has been accepted. Returns the job which is deploying it
+//     schema:
+//       $ref: "#/definitions/Job"
+//   400:
+//     description: Bad request
+//   500:
+//     description: Internal error
+func (a *App) DeployProduct(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	infraId := ps.ByName("infraId")
+	if infraId == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find infrastructure ID parameter")
+		return
+	}
+
+	product := ps.ByName("product")
+	if product == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find product parameter")
+		return
+	}
+
+	framework := ps.ByName("framework")
+	if framework == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find framework parameter")
+		return
+	}
+
+	params := GetParameters(r.URL.Query())
+
+	principal := a.principalOf(r)
+	job, err := a.JobManager.Submit(jobs.ProductJobType, []string{infraId}, func(ctx context.Context, progress model.ProgressFunc) (model.DeploymentInfo, error) {
+		progress(infraId, fmt.Sprintf("provisioning %s", product), nil)
+		infra, _, err := a.ProvisionerController.Provision(ctx, principal, infraId, product, params, framework)
+		if err != nil {
+			progress(infraId, "failed", err)
+			return nil, fmt.Errorf("Error deploying product: %w", err)
+		}
+		progress(infraId, "provisioned", nil)
+		return model.DeploymentInfo{infra}, nil
+	})
+
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJob(w, job)
+	return
+}
+
+// GetJob returns the status of an asynchronous job
+// swagger:operation GET /jobs/{jobId} job getJob
+//
+// Gets the state, progress and result of an asynchronous job
+//
+// ---
+// produces:
+// - application/json
+//
+// parameters:
+// - name: jobId
+//   in: path
+//   required: true
+//   type: string
+//   description: The job identifier
+//
+// responses:
+//   200:
+//     description: The job information
+//     schema:
+//       $ref: "#/definitions/Job"
+//   400:
+//     description: Bad request
+//   404:
+//     description: Job not found
+func (a *App) GetJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	jobID := ps.ByName("jobId")
+	if jobID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find job ID parameter")
+		return
+	}
+
+	job, err := a.JobManager.GetJob(jobID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Error finding job %s: %s", jobID, err.Error()))
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, job)
+	return
+}
+
+// CancelJob cancels a running asynchronous job
+// swagger:operation POST /jobs/{jobId}/cancel job cancelJob
+//
+// Cancels a job which is pending or running. The operation performed by the job is interrupted as soon as possible, killing the external commands it's running, and the job is marked as cancelled when it stops.
+//
+// Resources that were already created by the job are not deleted unless autoclean was requested for it.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: jobId
+//   in: path
+//   required: true
+//   type: string
+//   description: The job identifier
+//
+// responses:
+//   202:
+//     description: Cancellation requested. Returns the job, which can be polled until it's cancelled
+//     schema:
+//       $ref: "#/definitions/Job"
+//   400:
+//     description: Bad request
+//   404:
+//     description: Job not found
+//   409:
+//     description: The job is already finished or it's not running in this instance
+func (a *App) CancelJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	jobID := ps.ByName("jobId")
+	if jobID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Can't find job ID parameter")
+		return
+	}
+
+	_, err := a.JobManager.GetJob(jobID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, fmt.Sprintf("Error finding job %s: %s", jobID, err.Error()))
+		return
+	}
+
+	job, err := a.JobManager.Cancel(jobID)
+	if err != nil {
+		RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	RespondWithJob(w, job)
+	return
+}
+
+// GetInfraEvents returns the events of an infrastructure
+// swagger:operation GET /infra/{infraId}/events event getInfrastructureEvents
+//
+// Returns the lifecycle events of an infrastructure sorted by time, with the principal that performed each operation.
+//
+// Events are kept after the infrastructure is deleted.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: infraId
+//   in: path
+//   required: true
+//   type: string
+//   description: The infrastructure identifier
+//
+// responses:
+//   200:
+//     description: The events of the infrastructure
+//     schema:
+//       type: array
+//       items:
+//         $ref: "#/definitions/Event"
+//   404:
+//     description: No event repository configured
+//   500:
+//     description: Internal error
+func (a *App) GetInfraEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if a.Events == nil {
+		RespondWithError(w, http.StatusNotFound, "No event repository configured in this instance so this operation is not available")
+		return
+	}
+
+	events, err := a.Events.FindEventsByInfrastructure(ps.ByName("infraId"))
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, events)
+}
+
+// GetEvents returns the events since a given time
+// swagger:operation GET /events event getEvents
+//
+// Returns the lifecycle events of all the infrastructures which happened after a given time, sorted by time.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: since
+//   in: query
+//   type: string
+//   format: date-time
+//   description: Only events after this time, in RFC 3339 format, are returned. If it's not set, all the events are returned.
+//
+// responses:
+//   200:
+//     description: The events found
+//     schema:
+//       type: array
+//       items:
+//         $ref: "#/definitions/Event"
+//   400:
+//     description: Bad request
+//   404:
+//     description: No event repository configured
+//   500:
+//     description: Internal error
+func (a *App) GetEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if a.Events == nil {
+		RespondWithError(w, http.StatusNotFound, "No event repository configured in this instance so this operation is not available")
+		return
+	}
+
+	var since time.Time
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid since value %s: %s", sinceParam, err.Error()))
+			return
+		}
+	}
+
+	events, err := a.Events.FindEventsSince(since)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, events)
+}
+
+// webhooksAvailable checks that the webhooks are configured, answering with an error otherwise
+func (a *App) webhooksAvailable(w http.ResponseWriter) bool {
+	if a.Webhooks == nil {
+		RespondWithError(w, http.StatusNotFound, "No webhook repository configured in this instance so this operation is not available")
+		return false
+	}
+	return true
+}
+
+// CreateWebhook subscribes a new webhook to the events of the infrastructures
+// swagger:operation POST /webhooks webhook createWebhook
+//
+// Subscribes a URL to the events of the infrastructures. Each event is sent in a POST request with the event as JSON body, its type in the X-Deployment-Engine-Event header, the delivery identifier in the X-Deployment-Engine-Delivery header and the HMAC-SHA256 signature of the body with the webhook secret in the X-Deployment-Engine-Signature header, in the form sha256=<hex digest>.
+//
+// Failed deliveries are retried with an exponential backoff. The secret is saved in the vault and generated if it's not provided. It's only returned in this response.
+//
+// ---
+// consumes:
+// - application/json
+//
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: webhook
+//   in: body
+//   description: The URL of the webhook, the types of events to send and, optionally, the secret to sign them
+//   required: true
+//   schema:
+//     $ref: "#/definitions/Webhook"
+//
+// responses:
+//   201:
+//     description: The webhook has been created
+//     schema:
+//       $ref: "#/definitions/Webhook"
+//   400:
+//     description: Bad request
+//   404:
+//     description: No webhook repository configured
+func (a *App) CreateWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	defer r.Body.Close()
+
+	if !a.webhooksAvailable(w) {
+		return
+	}
+
+	var hook model.Webhook
+	if err := a.ReadBody(r, &hook); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hook, err := a.Webhooks.Subscribe(hook)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, hook)
+}
+
+// ListWebhooks returns the webhooks subscribed
+// swagger:operation GET /webhooks webhook listWebhooks
+//
+// Returns all the webhooks subscribed, without their secrets
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// responses:
+//   200:
+//     description: The webhooks
+//     schema:
+//       type: array
+//       items:
+//         $ref: "#/definitions/Webhook"
+//   404:
+//     description: No webhook repository configured
+//   500:
+//     description: Internal error
+func (a *App) ListWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if !a.webhooksAvailable(w) {
+		return
+	}
+
+	hooks, err := a.Webhooks.Repository.ListWebhooks()
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, hooks)
+}
+
+// GetWebhook returns a webhook
+// swagger:operation GET /webhooks/{webhookId} webhook getWebhook
+//
+// Returns a webhook, without its secret
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: webhookId
+//   in: path
+//   required: true
+//   type: string
+//   description: The webhook identifier
+//
+// responses:
+//   200:
+//     description: The webhook
+//     schema:
+//       $ref: "#/definitions/Webhook"
+//   404:
+//     description: Webhook not found
+func (a *App) GetWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if !a.webhooksAvailable(w) {
+		return
+	}
+
+	hook, err := a.Webhooks.Repository.FindWebhook(ps.ByName("webhookId"))
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhook unsubscribes a webhook
+// swagger:operation DELETE /webhooks/{webhookId} webhook deleteWebhook
+//
+// Deletes a webhook and its secret. Its delivery log is kept.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: webhookId
+//   in: path
+//   required: true
+//   type: string
+//   description: The webhook identifier
+//
+// responses:
+//   200:
+//     description: The webhook deleted
+//     schema:
+//       $ref: "#/definitions/Webhook"
+//   404:
+//     description: Webhook not found
+func (a *App) DeleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if !a.webhooksAvailable(w) {
+		return
+	}
+
+	hook, err := a.Webhooks.Unsubscribe(ps.ByName("webhookId"))
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, hook)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook
+// swagger:operation GET /webhooks/{webhookId}/deliveries webhook getWebhookDeliveries
+//
+// Returns the events sent to a webhook sorted by creation time, with their state, the number of attempts made and the last response code or error found.
+//
+// ---
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: webhookId
+//   in: path
+//   required: true
+//   type: string
+//   description: The webhook identifier
+//
+// responses:
+//   200:
+//     description: The deliveries of the webhook
+//     schema:
+//       type: array
+//       items:
+//         $ref: "#/definitions/WebhookDelivery"
+//   404:
+//     description: No webhook repository configured
+//   500:
+//     description: Internal error
+func (a *App) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	if !a.webhooksAvailable(w) {
+		return
+	}
+
+	deliveries, err := a.Webhooks.Repository.FindDeliveriesByWebhook(ps.ByName("webhookId"))
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// CreateSecret creates a secret in the configured vault
+// swagger:operation POST /secrets secret createSecret
+//
+// Stores a new secret in the configured vault
+//
+// ---
+// consumes:
+// - application/json
+//
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: secret
+//   in: body
+//   description: The secret description
+//   required: true
+//   schema:
+//     $ref: "#/definitions/Secret"
+//
+// responses:
+//   201:
+//     description: The secret has been saved. Returns the secret Identifier
+//     schema:
+//       type: string
+//   400:
+//     description: Bad request
+//   500:
+//     description: Internal error
+func (a *App) CreateSecret(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	defer r.Body.Close()
+
+	var secret model.Secret
+	if err := a.ReadBody(r, &secret); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if a.Vault != nil {
+		secretID, err := a.Vault.AddSecret(secret)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		Respond(w, http.StatusCreated, []byte(secretID), "plain/text")
+		return
+	} else {
+		Respond(w, http.StatusNotFound, []byte("No vault configured in this instance so this operation is not available"), "plain/text")
+		return
+	}
+
+}
+
+// GetProviders returns the list of supported providers
+// swagger:operation GET /providers provider listProviders
+//
+// Lists the types of cloud providers supported by this deployment engine and the credentials they need
+//
+// ---
+// produces:
+// - application/json
+//
+// responses:
+//   200:
+//     description: The list of supported providers
+//     schema:
+//       type: array
+//       items:
+//         $ref: "#/definitions/ProviderDescription"
+func (a *App) GetProviders(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	RespondWithJSON(w, http.StatusOK, infrastructure.ListProviders())
+}
+
+// CollectGarbage finds the resources of a provider left behind by infrastructures that are no longer in the repository
+// swagger:operation POST /providers/garbage provider collectGarbage
+//
+// Lists the resources of a cloud provider tagged by this deployment engine whose infrastructure doesn't exist, such as the ones left by a crash of the engine in the middle of a deployment.
+//
+// Only providers that tag the resources they create support this operation.
+//
+// ---
+// consumes:
+// - application/json
+//
+// produces:
+// - application/json
+// - text/plain
+//
+// parameters:
+// - name: request
+//   in: body
+//   description: The provider to look for orphan resources, with its credentials or secret identifier
+//   required: true
+//   schema:
+//     $ref: "#/definitions/CloudProviderInfo"
+// - name: delete
+//   in: query
+//   type: boolean
+//   description: If true, the orphan resources found are deleted
+//
+// responses:
+//   200:
+//     description: Returns the orphan resources found and the ones that were deleted
+//     schema:
+//       $ref: "#/definitions/GarbageReport"
+//   400:
+//     description: Bad request
+//   500:
+//     description: Internal error
+func (a *App) CollectGarbage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	defer r.Body.Close()
+
+	var provider model.CloudProviderInfo
+	if err := a.ReadBody(r, &provider); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	remove := false
+	deleteParam := r.URL.Query().Get("delete")
+	if deleteParam != "" {
+		value, err := strconv.ParseBool(deleteParam)
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid delete value %s: %s", deleteParam, err.Error()))
+			return
+		}
+		remove = value
+	}
+
+	report, err := a.DeploymentController.CollectGarbage(r.Context(), a.principalOf(r), provider, remove)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, infrastructure.ErrGarbageCollectionNotSupported) {
+			status = http.StatusBadRequest
+		}
+		RespondWithError(w, status, err.Error())
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, report)
+}
+
+func GetParameters(args map[string][]string) model.Parameters {
+	result := make(model.Parameters)
+	for k, v := range args {
+		if v != nil {
+			if len(v) == 1 {
+				result[k] = v[0]
+			}
+
+			if len(v) > 1 {
+				result[k] = v
+			}
+		}
+	}
+	return result
+}
+
+func RespondWithError(w http.ResponseWriter, code int, message string) {
+	RespondWithJSON(w, code, map[string]string{"error": message})
+}
+
+func Respond(w http.ResponseWriter, code int, payload []byte, contentType string) {
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(code)
 	w.Write(payload)
 }
 
+// RespondWithJob responds with an accepted status code and the job that will perform the operation, setting its location
+func RespondWithJob(w http.ResponseWriter, job model.Job) {
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%s", job.ID))
+	RespondWithJSON(w, http.StatusAccepted, job)
+}
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	Respond(w, code, response, "application/json")
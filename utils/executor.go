@@ -0,0 +1,177 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	SimulatedCommandsProperty     = "simulated.commands.enabled"
+	SimulatedCommandsListProperty = "simulated.commands.list"
+	SimulatedLatencyProperty      = "simulated.commands.latency"
+	SimulatedFailureRateProperty  = "simulated.commands.failure_rate"
+
+	SimulatedCommandsDefaultValue    = false
+	SimulatedLatencyDefaultValue     = "0s"
+	SimulatedFailureRateDefaultValue = 0.0
+)
+
+// SimulatedCommandsListDefaultValue are the commands simulated by default: the ones used by the ansible and kubernetes provisioners and the SSH connections to the nodes
+var SimulatedCommandsListDefaultValue = []string{"ansible-playbook", "ansible-galaxy", "kubectl", "ssh"}
+
+// CommandExecutor runs the external commands created with CreateCommand
+type CommandExecutor interface {
+	Run(cmd *exec.Cmd) error
+}
+
+// SystemExecutor runs the commands in the system
+type SystemExecutor struct{}
+
+func (e SystemExecutor) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+var (
+	executor     CommandExecutor = SystemExecutor{}
+	executorLock sync.RWMutex
+)
+
+// SetCommandExecutor replaces the executor of the external commands
+func SetCommandExecutor(newExecutor CommandExecutor) {
+	executorLock.Lock()
+	defer executorLock.Unlock()
+	executor = newExecutor
+}
+
+// GetCommandExecutor returns the current executor of the external commands
+func GetCommandExecutor() CommandExecutor {
+	executorLock.RLock()
+	defer executorLock.RUnlock()
+	return executor
+}
+
+// RunCommand runs a command created with CreateCommand with the current executor
+func RunCommand(cmd *exec.Cmd) error {
+	return GetCommandExecutor().Run(cmd)
+}
+
+// IsSimulated returns true if the command is not really executed by the current executor
+func IsSimulated(command string) bool {
+	simulated, ok := GetCommandExecutor().(*SimulatedExecutor)
+	return ok && simulated.Simulates(command)
+}
+
+// SimulatedExecutor records the commands it receives and pretends that they finish successfully after some latency, instead of running them. It allows to run whole deployments without external services.
+// Commands fail if the hash of their arguments falls in the failure rate, so the same command always has the same result. Commands not in the list of simulated ones are run in the system.
+type SimulatedExecutor struct {
+	Commands    map[string]bool
+	Latency     time.Duration
+	FailureRate float64
+
+	lock     sync.Mutex
+	executed [][]string
+}
+
+// NewSimulatedExecutor creates an executor which simulates the commands passed as parameter
+func NewSimulatedExecutor(latency time.Duration, failureRate float64, commands ...string) *SimulatedExecutor {
+	result := SimulatedExecutor{
+		Commands:    make(map[string]bool),
+		Latency:     latency,
+		FailureRate: failureRate,
+	}
+	for _, command := range commands {
+		result.Commands[command] = true
+	}
+	return &result
+}
+
+// Simulates returns true if the command is simulated by this executor
+func (e *SimulatedExecutor) Simulates(command string) bool {
+	return e.Commands[filepath.Base(command)]
+}
+
+// Executed returns the arguments of the commands simulated so far, including the command name
+func (e *SimulatedExecutor) Executed() [][]string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([][]string{}, e.executed...)
+}
+
+// Fails returns true if a simulated operation identified by the key fails with the failure rate passed as parameter. The result is always the same for the same key and rate.
+func Fails(key string, failureRate float64) bool {
+	if failureRate <= 0 {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return float64(hash.Sum32()%1000) < failureRate*1000
+}
+
+func (e *SimulatedExecutor) Run(cmd *exec.Cmd) error {
+	if len(cmd.Args) == 0 || !e.Simulates(cmd.Args[0]) {
+		return cmd.Run()
+	}
+
+	args := append([]string{filepath.Base(cmd.Args[0])}, cmd.Args[1:]...)
+	e.lock.Lock()
+	e.executed = append(e.executed, args)
+	e.lock.Unlock()
+
+	commandLine := strings.Join(args, " ")
+	log.Infof("Simulating command: %s", commandLine)
+
+	if cmd.Stdin != nil {
+		io.Copy(ioutil.Discard, cmd.Stdin)
+		if closer, ok := cmd.Stdin.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	time.Sleep(e.Latency)
+
+	if Fails(commandLine, e.FailureRate) {
+		return fmt.Errorf("Simulated failure of command %s", commandLine)
+	}
+
+	return nil
+}
+
+// ConfigureCommandExecutor replaces the executor of external commands with a simulated one if it's enabled in the configuration
+func ConfigureCommandExecutor() {
+	viper.SetDefault(SimulatedCommandsProperty, SimulatedCommandsDefaultValue)
+	viper.SetDefault(SimulatedCommandsListProperty, SimulatedCommandsListDefaultValue)
+	viper.SetDefault(SimulatedLatencyProperty, SimulatedLatencyDefaultValue)
+	viper.SetDefault(SimulatedFailureRateProperty, SimulatedFailureRateDefaultValue)
+
+	if viper.GetBool(SimulatedCommandsProperty) {
+		commands := viper.GetStringSlice(SimulatedCommandsListProperty)
+		log.Warnf("External commands %v will be simulated", commands)
+		SetCommandExecutor(NewSimulatedExecutor(viper.GetDuration(SimulatedLatencyProperty), viper.GetFloat64(SimulatedFailureRateProperty), commands...))
+	}
+}
@@ -0,0 +1,77 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package utils
+
+import (
+	"deployment-engine/model"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// FlavorsProperty is the catalog of flavors of every provider, indexed by name. Each provider can add or override flavors in the property with its API type followed by ".flavors".
+	FlavorsProperty = "flavors"
+)
+
+func readFlavors(property string, result map[string]model.Flavor) error {
+	flavors := make(map[string]model.Flavor)
+	err := viper.UnmarshalKey(property, &flavors)
+	if err != nil {
+		return fmt.Errorf("Invalid flavor catalog %s: %w", property, err)
+	}
+	for name, flavor := range flavors {
+		result[strings.ToLower(name)] = flavor
+	}
+	return nil
+}
+
+// Flavors returns the flavor catalog of a provider, indexed by lower case name since the configuration keys are case insensitive
+func Flavors(provider string) (map[string]model.Flavor, error) {
+	result := make(map[string]model.Flavor)
+	err := readFlavors(FlavorsProperty, result)
+	if err != nil {
+		return result, err
+	}
+	err = readFlavors(provider+"."+FlavorsProperty, result)
+	return result, err
+}
+
+// ResolveFlavors returns the resources with the CPU, cores, RAM and disk of their flavors in the catalog of the provider. Resources without type are returned as they are.
+// It returns an error if any type is not in the catalog.
+func ResolveFlavors(provider string, resources []model.ResourceType) ([]model.ResourceType, error) {
+	flavors, err := Flavors(provider)
+	if err != nil {
+		return resources, err
+	}
+
+	result := make([]model.ResourceType, len(resources))
+	for i, resource := range resources {
+		result[i] = resource
+		if resource.Type == "" {
+			continue
+		}
+
+		flavor, ok := flavors[strings.ToLower(resource.Type)]
+		if !ok {
+			return resources, fmt.Errorf("Unknown flavor %s for resource %s", resource.Type, resource.Name)
+		}
+		result[i] = flavor.Apply(resource)
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ */
+
+package utils
+
+import (
+	"deployment-engine/model"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxHostNameLength = 255
+
+var invalidHostNameChars = regexp.MustCompile("[^a-zA-Z0-9-]+")
+
+// ClearHostName converts a name to a valid hostname, in lower case and replacing the sequences of invalid characters with hyphens
+func ClearHostName(hostname string) (string, error) {
+	replaced := invalidHostNameChars.ReplaceAllString(strings.ToLower(hostname), "-")
+	if len(replaced) > maxHostNameLength || len(replaced) == 0 {
+		return "", errors.New("Infrastructure or host name of resource is too long or too short. Infrastructure name + resource name should be between 1 and 255 characters")
+	}
+
+	return replaced, nil
+}
+
+// BootDriveName returns the name of the boot drive of a node
+func BootDriveName(hostname string) string {
+	return fmt.Sprintf("boot-%s", hostname)
+}
+
+// DataDriveName returns the name of a data drive of a node
+func DataDriveName(hostname string, drive model.Drive) string {
+	return fmt.Sprintf("data-%s-%s", hostname, drive.Name)
+}
@@ -17,6 +17,7 @@
 package utils
 
 import (
+	"context"
 	"deployment-engine/model"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -47,12 +49,14 @@ type knownHostsLine struct {
 
 type knownHostsMap map[string][]knownHostsLine
 
-func ExecuteCommand(logger *log.Entry, name string, args ...string) error {
-	return CreateCommand(logger, nil, true, name, args...).Run()
+// ExecuteCommand runs a command with the current executor until it finishes. The process is killed if the context is cancelled before.
+func ExecuteCommand(ctx context.Context, logger *log.Entry, name string, args ...string) error {
+	return RunCommand(CreateCommand(ctx, logger, nil, true, name, args...))
 }
 
-func CreateCommand(logger *log.Entry, envVars map[string]string, preserveEnv bool, command string, args ...string) *exec.Cmd {
-	cmd := exec.Command(command, args...)
+// CreateCommand creates a command which will be killed if the context is cancelled before it finishes
+func CreateCommand(ctx context.Context, logger *log.Entry, envVars map[string]string, preserveEnv bool, command string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command, args...)
 	if logger != nil {
 		cmd.Stdout = logger.Writer()
 		cmd.Stderr = logger.Writer()
@@ -73,13 +77,17 @@ func CreateCommand(logger *log.Entry, envVars map[string]string, preserveEnv boo
 }
 
 // WaitForStatusChange calls the getter function during the time specified in timeout or until it returns a value which is different than the one specified in the "status" parameter.
-// It returns the final status, if there was a timeout and if the getter function returned error at any moment.
-func WaitForStatusChange(status string, timeout time.Duration, getter func() (string, error)) (string, bool, error) {
+// It returns the final status, if there was a timeout and if the getter function returned error at any moment. If the context is cancelled the wait stops and the context error is returned.
+func WaitForStatusChange(ctx context.Context, status string, timeout time.Duration, getter func() (string, error)) (string, bool, error) {
 	waited := 0 * time.Second
 	currentStatus := status
 	var err error
 	for currentStatus, err = getter(); currentStatus == status && waited < timeout && err == nil; currentStatus, err = getter() {
-		time.Sleep(3 * time.Second)
+		select {
+		case <-ctx.Done():
+			return currentStatus, false, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
 		waited += 3 * time.Second
 		//fmt.Print(".")
 	}
@@ -158,8 +166,8 @@ func WrapLogAndReturnError(log *log.Entry, message string, err error) error {
 	return errors.New(message)
 }
 
-func connectSSH(host model.NodeInfo, signer ssh.Signer, f *os.File) error {
-	config := &ssh.ClientConfig{
+func sshClientConfig(host model.NodeInfo, signer ssh.Signer, f *os.File) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
 		User:              host.Username,
 		HostKeyAlgorithms: []string{ssh.SigAlgoRSA},
 		Auth: []ssh.AuthMethod{
@@ -172,8 +180,12 @@ func connectSSH(host model.NodeInfo, signer ssh.Signer, f *os.File) error {
 			return nil
 		}),
 	}
+}
+
+func connectSSH(ctx context.Context, host model.NodeInfo, signer ssh.Signer, f *os.File) error {
+	config := sshClientConfig(host, signer, f)
 
-	_, timeout, err := WaitForStatusChange("not_connected", 60*time.Second, func() (string, error) {
+	_, timeout, err := WaitForStatusChange(ctx, "not_connected", 60*time.Second, func() (string, error) {
 		client, connError := ssh.Dial("tcp", host.IP+":22", config)
 		if connError != nil {
 			return "not_connected", nil
@@ -237,17 +249,193 @@ func readKnownHosts(knownHostsLocation string) (knownHostsMap, error) {
 	return result, nil
 }
 
-func WaitForSSHReady(infra model.InfrastructureDeploymentInfo, addToKNownHosts bool) error {
-	sshFolderLocation := os.Getenv("HOME") + "/.ssh"
-	privateKeyLocation := sshFolderLocation + "/id_rsa"
-	knownHostsLocation := sshFolderLocation + "/known_hosts"
+func sshFolder() string {
+	return os.Getenv("HOME") + "/.ssh"
+}
 
-	key, err := ioutil.ReadFile(privateKeyLocation)
+func readSigner() (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(sshFolder() + "/id_rsa")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(key)
+}
+
+// sshDialTimeout is the maximum time to establish an SSH connection with a host
+const sshDialTimeout = 10 * time.Second
+
+// dialSSH opens an SSH connection with a host. It gives up if the connection can't be established in the configured timeout or the context is done.
+func dialSSH(ctx context.Context, host model.NodeInfo, config *ssh.ClientConfig) (*ssh.Client, error) {
+	addr := host.IP + ":22"
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The deadline covers the SSH handshake, which is not bound by the dialer
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// ExecuteSSHCommand runs a command in a host with the private key of the deployment engine and returns its standard output. The command is interrupted if the context is done.
+func ExecuteSSHCommand(ctx context.Context, host model.NodeInfo, command string) (string, error) {
+	signer, err := readSigner()
+	if err != nil {
+		return "", fmt.Errorf("Error reading private key: %w", err)
+	}
+
+	config := sshClientConfig(host, signer, nil)
+	config.Timeout = sshDialTimeout
+	client, err := dialSSH(ctx, host, config)
+	if err != nil {
+		return "", fmt.Errorf("Error connecting to host %s: %w", host.Hostname, err)
+	}
+	defer client.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-finished:
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("Error getting a new session: %w", err)
+	}
+	defer session.Close()
+
+	output, err := session.Output(command)
+	if ctx.Err() != nil {
+		return string(output), ctx.Err()
+	}
+	if err != nil {
+		return string(output), fmt.Errorf("Error executing command in host %s: %w", host.Hostname, err)
+	}
+	return string(output), nil
+}
+
+// WaitForSSHAccess waits for the private key of the deployment engine to give access to every node of the infrastructure. Unlike WaitForSSHReady, it only connects to the hosts, so neither they nor the known hosts are modified.
+func WaitForSSHAccess(ctx context.Context, infra model.InfrastructureDeploymentInfo, timeout time.Duration) error {
+	if IsSimulated("ssh") {
+		log.WithField("infrastructure", infra.ID).Info("SSH connections are simulated. Not waiting for nodes")
+		return nil
+	}
+
+	signer, err := readSigner()
+	if err != nil {
+		return fmt.Errorf("Error reading private key: %w", err)
+	}
+
+	var accessErr error
+	infra.ForEachNode(func(host model.NodeInfo) {
+		if accessErr != nil {
+			return
+		}
+
+		config := sshClientConfig(host, signer, nil)
+		config.Timeout = sshDialTimeout
+		_, timedOut, err := WaitForStatusChange(ctx, "not_connected", timeout, func() (string, error) {
+			client, err := dialSSH(ctx, host, config)
+			if err != nil {
+				return "not_connected", nil
+			}
+			client.Close()
+			return "connected", nil
+		})
+		if err != nil {
+			accessErr = err
+		} else if timedOut {
+			accessErr = fmt.Errorf("Timeout connecting to host %s", host.Hostname)
+		}
+	})
+
+	return accessErr
+}
+
+// cloudInitPending is the status of cloud-init while it hasn't finished or the host can't be reached yet
+const cloudInitPending = "running"
+
+// parseCloudInitStatus reads the output of "cloud-init status", which is a line such as "status: done"
+func parseCloudInitStatus(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 && parts[0] == "status" {
+			switch status := strings.TrimSpace(parts[1]); status {
+			case "running", "not run", "not started":
+				return cloudInitPending
+			case model.CloudInitStatusDone:
+				return model.CloudInitStatusDone
+			default:
+				return model.CloudInitStatusError
+			}
+		}
+	}
+	return cloudInitPending
+}
+
+// WaitForCloudInit waits until cloud-init finishes in a host, connecting by SSH with the private key of the deployment engine. It returns done if it succeeded or error if it failed.
+func WaitForCloudInit(ctx context.Context, host model.NodeInfo, timeout time.Duration) (string, error) {
+	if IsSimulated("ssh") {
+		log.WithField("host", host.Hostname).Info("SSH connections are simulated. Not waiting for cloud-init")
+		return model.CloudInitStatusDone, nil
+	}
+
+	signer, err := readSigner()
+	if err != nil {
+		return "", fmt.Errorf("Error reading private key: %w", err)
 	}
 
-	signer, err := ssh.ParsePrivateKey(key)
+	config := sshClientConfig(host, signer, nil)
+	config.Timeout = sshDialTimeout
+
+	status, _, err := WaitForStatusChange(ctx, cloudInitPending, timeout, func() (string, error) {
+		client, err := dialSSH(ctx, host, config)
+		if err != nil {
+			return cloudInitPending, nil
+		}
+		defer client.Close()
+
+		session, err := client.NewSession()
+		if err != nil {
+			return cloudInitPending, nil
+		}
+		defer session.Close()
+
+		// The exit code is not zero when cloud-init fails, so it's read from the output
+		output, _ := session.Output("cloud-init status")
+		return parseCloudInitStatus(string(output)), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if status == cloudInitPending {
+		return "", fmt.Errorf("Timeout waiting for cloud-init to finish in host %s", host.Hostname)
+	}
+	return status, nil
+}
+
+func WaitForSSHReady(ctx context.Context, infra model.InfrastructureDeploymentInfo, addToKNownHosts bool) error {
+	if IsSimulated("ssh") {
+		log.WithField("infrastructure", infra.ID).Info("SSH connections are simulated. Not waiting for nodes")
+		return nil
+	}
+
+	knownHostsLocation := sshFolder() + "/known_hosts"
+
+	signer, err := readSigner()
 	if err != nil {
 		return err
 	}
@@ -265,7 +453,7 @@ func WaitForSSHReady(infra model.InfrastructureDeploymentInfo, addToKNownHosts b
 
 	for _, hosts := range infra.Nodes {
 		for _, host := range hosts {
-			err := connectSSH(host, signer, f)
+			err := connectSSH(ctx, host, signer, f)
 			if err != nil {
 				return WrapLogAndReturnError(log.NewEntry(log.New()), fmt.Sprintf("Error connecting to host %s", host.IP), err)
 			}
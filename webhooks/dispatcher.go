@@ -0,0 +1,344 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"deployment-engine/model"
+	"deployment-engine/persistence"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// SignatureHeader is the header with the HMAC-SHA256 signature of the payload, in the form sha256=<hex digest>
+	SignatureHeader = "X-Deployment-Engine-Signature"
+	// EventHeader is the header with the type of the event sent
+	EventHeader = "X-Deployment-Engine-Event"
+	// DeliveryHeader is the header with the identifier of the delivery, which is kept between retries
+	DeliveryHeader = "X-Deployment-Engine-Delivery"
+
+	// SecretFormat is the format of the webhook secrets saved in the vault
+	SecretFormat = "hmac-sha256"
+
+	MaxAttemptsProperty = "webhooks.max_attempts"
+	BackoffProperty     = "webhooks.backoff"
+	TimeoutProperty     = "webhooks.timeout"
+
+	MaxAttemptsDefaultValue = 5
+	BackoffDefaultValue     = "1s"
+	TimeoutDefaultValue     = "10s"
+)
+
+// DefaultEvents are the types of the events sent to the webhooks that don't specify them: the changes of state of the infrastructures and the results of the product installations
+var DefaultEvents = []string{
+	model.EventInfrastructureCreated,
+	model.EventInfrastructureFailed,
+	model.EventInfrastructureDeleted,
+	model.EventStatusChanged,
+	model.EventProductProvisioned,
+	model.EventProductFailed,
+}
+
+// Dispatcher manages the webhook subscriptions and sends them the events they are subscribed to. Failed deliveries are retried with an exponential backoff and every attempt is recorded in the repository.
+type Dispatcher struct {
+	Repository  persistence.WebhookRepository
+	Vault       persistence.Vault
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	pending     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher which saves webhooks and deliveries in the repository and their secrets in the vault, configured with the webhooks properties
+func NewDispatcher(repository persistence.WebhookRepository, vault persistence.Vault) *Dispatcher {
+	viper.SetDefault(MaxAttemptsProperty, MaxAttemptsDefaultValue)
+	viper.SetDefault(BackoffProperty, BackoffDefaultValue)
+	viper.SetDefault(TimeoutProperty, TimeoutDefaultValue)
+
+	return &Dispatcher{
+		Repository: repository,
+		Vault:      vault,
+		Client: &http.Client{
+			Timeout: viper.GetDuration(TimeoutProperty),
+		},
+		MaxAttempts: viper.GetInt(MaxAttemptsProperty),
+		Backoff:     viper.GetDuration(BackoffProperty),
+	}
+}
+
+// Sign returns the signature of a payload with the secret passed as parameter, as sent in the signature header
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Subscribe validates and saves a new webhook, storing its secret in the vault. A secret is generated if the webhook doesn't have one. The webhook is returned with its secret so it can be shared with the receiver.
+func (d *Dispatcher) Subscribe(hook model.Webhook) (model.Webhook, error) {
+	if d.Vault == nil {
+		return hook, errors.New("A vault is needed to save the secrets of the webhooks")
+	}
+
+	target, err := url.Parse(hook.URL)
+	if err != nil || !target.IsAbs() || (target.Scheme != "http" && target.Scheme != "https") {
+		return hook, fmt.Errorf("Invalid webhook URL %s. An absolute HTTP or HTTPS URL is needed", hook.URL)
+	}
+
+	secret := hook.Secret
+	if secret == "" {
+		secret, err = generateSecret()
+		if err != nil {
+			return hook, fmt.Errorf("Error generating webhook secret: %w", err)
+		}
+	}
+
+	hook.Secret = ""
+	hook.SecretID, err = d.Vault.AddSecret(model.Secret{
+		Description: fmt.Sprintf("Signing secret of webhook for %s", hook.URL),
+		Format:      SecretFormat,
+		Content:     secret,
+	})
+	if err != nil {
+		return hook, fmt.Errorf("Error saving webhook secret: %w", err)
+	}
+
+	saved, err := d.Repository.AddWebhook(hook)
+	if err != nil {
+		if secretErr := d.Vault.DeleteSecret(hook.SecretID); secretErr != nil {
+			log.WithError(secretErr).Errorf("Error deleting secret %s of webhook that couldn't be saved", hook.SecretID)
+		}
+		return saved, err
+	}
+
+	saved.Secret = secret
+	return saved, nil
+}
+
+// Unsubscribe deletes a webhook and its secret. Deliveries in progress are not cancelled but they will fail when they can't find the secret anymore.
+func (d *Dispatcher) Unsubscribe(hookID string) (model.Webhook, error) {
+	hook, err := d.Repository.DeleteWebhook(hookID)
+	if err != nil {
+		return hook, err
+	}
+
+	if d.Vault != nil && hook.SecretID != "" {
+		if err := d.Vault.DeleteSecret(hook.SecretID); err != nil {
+			log.WithError(err).Errorf("Error deleting secret %s of webhook %s", hook.SecretID, hookID)
+		}
+	}
+
+	return hook, nil
+}
+
+// subscribed returns true if the webhook has to receive the events of the type passed as parameter
+func subscribed(hook model.Webhook, eventType string) bool {
+	types := hook.Events
+	if len(types) == 0 {
+		types = DefaultEvents
+	}
+	for _, subscribedType := range types {
+		if subscribedType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify creates a delivery for each webhook subscribed to the event and sends them in background
+func (d *Dispatcher) Notify(event model.Event) {
+	hooks, err := d.Repository.ListWebhooks()
+	if err != nil {
+		log.WithError(err).Errorf("Error listing webhooks to notify event %s", event.ID)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !subscribed(hook, event.Type) {
+			continue
+		}
+
+		delivery, err := d.Repository.AddDelivery(model.WebhookDelivery{
+			WebhookID: hook.ID,
+			Event:     event,
+			State:     model.DeliveryStatePending,
+		})
+		if err != nil {
+			log.WithError(err).Errorf("Error saving delivery of event %s to webhook %s", event.ID, hook.ID)
+			continue
+		}
+
+		d.pending.Add(1)
+		go d.deliver(hook, delivery)
+	}
+}
+
+// Wait blocks until all the deliveries in progress have been delivered or have failed
+func (d *Dispatcher) Wait() {
+	d.pending.Wait()
+}
+
+func (d *Dispatcher) secretOf(hook model.Webhook) (string, error) {
+	if d.Vault == nil {
+		return "", errors.New("No vault configured to read the webhook secret")
+	}
+
+	secret, err := d.Vault.GetSecret(hook.SecretID)
+	if err != nil {
+		return "", fmt.Errorf("Error reading webhook secret: %w", err)
+	}
+
+	content, ok := secret.Content.(string)
+	if !ok {
+		return "", fmt.Errorf("Invalid content of webhook secret %s", hook.SecretID)
+	}
+	return content, nil
+}
+
+func (d *Dispatcher) updateDelivery(delivery model.WebhookDelivery) {
+	_, err := d.Repository.UpdateDelivery(delivery)
+	if err != nil {
+		log.WithError(err).Errorf("Error saving delivery %s", delivery.ID)
+	}
+}
+
+// deliver sends an event to a webhook until the receiver accepts it or the maximum number of attempts is reached, doubling the wait time after each failure
+func (d *Dispatcher) deliver(hook model.Webhook, delivery model.WebhookDelivery) {
+	defer d.pending.Done()
+
+	logger := log.WithField("webhook", hook.ID).WithField("delivery", delivery.ID)
+
+	secret, err := d.secretOf(hook)
+	if err != nil {
+		logger.WithError(err).Error("Can't sign event")
+		delivery.State = model.DeliveryStateFailed
+		delivery.Error = err.Error()
+		d.updateDelivery(delivery)
+		return
+	}
+
+	payload, err := json.Marshal(delivery.Event)
+	if err != nil {
+		logger.WithError(err).Error("Can't serialize event")
+		delivery.State = model.DeliveryStateFailed
+		delivery.Error = err.Error()
+		d.updateDelivery(delivery)
+		return
+	}
+
+	backoff := d.Backoff
+	for {
+		delivery.Attempts++
+		delivery.ResponseCode, err = d.send(hook, delivery, payload, secret)
+		if err == nil {
+			delivery.State = model.DeliveryStateDelivered
+			delivery.Error = ""
+			d.updateDelivery(delivery)
+			return
+		}
+
+		delivery.Error = err.Error()
+		if delivery.Attempts >= d.MaxAttempts {
+			logger.WithError(err).Errorf("Giving up delivery of event %s after %d attempts", delivery.Event.ID, delivery.Attempts)
+			delivery.State = model.DeliveryStateFailed
+			d.updateDelivery(delivery)
+			return
+		}
+
+		logger.WithError(err).Warnf("Error delivering event %s. Retrying in %s", delivery.Event.ID, backoff)
+		d.updateDelivery(delivery)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send posts the signed payload to the webhook URL and returns the status code of the response. Any status code other than 2xx is considered an error.
+func (d *Dispatcher) send(hook model.Webhook, delivery model.WebhookDelivery, payload []byte, secret string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, payload))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Webhook receiver answered with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// notifyingRepository saves the events in an event repository and notifies them to the webhooks
+type notifyingRepository struct {
+	persistence.EventRepository
+	dispatcher *Dispatcher
+}
+
+func (r notifyingRepository) AddEvent(event model.Event) (model.Event, error) {
+	saved, err := r.EventRepository.AddEvent(event)
+	if err == nil {
+		r.dispatcher.Notify(saved)
+	}
+	return saved, err
+}
+
+// Notifying returns an event repository which sends to the webhooks every event saved in the one passed as parameter
+func (d *Dispatcher) Notifying(events persistence.EventRepository) persistence.EventRepository {
+	return notifyingRepository{
+		EventRepository: events,
+		dispatcher:      d,
+	}
+}
@@ -0,0 +1,209 @@
+/**
+ * Copyright 2018 Atos
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy of
+ * the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations under
+ * the License.
+ *
+ */
+
+package webhooks
+
+import (
+	"deployment-engine/model"
+	"deployment-engine/persistence/memoryrepo"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedEvent struct {
+	event     model.Event
+	signature string
+	delivery  string
+}
+
+// receiver is a webhook receiver which fails the first requests it gets
+type receiver struct {
+	failures int
+	received []receivedEvent
+	lock     sync.Mutex
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var event model.Event
+	json.Unmarshal(body, &event)
+
+	if r.Header.Get(EventHeader) != event.Type {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rc.received = append(rc.received, receivedEvent{
+		event:     event,
+		signature: r.Header.Get(SignatureHeader),
+		delivery:  r.Header.Get(DeliveryHeader),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestDispatcher() *Dispatcher {
+	repo := memoryrepo.CreateMemoryRepository()
+	dispatcher := NewDispatcher(repo, repo)
+	dispatcher.Backoff = 10 * time.Millisecond
+	dispatcher.MaxAttempts = 3
+	return dispatcher
+}
+
+func TestDelivery(t *testing.T) {
+	rc := &receiver{failures: 1}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	dispatcher := newTestDispatcher()
+	events := dispatcher.Notifying(memoryrepo.CreateMemoryRepository())
+
+	hook, err := dispatcher.Subscribe(model.Webhook{
+		URL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing webhook: %s", err.Error())
+	}
+
+	if hook.Secret == "" || hook.SecretID == "" {
+		t.Fatalf("Secret not generated for webhook: %v", hook)
+	}
+
+	created, err := events.AddEvent(model.Event{
+		Type:             model.EventInfrastructureCreated,
+		InfrastructureID: "infra1",
+		Principal:        "admin",
+	})
+	if err != nil {
+		t.Fatalf("Error adding event: %s", err.Error())
+	}
+
+	_, err = events.AddEvent(model.Event{
+		Type:             model.EventSecretAccessed,
+		InfrastructureID: "infra1",
+		Principal:        "admin",
+	})
+	if err != nil {
+		t.Fatalf("Error adding event: %s", err.Error())
+	}
+
+	dispatcher.Wait()
+
+	if len(rc.received) != 1 {
+		t.Fatalf("Expected only the infrastructure creation to be received but got %v", rc.received)
+	}
+
+	received := rc.received[0]
+	if received.event.ID != created.ID || received.event.Principal != "admin" {
+		t.Fatalf("Unexpected event received: %v", received.event)
+	}
+
+	payload, _ := json.Marshal(created)
+	if received.signature != Sign(hook.Secret, payload) {
+		t.Fatalf("Invalid signature %s", received.signature)
+	}
+
+	deliveries, err := dispatcher.Repository.FindDeliveriesByWebhook(hook.ID)
+	if err != nil {
+		t.Fatalf("Error finding deliveries: %s", err.Error())
+	}
+
+	if len(deliveries) != 1 {
+		t.Fatalf("Unexpected deliveries found: %v", deliveries)
+	}
+
+	delivery := deliveries[0]
+	if delivery.ID != received.delivery || delivery.State != model.DeliveryStateDelivered || delivery.Attempts != 2 || delivery.ResponseCode != http.StatusNoContent || delivery.Error != "" {
+		t.Fatalf("Unexpected delivery found: %v", delivery)
+	}
+}
+
+func TestFailedDelivery(t *testing.T) {
+	rc := &receiver{failures: 10}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	dispatcher := newTestDispatcher()
+	hook, err := dispatcher.Subscribe(model.Webhook{
+		URL:    server.URL,
+		Events: []string{model.EventProductFailed},
+		Secret: "mysecret",
+	})
+	if err != nil {
+		t.Fatalf("Error subscribing webhook: %s", err.Error())
+	}
+
+	if hook.Secret != "mysecret" {
+		t.Fatalf("Secret of webhook changed to %s", hook.Secret)
+	}
+
+	dispatcher.Notify(model.Event{
+		ID:     "event1",
+		Type:   model.EventProductFailed,
+		Target: "kubernetes",
+	})
+	dispatcher.Wait()
+
+	deliveries, err := dispatcher.Repository.FindDeliveriesByWebhook(hook.ID)
+	if err != nil {
+		t.Fatalf("Error finding deliveries: %s", err.Error())
+	}
+
+	if len(deliveries) != 1 {
+		t.Fatalf("Unexpected deliveries found: %v", deliveries)
+	}
+
+	delivery := deliveries[0]
+	if delivery.State != model.DeliveryStateFailed || delivery.Attempts != 3 || delivery.ResponseCode != http.StatusServiceUnavailable || delivery.Error == "" {
+		t.Fatalf("Unexpected delivery found: %v", delivery)
+	}
+
+	if rc.failures != 7 {
+		t.Fatalf("Expected 3 attempts but receiver got %d", 10-rc.failures)
+	}
+
+	_, err = dispatcher.Unsubscribe(hook.ID)
+	if err != nil {
+		t.Fatalf("Error unsubscribing webhook: %s", err.Error())
+	}
+
+	if _, err := dispatcher.Vault.GetSecret(hook.SecretID); err == nil {
+		t.Fatal("Secret of webhook found after unsubscribing it")
+	}
+}
+
+func TestInvalidWebhook(t *testing.T) {
+	dispatcher := newTestDispatcher()
+	for _, url := range []string{"", "/relative", "ftp://example.com/hook"} {
+		if _, err := dispatcher.Subscribe(model.Webhook{URL: url}); err == nil {
+			t.Fatalf("Webhook with URL %s accepted", url)
+		}
+	}
+}